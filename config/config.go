@@ -2,12 +2,16 @@ package config
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"net/url"
 	"os"
+	"regexp"
 	"time"
 
+	"github.com/google/cel-go/cel"
 	pconfig "github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
 	yaml "gopkg.in/yaml.v3"
 )
 
@@ -38,6 +42,24 @@ var (
 			"kubeconfig": {
 				Prober: "kubeconfig",
 			},
+			"grpc": {
+				Prober: "grpc",
+			},
+			"quic": {
+				Prober: "quic",
+			},
+			"dtls": {
+				Prober: "dtls",
+			},
+			"ssh": {
+				Prober: "ssh",
+			},
+			"ssh_file": {
+				Prober: "ssh_file",
+			},
+			"scan": {
+				Prober: "scan",
+			},
 		},
 	}
 )
@@ -58,9 +80,49 @@ func LoadConfig(confFile string) (*Config, error) {
 		return c, fmt.Errorf("error parsing config file: %s", err)
 	}
 
+	if err = c.validate(); err != nil {
+		return c, fmt.Errorf("error validating config file: %s", err)
+	}
+
 	return c, nil
 }
 
+// validate checks the options of each module that depend on each other, which
+// can't be checked when they're parsed, and loads the CT log lists of the
+// modules that verify SCTs
+func (c *Config) validate() error {
+	for name, module := range c.Modules {
+		if err := module.validate(); err != nil {
+			return fmt.Errorf("module %s: %s", name, err)
+		}
+
+		if module.SCT {
+			logs, err := LoadCTLogList(module.CTLogListFile)
+			if err != nil {
+				return fmt.Errorf("module %s: loading the CT log list: %s", name, err)
+			}
+			module.CTLogs = logs
+			c.Modules[name] = module
+		}
+	}
+
+	return nil
+}
+
+func (m Module) validate() error {
+	// The PROXY protocol header describes the connection to the target, so
+	// it can't be sent through a proxy or a tunnel
+	proxied := m.SSHTunnel.Host != ""
+	if m.TCP.ProxyProtocol != "" && (proxied || m.TCP.ProxyURL.URL != nil || m.TCP.SOCKS5Proxy.Address != "") {
+		return fmt.Errorf("tcp proxy_protocol can't be combined with proxy_url, socks5_proxy or ssh_tunnel")
+	}
+	if m.HTTPS.ProxyProtocol != "" && (proxied || m.HTTPS.ProxyURL.URL != nil || m.HTTPS.SOCKS5Proxy.Address != "") {
+		return fmt.Errorf("https proxy_protocol can't be combined with proxy_url, socks5_proxy or ssh_tunnel")
+	}
+
+	return nil
+}
+
 // Config configures the exporter
 type Config struct {
 	DefaultModule string            `yaml:"default_module"`
@@ -72,11 +134,192 @@ type Module struct {
 	Prober     string          `yaml:"prober,omitempty"`
 	Target     string          `yaml:"target,omitempty"`
 	Timeout    time.Duration   `yaml:"timeout,omitempty"`
+	Timeouts   Timeouts        `yaml:"timeouts,omitempty"`
 	TLSConfig  TLSConfig       `yaml:"tls_config,omitempty"`
 	HTTPS      HTTPSProbe      `yaml:"https,omitempty"`
 	TCP        TCPProbe        `yaml:"tcp,omitempty"`
 	Kubernetes KubernetesProbe `yaml:"kubernetes,omitempty"`
 	HTTPFile   HTTPFileProbe   `yaml:"http_file,omitempty"`
+	GRPC       GRPCProbe       `yaml:"grpc,omitempty"`
+	Scan       ScanProbe       `yaml:"scan,omitempty"`
+	OCSP       OCSPProbe       `yaml:"ocsp,omitempty"`
+	CT         CTProbe         `yaml:"ct,omitempty"`
+	// Retries is the number of times a failed probe is retried, within the
+	// timeout
+	Retries int `yaml:"retries,omitempty"`
+	// RetryInterval is the time waited before the first retry, which is
+	// doubled after every retry
+	RetryInterval time.Duration `yaml:"retry_interval,omitempty"`
+	// SSHTunnel is an SSH server that the tcp, https, grpc and ssh probers
+	// dial targets through
+	SSHTunnel SSHTunnel `yaml:"ssh_tunnel,omitempty"`
+	// OuterTLSConfig configures an outer TLS session with a gateway, like
+	// stunnel, that the tcp, https and grpc probes are performed inside
+	OuterTLSConfig *TLSConfig `yaml:"outer_tls_config,omitempty"`
+	// SourceIPAddress is the local address that connections are made from
+	SourceIPAddress string `yaml:"source_ip_address,omitempty"`
+	// SourceInterface is the network interface that connections are bound
+	// to. Only supported on Linux.
+	SourceInterface string `yaml:"source_interface,omitempty"`
+	// PreferredIPProtocol restricts connections to the ip4 or ip6
+	// addresses of the target. Both are tried when it isn't set.
+	PreferredIPProtocol string `yaml:"preferred_ip_protocol,omitempty"`
+	// IPProtocolFallback allows connections to the other IP protocol when
+	// the target doesn't have any addresses of the preferred protocol.
+	// Defaults to true.
+	IPProtocolFallback *bool `yaml:"ip_protocol_fallback,omitempty"`
+	// Resolver is the address of a DNS server that target host names are
+	// resolved with, instead of the system resolver. Supports plain DNS
+	// (host:port), DNS over TLS (tls://host:port) and DNS over HTTPS
+	// (https:// URLs).
+	Resolver string `yaml:"resolver,omitempty"`
+	// ResolverTLSConfig configures the connections to DNS over TLS and
+	// DNS over HTTPS resolvers
+	ResolverTLSConfig TLSConfig `yaml:"resolver_tls_config,omitempty"`
+	// DNSSEC only allows connections to the target when the resolver
+	// authenticates its records with DNSSEC
+	DNSSEC bool `yaml:"dnssec,omitempty"`
+	// DANE validates the certificates presented to the tcp, https and grpc
+	// probers against the TLSA records of the target
+	DANE bool `yaml:"dane,omitempty"`
+	// CAA checks that the issuer of the certificate presented to the tcp,
+	// https and grpc probers is authorized by the CAA records of the target
+	CAA bool `yaml:"caa,omitempty"`
+	// CAAIssuers map the organization of certificate issuers to the domain
+	// name that identifies them in CAA records, in addition to the well
+	// known CAs
+	CAAIssuers map[string]string `yaml:"caa_issuers,omitempty"`
+	// SCT verifies the signed certificate timestamps of the certificate
+	// presented to the tcp, https and grpc probers against the logs in the
+	// CT log list
+	SCT bool `yaml:"sct,omitempty"`
+	// CTLogListFile is the CT log list in the v3 JSON format that the SCTs
+	// are verified against, rather than the bundled log list
+	CTLogListFile string `yaml:"ct_log_list_file,omitempty"`
+	// CTLogs are loaded from the CT log list when the config is loaded
+	CTLogs CTLogs `yaml:"-"`
+	// TrustStores are root stores that the certificates presented to the
+	// tcp, https and grpc probers are verified against, in addition to the
+	// ca_file of the tls_config
+	TrustStores []TrustStore `yaml:"trust_stores,omitempty"`
+	// ExpectedDNSNames are the names that the certificate presented to the
+	// tcp, https and grpc probers must be valid for. They are replaced by
+	// the expected_dns_name parameters of the probe request.
+	ExpectedDNSNames []string `yaml:"expected_dns_names,omitempty"`
+	// ExpectedFingerprints are the hex encoded SHA-256 fingerprints that
+	// the certificate presented to the tcp, https and grpc probers must
+	// match one of
+	ExpectedFingerprints []string `yaml:"expected_fingerprints,omitempty"`
+	// ExpectedSPKIHashes are the base64 encoded SHA-256 hashes of public
+	// keys, one of which a certificate in the chain presented to the tcp,
+	// https and grpc probers must have
+	ExpectedSPKIHashes []string `yaml:"expected_spki_hashes,omitempty"`
+	// ExpectedIssuer must match the common name or the distinguished name
+	// of the issuer of the certificate presented to the tcp, https and grpc
+	// probers
+	ExpectedIssuer Regexp `yaml:"expected_issuer,omitempty"`
+	// ExpectedSerial is the decimal serial number that the certificate
+	// presented to the tcp, https and grpc probers must have
+	ExpectedSerial string `yaml:"expected_serial,omitempty"`
+	// ExpectedAuthorityKeyID is the hex encoded authority key identifier
+	// that the certificate presented to the tcp, https and grpc probers must
+	// have
+	ExpectedAuthorityKeyID string `yaml:"expected_authority_key_id,omitempty"`
+	// ClockSkewTolerance is how far in the future the NotBefore of a
+	// certificate presented to the tcp, https and grpc probers can be before
+	// it's reported as not yet valid
+	ClockSkewTolerance time.Duration `yaml:"clock_skew_tolerance,omitempty"`
+	// ExpiryThresholds report whether the certificates presented to the tcp,
+	// https and grpc probers expire within each of them
+	ExpiryThresholds []ExpiryThreshold `yaml:"expiry_thresholds,omitempty"`
+	// CertChanges reports when the leaf certificate presented to the tcp,
+	// https and grpc probers changes between probes
+	CertChanges bool `yaml:"cert_changes,omitempty"`
+	// Name is the name of the module in the config, which tells the
+	// probes of a target by different modules apart
+	Name string `yaml:"-"`
+	// ECH offers the encrypted client hello configs published in the HTTPS
+	// records of the target in the handshakes of the tcp, https and grpc
+	// probers
+	ECH bool `yaml:"ech,omitempty"`
+	// SessionResumption checks whether the target resumes sessions with a
+	// second pair of connections, in the tcp, https and grpc probers
+	SessionResumption bool `yaml:"session_resumption,omitempty"`
+	// Policy is checked against the TLS versions and cipher suites that the
+	// target negotiates, or supports when it's scanned, and the keys of the
+	// certificates that it presents
+	Policy Policy `yaml:"policy,omitempty"`
+}
+
+// TrustStore is a named set of root certificates
+type TrustStore struct {
+	Name string `yaml:"name"`
+	// CAFile contains the root certificates, PEM encoded or in a Java
+	// KeyStore. The system roots are used when it isn't set.
+	CAFile string `yaml:"ca_file,omitempty"`
+	// Roots are loaded from the CA file when the config is loaded
+	Roots *x509.CertPool `yaml:"-"`
+}
+
+// Policy configures rules for weak protocols, ciphers and keys. A violation is
+// reported as a metric, rather than failing the probe.
+type Policy struct {
+	// MinVersion denies TLS versions older than it
+	MinVersion pconfig.TLSVersion `yaml:"min_version,omitempty"`
+	// Deny3DES denies cipher suites that use 3DES
+	Deny3DES bool `yaml:"deny_3des,omitempty"`
+	// DenyRC4 denies cipher suites that use RC4
+	DenyRC4 bool `yaml:"deny_rc4,omitempty"`
+	// DenyCBC denies cipher suites that use a block cipher in CBC mode
+	DenyCBC bool `yaml:"deny_cbc,omitempty"`
+	// DenyRSAKeyExchange denies cipher suites without forward secrecy, that
+	// use RSA to exchange the key
+	DenyRSAKeyExchange bool `yaml:"deny_rsa_key_exchange,omitempty"`
+	// MinRSABits denies certificates with RSA keys smaller than it
+	MinRSABits int `yaml:"min_rsa_bits,omitempty"`
+	// MinECDSABits denies certificates with ECDSA keys on curves smaller
+	// than it
+	MinECDSABits int `yaml:"min_ecdsa_bits,omitempty"`
+	// MaxLifetime denies leaf certificates that are valid for longer than
+	// it, from NotBefore to NotAfter
+	MaxLifetime model.Duration `yaml:"max_lifetime,omitempty"`
+	// Rules are CEL expressions that the certificates presented by the
+	// target must satisfy
+	Rules []PolicyRule `yaml:"rules,omitempty"`
+}
+
+// PolicyRule is a named CEL expression over the certificates presented by the
+// target. The rule is violated when the expression is false.
+type PolicyRule struct {
+	Name       string `yaml:"name"`
+	Expression string `yaml:"expression"`
+	// Program is the compiled expression, which is set when the rule is
+	// loaded from the config
+	Program cel.Program `yaml:"-"`
+}
+
+// Timeouts limit the time spent in each phase of a probe, within the timeout of
+// the module
+type Timeouts struct {
+	// Connect limits dialing the target, including any proxies or tunnels
+	Connect time.Duration `yaml:"connect,omitempty"`
+	// StartTLS limits the protocol exchange before the TLS handshake
+	StartTLS time.Duration `yaml:"starttls,omitempty"`
+	// Handshake limits the TLS handshake
+	Handshake time.Duration `yaml:"handshake,omitempty"`
+}
+
+// SSHTunnel configures an SSH jump host
+type SSHTunnel struct {
+	// Host is the address of the SSH server. The port defaults to 22.
+	Host    string `yaml:"host,omitempty"`
+	User    string `yaml:"user,omitempty"`
+	KeyFile string `yaml:"key_file,omitempty"`
+	// KnownHostsFile is used to verify the host key of the SSH server
+	KnownHostsFile string `yaml:"known_hosts_file,omitempty"`
+	// InsecureSkipVerify disables host key verification when there isn't a
+	// known hosts file
+	InsecureSkipVerify bool `yaml:"insecure_skip_verify,omitempty"`
 }
 
 // TLSConfig is a superset of config.TLSConfig that supports TLS renegotiation
@@ -89,6 +332,28 @@ type TLSConfig struct {
 	// Renegotiation controls what types of TLS renegotiation are supported.
 	// Supported values: never (default), once, freely.
 	Renegotiation renegotiation `yaml:"renegotiation,omitempty"`
+	// ALPNProtocols are the application protocols offered in the handshake,
+	// in order of preference
+	ALPNProtocols []string `yaml:"alpn_protocols,omitempty"`
+	// MinVersion and MaxVersion limit the TLS versions offered in the
+	// handshake
+	MinVersion pconfig.TLSVersion `yaml:"min_version,omitempty"`
+	MaxVersion pconfig.TLSVersion `yaml:"max_version,omitempty"`
+	// CipherSuites are the TLS 1.0-1.2 cipher suites offered in the
+	// handshake. Defaults to the suites that crypto/tls considers secure.
+	CipherSuites cipherSuites `yaml:"cipher_suites,omitempty"`
+	// CurvePreferences are the key exchange groups offered in the
+	// handshake, in order of preference
+	CurvePreferences curvePreferences `yaml:"curve_preferences,omitempty"`
+	// ClientHelloProfile sets the versions, cipher suites, key exchange
+	// groups and application protocols offered in the handshake to those of
+	// a real client, unless they're configured. Supported values: chrome,
+	// firefox, java8, openssl1.0.
+	ClientHelloProfile ClientHelloProfile `yaml:"client_hello_profile,omitempty"`
+	// AIAFetch fetches the intermediates that the target doesn't present
+	// from the caIssuers URLs of the certificates, when the chain can't be
+	// verified without them
+	AIAFetch bool `yaml:"aia_fetch,omitempty"`
 }
 
 type renegotiation tls.RenegotiationSupport
@@ -112,21 +377,89 @@ func (r *renegotiation) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	return nil
 }
 
+// curves maps the names of the key exchange groups, as they're exported by
+// ssl_curve_info and in the form used by RFC 8422, to their IDs
+var curves = map[string]tls.CurveID{
+	"X25519":    tls.X25519,
+	"CurveP256": tls.CurveP256,
+	"P-256":     tls.CurveP256,
+	"CurveP384": tls.CurveP384,
+	"P-384":     tls.CurveP384,
+	"CurveP521": tls.CurveP521,
+	"P-521":     tls.CurveP521,
+}
+
+// defaultCurvePreferences are offered when the curve preferences aren't
+// configured. The defaults of crypto/tls are used when it's empty.
+var defaultCurvePreferences []tls.CurveID
+
+type curvePreferences []tls.CurveID
+
+func (c *curvePreferences) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var names []string
+	if err := unmarshal(&names); err != nil {
+		return err
+	}
+
+	ids := make([]tls.CurveID, 0, len(names))
+	for _, name := range names {
+		id, ok := curves[name]
+		if !ok {
+			return fmt.Errorf("unsupported curve %s", name)
+		}
+		ids = append(ids, id)
+	}
+	*c = ids
+
+	return nil
+}
+
 // NewTLSConfig creates a new tls.Config from the given TLSConfig,
 // plus our local extensions
 func NewTLSConfig(cfg *TLSConfig) (*tls.Config, error) {
+	profile := &clientHelloProfile{curvePreferences: defaultCurvePreferences}
+	if cfg.ClientHelloProfile != "" {
+		var ok bool
+		profile, ok = clientHelloProfiles[string(cfg.ClientHelloProfile)]
+		if !ok {
+			return nil, fmt.Errorf("unsupported client hello profile %s", cfg.ClientHelloProfile)
+		}
+	}
+
+	minVersion, maxVersion := cfg.MinVersion, cfg.MaxVersion
+	if minVersion == 0 {
+		minVersion = pconfig.TLSVersion(profile.minVersion)
+	}
+	if maxVersion == 0 {
+		maxVersion = pconfig.TLSVersion(profile.maxVersion)
+	}
+
 	tlsConfig, err := pconfig.NewTLSConfig(&pconfig.TLSConfig{
 		CAFile:             cfg.CAFile,
 		CertFile:           cfg.CertFile,
 		KeyFile:            cfg.KeyFile,
 		ServerName:         cfg.ServerName,
 		InsecureSkipVerify: cfg.InsecureSkipVerify,
+		MinVersion:         minVersion,
+		MaxVersion:         maxVersion,
 	})
 	if err != nil {
 		return nil, err
 	}
 
 	tlsConfig.Renegotiation = tls.RenegotiationSupport(cfg.Renegotiation)
+	tlsConfig.NextProtos = cfg.ALPNProtocols
+	if len(tlsConfig.NextProtos) == 0 {
+		tlsConfig.NextProtos = profile.alpnProtocols
+	}
+	tlsConfig.CipherSuites = cfg.CipherSuites
+	if len(tlsConfig.CipherSuites) == 0 {
+		tlsConfig.CipherSuites = profile.cipherSuites
+	}
+	tlsConfig.CurvePreferences = cfg.CurvePreferences
+	if len(tlsConfig.CurvePreferences) == 0 {
+		tlsConfig.CurvePreferences = profile.curvePreferences
+	}
 
 	return tlsConfig, nil
 }
@@ -134,11 +467,64 @@ func NewTLSConfig(cfg *TLSConfig) (*tls.Config, error) {
 // TCPProbe configures a tcp probe
 type TCPProbe struct {
 	StartTLS string `yaml:"starttls,omitempty"`
+	// Protocol is an application protocol that is spoken over the TLS
+	// connection after the handshake to check that the server is healthy
+	Protocol string `yaml:"protocol,omitempty"`
+	// Username and PasswordFile are the credentials used by protocol checks
+	// that authenticate
+	Username     string `yaml:"username,omitempty"`
+	PasswordFile string `yaml:"password_file,omitempty"`
+	// ProxyProtocol is the version of the PROXY protocol header that is sent
+	// before the TLS handshake. Supported values: v1, v2.
+	ProxyProtocol string `yaml:"proxy_protocol,omitempty"`
+	// ProxyURL is a HTTP proxy that the connection is tunnelled through with
+	// the CONNECT method
+	ProxyURL URL `yaml:"proxy_url,omitempty"`
+	// ProxyBasicAuth are the credentials sent to the proxy
+	ProxyBasicAuth BasicAuth `yaml:"proxy_basic_auth,omitempty"`
+	// SOCKS5Proxy is a SOCKS5 proxy that the connection is made through
+	SOCKS5Proxy SOCKS5Proxy `yaml:"socks5_proxy,omitempty"`
+	// SMTP configures the EHLO exchange of the smtp STARTTLS negotiation and
+	// protocol check
+	SMTP SMTPConfig `yaml:"smtp,omitempty"`
+}
+
+// SMTPConfig configures the smtp EHLO exchange
+type SMTPConfig struct {
+	// EHLOName is the name sent with EHLO. Defaults to prober.
+	EHLOName string `yaml:"ehlo_name,omitempty"`
+	// RequireCapabilities fail the exchange when they aren't advertised
+	RequireCapabilities []string `yaml:"require_capabilities,omitempty"`
+	// ForbidCapabilities fail the exchange when they are advertised
+	ForbidCapabilities []string `yaml:"forbid_capabilities,omitempty"`
+}
+
+// BasicAuth configures basic authentication with a password that is read
+// from a file at probe time
+type BasicAuth struct {
+	Username     string `yaml:"username,omitempty"`
+	PasswordFile string `yaml:"password_file,omitempty"`
 }
 
 // HTTPSProbe configures a https probe
 type HTTPSProbe struct {
 	ProxyURL URL `yaml:"proxy_url,omitempty"`
+	// WebSocket performs the WebSocket opening handshake and requires a 101
+	// response from the target
+	WebSocket bool `yaml:"websocket,omitempty"`
+	// ProxyProtocol is the version of the PROXY protocol header that is sent
+	// before the TLS handshake. Supported values: v1, v2.
+	ProxyProtocol string `yaml:"proxy_protocol,omitempty"`
+	// SOCKS5Proxy is a SOCKS5 proxy that the connection is made through
+	SOCKS5Proxy SOCKS5Proxy `yaml:"socks5_proxy,omitempty"`
+}
+
+// SOCKS5Proxy configures a SOCKS5 proxy with optional username and password
+// authentication
+type SOCKS5Proxy struct {
+	Address      string `yaml:"address,omitempty"`
+	Username     string `yaml:"username,omitempty"`
+	PasswordFile string `yaml:"password_file,omitempty"`
 }
 
 // KubernetesProbe configures a kubernetes probe
@@ -151,6 +537,91 @@ type HTTPFileProbe struct {
 	ProxyURL URL `yaml:"proxy_url,omitempty"`
 }
 
+// OCSPProbe configures an ocsp probe
+type OCSPProbe struct {
+	// IssuerFile is the certificate of the CA that issued the certificate
+	// whose status is requested
+	IssuerFile string `yaml:"issuer_file,omitempty"`
+	// Serial is the serial number of the certificate, in hex with optional
+	// colons
+	Serial   string `yaml:"serial,omitempty"`
+	ProxyURL URL    `yaml:"proxy_url,omitempty"`
+	// CacheMaxAge enables caching responses between probes until their
+	// nextUpdate, for no longer than the duration
+	CacheMaxAge time.Duration `yaml:"cache_max_age,omitempty"`
+}
+
+// CTProbe configures a ct probe
+type CTProbe struct {
+	// URL is the crt.sh compatible API that certificates are searched with.
+	// Defaults to https://crt.sh/.
+	URL      URL `yaml:"url,omitempty"`
+	ProxyURL URL `yaml:"proxy_url,omitempty"`
+	// IncludeExpired includes the certificates that have expired
+	IncludeExpired bool `yaml:"include_expired,omitempty"`
+}
+
+// GRPCProbe configures a grpc probe
+type GRPCProbe struct {
+	// HealthCheck calls the grpc.health.v1.Health/Check method after the
+	// handshake
+	HealthCheck bool `yaml:"health_check,omitempty"`
+	// Service is the name of the service that is checked. The overall health
+	// of the server is checked when it is empty.
+	Service string `yaml:"service,omitempty"`
+}
+
+// ScanProbe configures a scan probe
+type ScanProbe struct {
+	// CipherSuites are the TLS 1.0-1.2 cipher suites that are tested one at
+	// a time. Defaults to all of the suites implemented by crypto/tls.
+	CipherSuites cipherSuites `yaml:"cipher_suites,omitempty"`
+	// VulnerabilityChecks are diagnostic handshakes that test the target for
+	// known weaknesses
+	VulnerabilityChecks VulnerabilityChecks `yaml:"vulnerability_checks,omitempty"`
+}
+
+// VulnerabilityChecks enables the diagnostic handshakes of the scan prober
+type VulnerabilityChecks struct {
+	// Compression offers TLS compression, which exposes the connection to
+	// the CRIME attack
+	Compression bool `yaml:"compression,omitempty"`
+	// FallbackSCSV offers a lower version than the target supports with
+	// TLS_FALLBACK_SCSV, which the target should reject to prevent
+	// downgrade attacks
+	FallbackSCSV bool `yaml:"fallback_scsv,omitempty"`
+}
+
+type cipherSuites []uint16
+
+func (c *cipherSuites) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var names []string
+	if err := unmarshal(&names); err != nil {
+		return err
+	}
+
+	suites := map[string]*tls.CipherSuite{}
+	for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		suites[suite.Name] = suite
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		suite, ok := suites[name]
+		if !ok {
+			return fmt.Errorf("unsupported cipher suite %s", name)
+		}
+		// The TLS 1.3 cipher suites can't be configured in crypto/tls
+		if len(suite.SupportedVersions) == 1 && suite.SupportedVersions[0] == tls.VersionTLS13 {
+			return fmt.Errorf("TLS 1.3 cipher suite %s can't be configured", name)
+		}
+		ids = append(ids, suite.ID)
+	}
+	*c = ids
+
+	return nil
+}
+
 // URL is a custom URL type that allows validation at configuration load time
 type URL struct {
 	*url.URL
@@ -170,3 +641,48 @@ func (u *URL) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	u.URL = urlp
 	return nil
 }
+
+// Regexp is a regular expression that is anchored at both ends and validated at
+// configuration load time
+type Regexp struct {
+	*regexp.Regexp
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface for Regexps.
+func (r *Regexp) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+
+	re, err := regexp.Compile("^(?:" + s + ")$")
+	if err != nil {
+		return err
+	}
+	r.Regexp = re
+	return nil
+}
+
+// ExpiryThreshold is a duration, like 30d, that keeps the text it was
+// configured with to label the metrics that it produces
+type ExpiryThreshold struct {
+	Name     string
+	Duration time.Duration
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface for
+// ExpiryThresholds.
+func (e *ExpiryThreshold) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+
+	d, err := model.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	e.Name = s
+	e.Duration = time.Duration(d)
+	return nil
+}
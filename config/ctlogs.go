@@ -0,0 +1,105 @@
+package config
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	_ "embed"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// bundledCTLogList is the log list published by Google, which is refreshed by
+// running make ct-log-list
+//
+//go:embed ct_log_list.json
+var bundledCTLogList []byte
+
+var (
+	bundledCTLogsOnce sync.Once
+	bundledCTLogs     CTLogs
+	bundledCTLogsErr  error
+)
+
+// ctLogListFile is a log list in the v3 JSON format
+type ctLogListFile struct {
+	Operators []struct {
+		Name string `json:"name"`
+		Logs []struct {
+			Description string `json:"description"`
+			Key         string `json:"key"`
+		} `json:"logs"`
+		TiledLogs []struct {
+			Description string `json:"description"`
+			Key         string `json:"key"`
+		} `json:"tiled_logs"`
+	} `json:"operators"`
+}
+
+// CTLog is a CT log that signs SCTs
+type CTLog struct {
+	Description string
+	Operator    string
+	Key         crypto.PublicKey
+}
+
+// CTLogs are the logs in a log list by their log ID, which is the SHA-256 hash
+// of their public key
+type CTLogs map[[32]byte]*CTLog
+
+// LoadCTLogList returns the logs in the log list file, or in the bundled log
+// list when the file isn't set
+func LoadCTLogList(file string) (CTLogs, error) {
+	if file == "" {
+		bundledCTLogsOnce.Do(func() {
+			bundledCTLogs, bundledCTLogsErr = ParseCTLogList(bundledCTLogList)
+		})
+		return bundledCTLogs, bundledCTLogsErr
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseCTLogList(data)
+}
+
+// ParseCTLogList returns the logs in a log list in the v3 JSON format
+func ParseCTLogList(data []byte) (CTLogs, error) {
+	var list ctLogListFile
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+
+	logs := CTLogs{}
+	for _, operator := range list.Operators {
+		keys := map[string]string{}
+		for _, l := range operator.Logs {
+			keys[l.Key] = l.Description
+		}
+		for _, l := range operator.TiledLogs {
+			keys[l.Key] = l.Description
+		}
+		for encodedKey, description := range keys {
+			der, err := base64.StdEncoding.DecodeString(encodedKey)
+			if err != nil {
+				return nil, fmt.Errorf("decoding the key of %s: %w", description, err)
+			}
+			key, err := x509.ParsePKIXPublicKey(der)
+			if err != nil {
+				return nil, fmt.Errorf("parsing the key of %s: %w", description, err)
+			}
+			logs[sha256.Sum256(der)] = &CTLog{
+				Description: description,
+				Operator:    operator.Name,
+				Key:         key,
+			}
+		}
+	}
+
+	return logs, nil
+}
@@ -0,0 +1,19 @@
+//go:build go1.24
+
+package config
+
+import "crypto/tls"
+
+func init() {
+	// The hybrid post-quantum key exchange is only implemented from go 1.24.
+	// It's offered explicitly, because it isn't a default of crypto/tls for
+	// modules that target older versions of go.
+	curves["X25519MLKEM768"] = tls.X25519MLKEM768
+	defaultCurvePreferences = []tls.CurveID{tls.X25519MLKEM768, tls.X25519, tls.CurveP256, tls.CurveP384, tls.CurveP521}
+
+	// Current browsers offer it first
+	for _, name := range []string{"chrome", "firefox"} {
+		profile := clientHelloProfiles[name]
+		profile.curvePreferences = append([]tls.CurveID{tls.X25519MLKEM768}, profile.curvePreferences...)
+	}
+}
@@ -0,0 +1,51 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/google/cel-go/cel"
+)
+
+// ruleEnv declares the variables that policy rules are evaluated with. The leaf
+// certificate is cert, the certificates presented by the target are chain and
+// the time of the probe is now.
+var ruleEnv, ruleEnvErr = cel.NewEnv(
+	cel.Variable("cert", cel.MapType(cel.StringType, cel.DynType)),
+	cel.Variable("chain", cel.ListType(cel.MapType(cel.StringType, cel.DynType))),
+	cel.Variable("now", cel.TimestampType),
+)
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface for PolicyRules. The
+// expression is compiled, so that a rule that can't be evaluated is a config
+// error rather than a failed probe.
+func (r *PolicyRule) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain PolicyRule
+	if err := unmarshal((*plain)(r)); err != nil {
+		return err
+	}
+
+	prg, err := CompilePolicyRule(r.Expression)
+	if err != nil {
+		return fmt.Errorf("policy rule %s: %s", r.Name, err)
+	}
+	r.Program = prg
+	return nil
+}
+
+// CompilePolicyRule compiles the expression of a policy rule, which must
+// evaluate to a bool
+func CompilePolicyRule(expression string) (cel.Program, error) {
+	if ruleEnvErr != nil {
+		return nil, ruleEnvErr
+	}
+
+	ast, iss := ruleEnv.Compile(expression)
+	if iss.Err() != nil {
+		return nil, iss.Err()
+	}
+	if ast.OutputType() != cel.BoolType {
+		return nil, fmt.Errorf("the expression returns %s rather than a bool", ast.OutputType())
+	}
+
+	return ruleEnv.Program(ast)
+}
@@ -0,0 +1,161 @@
+package config
+
+import (
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// jksMagic starts a Java KeyStore, like the cacerts of a JRE
+const jksMagic = 0xfeedfeed
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface for TrustStores. The
+// roots are loaded, so that a CA file that is missing or can't be decoded is a
+// config error rather than a failed probe, and so that the file isn't read by
+// every probe.
+func (s *TrustStore) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain TrustStore
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+
+	roots, err := LoadTrustStore(*s)
+	if err != nil {
+		return fmt.Errorf("trust store %s: %s", s.Name, err)
+	}
+	s.Roots = roots
+	return nil
+}
+
+// LoadTrustStore returns the roots in the CA file of the trust store, which
+// are the system roots when it isn't set
+func LoadTrustStore(store TrustStore) (*x509.CertPool, error) {
+	if store.CAFile == "" {
+		return x509.SystemCertPool()
+	}
+
+	data, err := os.ReadFile(store.CAFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading ca file: %w", err)
+	}
+
+	var certs []*x509.Certificate
+	if len(data) >= 4 && binary.BigEndian.Uint32(data) == jksMagic {
+		certs, err = parseJKSCertificates(data)
+	} else {
+		certs, err = decodePEMCertificates(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("decoding ca file: %w", err)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("the ca file doesn't contain a certificate")
+	}
+
+	roots := x509.NewCertPool()
+	for _, cert := range certs {
+		roots.AddCert(cert)
+	}
+
+	return roots, nil
+}
+
+// parseJKSCertificates returns the trusted certificates in a Java KeyStore.
+// The integrity of the keystore isn't checked, as that requires its
+// password.
+func parseJKSCertificates(data []byte) ([]*x509.Certificate, error) {
+	var (
+		input   = cryptobyte.String(data)
+		magic   uint32
+		version uint32
+		count   uint32
+	)
+	if !input.ReadUint32(&magic) || magic != jksMagic ||
+		!input.ReadUint32(&version) || (version != 1 && version != 2) ||
+		!input.ReadUint32(&count) {
+		return nil, errors.New("malformed java keystore header")
+	}
+
+	// readCertificate reads a certificate, which is preceded by its type in
+	// version 2
+	readCertificate := func() ([]byte, bool) {
+		var (
+			certType cryptobyte.String
+			length   uint32
+			der      []byte
+		)
+		if version == 2 && !input.ReadUint16LengthPrefixed(&certType) {
+			return nil, false
+		}
+		if !input.ReadUint32(&length) || !input.ReadBytes(&der, int(length)) {
+			return nil, false
+		}
+
+		return der, true
+	}
+
+	var certs []*x509.Certificate
+	for i := uint32(0); i < count; i++ {
+		var (
+			tag       uint32
+			alias     cryptobyte.String
+			timestamp uint64
+		)
+		if !input.ReadUint32(&tag) || !input.ReadUint16LengthPrefixed(&alias) || !input.ReadUint64(&timestamp) {
+			return nil, errors.New("malformed java keystore entry")
+		}
+
+		switch tag {
+		case 1:
+			// The chain of a private key isn't trusted, so it's skipped
+			var (
+				keyLength   uint32
+				chainLength uint32
+			)
+			if !input.ReadUint32(&keyLength) || !input.Skip(int(keyLength)) || !input.ReadUint32(&chainLength) {
+				return nil, fmt.Errorf("malformed java keystore private key entry %q", alias)
+			}
+			for j := uint32(0); j < chainLength; j++ {
+				if _, ok := readCertificate(); !ok {
+					return nil, fmt.Errorf("malformed java keystore private key entry %q", alias)
+				}
+			}
+		case 2:
+			der, ok := readCertificate()
+			if !ok {
+				return nil, fmt.Errorf("malformed java keystore certificate entry %q", alias)
+			}
+			cert, err := x509.ParseCertificate(der)
+			if err != nil {
+				return nil, fmt.Errorf("parsing java keystore certificate entry %q: %w", alias, err)
+			}
+			certs = append(certs, cert)
+		default:
+			return nil, fmt.Errorf("unsupported java keystore entry type %d", tag)
+		}
+	}
+
+	return certs, nil
+}
+
+// decodePEMCertificates returns the certificates in PEM encoded data
+func decodePEMCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" && block.Type != "TRUSTED CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return certs, err
+		}
+		certs = append(certs, cert)
+	}
+
+	return certs, nil
+}
@@ -0,0 +1,354 @@
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	bolt "go.etcd.io/bbolt"
+)
+
+var bucket = []byte("targets")
+
+const (
+	// pruneInterval is how often the records that have outlived the
+	// retention are removed
+	pruneInterval = time.Minute
+	// flushInterval is how often the records that have changed are written
+	// to the file
+	flushInterval = 10 * time.Second
+)
+
+// Record is what is known about a target from the probes of it by a module
+type Record struct {
+	Module string `json:"module"`
+	Target string `json:"target"`
+	// Certificates are the leaf certificates that the target presented to
+	// the last probes that received one, by the server name that the probes
+	// requested
+	Certificates map[string]*Certificate `json:"certificates,omitempty"`
+	LastProbe    time.Time               `json:"last_probe"`
+	LastSuccess  time.Time               `json:"last_success"`
+	Success      bool                    `json:"success"`
+	Error        string                  `json:"error,omitempty"`
+
+	// pending are the certificates presented to the attempts of the
+	// probe that is running, which are only recorded when it succeeds
+	pending map[string]*Certificate
+}
+
+// Certificate is a leaf certificate presented by a target
+type Certificate struct {
+	// Fingerprint is the hex encoded SHA-256 fingerprint of the certificate
+	Fingerprint string    `json:"fingerprint"`
+	NotAfter    time.Time `json:"not_after"`
+	// Changes is the number of times that the certificate has changed
+	Changes int `json:"changes"`
+}
+
+// History records the certificates and outcomes of the probes of each target
+// by each module. It's kept in memory, and in a BoltDB file when it's opened
+// with Open, so that it survives restarts of the exporter. The records of
+// targets that haven't been probed for longer than the retention are removed,
+// unless the retention is 0.
+type History struct {
+	mu        sync.Mutex
+	records   map[string]*Record
+	retention time.Duration
+	pruned    time.Time
+
+	db *bolt.DB
+	// dirty are the keys of the records that have changed or been removed
+	// since they were last written to the file
+	dirty  map[string]bool
+	done   chan struct{}
+	wg     sync.WaitGroup
+	logger log.Logger
+}
+
+// New returns a history that is only kept in memory
+func New(retention time.Duration) *History {
+	return &History{
+		records:   map[string]*Record{},
+		retention: retention,
+		dirty:     map[string]bool{},
+	}
+}
+
+// Open returns a history that is kept in the BoltDB file at the path, which is
+// created if it doesn't exist, starting with the records already in it. The
+// records that change are written to the file in the background, and when the
+// history is closed.
+func Open(path string, retention time.Duration, logger log.Logger) (*History, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	h := New(retention)
+	h.db = db
+	h.done = make(chan struct{})
+	h.logger = logger
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(bucket)
+		if err != nil {
+			return err
+		}
+		return b.ForEach(func(k, v []byte) error {
+			record := &Record{}
+			if err := json.Unmarshal(v, record); err != nil {
+				return err
+			}
+			h.records[string(k)] = record
+			return nil
+		})
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	h.wg.Add(1)
+	go h.run()
+
+	return h, nil
+}
+
+// Close writes the records that have changed to the file that the history is
+// kept in and closes it
+func (h *History) Close() error {
+	if h.db == nil {
+		return nil
+	}
+	close(h.done)
+	h.wg.Wait()
+
+	if err := h.flush(); err != nil {
+		h.db.Close()
+		return err
+	}
+	return h.db.Close()
+}
+
+// ObserveCertificate compares the leaf certificate presented to the module by
+// the target for the server name to the last one that was recorded, and
+// returns whether it's different, along with the number of times that it has
+// changed. The certificate is only recorded by ObserveOutcome when the probe
+// succeeds, so that the retries of a failed attempt compare it to the same
+// certificate.
+func (h *History) ObserveCertificate(module, target, serverName, fingerprint string, notAfter time.Time) (bool, int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var (
+		record  = h.record(module, target)
+		cert    = &Certificate{Fingerprint: fingerprint, NotAfter: notAfter}
+		changed bool
+	)
+	if last, ok := record.Certificates[serverName]; ok {
+		changed = last.Fingerprint != fingerprint
+		cert.Changes = last.Changes
+		if changed {
+			cert.Changes++
+		}
+	}
+	if record.pending == nil {
+		record.pending = map[string]*Certificate{}
+	}
+	record.pending[serverName] = cert
+
+	return changed, cert.Changes
+}
+
+// ObserveOutcome records the result of a probe of the target by the module, and
+// the certificates that were presented to it when it succeeded
+func (h *History) ObserveOutcome(module, target string, probeErr error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	h.prune(now)
+
+	record := h.record(module, target)
+	record.LastProbe = now
+	record.Success = probeErr == nil
+	record.Error = ""
+	if probeErr != nil {
+		record.Error = probeErr.Error()
+	} else {
+		record.LastSuccess = record.LastProbe
+		if len(record.pending) > 0 && record.Certificates == nil {
+			record.Certificates = map[string]*Certificate{}
+		}
+		for serverName, cert := range record.pending {
+			record.Certificates[serverName] = cert
+		}
+	}
+	record.pending = nil
+}
+
+// Records returns the records of every target, sorted by target and module
+func (h *History) Records() []Record {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	records := make([]Record, 0, len(h.records))
+	for _, record := range h.records {
+		r := *record
+		r.pending = nil
+		r.Certificates = make(map[string]*Certificate, len(record.Certificates))
+		for serverName, cert := range record.Certificates {
+			c := *cert
+			r.Certificates[serverName] = &c
+		}
+		records = append(records, r)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Target != records[j].Target {
+			return records[i].Target < records[j].Target
+		}
+		return records[i].Module < records[j].Module
+	})
+
+	return records
+}
+
+// ServeHTTP serves the records as JSON, or only the records of the target and
+// module parameters when they're set
+func (h *History) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var (
+		target  = r.URL.Query().Get("target")
+		module  = r.URL.Query().Get("module")
+		records = []Record{}
+	)
+	for _, record := range h.Records() {
+		if (target == "" || record.Target == target) && (module == "" || record.Module == module) {
+			records = append(records, record)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(records)
+}
+
+// record returns the record of the target and module, creating it if it
+// doesn't exist, and marks it as changed
+func (h *History) record(module, target string) *Record {
+	k := key(module, target)
+	record, ok := h.records[k]
+	if !ok {
+		record = &Record{Module: module, Target: target}
+		h.records[k] = record
+	}
+	h.markDirty(k)
+	return record
+}
+
+// markDirty marks the record of the key as changed, so that it's written by the
+// next flush. Nothing is marked when the history isn't kept in a file, as it's
+// never flushed.
+func (h *History) markDirty(k string) {
+	if h.db != nil {
+		h.dirty[k] = true
+	}
+}
+
+// prune removes the records of the targets that haven't been probed for longer
+// than the retention
+func (h *History) prune(now time.Time) {
+	if h.retention == 0 || now.Sub(h.pruned) < pruneInterval {
+		return
+	}
+	h.pruned = now
+
+	for k, record := range h.records {
+		// The records created by probes that are still running don't
+		// have a last probe yet
+		if !record.LastProbe.IsZero() && now.Sub(record.LastProbe) > h.retention {
+			delete(h.records, k)
+			h.markDirty(k)
+		}
+	}
+}
+
+// run writes the records that have changed to the file until the history is
+// closed
+func (h *History) run() {
+	defer h.wg.Done()
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := h.flush(); err != nil {
+				level.Error(h.logger).Log("msg", fmt.Sprintf("Error writing the history to the file: %s", err))
+			}
+		case <-h.done:
+			return
+		}
+	}
+}
+
+// flush writes the records that have changed to the file, and removes the
+// records that have been pruned from it. The file is written without holding
+// the lock, so that probes aren't held up by it.
+func (h *History) flush() error {
+	h.mu.Lock()
+	h.prune(time.Now())
+	updates := make(map[string][]byte, len(h.dirty))
+	for k := range h.dirty {
+		var data []byte
+		if record, ok := h.records[k]; ok {
+			var err error
+			data, err = json.Marshal(record)
+			if err != nil {
+				h.mu.Unlock()
+				return err
+			}
+		}
+		updates[k] = data
+	}
+	h.dirty = map[string]bool{}
+	h.mu.Unlock()
+
+	if len(updates) == 0 {
+		return nil
+	}
+	err := h.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucket)
+		for k, data := range updates {
+			var err error
+			if data == nil {
+				err = b.Delete([]byte(k))
+			} else {
+				err = b.Put([]byte(k), data)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		// The records are written again by the next flush
+		h.mu.Lock()
+		for k := range updates {
+			h.dirty[k] = true
+		}
+		h.mu.Unlock()
+	}
+
+	return err
+}
+
+// key is the key of the record of the target and module, which are separated
+// by a byte that they don't contain
+func key(module, target string) string {
+	return module + "\x00" + target
+}
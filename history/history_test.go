@@ -0,0 +1,95 @@
+package history
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+)
+
+// TestHistoryRetention tests that the records of targets that haven't been
+// probed for longer than the retention are removed from the file
+func TestHistoryRetention(t *testing.T) {
+	dir, err := os.MkdirTemp("", "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "history.db")
+
+	h, err := Open(path, time.Hour, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.ObserveOutcome("tcp", "old.example.com:443", nil)
+	h.ObserveOutcome("tcp", "new.example.com:443", nil)
+	if err := h.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	h.mu.Lock()
+	h.records[key("tcp", "old.example.com:443")].LastProbe = time.Now().Add(-2 * time.Hour)
+	h.pruned = time.Time{}
+	h.mu.Unlock()
+	if err := h.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	h, err = Open(path, time.Hour, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	records := h.Records()
+	if len(records) != 1 || records[0].Target != "new.example.com:443" {
+		t.Fatalf("expected only the record of new.example.com:443, got %+v", records)
+	}
+}
+
+// TestHistoryInMemory tests that a history that isn't kept in a file doesn't
+// track the records that have changed, as they're never written
+func TestHistoryInMemory(t *testing.T) {
+	h := New(0)
+	h.ObserveCertificate("tcp", "example.com:443", "example.com", "fingerprint", time.Now())
+	h.ObserveOutcome("tcp", "example.com:443", nil)
+
+	if len(h.Records()) != 1 {
+		t.Fatalf("expected one record, got %+v", h.Records())
+	}
+	if len(h.dirty) != 0 {
+		t.Fatalf("expected no dirty records, got %v", h.dirty)
+	}
+}
+
+// TestHistoryCertificateRetries tests that the certificates presented to a
+// probe are only recorded when it succeeds, so that the retries of a failed
+// attempt report the same change
+func TestHistoryCertificateRetries(t *testing.T) {
+	h := New(0)
+	h.ObserveCertificate("tcp", "example.com:443", "example.com", "old", time.Now())
+	h.ObserveOutcome("tcp", "example.com:443", nil)
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		changed, changes := h.ObserveCertificate("tcp", "example.com:443", "example.com", "new", time.Now())
+		if !changed || changes != 1 {
+			t.Fatalf("attempt %d: expected a change, got changed=%t changes=%d", attempt, changed, changes)
+		}
+	}
+	h.ObserveOutcome("tcp", "example.com:443", errors.New("probe failed"))
+
+	if fp := h.Records()[0].Certificates["example.com"].Fingerprint; fp != "old" {
+		t.Fatalf("expected the certificate of the failed probe not to be recorded, got %s", fp)
+	}
+
+	h.ObserveCertificate("tcp", "example.com:443", "example.com", "new", time.Now())
+	h.ObserveOutcome("tcp", "example.com:443", nil)
+
+	cert := h.Records()[0].Certificates["example.com"]
+	if cert.Fingerprint != "new" || cert.Changes != 1 {
+		t.Fatalf("expected the new certificate with one change, got %+v", cert)
+	}
+}
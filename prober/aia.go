@@ -0,0 +1,219 @@
+package prober
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/crypto/cryptobyte"
+	cryptobyte_asn1 "golang.org/x/crypto/cryptobyte/asn1"
+)
+
+const (
+	// aiaFetchTimeout limits each request for an issuer certificate
+	aiaFetchTimeout = 5 * time.Second
+	// aiaMaxFetches is the number of missing certificates that are fetched
+	// to complete a chain
+	aiaMaxFetches = 4
+	// aiaMaxSize limits the size of an issuer certificate response
+	aiaMaxSize = 1 << 20
+)
+
+// oidSignedData is the content type of a PKCS #7 SignedData, which is how
+// certificates are often published at caIssuers URLs
+var oidSignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+
+// verifyWithAIA verifies the certificates presented by the target like
+// crypto/tls, but fetches the intermediates that the target doesn't present
+// from the caIssuers URLs in the Authority Information Access extension of
+// the certificates when the chain can't be verified without them
+func verifyWithAIA(ctx context.Context, certs []*x509.Certificate, roots *x509.CertPool, serverName string, tolerance time.Duration, registry *prometheus.Registry) ([][]*x509.Certificate, error) {
+	var (
+		chainIncomplete = prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "chain_incomplete"),
+				Help: "If the certificates presented by the target can't be verified without fetching intermediates from their AIA caIssuers URLs",
+			},
+		)
+		chainVerifiableAfterAIA = prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "chain_verifiable_after_aia"),
+				Help: "If the certificates presented by the target can be verified, after fetching the intermediates that are missing from their AIA caIssuers URLs",
+			},
+		)
+	)
+	registry.MustRegister(chainIncomplete, chainVerifiableAfterAIA)
+
+	chains, incomplete, err := verifyChainWithAIA(ctx, certs, roots, serverName, tolerance)
+	if incomplete {
+		chainIncomplete.Set(1)
+	}
+	if err != nil {
+		return nil, err
+	}
+	chainVerifiableAfterAIA.Set(1)
+
+	return chains, nil
+}
+
+// verifyChainWithAIA verifies the certificates, allowing for the clock skew
+// tolerance and fetching the intermediates that are missing, and returns
+// whether any were missing
+func verifyChainWithAIA(ctx context.Context, certs []*x509.Certificate, roots *x509.CertPool, serverName string, tolerance time.Duration) ([][]*x509.Certificate, bool, error) {
+	if len(certs) == 0 {
+		return nil, false, errors.New("tls: the target didn't present a certificate")
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	opts := x509.VerifyOptions{
+		DNSName:       serverName,
+		Roots:         roots,
+		Intermediates: intermediates,
+	}
+
+	chains, err := verifyWithTolerance(certs[0], opts, tolerance)
+	if err == nil {
+		return chains, false, nil
+	}
+	var unknownAuthority x509.UnknownAuthorityError
+	if !errors.As(err, &unknownAuthority) {
+		return nil, false, &tls.CertificateVerificationError{UnverifiedCertificates: certs, Err: err}
+	}
+
+	// The missing certificate is the issuer of the last certificate that the
+	// presented certificates chain up to from the leaf
+	cert := certs[0]
+	for i := 0; i < len(certs); i++ {
+		issuer := issuerOf(cert, [][]*x509.Certificate{certs[1:]})
+		if issuer == nil {
+			break
+		}
+		cert = issuer
+	}
+
+	for i := 0; i < aiaMaxFetches && len(cert.IssuingCertificateURL) > 0; i++ {
+		issuer, fetchErr := fetchIssuer(ctx, cert)
+		if fetchErr != nil {
+			return nil, true, &tls.CertificateVerificationError{
+				UnverifiedCertificates: certs,
+				Err:                    fmt.Errorf("%w, and the issuer couldn't be fetched: %s", err, fetchErr),
+			}
+		}
+		opts.Intermediates.AddCert(issuer)
+
+		if chains, err := verifyWithTolerance(certs[0], opts, tolerance); err == nil {
+			return chains, true, nil
+		}
+		cert = issuer
+	}
+
+	return nil, true, &tls.CertificateVerificationError{UnverifiedCertificates: certs, Err: err}
+}
+
+// fetchIssuer fetches the issuer of the certificate from the first of its
+// caIssuers URLs that responds with it
+func fetchIssuer(ctx context.Context, cert *x509.Certificate) (*x509.Certificate, error) {
+	var err error
+	for _, u := range cert.IssuingCertificateURL {
+		var issuers []*x509.Certificate
+		issuers, err = fetchCertificates(ctx, u)
+		if err != nil {
+			continue
+		}
+		for _, issuer := range issuers {
+			if cert.CheckSignatureFrom(issuer) == nil {
+				return issuer, nil
+			}
+		}
+		err = fmt.Errorf("%s doesn't contain the issuer", u)
+	}
+
+	return nil, err
+}
+
+// fetchCertificates fetches the certificates at the URL, which can be DER or
+// PEM encoded, or in a PKCS #7 bundle
+func fetchCertificates(ctx context.Context, u string) ([]*x509.Certificate, error) {
+	ctx, cancel := context.WithTimeout(ctx, aiaFetchTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating http request: %w", err)
+	}
+	req.Header.Set("User-Agent", userAgent)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("making http request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response code: %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, aiaMaxSize))
+	if err != nil {
+		return nil, fmt.Errorf("reading response body: %w", err)
+	}
+
+	if certs, err := decodeCertificates(body); err == nil && len(certs) > 0 {
+		return certs, nil
+	}
+	if cert, err := x509.ParseCertificate(body); err == nil {
+		return []*x509.Certificate{cert}, nil
+	}
+
+	return parsePKCS7Certificates(body)
+}
+
+// parsePKCS7Certificates returns the certificates in a PKCS #7 SignedData,
+// from RFC 2315
+func parsePKCS7Certificates(data []byte) ([]*x509.Certificate, error) {
+	var (
+		input       = cryptobyte.String(data)
+		contentInfo cryptobyte.String
+		contentType asn1.ObjectIdentifier
+		content     cryptobyte.String
+		signedData  cryptobyte.String
+		version     int
+		certs       cryptobyte.String
+	)
+	if !input.ReadASN1(&contentInfo, cryptobyte_asn1.SEQUENCE) ||
+		!contentInfo.ReadASN1ObjectIdentifier(&contentType) ||
+		!contentType.Equal(oidSignedData) ||
+		!contentInfo.ReadASN1(&content, cryptobyte_asn1.Tag(0).Constructed().ContextSpecific()) ||
+		!content.ReadASN1(&signedData, cryptobyte_asn1.SEQUENCE) ||
+		!signedData.ReadASN1Integer(&version) ||
+		// The digest algorithms and the content
+		!signedData.SkipASN1(cryptobyte_asn1.SET) ||
+		!signedData.SkipASN1(cryptobyte_asn1.SEQUENCE) ||
+		!signedData.ReadASN1(&certs, cryptobyte_asn1.Tag(0).Constructed().ContextSpecific()) {
+		return nil, errors.New("the response isn't a certificate")
+	}
+
+	var parsed []*x509.Certificate
+	for !certs.Empty() {
+		var der cryptobyte.String
+		if !certs.ReadASN1Element(&der, cryptobyte_asn1.SEQUENCE) {
+			return nil, errors.New("malformed PKCS #7 certificates")
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, cert)
+	}
+
+	return parsed, nil
+}
@@ -0,0 +1,205 @@
+package prober
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// typeCAA is the CAA resource record type, which dnsmessage doesn't define
+const typeCAA dnsmessage.Type = 257
+
+// caaIssuerDomains map the organizations of well known CAs to the issuer
+// domain names that they recognise in CAA records
+var caaIssuerDomains = map[string]string{
+	"Amazon":                    "amazon.com",
+	"Buypass AS-983163327":      "buypass.com",
+	"DigiCert Inc":              "digicert.com",
+	"Entrust, Inc.":             "entrust.net",
+	"GlobalSign nv-sa":          "globalsign.com",
+	"Google Trust Services":     "pki.goog",
+	"Google Trust Services LLC": "pki.goog",
+	"Let's Encrypt":             "letsencrypt.org",
+	"SSL Corporation":           "ssl.com",
+	"Sectigo Limited":           "sectigo.com",
+}
+
+// caaRecord is a CAA record, as described in RFC 8659
+type caaRecord struct {
+	flags uint8
+	tag   string
+	value string
+}
+
+// critical reports whether the issuer critical flag is set
+func (r caaRecord) critical() bool {
+	return r.flags&0x80 != 0
+}
+
+// issuerDomain returns the issuer domain name in the value of an issue or
+// issuewild property, which is empty when no CA is authorized
+func (r caaRecord) issuerDomain() string {
+	domain, _, _ := strings.Cut(r.value, ";")
+
+	return strings.ToLower(strings.TrimSpace(domain))
+}
+
+// lookupCAA returns the relevant CAA records for the host, which are those of
+// the closest name to the host, including itself, that has any. The name they
+// were found at is also returned.
+func lookupCAA(ctx context.Context, host string, opts dialOptions) (string, []caaRecord, error) {
+	name := strings.TrimSuffix(strings.ToLower(host), ".")
+	for name != "" {
+		resp, err := queryDNS(ctx, opts, name, typeCAA)
+		if err != nil {
+			return "", nil, err
+		}
+
+		var records []caaRecord
+		for _, answer := range resp.Answers {
+			body, ok := answer.Body.(*dnsmessage.UnknownResource)
+			if !ok || answer.Header.Type != typeCAA || len(body.Data) < 2 || len(body.Data) < 2+int(body.Data[1]) {
+				continue
+			}
+			records = append(records, caaRecord{
+				flags: body.Data[0],
+				tag:   strings.ToLower(string(body.Data[2 : 2+body.Data[1]])),
+				value: string(body.Data[2+body.Data[1]:]),
+			})
+		}
+		if len(records) > 0 {
+			return name, records, nil
+		}
+
+		_, name, _ = strings.Cut(name, ".")
+	}
+
+	return "", nil, nil
+}
+
+// caaAuthorizes reports whether the records authorize the CA with the issuer
+// domain to issue the certificate. The issuewild properties apply to
+// certificates with wildcard names, when there are any.
+func caaAuthorizes(records []caaRecord, issuerDomain string, cert *x509.Certificate) bool {
+	var issue, issueWild []caaRecord
+	for _, record := range records {
+		switch record.tag {
+		case "issue":
+			issue = append(issue, record)
+		case "issuewild":
+			issueWild = append(issueWild, record)
+		case "iodef":
+		default:
+			// A CA must not issue when it doesn't understand a critical
+			// property
+			if record.critical() {
+				return false
+			}
+		}
+	}
+
+	properties := issue
+	for _, name := range cert.DNSNames {
+		if strings.HasPrefix(name, "*.") && len(issueWild) > 0 {
+			properties = issueWild
+			break
+		}
+	}
+	if len(properties) == 0 {
+		return true
+	}
+
+	for _, property := range properties {
+		if domain := property.issuerDomain(); domain != "" && domain == issuerDomain {
+			return true
+		}
+	}
+
+	return false
+}
+
+// caaIssuerDomain returns the CAA issuer domain name of the CA that issued the
+// certificate, from its organization
+func caaIssuerDomain(cert *x509.Certificate, issuers map[string]string) string {
+	for _, org := range cert.Issuer.Organization {
+		if domain, ok := issuers[org]; ok {
+			return strings.ToLower(domain)
+		}
+		if domain, ok := caaIssuerDomains[org]; ok {
+			return domain
+		}
+	}
+
+	return ""
+}
+
+// collectCAAMetrics checks that the issuer of the leaf certificate presented
+// by the target at the address is authorized by its CAA records. A non
+// compliant certificate doesn't fail the probe, so that the certificate
+// metrics are still reported.
+func collectCAAMetrics(ctx context.Context, logger log.Logger, address string, cert *x509.Certificate, issuers map[string]string, opts dialOptions, registry *prometheus.Registry) {
+	var (
+		caaCompliant = prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "caa_compliant"),
+				Help: "If the issuer of the certificate presented by the target is authorized by its CAA records",
+			},
+		)
+		caaRecordInfo = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "caa_record_info"),
+				Help: "The CAA records that apply to the target",
+			},
+			[]string{"domain", "flags", "tag", "value"},
+		)
+	)
+	registry.MustRegister(caaCompliant, caaRecordInfo)
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		level.Error(logger).Log("msg", fmt.Sprintf("Error checking CAA records: %s", err))
+		return
+	}
+	if net.ParseIP(host) != nil {
+		level.Error(logger).Log("msg", fmt.Sprintf("Error checking CAA records: the target %s isn't a host name", host))
+		return
+	}
+	host, err = toASCIIHost(host)
+	if err != nil {
+		level.Error(logger).Log("msg", fmt.Sprintf("Error checking CAA records: %s", err))
+		return
+	}
+
+	domain, records, err := lookupCAA(ctx, host, opts)
+	if err != nil {
+		level.Error(logger).Log("msg", fmt.Sprintf("Error looking up CAA records: %s", err))
+		return
+	}
+	for _, record := range records {
+		caaRecordInfo.WithLabelValues(domain, strconv.Itoa(int(record.flags)), record.tag, record.value).Set(1)
+	}
+
+	// Any CA can issue certificates for names without CAA records
+	if len(records) == 0 {
+		caaCompliant.Set(1)
+		return
+	}
+
+	issuer := caaIssuerDomain(cert, issuers)
+	if issuer == "" {
+		level.Error(logger).Log("msg", fmt.Sprintf("The CAA issuer domain of %q is unknown, add it to caa_issuers", cert.Issuer.String()))
+		return
+	}
+
+	if caaAuthorizes(records, issuer, cert) {
+		caaCompliant.Set(1)
+	}
+}
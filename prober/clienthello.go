@@ -0,0 +1,151 @@
+package prober
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"net"
+
+	"github.com/ribbybibby/ssl_exporter/v2/config"
+)
+
+const (
+	handshakeTypeClientHello = 1
+
+	compressionNone    = 0
+	compressionDeflate = 1
+
+	// fallbackSCSV is the cipher suite value that signals that the client is
+	// retrying with a lower version, from RFC 7507
+	fallbackSCSV = 0x5600
+
+	alertInappropriateFallback = 86
+
+	extensionServerName          = 0
+	extensionSupportedGroups     = 10
+	extensionECPointFormats      = 11
+	extensionSignatureAlgorithms = 13
+)
+
+// clientHelloGroups are the key exchange groups offered by a clientHello
+var clientHelloGroups = []uint16{29, 23, 24, 25}
+
+// clientHelloSignatureAlgorithms are the signature algorithms offered by a
+// clientHello
+var clientHelloSignatureAlgorithms = []uint16{
+	0x0804, 0x0805, 0x0806, // rsa_pss_rsae
+	0x0403, 0x0503, 0x0603, // ecdsa
+	0x0807,                 // ed25519
+	0x0401, 0x0501, 0x0601, // rsa_pkcs1
+	0x0201, 0x0203, // sha1
+}
+
+// clientHello is a TLS 1.0-1.2 ClientHello for the diagnostic handshakes that
+// crypto/tls can't make
+type clientHello struct {
+	version            uint16
+	cipherSuites       []uint16
+	compressionMethods []uint8
+	serverName         string
+}
+
+// marshal returns the ClientHello in a handshake record
+func (h *clientHello) marshal() []byte {
+	random := make([]byte, 32)
+	rand.Read(random)
+
+	body := binary.BigEndian.AppendUint16(nil, h.version)
+	body = append(body, random...)
+	// There isn't a session ID
+	body = append(body, 0)
+	body = binary.BigEndian.AppendUint16(body, uint16(2*len(h.cipherSuites)))
+	for _, id := range h.cipherSuites {
+		body = binary.BigEndian.AppendUint16(body, id)
+	}
+	body = append(body, uint8(len(h.compressionMethods)))
+	body = append(body, h.compressionMethods...)
+
+	var extensions []byte
+	// IP addresses aren't sent as the server name
+	if h.serverName != "" && net.ParseIP(h.serverName) == nil {
+		name := append([]byte{0}, binary.BigEndian.AppendUint16(nil, uint16(len(h.serverName)))...)
+		name = append(name, h.serverName...)
+		extensions = appendExtension(extensions, extensionServerName, binary.BigEndian.AppendUint16(nil, uint16(len(name))), name)
+	}
+	extensions = appendExtension(extensions, extensionSupportedGroups, uint16List(clientHelloGroups))
+	extensions = appendExtension(extensions, extensionECPointFormats, []byte{1, 0})
+	extensions = appendExtension(extensions, extensionSignatureAlgorithms, uint16List(clientHelloSignatureAlgorithms))
+	extensions = appendExtension(extensions, extensionRenegotiationInfo, []byte{0})
+	body = binary.BigEndian.AppendUint16(body, uint16(len(extensions)))
+	body = append(body, extensions...)
+
+	message := []byte{handshakeTypeClientHello, byte(len(body) >> 16), byte(len(body) >> 8), byte(len(body))}
+	message = append(message, body...)
+
+	record := []byte{recordTypeHandshake, 0x03, 0x01}
+	record = binary.BigEndian.AppendUint16(record, uint16(len(message)))
+
+	return append(record, message...)
+}
+
+// appendExtension appends an extension with the given data to b
+func appendExtension(b []byte, extension uint16, data ...[]byte) []byte {
+	var length int
+	for _, d := range data {
+		length += len(d)
+	}
+	b = binary.BigEndian.AppendUint16(b, extension)
+	b = binary.BigEndian.AppendUint16(b, uint16(length))
+	for _, d := range data {
+		b = append(b, d...)
+	}
+
+	return b
+}
+
+// uint16List returns a list of uint16 values prefixed by its length
+func uint16List(values []uint16) []byte {
+	b := binary.BigEndian.AppendUint16(nil, uint16(2*len(values)))
+	for _, v := range values {
+		b = binary.BigEndian.AppendUint16(b, v)
+	}
+
+	return b
+}
+
+// sendClientHello sends the ClientHello over a new connection to the address
+// and returns the ServerHello, which is nil when the server rejects the
+// ClientHello, and the alert that it was rejected with. An error is only
+// returned when the target can't be reached.
+func sendClientHello(ctx context.Context, network, address string, hello *clientHello, module config.Module, opts dialOptions) (*serverHello, uint8, error) {
+	connect := newPhase(ctx, "connect", module.Timeouts.Connect)
+	dialCtx, cancel := connect.context(ctx)
+	conn, err := dialContext(dialCtx, network, address, opts)
+	cancel()
+	if err != nil {
+		return nil, 0, connect.err(err)
+	}
+	defer conn.Close()
+
+	handshake := newPhase(ctx, "handshake", module.Timeouts.Handshake)
+	if err := conn.SetDeadline(handshake.deadline); err != nil {
+		return nil, 0, err
+	}
+
+	if _, err := conn.Write(hello.marshal()); err != nil {
+		return nil, 0, nil
+	}
+
+	recorder := &helloConn{Conn: conn}
+	buf := make([]byte, 4096)
+	for !recorder.finished() {
+		if _, err := recorder.Read(buf); err != nil {
+			if ctx.Err() != nil {
+				return nil, 0, ctx.Err()
+			}
+			break
+		}
+	}
+
+	return recorder.serverHello(), recorder.serverAlert(), nil
+}
@@ -0,0 +1,213 @@
+package prober
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/ribbybibby/ssl_exporter/v2/config"
+)
+
+// defaultCTSearchURL is the CT search API that is used by default
+const defaultCTSearchURL = "https://crt.sh/"
+
+// ctSearchTimeLayout is the layout of the times returned by the search API,
+// which are in UTC
+const ctSearchTimeLayout = "2006-01-02T15:04:05"
+
+// ctSearchResult is a certificate returned by the search API
+type ctSearchResult struct {
+	ID             int64  `json:"id"`
+	IssuerName     string `json:"issuer_name"`
+	CommonName     string `json:"common_name"`
+	NameValue      string `json:"name_value"`
+	SerialNumber   string `json:"serial_number"`
+	EntryTimestamp string `json:"entry_timestamp"`
+	NotBefore      string `json:"not_before"`
+	NotAfter       string `json:"not_after"`
+}
+
+// issuerCN returns the common name in the issuer name
+func (r ctSearchResult) issuerCN() string {
+	for _, attr := range strings.Split(r.IssuerName, ", ") {
+		if cn, ok := strings.CutPrefix(attr, "CN="); ok {
+			return cn
+		}
+	}
+
+	return ""
+}
+
+// serialNo returns the serial number in decimal, like the serial_no label of
+// the other certificate metrics
+func (r ctSearchResult) serialNo() string {
+	serial, ok := new(big.Int).SetString(r.SerialNumber, 16)
+	if !ok {
+		return r.SerialNumber
+	}
+
+	return serial.String()
+}
+
+// dnsNames returns the names of the certificate in the same format as the
+// dnsnames label of the other certificate metrics
+func (r ctSearchResult) dnsNames() string {
+	var names []string
+	for _, name := range strings.Split(r.NameValue, "\n") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+
+	return "," + strings.Join(names, ",") + ","
+}
+
+// ProbeCT searches certificate transparency logs for the certificates issued
+// for the target, which is a domain name or the SHA-256 fingerprint of a
+// certificate
+func ProbeCT(ctx context.Context, logger log.Logger, target string, module config.Module, registry *prometheus.Registry) error {
+	searchURL, err := url.Parse(defaultCTSearchURL)
+	if err != nil {
+		return err
+	}
+	if module.CT.URL.URL != nil {
+		searchURL = module.CT.URL.URL
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if module.CT.ProxyURL.URL != nil {
+		proxy = http.ProxyURL(module.CT.ProxyURL.URL)
+	}
+
+	tlsConfig, err := config.NewTLSConfig(&module.TLSConfig)
+	if err != nil {
+		return fmt.Errorf("creating TLS config: %w", err)
+	}
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig:   tlsConfig,
+			Proxy:             proxy,
+			DisableKeepAlives: true,
+		},
+	}
+
+	query := url.Values{}
+	query.Set("q", strings.ReplaceAll(target, ":", ""))
+	query.Set("output", "json")
+	// The precertificate and the certificate are returned once
+	query.Set("deduplicate", "Y")
+	if !module.CT.IncludeExpired {
+		query.Set("exclude", "expired")
+	}
+	reqURL := *searchURL
+	reqURL.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL.String(), nil)
+	if err != nil {
+		return fmt.Errorf("creating http request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", userAgent)
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("making http request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected response code: %d", resp.StatusCode)
+	}
+
+	var results []ctSearchResult
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		return fmt.Errorf("decoding response body: %w", err)
+	}
+
+	return collectCTMetrics(results, registry)
+}
+
+// collectCTMetrics collects metrics for the certificates found in the logs
+func collectCTMetrics(results []ctSearchResult, registry *prometheus.Registry) error {
+	var (
+		certificates = prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "ct_certificates"),
+				Help: "The number of certificates found in the certificate transparency logs",
+			},
+		)
+		issuerCertificates = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "ct_issuer_certificates"),
+				Help: "The number of certificates found in the certificate transparency logs by issuer",
+			},
+			[]string{"issuer"},
+		)
+		notAfter = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "ct_cert_not_after"),
+				Help: "NotAfter expressed as a Unix Epoch Time for a certificate found in the certificate transparency logs",
+			},
+			[]string{"id", "serial_no", "issuer_cn", "cn", "dnsnames"},
+		)
+		notBefore = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "ct_cert_not_before"),
+				Help: "NotBefore expressed as a Unix Epoch Time for a certificate found in the certificate transparency logs",
+			},
+			[]string{"id", "serial_no", "issuer_cn", "cn", "dnsnames"},
+		)
+		loggedAt = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "ct_cert_logged_at"),
+				Help: "The time that a certificate was first logged expressed as a Unix Epoch Time",
+			},
+			[]string{"id", "serial_no", "issuer_cn", "cn", "dnsnames"},
+		)
+	)
+	registry.MustRegister(certificates, issuerCertificates, notAfter, notBefore, loggedAt)
+
+	certificates.Set(float64(len(results)))
+
+	for _, result := range results {
+		issuerCertificates.WithLabelValues(result.IssuerName).Inc()
+
+		labels := []string{
+			strconv.FormatInt(result.ID, 10),
+			result.serialNo(),
+			result.issuerCN(),
+			result.CommonName,
+			result.dnsNames(),
+		}
+		for _, m := range []struct {
+			gauge *prometheus.GaugeVec
+			value string
+		}{
+			{notAfter, result.NotAfter},
+			{notBefore, result.NotBefore},
+			{loggedAt, result.EntryTimestamp},
+		} {
+			if m.value == "" {
+				continue
+			}
+			t, err := time.Parse(ctSearchTimeLayout, m.value)
+			if err != nil {
+				return fmt.Errorf("parsing the times of certificate %d: %w", result.ID, err)
+			}
+			m.gauge.WithLabelValues(labels...).Set(float64(t.Unix()))
+		}
+	}
+
+	return nil
+}
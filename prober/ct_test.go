@@ -0,0 +1,139 @@
+package prober
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/ribbybibby/ssl_exporter/v2/config"
+)
+
+// TestProbeCT tests the metrics returned by a ct probe
+func TestProbeCT(t *testing.T) {
+	var query url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[
+			{
+				"issuer_ca_id": 183267,
+				"issuer_name": "C=US, O=Let's Encrypt, CN=R3",
+				"common_name": "example.com",
+				"name_value": "example.com\nwww.example.com",
+				"id": 1234,
+				"entry_timestamp": "2024-01-02T03:04:05.678",
+				"not_before": "2024-01-02T00:00:00",
+				"not_after": "2024-04-01T23:59:59",
+				"serial_number": "03a15c2e"
+			},
+			{
+				"issuer_ca_id": 1,
+				"issuer_name": "C=US, O=Example Corp, CN=Example CA",
+				"common_name": "example.com",
+				"name_value": "example.com",
+				"id": 5678,
+				"entry_timestamp": "2024-02-02T03:04:05",
+				"not_before": "2024-02-02T00:00:00",
+				"not_after": "2025-02-01T23:59:59",
+				"serial_number": "01"
+			}
+		]`))
+	}))
+	defer server.Close()
+
+	serverURL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	module := config.Module{
+		CT: config.CTProbe{
+			URL: config.URL{URL: serverURL},
+		},
+	}
+
+	registry := prometheus.NewRegistry()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeCT(ctx, newTestLogger(), "example.com", module, registry); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	if query.Get("q") != "example.com" || query.Get("output") != "json" || query.Get("exclude") != "expired" {
+		t.Errorf("unexpected query: %s", query.Encode())
+	}
+
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	labels := map[string]string{
+		"id":        "1234",
+		"serial_no": "60906542",
+		"issuer_cn": "R3",
+		"cn":        "example.com",
+		"dnsnames":  ",example.com,www.example.com,",
+	}
+	expectedResults := []*registryResult{
+		&registryResult{
+			Name:  "ssl_ct_certificates",
+			Value: 2,
+		},
+		&registryResult{
+			Name:        "ssl_ct_issuer_certificates",
+			LabelValues: map[string]string{"issuer": "C=US, O=Example Corp, CN=Example CA"},
+			Value:       1,
+		},
+		&registryResult{
+			Name:        "ssl_ct_cert_not_after",
+			LabelValues: labels,
+			Value:       float64(time.Date(2024, 4, 1, 23, 59, 59, 0, time.UTC).Unix()),
+		},
+		&registryResult{
+			Name:        "ssl_ct_cert_not_before",
+			LabelValues: labels,
+			Value:       float64(time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC).Unix()),
+		},
+		&registryResult{
+			Name:        "ssl_ct_cert_logged_at",
+			LabelValues: labels,
+			Value:       float64(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC).Unix()),
+		},
+	}
+	checkRegistryResults(expectedResults, mfs, t)
+}
+
+// TestProbeCTUnavailable tests that the probe fails when the search API
+// returns an error
+func TestProbeCTUnavailable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	serverURL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	module := config.Module{
+		CT: config.CTProbe{
+			URL: config.URL{URL: serverURL},
+		},
+	}
+
+	registry := prometheus.NewRegistry()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeCT(ctx, newTestLogger(), "example.com", module, registry); err == nil {
+		t.Fatalf("expected error but err was nil")
+	}
+}
@@ -0,0 +1,15 @@
+//go:build go1.25
+
+package prober
+
+import "crypto/tls"
+
+// curveSupported reports whether the key exchange group is available in the
+// connection state
+const curveSupported = true
+
+// curveID returns the key exchange group negotiated for the connection, which
+// is zero for a legacy RSA key exchange
+func curveID(state tls.ConnectionState) tls.CurveID {
+	return state.CurveID
+}
@@ -0,0 +1,15 @@
+//go:build !go1.25
+
+package prober
+
+import "crypto/tls"
+
+// curveSupported reports whether the key exchange group is available in the
+// connection state
+const curveSupported = false
+
+// curveID returns zero, because the key exchange group is only exposed by the
+// connection state from go 1.25
+func curveID(state tls.ConnectionState) tls.CurveID {
+	return 0
+}
@@ -0,0 +1,215 @@
+package prober
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// typeTLSA is the TLSA resource record type, which dnsmessage doesn't define
+const typeTLSA dnsmessage.Type = 52
+
+var (
+	// These are the mnemonics for the fields of TLSA records from RFC 7218
+	tlsaUsages        = []string{"PKIX-TA", "PKIX-EE", "DANE-TA", "DANE-EE"}
+	tlsaSelectors     = []string{"Cert", "SPKI"}
+	tlsaMatchingTypes = []string{"Full", "SHA2-256", "SHA2-512"}
+)
+
+// tlsaRecord is a TLSA record, as described in RFC 6698
+type tlsaRecord struct {
+	usage        uint8
+	selector     uint8
+	matchingType uint8
+	data         []byte
+}
+
+// labelValues returns the mnemonics of the usage, selector and matching type
+// of the record, or their numbers when they aren't known
+func (r tlsaRecord) labelValues() []string {
+	mnemonic := func(names []string, v uint8) string {
+		if int(v) < len(names) {
+			return names[v]
+		}
+		return strconv.Itoa(int(v))
+	}
+
+	return []string{
+		mnemonic(tlsaUsages, r.usage),
+		mnemonic(tlsaSelectors, r.selector),
+		mnemonic(tlsaMatchingTypes, r.matchingType),
+	}
+}
+
+// matches reports whether the certificate is associated with the record
+func (r tlsaRecord) matches(cert *x509.Certificate) bool {
+	var data []byte
+	switch r.selector {
+	case 0:
+		data = cert.Raw
+	case 1:
+		data = cert.RawSubjectPublicKeyInfo
+	default:
+		return false
+	}
+
+	switch r.matchingType {
+	case 0:
+	case 1:
+		sum := sha256.Sum256(data)
+		data = sum[:]
+	case 2:
+		sum := sha512.Sum512(data)
+		data = sum[:]
+	default:
+		return false
+	}
+
+	return bytes.Equal(data, r.data)
+}
+
+// validates reports whether the record validates the connection, following
+// the rules for each usage in RFC 7671
+func (r tlsaRecord) validates(serverName string, state tls.ConnectionState) bool {
+	if len(state.PeerCertificates) == 0 {
+		return false
+	}
+	leaf := state.PeerCertificates[0]
+
+	switch r.usage {
+	case 0:
+		// A CA in a chain that was verified against the trusted roots
+		for _, chain := range state.VerifiedChains {
+			for _, cert := range chain[1:] {
+				if r.matches(cert) {
+					return true
+				}
+			}
+		}
+	case 1:
+		// The leaf, which must also be verified against the trusted roots
+		return len(state.VerifiedChains) > 0 && r.matches(leaf)
+	case 2:
+		// A CA presented by the server that the leaf chains to
+		intermediates := x509.NewCertPool()
+		for _, cert := range state.PeerCertificates[1:] {
+			intermediates.AddCert(cert)
+		}
+		for _, cert := range state.PeerCertificates[1:] {
+			if !r.matches(cert) {
+				continue
+			}
+			roots := x509.NewCertPool()
+			roots.AddCert(cert)
+			if _, err := leaf.Verify(x509.VerifyOptions{
+				DNSName:       serverName,
+				Roots:         roots,
+				Intermediates: intermediates,
+			}); err == nil {
+				return true
+			}
+		}
+	case 3:
+		// The leaf, without any other checks
+		return r.matches(leaf)
+	}
+
+	return false
+}
+
+// lookupTLSA returns the TLSA records of the TLS service at the host and port,
+// and whether the resolver authenticated them with DNSSEC
+func lookupTLSA(ctx context.Context, host, port string, opts dialOptions) ([]tlsaRecord, bool, error) {
+	resp, err := queryDNS(ctx, opts, fmt.Sprintf("_%s._tcp.%s", port, host), typeTLSA)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var records []tlsaRecord
+	for _, answer := range resp.Answers {
+		body, ok := answer.Body.(*dnsmessage.UnknownResource)
+		if !ok || answer.Header.Type != typeTLSA || len(body.Data) < 3 {
+			continue
+		}
+		records = append(records, tlsaRecord{
+			usage:        body.Data[0],
+			selector:     body.Data[1],
+			matchingType: body.Data[2],
+			data:         body.Data[3:],
+		})
+	}
+
+	return records, resp.AuthenticData, nil
+}
+
+// collectDANEMetrics validates the connection to the address against its TLSA
+// records. An invalid connection doesn't fail the probe, so that the
+// certificate metrics are still reported.
+func collectDANEMetrics(ctx context.Context, logger log.Logger, address, serverName string, state tls.ConnectionState, opts dialOptions, registry *prometheus.Registry) {
+	var (
+		daneValid = prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "dane_valid"),
+				Help: "If the certificates presented by the target match its TLSA records",
+			},
+		)
+		daneMatch = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "dane_match_info"),
+				Help: "The usage, selector and matching type of the TLSA records that match the certificates presented by the target",
+			},
+			[]string{"usage", "selector", "matching_type"},
+		)
+	)
+	registry.MustRegister(daneValid, daneMatch)
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		level.Error(logger).Log("msg", fmt.Sprintf("Error validating DANE: %s", err))
+		return
+	}
+	if net.ParseIP(host) != nil {
+		level.Error(logger).Log("msg", fmt.Sprintf("Error validating DANE: the target %s isn't a host name", host))
+		return
+	}
+	host, err = toASCIIHost(host)
+	if err != nil {
+		level.Error(logger).Log("msg", fmt.Sprintf("Error validating DANE: %s", err))
+		return
+	}
+
+	records, authenticated, err := lookupTLSA(ctx, host, port, opts)
+	if err != nil {
+		level.Error(logger).Log("msg", fmt.Sprintf("Error looking up TLSA records: %s", err))
+		return
+	}
+	if opts.dnssec && !authenticated {
+		level.Error(logger).Log("msg", fmt.Sprintf("The TLSA records for %s aren't authenticated with DNSSEC", address))
+		return
+	}
+	if len(records) == 0 {
+		level.Error(logger).Log("msg", fmt.Sprintf("There aren't any TLSA records for %s", address))
+		return
+	}
+
+	if serverName == "" {
+		serverName = host
+	}
+	for _, record := range records {
+		if record.validates(serverName, state) {
+			daneValid.Set(1)
+			daneMatch.WithLabelValues(record.labelValues()...).Set(1)
+		}
+	}
+}
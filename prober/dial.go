@@ -0,0 +1,639 @@
+package prober
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/ribbybibby/ssl_exporter/v2/config"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"golang.org/x/net/proxy"
+)
+
+// proxyProtocolV2Signature starts every PROXY protocol version 2 header
+var proxyProtocolV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// dialOptions configure how dialContext connects to a target
+type dialOptions struct {
+	// proxyURL is a HTTP proxy that the connection is tunnelled through with
+	// the CONNECT method
+	proxyURL *url.URL
+	// proxyBasicAuth are the credentials sent to the HTTP proxy. The
+	// credentials in proxyURL are used when they aren't set.
+	proxyBasicAuth config.BasicAuth
+	// socks5Proxy is a SOCKS5 proxy that the connection is made through
+	socks5Proxy config.SOCKS5Proxy
+	// sshTunnel is an SSH server that the connection is tunnelled through
+	sshTunnel config.SSHTunnel
+	// proxyProtocol is the version of the PROXY protocol header that is sent
+	// to the target, if any
+	proxyProtocol string
+	// outerTLSConfig configures a TLS session with a gateway that the probe
+	// is performed inside, if any
+	outerTLSConfig *config.TLSConfig
+	// sourceIPAddress and sourceInterface bind outgoing connections to a
+	// local address or network interface
+	sourceIPAddress string
+	sourceInterface string
+	// preferredIPProtocol restricts direct connections to ip4 or ip6
+	// addresses, unless ipProtocolFallback allows the other protocol when
+	// there aren't any
+	preferredIPProtocol string
+	ipProtocolFallback  bool
+	// resolver is the address of a DNS server that host names are resolved
+	// with, instead of the system resolver
+	resolver          string
+	resolverTLSConfig config.TLSConfig
+	// dnssec only allows connections to the addresses of host names that the
+	// resolver authenticated with DNSSEC
+	dnssec bool
+	// trace records the details of the connection, if it's set
+	trace *dialTrace
+}
+
+// dialTrace records how a connection was made, so that it can be reported by
+// the prober once it's done dialing
+type dialTrace struct {
+	// resolved is true when the target host name was looked up
+	resolved      bool
+	resolver      string
+	dnsLookupTime time.Duration
+	// dnssec is true when the host name was looked up with DNSSEC and
+	// dnssecValid reports whether the records were authenticated
+	dnssec      bool
+	dnssecValid bool
+}
+
+// newDialOptions returns the dial options that are configured for the whole
+// module
+func newDialOptions(module config.Module) dialOptions {
+	opts := dialOptions{
+		sshTunnel:           module.SSHTunnel,
+		outerTLSConfig:      module.OuterTLSConfig,
+		sourceIPAddress:     module.SourceIPAddress,
+		sourceInterface:     module.SourceInterface,
+		preferredIPProtocol: module.PreferredIPProtocol,
+		ipProtocolFallback:  true,
+		resolver:            module.Resolver,
+		resolverTLSConfig:   module.ResolverTLSConfig,
+		dnssec:              module.DNSSEC,
+	}
+	if module.IPProtocolFallback != nil {
+		opts.ipProtocolFallback = *module.IPProtocolFallback
+	}
+
+	return opts
+}
+
+// dialContext connects to the address, through a proxy if one is configured,
+// and sends the PROXY protocol header when a version is given, so that
+// targets behind load balancers that require it can be probed directly
+func dialContext(ctx context.Context, network, address string, opts dialOptions) (net.Conn, error) {
+	if network != "unix" {
+		var err error
+		address, err = toASCIIAddress(address)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	resolver, err := newResolver(opts.resolver, &opts.resolverTLSConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer, err := newNetDialer(opts.sourceIPAddress, opts.sourceInterface, resolver)
+	if err != nil {
+		return nil, err
+	}
+
+	var conn net.Conn
+	switch {
+	case opts.proxyURL != nil:
+		conn, err = dialHTTPProxy(ctx, dialer, network, address, opts.proxyURL, opts.proxyBasicAuth)
+	case opts.socks5Proxy.Address != "":
+		conn, err = dialSOCKS5Proxy(ctx, dialer, network, address, opts.socks5Proxy)
+	case opts.sshTunnel.Host != "":
+		conn, err = dialSSHTunnel(ctx, dialer, network, address, opts.sshTunnel)
+	default:
+		conn, err = dialDirect(ctx, dialer, network, address, opts)
+		// The header describes the connection to the target, so it's
+		// only sent when the target is dialled directly
+		if err == nil && opts.proxyProtocol != "" {
+			if err = writeProxyProtocolHeader(conn, opts.proxyProtocol); err != nil {
+				conn.Close()
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.outerTLSConfig != nil {
+		conn, err = outerTLSHandshake(ctx, conn, address, opts.outerTLSConfig)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+// dialDirect resolves the host in the address and connects to one of its
+// addresses, restricted to the preferred IP protocol
+func dialDirect(ctx context.Context, dialer *net.Dialer, network, address string, opts dialOptions) (net.Conn, error) {
+	if err := validateIPProtocol(opts.preferredIPProtocol); err != nil {
+		return nil, err
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if network == "unix" || err != nil || net.ParseIP(host) != nil {
+		return dialer.DialContext(ctx, network, address)
+	}
+
+	ips, err := lookupIPAddr(ctx, dialer.Resolver, host, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	ips, err = filterIPProtocol(host, ips, opts.preferredIPProtocol, opts.ipProtocolFallback)
+	if err != nil {
+		return nil, err
+	}
+
+	return dialParallel(ctx, dialer, network, ips, port)
+}
+
+// listenUDP returns a socket that is bound to the source address and interface
+// of the options, and the address of the target, which is looked up with the
+// resolver and IP protocol of the options, for the probers whose protocols are
+// carried over UDP
+func listenUDP(ctx context.Context, address string, opts dialOptions) (net.PacketConn, *net.UDPAddr, error) {
+	if err := validateIPProtocol(opts.preferredIPProtocol); err != nil {
+		return nil, nil, err
+	}
+
+	address, err := toASCIIAddress(address)
+	if err != nil {
+		return nil, nil, err
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		resolver, err := newResolver(opts.resolver, &opts.resolverTLSConfig)
+		if err != nil {
+			return nil, nil, err
+		}
+		ips, err := lookupIPAddr(ctx, resolver, host, opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		ips, err = filterIPProtocol(host, ips, opts.preferredIPProtocol, opts.ipProtocolFallback)
+		if err != nil {
+			return nil, nil, err
+		}
+		ip = ips[0].IP
+	}
+	raddr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(ip.String(), port))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The socket is bound to the IP protocol of the target, which the
+	// source address must match
+	network := "udp6"
+	if raddr.IP.To4() != nil {
+		network = "udp4"
+	}
+	var local string
+	if opts.sourceIPAddress != "" {
+		sourceIP := net.ParseIP(opts.sourceIPAddress)
+		if sourceIP == nil {
+			return nil, nil, fmt.Errorf("invalid source IP address %s", opts.sourceIPAddress)
+		}
+		local = sourceIP.String()
+	}
+	lc := net.ListenConfig{}
+	if opts.sourceInterface != "" {
+		lc.Control = bindToInterface(opts.sourceInterface)
+	}
+	conn, err := lc.ListenPacket(ctx, network, net.JoinHostPort(local, "0"))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return conn, raddr, nil
+}
+
+// dialParallel connects to the first of the addresses that accepts a
+// connection. When there are addresses of both IP protocols, the protocol of
+// the first address is tried first and the other protocol is raced against
+// it after the fallback delay, as described in RFC 8305.
+func dialParallel(ctx context.Context, dialer *net.Dialer, network string, ips []net.IPAddr, port string) (net.Conn, error) {
+	var primaries, fallbacks []net.IPAddr
+	for _, ip := range ips {
+		if (ip.IP.To4() != nil) == (ips[0].IP.To4() != nil) {
+			primaries = append(primaries, ip)
+		} else {
+			fallbacks = append(fallbacks, ip)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type dialResult struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan dialResult, 2)
+	dialSerial := func(ips []net.IPAddr) {
+		var err error
+		for _, ip := range ips {
+			var conn net.Conn
+			conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+			if err == nil {
+				results <- dialResult{conn: conn}
+				return
+			}
+		}
+		results <- dialResult{err: err}
+	}
+
+	go dialSerial(primaries)
+	pending := 1
+
+	startFallbacks := func() {
+		go dialSerial(fallbacks)
+		fallbacks = nil
+		pending++
+	}
+
+	var fallbackTimer <-chan time.Time
+	if len(fallbacks) > 0 {
+		timer := time.NewTimer(dialer.FallbackDelay)
+		defer timer.Stop()
+		fallbackTimer = timer.C
+	}
+
+	var firstErr error
+	for {
+		select {
+		case <-fallbackTimer:
+			fallbackTimer = nil
+			startFallbacks()
+		case res := <-results:
+			pending--
+			if res.err == nil {
+				// Close the connection of the other protocol if it
+				// also succeeds
+				go func(pending int) {
+					for i := 0; i < pending; i++ {
+						if res := <-results; res.conn != nil {
+							res.conn.Close()
+						}
+					}
+				}(pending)
+				return res.conn, nil
+			}
+			if firstErr == nil {
+				firstErr = res.err
+			}
+			if len(fallbacks) > 0 {
+				// Don't wait for the fallback delay when the
+				// primary protocol has already failed
+				fallbackTimer = nil
+				startFallbacks()
+			} else if pending == 0 {
+				return nil, firstErr
+			}
+		}
+	}
+}
+
+// newNetDialer returns a dialer that binds connections to the source IP
+// address and network interface and resolves host names with the resolver,
+// when they're set
+func newNetDialer(sourceIPAddress, sourceInterface string, resolver *net.Resolver) (*net.Dialer, error) {
+	// When a host has both IPv4 and IPv6 addresses, connections to each
+	// family are raced with the delay recommended by RFC 8305
+	dialer := &net.Dialer{
+		FallbackDelay: 250 * time.Millisecond,
+	}
+
+	if sourceIPAddress != "" {
+		ip := net.ParseIP(sourceIPAddress)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid source IP address %s", sourceIPAddress)
+		}
+		dialer.LocalAddr = &net.TCPAddr{IP: ip}
+	}
+
+	if sourceInterface != "" {
+		dialer.Control = bindToInterface(sourceInterface)
+	}
+
+	dialer.Resolver = resolver
+
+	return dialer, nil
+}
+
+// outerTLSHandshake establishes the outer TLS session with a gateway that
+// unwraps it and forwards the inner session to the target. The gateway's
+// certificate isn't reported.
+func outerTLSHandshake(ctx context.Context, conn net.Conn, address string, cfg *config.TLSConfig) (net.Conn, error) {
+	tlsConfig, err := config.NewTLSConfig(cfg)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if tlsConfig.ServerName == "" {
+		// Unix socket addresses don't have a host
+		if host, _, err := net.SplitHostPort(address); err == nil {
+			tlsConfig.ServerName = host
+		}
+	}
+
+	tlsConn := tls.Client(conn, tlsConfig)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("outer TLS handshake: %w", err)
+	}
+
+	return tlsConn, nil
+}
+
+// dialHTTPProxy connects to the address through a HTTP proxy with the
+// CONNECT method
+func dialHTTPProxy(ctx context.Context, dialer *net.Dialer, network, address string, proxyURL *url.URL, basicAuth config.BasicAuth) (net.Conn, error) {
+	proxyAddr := proxyURL.Host
+	if proxyURL.Port() == "" {
+		port := "80"
+		if proxyURL.Scheme == "https" {
+			port = "443"
+		}
+		proxyAddr = net.JoinHostPort(proxyURL.Hostname(), port)
+	}
+
+	var (
+		conn net.Conn
+		err  error
+	)
+	switch proxyURL.Scheme {
+	case "http":
+		conn, err = dialer.DialContext(ctx, network, proxyAddr)
+	case "https":
+		tlsDialer := &tls.Dialer{
+			NetDialer: dialer,
+			Config:    &tls.Config{ServerName: proxyURL.Hostname()},
+		}
+		conn, err = tlsDialer.DialContext(ctx, network, proxyAddr)
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %s", proxyURL.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("Error setting deadline")
+		}
+	}
+
+	request := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: http.Header{},
+	}
+	request.Header.Set("User-Agent", userAgent)
+
+	username, password := proxyURL.User.Username(), ""
+	if p, ok := proxyURL.User.Password(); ok {
+		password = p
+	}
+	if basicAuth.Username != "" {
+		username = basicAuth.Username
+		password, err = readPasswordFile(basicAuth.PasswordFile)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if username != "" {
+		request.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(username+":"+password)))
+	}
+
+	if err := request.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, request)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	// The body of a successful response is the tunnel, so it's only closed
+	// when the proxy refuses the connection
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		conn.Close()
+		return nil, fmt.Errorf("proxy CONNECT to %s failed: %s", address, resp.Status)
+	}
+
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("Error setting deadline")
+	}
+
+	return &bufferedConn{Conn: conn, reader: reader}, nil
+}
+
+// dialSOCKS5Proxy connects to the address through a SOCKS5 proxy
+func dialSOCKS5Proxy(ctx context.Context, dialer *net.Dialer, network, address string, cfg config.SOCKS5Proxy) (net.Conn, error) {
+	var auth *proxy.Auth
+	if cfg.Username != "" {
+		password, err := readPasswordFile(cfg.PasswordFile)
+		if err != nil {
+			return nil, err
+		}
+		auth = &proxy.Auth{
+			User:     cfg.Username,
+			Password: password,
+		}
+	}
+
+	socks5Dialer, err := proxy.SOCKS5("tcp", cfg.Address, auth, dialer)
+	if err != nil {
+		return nil, err
+	}
+
+	return socks5Dialer.(proxy.ContextDialer).DialContext(ctx, network, address)
+}
+
+// dialSSHTunnel connects to the address through an SSH server. The SSH
+// connection is closed with the returned connection.
+func dialSSHTunnel(ctx context.Context, dialer *net.Dialer, network, address string, cfg config.SSHTunnel) (net.Conn, error) {
+	key, err := os.ReadFile(cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading ssh key file: %w", err)
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("parsing ssh key file: %w", err)
+	}
+
+	var hostKeyCallback ssh.HostKeyCallback
+	switch {
+	case cfg.KnownHostsFile != "":
+		hostKeyCallback, err = knownhosts.New(cfg.KnownHostsFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading known hosts file: %w", err)
+		}
+	case cfg.InsecureSkipVerify:
+		hostKeyCallback = ssh.InsecureIgnoreHostKey()
+	default:
+		return nil, fmt.Errorf("a known hosts file is required to verify the ssh tunnel host key")
+	}
+
+	host := cfg.Host
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, "22")
+	}
+
+	sshConn, err := dialer.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return nil, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := sshConn.SetDeadline(deadline); err != nil {
+			sshConn.Close()
+			return nil, fmt.Errorf("Error setting deadline")
+		}
+	}
+
+	c, chans, reqs, err := ssh.NewClientConn(sshConn, host, &ssh.ClientConfig{
+		User:            cfg.User,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hostKeyCallback,
+	})
+	if err != nil {
+		sshConn.Close()
+		return nil, fmt.Errorf("ssh tunnel: %w", err)
+	}
+	client := ssh.NewClient(c, chans, reqs)
+
+	conn, err := client.DialContext(ctx, network, address)
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("ssh tunnel: %w", err)
+	}
+
+	return &sshTunnelConn{Conn: conn, client: client, tunnel: sshConn}, nil
+}
+
+// sshTunnelConn is a connection through an SSH tunnel that closes the tunnel
+// when it's closed
+type sshTunnelConn struct {
+	net.Conn
+	client *ssh.Client
+	// tunnel is the connection to the SSH server
+	tunnel net.Conn
+}
+
+func (c *sshTunnelConn) Close() error {
+	err := c.Conn.Close()
+	c.client.Close()
+
+	return err
+}
+
+// SSH channels don't support deadlines, so they're set on the connection to
+// the SSH server instead
+
+func (c *sshTunnelConn) SetDeadline(t time.Time) error {
+	return c.tunnel.SetDeadline(t)
+}
+
+func (c *sshTunnelConn) SetReadDeadline(t time.Time) error {
+	return c.tunnel.SetReadDeadline(t)
+}
+
+func (c *sshTunnelConn) SetWriteDeadline(t time.Time) error {
+	return c.tunnel.SetWriteDeadline(t)
+}
+
+// bufferedConn reads from a buffered reader that may hold data that the
+// target sent straight after the proxy's response
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// writeProxyProtocolHeader writes a PROXY protocol header that describes the
+// connection, as the proxy that the target expects would have
+func writeProxyProtocolHeader(conn net.Conn, version string) error {
+	src, srcOK := conn.LocalAddr().(*net.TCPAddr)
+	dst, dstOK := conn.RemoteAddr().(*net.TCPAddr)
+	if !srcOK || !dstOK {
+		return fmt.Errorf("the PROXY protocol is only supported for tcp connections")
+	}
+
+	var header []byte
+	switch version {
+	case "v1":
+		family := "TCP6"
+		if src.IP.To4() != nil && dst.IP.To4() != nil {
+			family = "TCP4"
+		}
+		header = []byte(fmt.Sprintf("PROXY %s %s %s %d %d\r\n", family, src.IP, dst.IP, src.Port, dst.Port))
+	case "v2":
+		// Version 2 with the PROXY command, followed by the address
+		// family and transport protocol
+		buf := bytes.NewBuffer(append([]byte{}, proxyProtocolV2Signature...))
+		buf.WriteByte(0x21)
+		if src4, dst4 := src.IP.To4(), dst.IP.To4(); src4 != nil && dst4 != nil {
+			buf.WriteByte(0x11)
+			binary.Write(buf, binary.BigEndian, uint16(12))
+			buf.Write(src4)
+			buf.Write(dst4)
+		} else {
+			buf.WriteByte(0x21)
+			binary.Write(buf, binary.BigEndian, uint16(36))
+			buf.Write(src.IP.To16())
+			buf.Write(dst.IP.To16())
+		}
+		binary.Write(buf, binary.BigEndian, uint16(src.Port))
+		binary.Write(buf, binary.BigEndian, uint16(dst.Port))
+		header = buf.Bytes()
+	default:
+		return fmt.Errorf("unsupported PROXY protocol version %s", version)
+	}
+
+	_, err := conn.Write(header)
+
+	return err
+}
@@ -0,0 +1,25 @@
+package prober
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// bindToInterface returns a net.Dialer control function that binds sockets
+// to the network interface with SO_BINDTODEVICE
+func bindToInterface(name string) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		var sockErr error
+		err := c.Control(func(fd uintptr) {
+			sockErr = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, name)
+		})
+		if err != nil {
+			return err
+		}
+		if sockErr != nil {
+			return fmt.Errorf("binding to interface %s: %w", name, sockErr)
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package prober
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// bindToInterface returns a net.Dialer control function that fails, because
+// binding sockets to a network interface is only supported on Linux
+func bindToInterface(name string) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		return fmt.Errorf("binding to interface %s is only supported on linux", name)
+	}
+}
@@ -0,0 +1,118 @@
+package prober
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/pion/dtls/v3"
+	"github.com/pion/dtls/v3/pkg/crypto/elliptic"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/ribbybibby/ssl_exporter/v2/config"
+)
+
+// ProbeDTLS performs a dtls probe
+func ProbeDTLS(ctx context.Context, logger log.Logger, target string, module config.Module, registry *prometheus.Registry) error {
+	tlsConfig, _, err := newTLSConfig(ctx, target, &module.TLSConfig, module.ClockSkewTolerance, registry)
+	if err != nil {
+		return err
+	}
+
+	udpConn, raddr, err := listenUDP(ctx, target, newDialOptions(module))
+	if err != nil {
+		return err
+	}
+
+	dtlsConfig := &dtls.Config{
+		Certificates:         tlsConfig.Certificates,
+		RootCAs:              tlsConfig.RootCAs,
+		ServerName:           tlsConfig.ServerName,
+		InsecureSkipVerify:   module.TLSConfig.InsecureSkipVerify,
+		ExtendedMasterSecret: dtls.RequestExtendedMasterSecret,
+		SupportedProtocols:   tlsConfig.NextProtos,
+		CipherSuites:         dtlsCipherSuites(tlsConfig.CipherSuites),
+		EllipticCurves:       dtlsEllipticCurves(module.TLSConfig.CurvePreferences),
+		// The metrics are collected here rather than by newTLSConfig, as
+		// this is where the verified chains are available
+		VerifyPeerCertificate: func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+			return collectDTLSMetrics(rawCerts, verifiedChains, registry)
+		},
+	}
+
+	conn, err := dtls.Client(udpConn, raddr, dtlsConfig)
+	if err != nil {
+		udpConn.Close()
+		return err
+	}
+	defer conn.Close()
+
+	handshakeStart := time.Now()
+	err = conn.HandshakeContext(ctx)
+	collectTLSHandshakeMetrics(time.Since(handshakeStart), registry)
+	if err != nil {
+		return err
+	}
+
+	if state, ok := conn.ConnectionState(); ok {
+		collectCipherSuiteMetrics(state.CipherSuiteID.String(), registry)
+		collectALPNMetrics(state.NegotiatedProtocol, registry)
+	}
+
+	return nil
+}
+
+// dtlsCipherSuites converts the configured cipher suites, which share their
+// IDs with TLS, so that the defaults are used when none are configured
+func dtlsCipherSuites(ids []uint16) []dtls.CipherSuiteID {
+	var suites []dtls.CipherSuiteID
+	for _, id := range ids {
+		suites = append(suites, dtls.CipherSuiteID(id))
+	}
+
+	return suites
+}
+
+// dtlsEllipticCurves converts the configured curve preferences, which share
+// their IDs with TLS
+func dtlsEllipticCurves(ids []tls.CurveID) []elliptic.Curve {
+	var curves []elliptic.Curve
+	for _, id := range ids {
+		curves = append(curves, elliptic.Curve(id))
+	}
+
+	return curves
+}
+
+// collectDTLSMetrics collects the same metrics as collectConnectionStateMetrics
+// for a DTLS 1.2 connection, which doesn't support OCSP stapling
+func collectDTLSMetrics(rawCerts [][]byte, verifiedChains [][]*x509.Certificate, registry *prometheus.Registry) error {
+	if err := collectTLSVersionMetrics(versionDTLS12, registry); err != nil {
+		return err
+	}
+
+	var certs []*x509.Certificate
+	for _, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return err
+		}
+		certs = append(certs, cert)
+	}
+	if err := collectCertificateMetrics(certs, verifiedChains, registry); err != nil {
+		return err
+	}
+
+	if err := collectVerifiedChainMetrics(verifiedChains, registry); err != nil {
+		return err
+	}
+
+	collectChainMetrics(certs, verifiedChains, registry)
+
+	if err := collectMustStapleMetrics(certs, false, registry); err != nil {
+		return err
+	}
+
+	return collectOCSPMetrics(nil, registry)
+}
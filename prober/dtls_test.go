@@ -0,0 +1,104 @@
+package prober
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/ribbybibby/ssl_exporter/v2/config"
+	"github.com/ribbybibby/ssl_exporter/v2/test"
+)
+
+// TestProbeDTLS tests the typical case
+func TestProbeDTLS(t *testing.T) {
+	server, certPEM, _, caFile, teardown, err := test.SetupDTLSServer()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer teardown()
+
+	server.Start()
+	defer server.Close()
+
+	module := config.Module{
+		TLSConfig: config.TLSConfig{
+			CAFile: caFile,
+		},
+	}
+
+	registry := prometheus.NewRegistry()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeDTLS(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	cert, err := newCertificate(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCertificateMetrics(cert, registry, t)
+	checkOCSPMetrics([]byte{}, registry, t)
+	checkTLSVersionMetrics("DTLS 1.2", registry, t)
+	checkCipherSuiteMetrics("TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", registry, t)
+	checkTLSHandshakeMetrics(registry, t)
+}
+
+// TestProbeDTLSInvalidName tests hitting the server on an address which isn't
+// in the SANs (localhost)
+func TestProbeDTLSInvalidName(t *testing.T) {
+	server, _, _, caFile, teardown, err := test.SetupDTLSServer()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer teardown()
+
+	server.Start()
+	defer server.Close()
+
+	module := config.Module{
+		TLSConfig: config.TLSConfig{
+			CAFile: caFile,
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, listenPort, _ := net.SplitHostPort(server.Listener.Addr().String())
+
+	if err := ProbeDTLS(ctx, newTestLogger(), "localhost:"+listenPort, module, prometheus.NewRegistry()); err == nil {
+		t.Fatalf("expected error but err was nil")
+	}
+}
+
+// TestProbeDTLSSourceIPAddress tests that the dtls probe connects from the
+// source IP address
+func TestProbeDTLSSourceIPAddress(t *testing.T) {
+	server, _, _, caFile, teardown, err := test.SetupDTLSServer()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer teardown()
+
+	server.Start()
+	defer server.Close()
+
+	module := config.Module{
+		TLSConfig: config.TLSConfig{
+			CAFile: caFile,
+		},
+		SourceIPAddress: "127.0.0.1",
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeDTLS(ctx, newTestLogger(), server.Listener.Addr().String(), module, prometheus.NewRegistry()); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+}
@@ -0,0 +1,166 @@
+package prober
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"net"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// typeHTTPS is the HTTPS resource record type from RFC 9460, which
+	// dnsmessage doesn't define
+	typeHTTPS dnsmessage.Type = 65
+
+	// svcParamECH is the key of the SvcParam that holds the ECHConfigList
+	svcParamECH = 5
+)
+
+// parseHTTPSRecord returns the priority and ECHConfigList of the data of an
+// HTTPS record. It returns false for AliasMode records, which don't have any
+// SvcParams, and for records without an ECHConfigList.
+func parseHTTPSRecord(data []byte) (uint16, []byte, bool) {
+	if len(data) < 3 {
+		return 0, nil, false
+	}
+	priority := binary.BigEndian.Uint16(data)
+	if priority == 0 {
+		return 0, nil, false
+	}
+
+	// The TargetName isn't compressed
+	data = data[2:]
+	for len(data) > 0 && data[0] != 0 {
+		if int(data[0]) >= len(data) {
+			return 0, nil, false
+		}
+		data = data[1+data[0]:]
+	}
+	if len(data) == 0 {
+		return 0, nil, false
+	}
+	data = data[1:]
+
+	for len(data) >= 4 {
+		key := binary.BigEndian.Uint16(data)
+		length := int(binary.BigEndian.Uint16(data[2:]))
+		if len(data) < 4+length {
+			return 0, nil, false
+		}
+		if key == svcParamECH {
+			return priority, data[4 : 4+length], true
+		}
+		data = data[4+length:]
+	}
+
+	return 0, nil, false
+}
+
+// lookupECHConfigList returns the ECHConfigList in the HTTPS records of the
+// TLS service at the host and port, and whether the resolver authenticated it
+// with DNSSEC. The HTTPS records of port 443 are found at the host itself.
+func lookupECHConfigList(ctx context.Context, host, port string, opts dialOptions) ([]byte, bool, error) {
+	name := host
+	if port != "443" {
+		name = fmt.Sprintf("_%s._https.%s", port, host)
+	}
+
+	resp, err := queryDNS(ctx, opts, name, typeHTTPS)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var (
+		list     []byte
+		priority uint16
+	)
+	for _, answer := range resp.Answers {
+		body, ok := answer.Body.(*dnsmessage.UnknownResource)
+		if !ok || answer.Header.Type != typeHTTPS {
+			continue
+		}
+		p, ech, ok := parseHTTPSRecord(body.Data)
+		if !ok {
+			continue
+		}
+		if list == nil || p < priority {
+			list, priority = ech, p
+		}
+	}
+
+	return list, resp.AuthenticData, nil
+}
+
+// setupECH looks up the ECH configs published for the address and offers them
+// in the handshake. It returns whether ECH is offered. A failed lookup doesn't
+// fail the probe, the handshake is performed without ECH instead.
+func setupECH(ctx context.Context, logger log.Logger, address string, tlsConfig *tls.Config, opts dialOptions, registry *prometheus.Registry) bool {
+	var (
+		echPublished = prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "ech_published"),
+				Help: "If an ECH config is published in the HTTPS records of the target",
+			},
+		)
+	)
+	registry.MustRegister(echPublished)
+
+	if !echSupported {
+		level.Error(logger).Log("msg", "ECH isn't supported by this build, which requires go 1.23")
+		return false
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		level.Error(logger).Log("msg", fmt.Sprintf("Error looking up ECH configs: %s", err))
+		return false
+	}
+	host, err = toASCIIHost(host)
+	if err != nil {
+		level.Error(logger).Log("msg", fmt.Sprintf("Error looking up ECH configs: %s", err))
+		return false
+	}
+
+	list, authenticated, err := lookupECHConfigList(ctx, host, port, opts)
+	if err != nil {
+		level.Error(logger).Log("msg", fmt.Sprintf("Error looking up HTTPS records: %s", err))
+		return false
+	}
+	if opts.dnssec && !authenticated {
+		level.Error(logger).Log("msg", fmt.Sprintf("The HTTPS records for %s aren't authenticated with DNSSEC", address))
+		return false
+	}
+	if list == nil {
+		level.Error(logger).Log("msg", fmt.Sprintf("There isn't an ECH config in the HTTPS records for %s", address))
+		return false
+	}
+	echPublished.Set(1)
+
+	setECHConfigList(tlsConfig, list)
+
+	return true
+}
+
+// collectECHMetrics collects whether the server accepted ECH. A server that
+// rejects it fails the handshake.
+func collectECHMetrics(accepted bool, registry *prometheus.Registry) {
+	var (
+		echAccepted = prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "ech_accepted"),
+				Help: "If the target accepted the encrypted client hello",
+			},
+		)
+	)
+	registry.MustRegister(echAccepted)
+
+	if accepted {
+		echAccepted.Set(1)
+	}
+}
@@ -0,0 +1,18 @@
+//go:build go1.23
+
+package prober
+
+import "crypto/tls"
+
+// echSupported reports whether crypto/tls supports ECH as a client
+const echSupported = true
+
+// setECHConfigList offers the ECH configs in the handshake
+func setECHConfigList(tlsConfig *tls.Config, list []byte) {
+	tlsConfig.EncryptedClientHelloConfigList = list
+}
+
+// echAccepted reports whether the server accepted ECH
+func echAccepted(state tls.ConnectionState) bool {
+	return state.ECHAccepted
+}
@@ -0,0 +1,17 @@
+//go:build !go1.23
+
+package prober
+
+import "crypto/tls"
+
+// echSupported reports whether crypto/tls supports ECH as a client, which it
+// only does from go 1.23
+const echSupported = false
+
+// setECHConfigList does nothing, because ECH isn't supported
+func setECHConfigList(tlsConfig *tls.Config, list []byte) {}
+
+// echAccepted is always false, because ECH isn't supported
+func echAccepted(state tls.ConnectionState) bool {
+	return false
+}
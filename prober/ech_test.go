@@ -0,0 +1,97 @@
+//go:build go1.24
+
+package prober
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/ribbybibby/ssl_exporter/v2/config"
+	"github.com/ribbybibby/ssl_exporter/v2/test"
+)
+
+// TestProbeTCPECH tests offering the ECH configs published in the HTTPS
+// records of the target
+func TestProbeTCPECH(t *testing.T) {
+	echConfig, echKey, echConfigList, err := test.GenerateECHConfig(1, "example.ribbybibby.me")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dnsServer, err := test.SetupDNSServer(map[string][]net.IP{
+		"probe.example.test.": []net.IP{net.ParseIP("127.0.0.1")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dnsServer.Close()
+
+	testCases := []struct {
+		name      string
+		published bool
+		serverKey bool
+		accepted  float64
+		expectErr bool
+	}{
+		{name: "accepted", published: true, serverKey: true, accepted: 1},
+		{name: "rejected", published: true, accepted: 0, expectErr: true},
+		{name: "not published", serverKey: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server, _, _, caFile, teardown, err := test.SetupTCPServer()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer teardown()
+
+			if tc.serverKey {
+				server.TLS.EncryptedClientHelloKeys = []tls.EncryptedClientHelloKey{
+					{Config: echConfig, PrivateKey: echKey},
+				}
+			}
+
+			server.StartTLS()
+			defer server.Close()
+
+			_, port, err := net.SplitHostPort(server.Listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			var records []test.HTTPS
+			if tc.published {
+				records = []test.HTTPS{{Priority: 1, Target: ".", ECHConfigList: echConfigList}}
+			}
+			dnsServer.SetHTTPS("_"+port+"._https.probe.example.test.", records)
+
+			module := config.Module{
+				Resolver: dnsServer.Conn.LocalAddr().String(),
+				ECH:      true,
+				TLSConfig: config.TLSConfig{
+					CAFile:     caFile,
+					ServerName: "127.0.0.1",
+				},
+			}
+
+			registry := prometheus.NewRegistry()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			err = ProbeTCP(ctx, newTestLogger(), net.JoinHostPort("probe.example.test", port), module, registry)
+			if tc.expectErr && err == nil {
+				t.Fatalf("expected error but err was nil")
+			}
+			if !tc.expectErr && err != nil {
+				t.Fatalf("error: %s", err)
+			}
+
+			checkECHMetrics(tc.published, tc.accepted, registry, t)
+		})
+	}
+}
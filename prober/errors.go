@@ -0,0 +1,50 @@
+package prober
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+)
+
+// ErrorType classifies the error that failed a probe, so that an expired
+// certificate can be told apart from a target that can't be reached. The
+// types are dns, connect, starttls, handshake, verify_expired,
+// verify_unknown_authority, hostname_mismatch and timeout, or other when the
+// error doesn't fit any of them.
+func ErrorType(err error) string {
+	var (
+		invalidErr   x509.CertificateInvalidError
+		authorityErr x509.UnknownAuthorityError
+		hostnameErr  x509.HostnameError
+		lookupErr    *lookupError
+		dnsErr       *net.DNSError
+		netErr       net.Error
+		phaseErr     *phaseError
+		alertErr     tls.AlertError
+		recordErr    tls.RecordHeaderError
+		verifyErr    *tls.CertificateVerificationError
+	)
+
+	switch {
+	case errors.As(err, &invalidErr) && invalidErr.Reason == x509.Expired:
+		return "verify_expired"
+	case errors.As(err, &authorityErr):
+		return "verify_unknown_authority"
+	case errors.As(err, &hostnameErr):
+		return "hostname_mismatch"
+	case errors.As(err, &lookupErr), errors.As(err, &dnsErr):
+		return "dns"
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.As(err, &phaseErr):
+		return phaseErr.phase
+	// The https prober doesn't attribute the handshake to a phase, because
+	// it happens inside the http client
+	case errors.As(err, &alertErr), errors.As(err, &recordErr), errors.As(err, &verifyErr):
+		return "handshake"
+	}
+
+	return "other"
+}
@@ -0,0 +1,272 @@
+package prober
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/ribbybibby/ssl_exporter/v2/config"
+	"golang.org/x/net/http2"
+)
+
+// grpcServingStatuses are the values of the ServingStatus enum in the
+// grpc.health.v1 HealthCheckResponse message
+var grpcServingStatuses = []string{"UNKNOWN", "SERVING", "NOT_SERVING", "SERVICE_UNKNOWN"}
+
+// ProbeGRPC performs a grpc probe
+func ProbeGRPC(ctx context.Context, logger log.Logger, target string, module config.Module, registry *prometheus.Registry) error {
+	tlsConfig, aia, err := newTLSConfig(ctx, target, &module.TLSConfig, module.ClockSkewTolerance, registry)
+	if err != nil {
+		return err
+	}
+	tlsConfig.NextProtos = []string{http2.NextProtoTLS}
+
+	opts := newDialOptions(module)
+	opts.trace = &dialTrace{}
+
+	durations := newPhaseDurationMetrics(registry)
+
+	var echOffered bool
+	if module.ECH {
+		echOffered = setupECH(ctx, logger, target, tlsConfig, opts, registry)
+	}
+
+	connect := newPhase(ctx, "connect", module.Timeouts.Connect)
+	dialCtx, cancel := connect.context(ctx)
+	conn, err := dialContext(dialCtx, "tcp", target, opts)
+	cancel()
+	collectDNSMetrics(opts.trace, registry)
+	collectDialPhaseMetrics(durations, opts.trace, connect.elapsed())
+	if err != nil {
+		return connect.err(err)
+	}
+	defer conn.Close()
+
+	collectIPProtocolMetrics(conn.RemoteAddr(), registry)
+
+	handshake := newPhase(ctx, "handshake", module.Timeouts.Handshake)
+	handshakeCtx, cancel := handshake.context(ctx)
+	defer cancel()
+
+	hello := &helloConn{Conn: conn}
+	tlsConn := tls.Client(hello, tlsConfig)
+	err = tlsConn.HandshakeContext(handshakeCtx)
+	handshakeDuration := handshake.elapsed()
+	collectTLSHandshakeMetrics(handshakeDuration, registry)
+	durations.WithLabelValues(handshake.name).Set(handshakeDuration.Seconds())
+	if echOffered {
+		collectECHMetrics(err == nil && echAccepted(tlsConn.ConnectionState()), registry)
+	}
+	if err != nil {
+		return handshake.err(err)
+	}
+	if proto := tlsConn.ConnectionState().NegotiatedProtocol; proto != http2.NextProtoTLS {
+		return fmt.Errorf("The server negotiated the ALPN protocol %q rather than %q", proto, http2.NextProtoTLS)
+	}
+
+	state := aia.fill(tlsConn.ConnectionState())
+	collectCurveMetrics(state, registry)
+	collectServerHelloMetrics(hello.serverHello(), state.Version, registry)
+	if err := collectPolicyMetrics(module.Policy, []uint16{state.Version}, []uint16{state.CipherSuite}, state.PeerCertificates, registry); err != nil {
+		return err
+	}
+
+	if module.DANE {
+		collectDANEMetrics(ctx, logger, target, tlsConfig.ServerName, state, opts, registry)
+	}
+
+	if module.CAA {
+		collectCAAMetrics(ctx, logger, target, state.PeerCertificates[0], module.CAAIssuers, opts, registry)
+	}
+
+	if module.SCT {
+		collectSCTMetrics(logger, state, module.CTLogs, module.CTLogListFile, registry)
+	}
+
+	if len(module.TrustStores) > 0 {
+		collectTrustStoreMetrics(logger, module.TrustStores, state, tlsConfig.ServerName, registry)
+	}
+
+	if len(module.ExpectedDNSNames) > 0 {
+		collectExpectedNamesMetrics(module.ExpectedDNSNames, state.PeerCertificates[0], registry)
+	}
+
+	if len(module.ExpectedFingerprints) > 0 {
+		collectFingerprintMetrics(module.ExpectedFingerprints, state.PeerCertificates[0], registry)
+	}
+
+	if len(module.ExpectedSPKIHashes) > 0 {
+		collectSPKIPinMetrics(module.ExpectedSPKIHashes, state, registry)
+	}
+
+	if module.ExpectedIssuer.Regexp != nil {
+		collectExpectedIssuerMetrics(module.ExpectedIssuer.Regexp, state.PeerCertificates[0], registry)
+	}
+
+	if module.ExpectedSerial != "" || module.ExpectedAuthorityKeyID != "" {
+		collectSerialMetrics(module.ExpectedSerial, module.ExpectedAuthorityKeyID, state.PeerCertificates[0], registry)
+	}
+
+	collectNotYetValidMetrics(state.PeerCertificates, module.ClockSkewTolerance, registry)
+
+	if module.CertChanges {
+		collectCertChangeMetrics(module.Name, target, state, registry)
+	}
+
+	if len(module.ExpiryThresholds) > 0 {
+		collectExpiryThresholdMetrics(state.PeerCertificates, module.ExpiryThresholds, registry)
+	}
+
+	if module.SessionResumption {
+		collectSessionResumptionMetrics(ctx, logger, "tcp", target, tlsConfig, module, opts, registry)
+	}
+
+	if !module.GRPC.HealthCheck {
+		return nil
+	}
+
+	var (
+		healthCheckResponse = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "grpc_healthcheck_response"),
+				Help: "The serving status returned by the gRPC health check",
+			},
+			[]string{"serving_status"},
+		)
+	)
+	registry.MustRegister(healthCheckResponse)
+
+	for _, status := range grpcServingStatuses {
+		healthCheckResponse.WithLabelValues(status).Set(0)
+	}
+
+	// A failed health check doesn't fail the probe, so that the certificate
+	// metrics are still reported for servers that are listening but unhealthy
+	status, err := grpcHealthCheck(ctx, logger, tlsConn, target, module.GRPC.Service)
+	if err != nil {
+		level.Error(logger).Log("msg", fmt.Sprintf("grpc health check failed: %s", err))
+		return nil
+	}
+	healthCheckResponse.WithLabelValues(status).Set(1)
+
+	return nil
+}
+
+// grpcHealthCheck calls grpc.health.v1.Health/Check for the given service
+// over conn and returns the serving status
+func grpcHealthCheck(ctx context.Context, logger log.Logger, conn net.Conn, authority, service string) (string, error) {
+	transport := &http2.Transport{}
+	clientConn, err := transport.NewClientConn(conn)
+	if err != nil {
+		return "", err
+	}
+	defer clientConn.Close()
+
+	// The HealthCheckRequest message has a single string field
+	message := []byte{}
+	if service != "" {
+		message = append([]byte{0x0a}, binary.AppendUvarint(nil, uint64(len(service)))...)
+		message = append(message, service...)
+	}
+	body := append([]byte{0x00}, binary.BigEndian.AppendUint32(nil, uint32(len(message)))...)
+	body = append(body, message...)
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, (&url.URL{Scheme: "https", Host: authority, Path: "/grpc.health.v1.Health/Check"}).String(), bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Content-Type", "application/grpc")
+	request.Header.Set("TE", "trailers")
+	request.Header.Set("User-Agent", userAgent)
+
+	level.Debug(logger).Log("msg", fmt.Sprintf("calling grpc health check for service %q", service))
+
+	resp, err := clientConn.RoundTrip(request)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected http status: %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	// The grpc status is sent in the trailers, or in the headers for
+	// responses without a body
+	grpcStatus := resp.Trailer.Get("Grpc-Status")
+	grpcMessage := resp.Trailer.Get("Grpc-Message")
+	if grpcStatus == "" {
+		grpcStatus = resp.Header.Get("Grpc-Status")
+		grpcMessage = resp.Header.Get("Grpc-Message")
+	}
+	if grpcStatus != "0" {
+		return "", fmt.Errorf("grpc status %s: %s", grpcStatus, grpcMessage)
+	}
+
+	if len(data) < 5 {
+		return "", fmt.Errorf("grpc response is too short")
+	}
+	if data[0] != 0 {
+		return "", fmt.Errorf("compressed grpc responses aren't supported")
+	}
+	length := binary.BigEndian.Uint32(data[1:5])
+	if uint32(len(data)-5) < length {
+		return "", fmt.Errorf("grpc response is too short")
+	}
+
+	return parseHealthCheckResponse(data[5 : 5+length])
+}
+
+// parseHealthCheckResponse returns the serving status in a HealthCheckResponse
+// message. The status defaults to UNKNOWN when the field is omitted.
+func parseHealthCheckResponse(message []byte) (string, error) {
+	var status uint64
+	for len(message) > 0 {
+		tag, n := binary.Uvarint(message)
+		if n <= 0 {
+			return "", fmt.Errorf("invalid protobuf field tag")
+		}
+		message = message[n:]
+
+		switch tag & 0x07 {
+		case 0:
+			v, n := binary.Uvarint(message)
+			if n <= 0 {
+				return "", fmt.Errorf("invalid protobuf varint")
+			}
+			message = message[n:]
+			if tag>>3 == 1 {
+				status = v
+			}
+		case 2:
+			l, n := binary.Uvarint(message)
+			if n <= 0 || uint64(len(message)-n) < l {
+				return "", fmt.Errorf("invalid protobuf length")
+			}
+			message = message[n+int(l):]
+		default:
+			return "", fmt.Errorf("unsupported protobuf wire type %d", tag&0x07)
+		}
+	}
+
+	if status >= uint64(len(grpcServingStatuses)) {
+		return strconv.FormatUint(status, 10), nil
+	}
+
+	return grpcServingStatuses[status], nil
+}
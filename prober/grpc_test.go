@@ -0,0 +1,113 @@
+package prober
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/ribbybibby/ssl_exporter/v2/config"
+	"github.com/ribbybibby/ssl_exporter/v2/test"
+)
+
+// TestProbeGRPC tests the typical case
+func TestProbeGRPC(t *testing.T) {
+	server, certPEM, _, caFile, teardown, err := test.SetupGRPCServer(1)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer teardown()
+
+	server.StartTLS()
+	defer server.Close()
+
+	module := config.Module{
+		TLSConfig: config.TLSConfig{
+			CAFile: caFile,
+		},
+	}
+
+	registry := prometheus.NewRegistry()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeGRPC(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	cert, err := newCertificate(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCertificateMetrics(cert, registry, t)
+	checkOCSPMetrics([]byte{}, registry, t)
+	checkTLSVersionMetrics("TLS 1.3", registry, t)
+	checkALPNMetrics("h2", registry, t)
+	checkTLSHandshakeMetrics(registry, t)
+	checkPhaseDurationMetrics([]string{"connect", "handshake"}, registry, t)
+}
+
+// TestProbeGRPCHealthCheck tests that the serving status returned by the
+// health check is exported
+func TestProbeGRPCHealthCheck(t *testing.T) {
+	for _, status := range []byte{1, 2} {
+		server, _, _, caFile, teardown, err := test.SetupGRPCServer(status)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		defer teardown()
+
+		server.StartTLS()
+		defer server.Close()
+
+		module := config.Module{
+			GRPC: config.GRPCProbe{
+				HealthCheck: true,
+				Service:     "example.Service",
+			},
+			TLSConfig: config.TLSConfig{
+				CAFile: caFile,
+			},
+		}
+
+		registry := prometheus.NewRegistry()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := ProbeGRPC(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+			t.Fatalf("error: %s", err)
+		}
+
+		checkGRPCHealthCheckMetrics(grpcServingStatuses[status], registry, t)
+	}
+}
+
+// TestProbeGRPCNoH2 tests that the probe fails against a server that doesn't
+// negotiate h2
+func TestProbeGRPCNoH2(t *testing.T) {
+	server, _, _, caFile, teardown, err := test.SetupHTTPSServer()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer teardown()
+
+	server.StartTLS()
+	defer server.Close()
+
+	module := config.Module{
+		TLSConfig: config.TLSConfig{
+			CAFile: caFile,
+		},
+	}
+
+	registry := prometheus.NewRegistry()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeGRPC(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err == nil {
+		t.Fatalf("expected error, but err was nil")
+	}
+}
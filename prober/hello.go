@@ -0,0 +1,225 @@
+package prober
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	recordTypeChangeCipherSpec = 20
+	recordTypeAlert            = 21
+	recordTypeHandshake        = 22
+
+	handshakeTypeServerHello = 2
+
+	extensionExtendedMasterSecret = 23
+	extensionRenegotiationInfo    = 0xff01
+)
+
+// helloRetryRequestRandom is the random value of a ServerHello that is a
+// HelloRetryRequest, from RFC 8446
+var helloRetryRequestRandom = []byte{
+	0xcf, 0x21, 0xad, 0x74, 0xe5, 0x9a, 0x61, 0x11,
+	0xbe, 0x1d, 0x8c, 0x02, 0x1e, 0x65, 0xb8, 0x91,
+	0xc2, 0xa2, 0x11, 0x16, 0x7a, 0xbb, 0x8c, 0x5e,
+	0x07, 0x9e, 0x09, 0xe2, 0xc8, 0xa8, 0x33, 0x9c,
+}
+
+// serverHello is the ServerHello message sent by the target
+type serverHello struct {
+	version           uint16
+	cipherSuite       uint16
+	compressionMethod uint8
+	// extensions are the types of the extensions in the order they were
+	// sent
+	extensions []uint16
+}
+
+// hasExtension returns true if the ServerHello includes the extension
+func (h *serverHello) hasExtension(extension uint16) bool {
+	for _, e := range h.extensions {
+		if e == extension {
+			return true
+		}
+	}
+	return false
+}
+
+// ja3s returns the JA3S fingerprint of the ServerHello, which is the MD5 hash
+// of its version, cipher suite and extensions
+func (h *serverHello) ja3s() string {
+	extensions := make([]string, len(h.extensions))
+	for i, e := range h.extensions {
+		extensions[i] = strconv.Itoa(int(e))
+	}
+	fields := []string{
+		strconv.Itoa(int(h.version)),
+		strconv.Itoa(int(h.cipherSuite)),
+		strings.Join(extensions, "-"),
+	}
+	sum := md5.Sum([]byte(strings.Join(fields, ",")))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// helloConn records the ServerHello that is read from the connection, which
+// the tls package doesn't expose
+type helloConn struct {
+	net.Conn
+
+	mu        sync.Mutex
+	done      bool
+	records   []byte
+	handshake []byte
+	hello     *serverHello
+	// alert is the description of an alert that was read instead of the
+	// ServerHello
+	alert uint8
+}
+
+// Read reads from the connection and parses the records until the
+// ServerHello has been read
+func (c *helloConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.done {
+		c.records = append(c.records, b[:n]...)
+		c.parseRecords()
+	}
+
+	return n, err
+}
+
+// serverHello returns the ServerHello, or nil if one wasn't read
+func (c *helloConn) serverHello() *serverHello {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.hello
+}
+
+// serverAlert returns the description of the alert that the server sent
+// instead of the ServerHello, or 0 if it didn't send one
+func (c *helloConn) serverAlert() uint8 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.alert
+}
+
+// finished returns true once the ServerHello has been read, or when it can't
+// be read anymore
+func (c *helloConn) finished() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.done
+}
+
+// parseRecords parses the complete plaintext records that have been read.
+// Parsing stops at the first record that isn't a handshake message or change
+// cipher spec, because everything after it is encrypted.
+func (c *helloConn) parseRecords() {
+	for !c.done && len(c.records) >= 5 {
+		length := int(binary.BigEndian.Uint16(c.records[3:5]))
+		if len(c.records) < 5+length {
+			return
+		}
+		fragment := c.records[5 : 5+length]
+
+		switch c.records[0] {
+		case recordTypeHandshake:
+			c.handshake = append(c.handshake, fragment...)
+			c.parseHandshake()
+		case recordTypeChangeCipherSpec:
+		case recordTypeAlert:
+			if c.hello == nil && len(fragment) == 2 {
+				c.alert = fragment[1]
+			}
+			c.done = true
+		default:
+			c.done = true
+		}
+		c.records = c.records[5+length:]
+	}
+	if c.done {
+		c.records = nil
+		c.handshake = nil
+	}
+}
+
+// parseHandshake parses the complete handshake messages that have been read
+// until it finds a ServerHello that isn't a HelloRetryRequest
+func (c *helloConn) parseHandshake() {
+	for !c.done && len(c.handshake) >= 4 {
+		length := int(c.handshake[1])<<16 | int(c.handshake[2])<<8 | int(c.handshake[3])
+		if len(c.handshake) < 4+length {
+			return
+		}
+		if c.handshake[0] != handshakeTypeServerHello {
+			c.done = true
+			return
+		}
+
+		hello, retry, ok := parseServerHello(c.handshake[4 : 4+length])
+		if !ok || !retry {
+			c.hello = hello
+			c.done = true
+			return
+		}
+		c.handshake = c.handshake[4+length:]
+	}
+}
+
+// parseServerHello parses the body of a ServerHello message and returns
+// whether it's a HelloRetryRequest
+func parseServerHello(data []byte) (*serverHello, bool, bool) {
+	if len(data) < 35 {
+		return nil, false, false
+	}
+	hello := &serverHello{
+		version: binary.BigEndian.Uint16(data[0:2]),
+	}
+	retry := bytes.Equal(data[2:34], helloRetryRequestRandom)
+
+	sessionIDLength := int(data[34])
+	data = data[35:]
+	if len(data) < sessionIDLength+3 {
+		return nil, false, false
+	}
+	data = data[sessionIDLength:]
+	hello.cipherSuite = binary.BigEndian.Uint16(data[0:2])
+	hello.compressionMethod = data[2]
+	data = data[3:]
+
+	// The extensions are optional before TLS 1.3
+	if len(data) == 0 {
+		return hello, retry, true
+	}
+	if len(data) < 2 || len(data) != 2+int(binary.BigEndian.Uint16(data[0:2])) {
+		return nil, false, false
+	}
+	data = data[2:]
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return nil, false, false
+		}
+		extension := binary.BigEndian.Uint16(data[0:2])
+		length := int(binary.BigEndian.Uint16(data[2:4]))
+		if len(data) < 4+length {
+			return nil, false, false
+		}
+		hello.extensions = append(hello.extensions, extension)
+		data = data[4+length:]
+	}
+
+	return hello, retry, true
+}
@@ -0,0 +1,19 @@
+package prober
+
+import (
+	"testing"
+)
+
+// TestServerHelloJA3S tests the JA3S fingerprint of a ServerHello
+func TestServerHelloJA3S(t *testing.T) {
+	hello := &serverHello{
+		version:     0x0303,
+		cipherSuite: 0xc02f,
+		extensions:  []uint16{0xff01, 11, 35},
+	}
+
+	// The MD5 hash of "771,49199,65281-11-35"
+	if fingerprint := hello.ja3s(); fingerprint != "ccc514751b175866924439bdbb5bba34" {
+		t.Errorf("unexpected JA3S fingerprint %s", fingerprint)
+	}
+}
@@ -0,0 +1,47 @@
+package prober
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/ribbybibby/ssl_exporter/v2/history"
+)
+
+// History records the certificates presented to the probes of each target. It's
+// replaced with a history that is kept in a file, or that has a retention,
+// when the exporter is started with one.
+var History = history.New(0)
+
+// collectCertChangeMetrics compares the leaf certificate presented by the
+// target to the one that it presented to the last successful probe by the
+// module, so that certificates that are reissued unexpectedly, or that flap
+// between the members of a load balancer, are reported. Targets are told apart
+// by the server name as well, as they can present different certificates for
+// each.
+func collectCertChangeMetrics(module, target string, state tls.ConnectionState, registry *prometheus.Registry) {
+	var (
+		certChanged = prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "cert_changed"),
+				Help: "If the leaf certificate presented by the target is different to the one that it presented to the last probe",
+			},
+		)
+		certChangedTotal = prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: prometheus.BuildFQName(namespace, "", "cert_changed_total"),
+				Help: "The number of times that the leaf certificate presented by the target has changed",
+			},
+		)
+	)
+	registry.MustRegister(certChanged, certChangedTotal)
+
+	cert := state.PeerCertificates[0]
+	sum := sha256.Sum256(cert.Raw)
+	changed, changes := History.ObserveCertificate(module, target, state.ServerName, hex.EncodeToString(sum[:]), cert.NotAfter)
+	if changed {
+		certChanged.Set(1)
+	}
+	certChangedTotal.Add(float64(changes))
+}
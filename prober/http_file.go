@@ -56,5 +56,5 @@ func ProbeHTTPFile(ctx context.Context, logger log.Logger, target string, module
 		return fmt.Errorf("decoding certificates from response body: %w", err)
 	}
 
-	return collectCertificateMetrics(certs, registry)
+	return collectCertificateMetrics(certs, nil, registry)
 }
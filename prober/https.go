@@ -2,25 +2,35 @@ package prober
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
+	"slices"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/version"
 	"github.com/ribbybibby/ssl_exporter/v2/config"
+	"golang.org/x/net/http2"
 )
 
 var userAgent = fmt.Sprintf("SSLExporter/%s", version.Version)
 
 // ProbeHTTPS performs a https probe
 func ProbeHTTPS(ctx context.Context, logger log.Logger, target string, module config.Module, registry *prometheus.Registry) error {
-	tlsConfig, err := newTLSConfig("", registry, &module.TLSConfig)
+	tlsConfig, aia, err := newTLSConfig(ctx, "", &module.TLSConfig, module.ClockSkewTolerance, registry)
 	if err != nil {
 		return err
 	}
@@ -38,19 +48,90 @@ func ProbeHTTPS(ctx context.Context, logger log.Logger, target string, module co
 		return err
 	}
 
+	// Unicode host names are requested in their punycode form
+	host, err := toASCIIHost(targetURL.Hostname())
+	if err != nil {
+		return err
+	}
+	if host != targetURL.Hostname() {
+		if port := targetURL.Port(); port != "" {
+			targetURL.Host = net.JoinHostPort(host, port)
+		} else {
+			targetURL.Host = host
+		}
+	}
+
 	proxy := http.ProxyFromEnvironment
 	if module.HTTPS.ProxyURL.URL != nil {
 		proxy = http.ProxyURL(module.HTTPS.ProxyURL.URL)
+	} else if module.HTTPS.SOCKS5Proxy.Address != "" || module.SSHTunnel.Host != "" || module.OuterTLSConfig != nil {
+		// The dialer connects through the SOCKS5 proxy, SSH tunnel or
+		// outer TLS session instead
+		proxy = nil
+	}
+
+	opts := newDialOptions(module)
+	opts.socks5Proxy = module.HTTPS.SOCKS5Proxy
+	opts.proxyProtocol = module.HTTPS.ProxyProtocol
+	opts.trace = &dialTrace{}
+
+	port := targetURL.Port()
+	if port == "" {
+		port = "443"
+	}
+	address := net.JoinHostPort(targetURL.Hostname(), port)
+
+	// IP addresses aren't sent as the server name, so the chain that is
+	// verified with AIA, or allowing for clock skew, needs it to check the
+	// address
+	if (module.TLSConfig.AIAFetch || module.ClockSkewTolerance > 0) && tlsConfig.ServerName == "" && net.ParseIP(host) != nil {
+		tlsConfig.ServerName = host
+	}
+
+	var echOffered bool
+	if module.ECH {
+		echOffered = setupECH(ctx, logger, address, tlsConfig, opts, registry)
 	}
 
+	// The connection is made and timed by the transport, which may still be
+	// running when the request times out
+	var (
+		mu                sync.Mutex
+		remoteAddr        net.Addr
+		hello             *helloConn
+		dialDuration      time.Duration
+		handshakeStart    time.Time
+		handshakeDuration time.Duration
+	)
 	client := &http.Client{
 		CheckRedirect: func(req *http.Request, via []*http.Request) error {
 			return http.ErrUseLastResponse
 		},
 		Transport: &http.Transport{
-			TLSClientConfig:   tlsConfig,
-			Proxy:             proxy,
-			DisableKeepAlives: true,
+			TLSClientConfig:     tlsConfig,
+			Proxy:               proxy,
+			DisableKeepAlives:   true,
+			TLSHandshakeTimeout: module.Timeouts.Handshake,
+			// HTTP/2 is only spoken when it's offered with alpn_protocols
+			ForceAttemptHTTP2: slices.Contains(tlsConfig.NextProtos, http2.NextProtoTLS),
+			DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+				connect := newPhase(ctx, "connect", module.Timeouts.Connect)
+				dialCtx, cancel := connect.context(ctx)
+				defer cancel()
+
+				conn, err := dialContext(dialCtx, network, address, opts)
+
+				mu.Lock()
+				defer mu.Unlock()
+				dialDuration = connect.elapsed()
+				if err != nil {
+					return nil, connect.err(err)
+				}
+				remoteAddr = conn.RemoteAddr()
+				hello = &helloConn{Conn: conn}
+
+				return hello, nil
+			},
 		},
 	}
 
@@ -59,24 +140,187 @@ func ProbeHTTPS(ctx context.Context, logger log.Logger, target string, module co
 	if err != nil {
 		return err
 	}
-	request = request.WithContext(ctx)
+	trace := &httptrace.ClientTrace{
+		TLSHandshakeStart: func() {
+			mu.Lock()
+			defer mu.Unlock()
+			handshakeStart = time.Now()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			handshakeDuration = time.Since(handshakeStart)
+		},
+	}
+	request = request.WithContext(httptrace.WithClientTrace(ctx, trace))
 	request.Header.Set("User-Agent", userAgent)
+
+	var webSocketKey string
+	if module.HTTPS.WebSocket {
+		webSocketKey, err = newWebSocketKey()
+		if err != nil {
+			return err
+		}
+		request.Header.Set("Connection", "Upgrade")
+		request.Header.Set("Upgrade", "websocket")
+		request.Header.Set("Sec-WebSocket-Version", "13")
+		request.Header.Set("Sec-WebSocket-Key", webSocketKey)
+	}
+
 	resp, err := client.Do(request)
+	collectDNSMetrics(opts.trace, registry)
+	mu.Lock()
+	// The phase durations are only reported once a connection has been
+	// established, like the ip protocol
+	if remoteAddr != nil {
+		durations := newPhaseDurationMetrics(registry)
+		collectDialPhaseMetrics(durations, opts.trace, dialDuration)
+		if !handshakeStart.IsZero() {
+			if handshakeDuration == 0 {
+				handshakeDuration = time.Since(handshakeStart)
+			}
+			collectTLSHandshakeMetrics(handshakeDuration, registry)
+			durations.WithLabelValues("handshake").Set(handshakeDuration.Seconds())
+		}
+	}
+	addr := remoteAddr
+	recorder := hello
+	mu.Unlock()
+	if echOffered {
+		collectECHMetrics(err == nil && resp.TLS != nil && echAccepted(*resp.TLS), registry)
+	}
 	if err != nil {
 		return err
 	}
 	defer func() {
-		_, err := io.Copy(ioutil.Discard, resp.Body)
-		if err != nil {
-			level.Error(logger).Log("msg", err)
+		// The body of a 101 response is the upgraded connection, which
+		// won't reach EOF
+		if resp.StatusCode != http.StatusSwitchingProtocols {
+			_, err := io.Copy(ioutil.Discard, resp.Body)
+			if err != nil {
+				level.Error(logger).Log("msg", err)
+			}
 		}
 		resp.Body.Close()
 	}()
 
+	if addr != nil {
+		collectIPProtocolMetrics(addr, registry)
+	}
+
 	// Check if the response from the target is encrypted
 	if resp.TLS == nil {
 		return fmt.Errorf("The response from %s is unencrypted", targetURL.String())
 	}
 
+	// The chains verified with AIA are checked by DANE, SCT and the SPKI pins
+	state := aia.fill(*resp.TLS)
+
+	collectCurveMetrics(*resp.TLS, registry)
+	if recorder != nil {
+		collectServerHelloMetrics(recorder.serverHello(), resp.TLS.Version, registry)
+	}
+	if err := collectPolicyMetrics(module.Policy, []uint16{resp.TLS.Version}, []uint16{resp.TLS.CipherSuite}, resp.TLS.PeerCertificates, registry); err != nil {
+		return err
+	}
+
+	if module.DANE {
+		collectDANEMetrics(ctx, logger, address, tlsConfig.ServerName, state, opts, registry)
+	}
+
+	if module.CAA {
+		collectCAAMetrics(ctx, logger, address, resp.TLS.PeerCertificates[0], module.CAAIssuers, opts, registry)
+	}
+
+	if module.SCT {
+		collectSCTMetrics(logger, state, module.CTLogs, module.CTLogListFile, registry)
+	}
+
+	if len(module.TrustStores) > 0 {
+		serverName := tlsConfig.ServerName
+		if serverName == "" {
+			serverName = host
+		}
+		collectTrustStoreMetrics(logger, module.TrustStores, *resp.TLS, serverName, registry)
+	}
+
+	if len(module.ExpectedDNSNames) > 0 {
+		collectExpectedNamesMetrics(module.ExpectedDNSNames, resp.TLS.PeerCertificates[0], registry)
+	}
+
+	if len(module.ExpectedFingerprints) > 0 {
+		collectFingerprintMetrics(module.ExpectedFingerprints, resp.TLS.PeerCertificates[0], registry)
+	}
+
+	if len(module.ExpectedSPKIHashes) > 0 {
+		collectSPKIPinMetrics(module.ExpectedSPKIHashes, state, registry)
+	}
+
+	if module.ExpectedIssuer.Regexp != nil {
+		collectExpectedIssuerMetrics(module.ExpectedIssuer.Regexp, resp.TLS.PeerCertificates[0], registry)
+	}
+
+	if module.ExpectedSerial != "" || module.ExpectedAuthorityKeyID != "" {
+		collectSerialMetrics(module.ExpectedSerial, module.ExpectedAuthorityKeyID, resp.TLS.PeerCertificates[0], registry)
+	}
+
+	collectNotYetValidMetrics(resp.TLS.PeerCertificates, module.ClockSkewTolerance, registry)
+
+	if module.CertChanges {
+		collectCertChangeMetrics(module.Name, target, *resp.TLS, registry)
+	}
+
+	if len(module.ExpiryThresholds) > 0 {
+		collectExpiryThresholdMetrics(resp.TLS.PeerCertificates, module.ExpiryThresholds, registry)
+	}
+
+	if module.SessionResumption {
+		// The resumption check connects to the target directly, so it isn't
+		// performed through an http proxy
+		var proxyURL *url.URL
+		if proxy != nil {
+			proxyURL, _ = proxy(request)
+		}
+		if proxyURL == nil {
+			collectSessionResumptionMetrics(ctx, logger, "tcp", address, tlsConfig, module, opts, registry)
+		} else {
+			level.Debug(logger).Log("msg", "Not checking session resumption through an http proxy")
+		}
+	}
+
+	if module.HTTPS.WebSocket {
+		return checkWebSocketUpgrade(resp, webSocketKey)
+	}
+
+	return nil
+}
+
+// newWebSocketKey returns a random value for the Sec-WebSocket-Key header
+func newWebSocketKey() (string, error) {
+	key := make([]byte, 16)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// checkWebSocketUpgrade checks that the response completes the WebSocket
+// opening handshake described in RFC 6455
+func checkWebSocketUpgrade(resp *http.Response, key string) error {
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return fmt.Errorf("The WebSocket upgrade was rejected with status: %s", resp.Status)
+	}
+
+	if !strings.EqualFold(resp.Header.Get("Upgrade"), "websocket") {
+		return fmt.Errorf("The response upgraded to %q rather than websocket", resp.Header.Get("Upgrade"))
+	}
+
+	h := sha1.New()
+	h.Write([]byte(key + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+	if accept := resp.Header.Get("Sec-WebSocket-Accept"); accept != base64.StdEncoding.EncodeToString(h.Sum(nil)) {
+		return fmt.Errorf("The Sec-WebSocket-Accept header is invalid: %s", accept)
+	}
+
 	return nil
 }
@@ -5,14 +5,18 @@ import (
 	"context"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha1"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/base64"
 	"fmt"
 	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"sync"
 	"testing"
 	"time"
 
@@ -56,10 +60,99 @@ func TestProbeHTTPS(t *testing.T) {
 	checkCertificateMetrics(cert, registry, t)
 	checkOCSPMetrics([]byte{}, registry, t)
 	checkTLSVersionMetrics("TLS 1.3", registry, t)
+	checkTLSHandshakeMetrics(registry, t)
+	checkPhaseDurationMetrics([]string{"connect", "handshake"}, registry, t)
+	checkIPProtocolMetrics(4, registry, t)
+}
+
+// TestProbeHTTPSALPN tests that the request is made over HTTP/2 when h2 is
+// offered with alpn_protocols
+func TestProbeHTTPSALPN(t *testing.T) {
+	server, _, _, caFile, teardown, err := test.SetupHTTPSServer()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer teardown()
+
+	protos := make(chan string, 1)
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		protos <- r.Proto
+	})
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	module := config.Module{
+		TLSConfig: config.TLSConfig{
+			CAFile:        caFile,
+			ALPNProtocols: []string{"h2"},
+		},
+	}
+
+	registry := prometheus.NewRegistry()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeHTTPS(ctx, newTestLogger(), server.URL, module, registry); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	if proto := <-protos; proto != "HTTP/2.0" {
+		t.Errorf("expected the request to be made with HTTP/2.0 but it was made with %s", proto)
+	}
+	checkALPNMetrics("h2", registry, t)
 }
 
 // TestProbeHTTPSTimeout tests that the https probe respects the timeout in the
 // context
+func TestProbeHTTPSSessionResumption(t *testing.T) {
+	testcases := []struct {
+		name      string
+		version   uint16
+		disabled  bool
+		supported float64
+	}{
+		{name: "tls12", version: tls.VersionTLS12, supported: 1},
+		{name: "tls13", version: tls.VersionTLS13, supported: 1},
+		{name: "tickets disabled", version: tls.VersionTLS13, disabled: true, supported: 0},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			server, _, _, caFile, teardown, err := test.SetupHTTPSServer()
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+			defer teardown()
+
+			server.TLS.MinVersion = tc.version
+			server.TLS.MaxVersion = tc.version
+			server.TLS.SessionTicketsDisabled = tc.disabled
+			server.StartTLS()
+			defer server.Close()
+
+			module := config.Module{
+				TLSConfig: config.TLSConfig{
+					CAFile: caFile,
+				},
+				SessionResumption: true,
+			}
+
+			registry := prometheus.NewRegistry()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			if err := ProbeHTTPS(ctx, newTestLogger(), server.URL, module, registry); err != nil {
+				t.Fatalf("error: %s", err)
+			}
+
+			checkSessionResumptionMetrics(tc.supported, registry, t)
+		})
+	}
+}
+
 func TestProbeHTTPSTimeout(t *testing.T) {
 	server, _, _, caFile, teardown, err := test.SetupHTTPSServer()
 	if err != nil {
@@ -489,6 +582,91 @@ func TestProbeHTTPSProxy(t *testing.T) {
 	checkTLSVersionMetrics("TLS 1.3", registry, t)
 }
 
+// TestProbeHTTPSWebSocket tests the WebSocket upgrade handshake
+func TestProbeHTTPSWebSocket(t *testing.T) {
+	server, certPEM, _, caFile, teardown, err := test.SetupHTTPSServer()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer teardown()
+
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "websocket" || r.Header.Get("Sec-WebSocket-Version") != "13" {
+			http.Error(w, "Upgrade Required", http.StatusUpgradeRequired)
+			return
+		}
+		h := sha1.New()
+		h.Write([]byte(r.Header.Get("Sec-WebSocket-Key") + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+
+		fmt.Fprintf(conn, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", base64.StdEncoding.EncodeToString(h.Sum(nil)))
+	})
+
+	server.StartTLS()
+	defer server.Close()
+
+	module := config.Module{
+		HTTPS: config.HTTPSProbe{
+			WebSocket: true,
+		},
+		TLSConfig: config.TLSConfig{
+			CAFile: caFile,
+		},
+	}
+
+	registry := prometheus.NewRegistry()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeHTTPS(ctx, newTestLogger(), server.URL, module, registry); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	cert, err := newCertificate(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCertificateMetrics(cert, registry, t)
+	checkOCSPMetrics([]byte{}, registry, t)
+	checkTLSVersionMetrics("TLS 1.3", registry, t)
+}
+
+// TestProbeHTTPSWebSocketNoUpgrade tests that the probe fails when the server
+// doesn't upgrade the connection
+func TestProbeHTTPSWebSocketNoUpgrade(t *testing.T) {
+	server, _, _, caFile, teardown, err := test.SetupHTTPSServer()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer teardown()
+
+	server.StartTLS()
+	defer server.Close()
+
+	module := config.Module{
+		HTTPS: config.HTTPSProbe{
+			WebSocket: true,
+		},
+		TLSConfig: config.TLSConfig{
+			CAFile: caFile,
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeHTTPS(ctx, newTestLogger(), server.URL, module, prometheus.NewRegistry()); err == nil {
+		t.Fatalf("expected error, but err was nil")
+	}
+}
+
 // TestProbeHTTPSOCSP tests a HTTPS probe with OCSP stapling
 func TestProbeHTTPSOCSP(t *testing.T) {
 	server, certPEM, keyPEM, caFile, teardown, err := test.SetupHTTPSServer()
@@ -616,3 +794,219 @@ func TestProbeHTTPSVerifiedChains(t *testing.T) {
 	checkVerifiedChainMetrics(verifiedChains, registry, t)
 	checkTLSVersionMetrics("TLS 1.3", registry, t)
 }
+
+// TestProbeHTTPSProxyProtocol tests sending the PROXY protocol header to a
+// server that requires it
+func TestProbeHTTPSProxyProtocol(t *testing.T) {
+	server, certPEM, _, caFile, teardown, err := test.SetupHTTPSServer()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer teardown()
+
+	server.Listener = test.NewProxyProtocolListener(server.Listener, "v2")
+	server.StartTLS()
+	defer server.Close()
+
+	module := config.Module{
+		HTTPS: config.HTTPSProbe{
+			ProxyProtocol: "v2",
+		},
+		TLSConfig: config.TLSConfig{
+			CAFile: caFile,
+		},
+	}
+
+	registry := prometheus.NewRegistry()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeHTTPS(ctx, newTestLogger(), server.URL, module, registry); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	cert, err := newCertificate(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCertificateMetrics(cert, registry, t)
+
+	// The server should reject probes without the header
+	module.HTTPS.ProxyProtocol = ""
+	if err := ProbeHTTPS(ctx, newTestLogger(), server.URL, module, prometheus.NewRegistry()); err == nil {
+		t.Fatalf("expected error but err was nil")
+	}
+}
+
+// TestProbeHTTPSSOCKS5Proxy tests the socks5_proxy field in the configuration
+func TestProbeHTTPSSOCKS5Proxy(t *testing.T) {
+	server, certPEM, _, caFile, teardown, err := test.SetupHTTPSServer()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer teardown()
+
+	server.StartTLS()
+	defer server.Close()
+
+	proxyServer, err := test.SetupSOCKS5Server("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxyServer.Close()
+
+	module := config.Module{
+		HTTPS: config.HTTPSProbe{
+			SOCKS5Proxy: config.SOCKS5Proxy{
+				Address: proxyServer.Listener.Addr().String(),
+			},
+		},
+		TLSConfig: config.TLSConfig{
+			CAFile: caFile,
+		},
+	}
+
+	registry := prometheus.NewRegistry()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeHTTPS(ctx, newTestLogger(), server.URL, module, registry); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	cert, err := newCertificate(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCertificateMetrics(cert, registry, t)
+}
+
+// TestProbeHTTPSIDN tests that Unicode host names in the target are converted
+// to punycode
+func TestProbeHTTPSIDN(t *testing.T) {
+	server, _, _, caFile, teardown, err := test.SetupHTTPSServer()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer teardown()
+
+	serverNames := make(chan string, 1)
+	server.TLS.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		serverNames <- hello.ServerName
+		return nil, nil
+	}
+
+	server.StartTLS()
+	defer server.Close()
+
+	dnsServer, err := test.SetupDNSServer(map[string][]net.IP{
+		"xn--bcher-kva.example.test.": []net.IP{net.ParseIP("127.0.0.1")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dnsServer.Close()
+
+	_, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	module := config.Module{
+		Resolver: dnsServer.Conn.LocalAddr().String(),
+		TLSConfig: config.TLSConfig{
+			CAFile:             caFile,
+			InsecureSkipVerify: true,
+		},
+	}
+
+	registry := prometheus.NewRegistry()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeHTTPS(ctx, newTestLogger(), "https://"+net.JoinHostPort("bücher.example.test", port), module, registry); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	if serverName := <-serverNames; serverName != "xn--bcher-kva.example.test" {
+		t.Errorf("expected server name xn--bcher-kva.example.test but got %s", serverName)
+	}
+}
+
+// TestProbeHTTPSCertChanged tests that a certificate that changes between
+// probes of the same target is reported
+func TestProbeHTTPSCertChanged(t *testing.T) {
+	server, certPEM, keyPEM, caFile, teardown, err := test.SetupHTTPSServer()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer teardown()
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newCertPEM, newKeyPEM := test.GenerateTestCertificate(time.Now().AddDate(0, 0, 2))
+	newCert, err := tls.X509KeyPair(newCertPEM, newKeyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		mu      sync.Mutex
+		current = &cert
+	)
+	server.TLS.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return &tls.Config{Certificates: []tls.Certificate{*current}}, nil
+	}
+
+	server.StartTLS()
+	defer server.Close()
+
+	module := config.Module{
+		TLSConfig: config.TLSConfig{
+			CAFile:             caFile,
+			InsecureSkipVerify: true,
+		},
+		CertChanges: true,
+	}
+
+	testcases := []struct {
+		name    string
+		cert    *tls.Certificate
+		changed float64
+		changes float64
+	}{
+		{name: "first probe", cert: &cert},
+		{name: "unchanged", cert: &cert},
+		{name: "changed", cert: &newCert, changed: 1, changes: 1},
+		{name: "changed back", cert: &cert, changed: 1, changes: 2},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mu.Lock()
+			current = tc.cert
+			mu.Unlock()
+
+			registry := prometheus.NewRegistry()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			if err := ProbeHTTPS(ctx, newTestLogger(), server.URL, module, registry); err != nil {
+				t.Fatalf("error: %s", err)
+			}
+			// The certificates are recorded with the outcome of the probe,
+			// like the probe handler does
+			History.ObserveOutcome(module.Name, server.URL, nil)
+
+			checkCertChangeMetrics(tc.changed, tc.changes, registry, t)
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package prober
+
+import (
+	"fmt"
+	"net"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+)
+
+// toASCIIHost converts an internationalized host name to the punycode form
+// that is used in DNS queries and SNI. Names that are already ASCII, including
+// IP addresses, are returned unchanged.
+func toASCIIHost(host string) (string, error) {
+	for i := 0; i < len(host); i++ {
+		if host[i] < utf8.RuneSelf {
+			continue
+		}
+		ascii, err := idna.Lookup.ToASCII(host)
+		if err != nil {
+			return "", fmt.Errorf("invalid internationalized host name %s: %s", host, err)
+		}
+		return ascii, nil
+	}
+
+	return host, nil
+}
+
+// toASCIIAddress converts the host in a host:port address with toASCIIHost.
+// Addresses without a port are returned unchanged.
+func toASCIIAddress(address string) (string, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return address, nil
+	}
+
+	host, err = toASCIIHost(host)
+	if err != nil {
+		return "", err
+	}
+
+	return net.JoinHostPort(host, port), nil
+}
@@ -1,11 +1,17 @@
 package prober
 
 import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/asn1"
 	"encoding/base64"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"sort"
 	"strconv"
 	"strings"
@@ -14,12 +20,17 @@ import (
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/ribbybibby/ssl_exporter/v2/config"
 	"golang.org/x/crypto/ocsp"
+	"golang.org/x/crypto/ssh"
 	v1 "k8s.io/api/core/v1"
 )
 
 const (
 	namespace = "ssl"
+
+	// versionDTLS12 is the wire version of DTLS 1.2
+	versionDTLS12 = 0xfefd
 )
 
 func collectConnectionStateMetrics(state tls.ConnectionState, registry *prometheus.Registry) error {
@@ -27,7 +38,11 @@ func collectConnectionStateMetrics(state tls.ConnectionState, registry *promethe
 		return err
 	}
 
-	if err := collectCertificateMetrics(state.PeerCertificates, registry); err != nil {
+	collectCipherSuiteMetrics(tls.CipherSuiteName(state.CipherSuite), registry)
+
+	collectALPNMetrics(state.NegotiatedProtocol, registry)
+
+	if err := collectCertificateMetrics(state.PeerCertificates, state.VerifiedChains, registry); err != nil {
 		return err
 	}
 
@@ -35,6 +50,12 @@ func collectConnectionStateMetrics(state tls.ConnectionState, registry *promethe
 		return err
 	}
 
+	collectChainMetrics(state.PeerCertificates, state.VerifiedChains, registry)
+
+	if err := collectMustStapleMetrics(state.PeerCertificates, len(state.OCSPResponse) > 0, registry); err != nil {
+		return err
+	}
+
 	return collectOCSPMetrics(state.OCSPResponse, registry)
 }
 
@@ -50,26 +71,311 @@ func collectTLSVersionMetrics(version uint16, registry *prometheus.Registry) err
 	)
 	registry.MustRegister(tlsVersion)
 
-	var v string
+	tlsVersion.WithLabelValues(tlsVersionName(version)).Set(1)
+
+	return nil
+}
+
+// tlsVersionName returns the label value of a TLS or DTLS version
+func tlsVersionName(version uint16) string {
 	switch version {
 	case tls.VersionTLS10:
-		v = "TLS 1.0"
+		return "TLS 1.0"
 	case tls.VersionTLS11:
-		v = "TLS 1.1"
+		return "TLS 1.1"
 	case tls.VersionTLS12:
-		v = "TLS 1.2"
+		return "TLS 1.2"
 	case tls.VersionTLS13:
-		v = "TLS 1.3"
+		return "TLS 1.3"
+	case versionDTLS12:
+		return "DTLS 1.2"
 	default:
-		v = "unknown"
+		return "unknown"
+	}
+}
+
+// newPhaseDurationMetrics registers the gauge that the duration of each phase
+// of a probe is reported to
+func newPhaseDurationMetrics(registry *prometheus.Registry) *prometheus.GaugeVec {
+	var (
+		phaseDuration = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "probe", "duration_seconds"),
+				Help: "The duration of each phase of the probe in seconds",
+			},
+			[]string{"phase"},
+		)
+	)
+	registry.MustRegister(phaseDuration)
+
+	return phaseDuration
+}
+
+// collectCipherSuiteMetrics reports the cipher suite negotiated with the server
+func collectCipherSuiteMetrics(cipher string, registry *prometheus.Registry) {
+	var (
+		cipherSuite = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "cipher_suite_info"),
+				Help: "The cipher suite negotiated with the server",
+			},
+			[]string{"cipher"},
+		)
+	)
+	registry.MustRegister(cipherSuite)
+
+	cipherSuite.WithLabelValues(cipher).Set(1)
+}
+
+// collectALPNMetrics collects the application protocol selected by the
+// server, if it selected one
+func collectALPNMetrics(protocol string, registry *prometheus.Registry) {
+	if protocol == "" {
+		return
+	}
+
+	var (
+		alpnProtocol = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "alpn_protocol_info"),
+				Help: "The application protocol selected by the server with ALPN",
+			},
+			[]string{"protocol"},
+		)
+	)
+	registry.MustRegister(alpnProtocol)
+
+	alpnProtocol.WithLabelValues(protocol).Set(1)
+}
+
+// pqHybridCurves are the hybrid post-quantum key exchange groups, by their
+// IDs, as not all of them are defined by every version of crypto/tls
+var pqHybridCurves = map[tls.CurveID]bool{
+	0x11eb: true, // SecP256r1MLKEM768
+	0x11ec: true, // X25519MLKEM768
+	0x11ed: true, // SecP384r1MLKEM1024
+	0x6399: true, // X25519Kyber768Draft00
+}
+
+// collectCurveMetrics collects the key exchange group from the state of a
+// completed handshake. The group isn't known when the certificates are
+// verified in a TLS 1.2 handshake.
+func collectCurveMetrics(state tls.ConnectionState, registry *prometheus.Registry) {
+	if !curveSupported {
+		return
+	}
+
+	var (
+		pqHybrid = prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "pq_hybrid_key_exchange"),
+				Help: "If a hybrid post-quantum key exchange group was negotiated with the server",
+			},
+		)
+	)
+	registry.MustRegister(pqHybrid)
+
+	id := curveID(state)
+	if pqHybridCurves[id] {
+		pqHybrid.Set(1)
+	}
+	if id == 0 {
+		return
+	}
+
+	var (
+		curveInfo = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "curve_info"),
+				Help: "The key exchange group negotiated with the server",
+			},
+			[]string{"curve"},
+		)
+	)
+	registry.MustRegister(curveInfo)
+
+	curveInfo.WithLabelValues(id.String()).Set(1)
+}
+
+// collectServerHelloMetrics collects the extensions in the ServerHello sent by
+// the target. Nothing is reported when the ServerHello wasn't read.
+func collectServerHelloMetrics(hello *serverHello, version uint16, registry *prometheus.Registry) {
+	if hello == nil {
+		return
+	}
+
+	// There isn't any renegotiation in TLS 1.3
+	if version < tls.VersionTLS13 {
+		var (
+			secureRenegotiation = prometheus.NewGauge(
+				prometheus.GaugeOpts{
+					Name: prometheus.BuildFQName(namespace, "", "secure_renegotiation_supported"),
+					Help: "If the server supports secure renegotiation with the renegotiation_info extension",
+				},
+			)
+		)
+		registry.MustRegister(secureRenegotiation)
+
+		if hello.hasExtension(extensionRenegotiationInfo) {
+			secureRenegotiation.Set(1)
+		}
+	}
+
+	var (
+		extendedMasterSecret = prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "extended_master_secret"),
+				Help: "If the master secret is bound to the handshake with the extended_master_secret extension",
+			},
+		)
+	)
+	registry.MustRegister(extendedMasterSecret)
+
+	// The TLS 1.3 key schedule always binds the secrets to the handshake
+	if version >= tls.VersionTLS13 || hello.hasExtension(extensionExtendedMasterSecret) {
+		extendedMasterSecret.Set(1)
+	}
+
+	var (
+		ja3s = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "ja3s_info"),
+				Help: "The JA3S fingerprint of the ServerHello",
+			},
+			[]string{"ja3s"},
+		)
+	)
+	registry.MustRegister(ja3s)
+
+	ja3s.WithLabelValues(hello.ja3s()).Set(1)
+}
+
+// collectDialPhaseMetrics splits the duration of a dial into the resolve and
+// connect phases
+func collectDialPhaseMetrics(durations *prometheus.GaugeVec, trace *dialTrace, dialDuration time.Duration) {
+	if trace.resolved {
+		durations.WithLabelValues("resolve").Set(trace.dnsLookupTime.Seconds())
+		dialDuration -= trace.dnsLookupTime
+	}
+	durations.WithLabelValues("connect").Set(dialDuration.Seconds())
+}
+
+// collectTLSHandshakeMetrics reports the duration of the TLS handshake, whether
+// it succeeded or not
+func collectTLSHandshakeMetrics(duration time.Duration, registry *prometheus.Registry) {
+	var (
+		handshakeDuration = prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "tls", "handshake_duration_seconds"),
+				Help: "The duration of the TLS handshake in seconds",
+			},
+		)
+	)
+	registry.MustRegister(handshakeDuration)
+
+	handshakeDuration.Set(duration.Seconds())
+}
+
+// collectIPProtocolMetrics reports the IP version of the connection to the
+// target. Nothing is reported for connections that aren't over IP, like unix
+// sockets.
+func collectIPProtocolMetrics(addr net.Addr, registry *prometheus.Registry) {
+	var ip net.IP
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		ip = a.IP
+	case *net.UDPAddr:
+		ip = a.IP
+	default:
+		return
+	}
+
+	var (
+		ipProtocol = prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "probe_ip_protocol"),
+				Help: "The IP protocol version used to connect to the target (4 or 6)",
+			},
+		)
+	)
+	registry.MustRegister(ipProtocol)
+
+	if ip.To4() != nil {
+		ipProtocol.Set(4)
+	} else {
+		ipProtocol.Set(6)
+	}
+}
+
+// collectDNSMetrics reports the lookup of the target host name. Nothing is
+// reported when the host wasn't looked up, like when it's an IP address or is
+// resolved by a proxy.
+func collectDNSMetrics(trace *dialTrace, registry *prometheus.Registry) {
+	if !trace.resolved {
+		return
+	}
+
+	var (
+		dnsLookupTime = prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "probe", "dns_lookup_time_seconds"),
+				Help: "The time taken to resolve the target host name in seconds",
+			},
+		)
+		dnsResolver = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "dns_resolver_info"),
+				Help: "The DNS resolver used to resolve the target host name",
+			},
+			[]string{"resolver"},
+		)
+	)
+	registry.MustRegister(dnsLookupTime, dnsResolver)
+
+	dnsLookupTime.Set(trace.dnsLookupTime.Seconds())
+	dnsResolver.WithLabelValues(trace.resolver).Set(1)
+
+	if !trace.dnssec {
+		return
 	}
 
-	tlsVersion.WithLabelValues(v).Set(1)
+	var (
+		dnssecValid = prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "dnssec_valid"),
+				Help: "If the records of the target host name were authenticated with DNSSEC",
+			},
+		)
+	)
+	registry.MustRegister(dnssecValid)
+
+	if trace.dnssecValid {
+		dnssecValid.Set(1)
+	}
+}
+
+func collectSMTPCapabilityMetrics(capabilities []string, registry *prometheus.Registry) error {
+	var (
+		smtpCapability = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "smtp_capability_info"),
+				Help: "The capabilities advertised by the smtp server in response to EHLO",
+			},
+			[]string{"capability"},
+		)
+	)
+	registry.MustRegister(smtpCapability)
+
+	for _, capability := range capabilities {
+		smtpCapability.WithLabelValues(capability).Set(1)
+	}
 
 	return nil
 }
 
-func collectCertificateMetrics(certs []*x509.Certificate, registry *prometheus.Registry) error {
+// collectCertificateMetrics collects the metrics of each certificate. The roots
+// of the verified chains are trust anchors, whose signatures aren't weak.
+func collectCertificateMetrics(certs []*x509.Certificate, verifiedChains [][]*x509.Certificate, registry *prometheus.Registry) error {
 	var (
 		notAfter = prometheus.NewGaugeVec(
 			prometheus.GaugeOpts{
@@ -85,8 +391,85 @@ func collectCertificateMetrics(certs []*x509.Certificate, registry *prometheus.R
 			},
 			[]string{"serial_no", "issuer_cn", "cn", "dnsnames", "ips", "emails", "ou"},
 		)
+		keyInfo = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "cert_key_info"),
+				Help: "The algorithm, size and curve of the public key",
+			},
+			[]string{"serial_no", "issuer_cn", "cn", "dnsnames", "ips", "emails", "ou", "algorithm", "bits", "curve"},
+		)
+		signatureInfo = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "cert_signature_info"),
+				Help: "The algorithm that the certificate is signed with",
+			},
+			[]string{"serial_no", "issuer_cn", "cn", "dnsnames", "ips", "emails", "ou", "algorithm"},
+		)
+		weakSignature = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "cert_weak_signature"),
+				Help: "If the certificate is signed with MD2, MD5 or SHA-1",
+			},
+			[]string{"serial_no", "issuer_cn", "cn", "dnsnames", "ips", "emails", "ou"},
+		)
+		isCA = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "cert_ca"),
+				Help: "If the basic constraints of the certificate allow it to issue certificates",
+			},
+			[]string{"serial_no", "issuer_cn", "cn", "dnsnames", "ips", "emails", "ou"},
+		)
+		maxPathLen = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "cert_max_path_len"),
+				Help: "The maximum number of intermediates that may follow a CA certificate in a chain, when its basic constraints limit it",
+			},
+			[]string{"serial_no", "issuer_cn", "cn", "dnsnames", "ips", "emails", "ou"},
+		)
+		keyUsage = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "cert_key_usage_info"),
+				Help: "The key usages of the certificate",
+			},
+			[]string{"serial_no", "issuer_cn", "cn", "dnsnames", "ips", "emails", "ou", "usage"},
+		)
+		extKeyUsage = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "cert_ext_key_usage_info"),
+				Help: "The extended key usages of the certificate",
+			},
+			[]string{"serial_no", "issuer_cn", "cn", "dnsnames", "ips", "emails", "ou", "usage"},
+		)
+		sans = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "cert_sans"),
+				Help: "The number of subject alternative names of the certificate",
+			},
+			[]string{"serial_no", "issuer_cn", "cn", "dnsnames", "ips", "emails", "ou"},
+		)
+		wildcard = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "cert_wildcard"),
+				Help: "If any of the DNS names of the certificate are wildcards",
+			},
+			[]string{"serial_no", "issuer_cn", "cn", "dnsnames", "ips", "emails", "ou"},
+		)
+		ipSANs = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "cert_has_ip_sans"),
+				Help: "If the certificate has IP address subject alternative names",
+			},
+			[]string{"serial_no", "issuer_cn", "cn", "dnsnames", "ips", "emails", "ou"},
+		)
+		lifetimeElapsed = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "cert_lifetime_elapsed_ratio"),
+				Help: "The fraction of the validity period of the certificate that has elapsed, from 0 at NotBefore to 1 at NotAfter",
+			},
+			[]string{"serial_no", "issuer_cn", "cn", "dnsnames", "ips", "emails", "ou"},
+		)
 	)
-	registry.MustRegister(notAfter, notBefore)
+	registry.MustRegister(notAfter, notBefore, lifetimeElapsed, keyInfo, signatureInfo, weakSignature, isCA, maxPathLen, keyUsage, extKeyUsage, sans, wildcard, ipSANs)
 
 	certs = uniq(certs)
 
@@ -94,6 +477,13 @@ func collectCertificateMetrics(certs []*x509.Certificate, registry *prometheus.R
 		return fmt.Errorf("No certificates found")
 	}
 
+	var anchors []*x509.Certificate
+	for _, chain := range verifiedChains {
+		if len(chain) > 0 {
+			anchors = append(anchors, chain[len(chain)-1])
+		}
+	}
+
 	for _, cert := range certs {
 		labels := labelValues(cert)
 
@@ -104,6 +494,50 @@ func collectCertificateMetrics(certs []*x509.Certificate, registry *prometheus.R
 		if !cert.NotBefore.IsZero() {
 			notBefore.WithLabelValues(labels...).Set(float64(cert.NotBefore.Unix()))
 		}
+
+		if cert.NotAfter.After(cert.NotBefore) {
+			lifetimeElapsed.WithLabelValues(labels...).Set(lifetimeElapsedRatio(cert, time.Now()))
+		}
+
+		keyInfo.WithLabelValues(append(labels, keyLabelValues(cert)...)...).Set(1)
+		signatureInfo.WithLabelValues(append(labels, cert.SignatureAlgorithm.String())...).Set(1)
+		var weak float64
+		if hasWeakSignature(cert, anchors) {
+			weak = 1
+		}
+		weakSignature.WithLabelValues(labels...).Set(weak)
+
+		for _, usage := range keyUsageNames(cert.KeyUsage) {
+			keyUsage.WithLabelValues(append(labels, usage)...).Set(1)
+		}
+		for _, usage := range extKeyUsageNames(cert) {
+			extKeyUsage.WithLabelValues(append(labels, usage)...).Set(1)
+		}
+
+		sans.WithLabelValues(labels...).Set(float64(len(cert.DNSNames) + len(cert.IPAddresses) + len(cert.EmailAddresses) + len(cert.URIs)))
+		var hasWildcard float64
+		for _, name := range cert.DNSNames {
+			if strings.HasPrefix(name, "*.") {
+				hasWildcard = 1
+				break
+			}
+		}
+		wildcard.WithLabelValues(labels...).Set(hasWildcard)
+		var hasIPSANs float64
+		if len(cert.IPAddresses) > 0 {
+			hasIPSANs = 1
+		}
+		ipSANs.WithLabelValues(labels...).Set(hasIPSANs)
+
+		if !cert.IsCA {
+			isCA.WithLabelValues(labels...).Set(0)
+			continue
+		}
+		isCA.WithLabelValues(labels...).Set(1)
+		// A MaxPathLen of 0 is only a limit when MaxPathLenZero is set
+		if cert.MaxPathLen > 0 || cert.MaxPathLenZero {
+			maxPathLen.WithLabelValues(labels...).Set(float64(cert.MaxPathLen))
+		}
 	}
 
 	return nil
@@ -125,27 +559,42 @@ func collectVerifiedChainMetrics(verifiedChains [][]*x509.Certificate, registry
 			},
 			[]string{"chain_no", "serial_no", "issuer_cn", "cn", "dnsnames", "ips", "emails", "ou"},
 		)
+		chains = prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "verified_chains"),
+				Help: "The number of chains that the certificates presented by the target were verified with",
+			},
+		)
+		chainNotAfter = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "verified_chain_not_after"),
+				Help: "NotAfter expressed as a Unix Epoch Time of the certificate in the verified chain that expires first",
+			},
+			[]string{"chain_no", "root_cn", "serial_no", "issuer_cn", "cn"},
+		)
 	)
-	registry.MustRegister(verifiedNotAfter, verifiedNotBefore)
+	registry.MustRegister(verifiedNotAfter, verifiedNotBefore, chains, chainNotAfter)
 
 	sort.Slice(verifiedChains, func(i, j int) bool {
-		iExpiry := time.Time{}
-		for _, cert := range verifiedChains[i] {
-			if (iExpiry.IsZero() || cert.NotAfter.Before(iExpiry)) && !cert.NotAfter.IsZero() {
-				iExpiry = cert.NotAfter
-			}
-		}
-		jExpiry := time.Time{}
-		for _, cert := range verifiedChains[j] {
-			if (jExpiry.IsZero() || cert.NotAfter.Before(jExpiry)) && !cert.NotAfter.IsZero() {
-				jExpiry = cert.NotAfter
-			}
+		iExpiry := firstToExpire(verifiedChains[i])
+		jExpiry := firstToExpire(verifiedChains[j])
+		if iExpiry == nil || jExpiry == nil {
+			return jExpiry == nil && iExpiry != nil
 		}
 
-		return iExpiry.After(jExpiry)
+		return iExpiry.NotAfter.After(jExpiry.NotAfter)
 	})
 
+	chains.Set(float64(len(verifiedChains)))
+
 	for i, chain := range verifiedChains {
+		// Each path, like one through a cross-signed root, is only as
+		// good as its certificate that expires first
+		if cert := firstToExpire(chain); cert != nil {
+			root := chain[len(chain)-1]
+			chainNotAfter.WithLabelValues(strconv.Itoa(i), root.Subject.CommonName, cert.SerialNumber.String(), cert.Issuer.CommonName, cert.Subject.CommonName).Set(float64(cert.NotAfter.Unix()))
+		}
+
 		chain = uniq(chain)
 		for _, cert := range chain {
 			chainNo := strconv.Itoa(i)
@@ -164,53 +613,260 @@ func collectVerifiedChainMetrics(verifiedChains [][]*x509.Certificate, registry
 	return nil
 }
 
-func collectOCSPMetrics(ocspResponse []byte, registry *prometheus.Registry) error {
+// firstToExpire returns the certificate in the chain that expires first
+func firstToExpire(chain []*x509.Certificate) *x509.Certificate {
+	var first *x509.Certificate
+	for _, cert := range chain {
+		if cert.NotAfter.IsZero() {
+			continue
+		}
+		if first == nil || cert.NotAfter.Before(first.NotAfter) {
+			first = cert
+		}
+	}
+
+	return first
+}
+
+// collectChainMetrics checks the certificates presented by the target for
+// misconfigurations that strict clients reject, which are intermediates that
+// aren't presented, certificates that aren't in the order of the chain,
+// roots that are presented with it and certificates that have expired. The
+// certificates are also exported by their position in the chain, from 0 for
+// the leaf, and the leaf is checked for whether it is self-signed and when it
+// was issued.
+func collectChainMetrics(certs []*x509.Certificate, verifiedChains [][]*x509.Certificate, registry *prometheus.Registry) {
 	var (
-		ocspStapled = prometheus.NewGauge(
+		outOfOrder = prometheus.NewGauge(
 			prometheus.GaugeOpts{
-				Name: prometheus.BuildFQName(namespace, "", "ocsp_response_stapled"),
-				Help: "If the connection state contains a stapled OCSP response",
+				Name: prometheus.BuildFQName(namespace, "", "chain_out_of_order"),
+				Help: "If the certificates presented by the target aren't in the order of the chain, with each certificate followed by its issuer",
 			},
 		)
-		ocspStatus = prometheus.NewGauge(
+		rootIncluded = prometheus.NewGauge(
 			prometheus.GaugeOpts{
-				Name: prometheus.BuildFQName(namespace, "", "ocsp_response_status"),
-				Help: "The status in the OCSP response 0=Good 1=Revoked 2=Unknown",
+				Name: prometheus.BuildFQName(namespace, "", "chain_root_included"),
+				Help: "If the target presents a self-signed root certificate along with its chain",
 			},
 		)
-		ocspProducedAt = prometheus.NewGauge(
+		selfSigned = prometheus.NewGauge(
 			prometheus.GaugeOpts{
-				Name: prometheus.BuildFQName(namespace, "", "ocsp_response_produced_at"),
-				Help: "The producedAt value in the OCSP response, expressed as a Unix Epoch Time",
+				Name: prometheus.BuildFQName(namespace, "", "cert_self_signed"),
+				Help: "If the leaf certificate presented by the target is self-signed",
 			},
 		)
-		ocspThisUpdate = prometheus.NewGauge(
+		leafIssued = prometheus.NewGauge(
 			prometheus.GaugeOpts{
-				Name: prometheus.BuildFQName(namespace, "", "ocsp_response_this_update"),
-				Help: "The thisUpdate value in the OCSP response, expressed as a Unix Epoch Time",
+				Name: prometheus.BuildFQName(namespace, "", "cert_leaf_issued_timestamp"),
+				Help: "NotBefore of the leaf certificate presented by the target expressed as a Unix Epoch Time, which changes when the leaf is reissued",
 			},
 		)
-		ocspNextUpdate = prometheus.NewGauge(
+		leafAge = prometheus.NewGauge(
 			prometheus.GaugeOpts{
-				Name: prometheus.BuildFQName(namespace, "", "ocsp_response_next_update"),
-				Help: "The nextUpdate value in the OCSP response, expressed as a Unix Epoch Time",
+				Name: prometheus.BuildFQName(namespace, "", "cert_leaf_age_seconds"),
+				Help: "The number of seconds since the NotBefore of the leaf certificate presented by the target",
 			},
 		)
-		ocspRevokedAt = prometheus.NewGauge(
+		expiredCerts = prometheus.NewGauge(
 			prometheus.GaugeOpts{
-				Name: prometheus.BuildFQName(namespace, "", "ocsp_response_revoked_at"),
-				Help: "The revocationTime value in the OCSP response, expressed as a Unix Epoch Time",
+				Name: prometheus.BuildFQName(namespace, "", "chain_expired_certs"),
+				Help: "The number of certificates presented by the target that have expired, including those that the verified chains don't use",
 			},
 		)
+		chainNotAfter = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "chain_cert_not_after"),
+				Help: "NotAfter expressed as a Unix Epoch Time for each certificate presented by the target, by its position and whether a verified chain uses it",
+			},
+			[]string{"position", "verified", "serial_no", "issuer_cn", "cn", "dnsnames", "ips", "emails", "ou"},
+		)
+		chainNotBefore = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "chain_cert_not_before"),
+				Help: "NotBefore expressed as a Unix Epoch Time for each certificate presented by the target, by its position and whether a verified chain uses it",
+			},
+			[]string{"position", "verified", "serial_no", "issuer_cn", "cn", "dnsnames", "ips", "emails", "ou"},
+		)
+		chainInfo = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "chain_cert_info"),
+				Help: "The subject and issuer of each certificate presented by the target, by its position",
+			},
+			[]string{"position", "serial_no", "subject", "issuer"},
+		)
 	)
-	registry.MustRegister(
-		ocspStapled,
-		ocspStatus,
-		ocspProducedAt,
-		ocspThisUpdate,
-		ocspNextUpdate,
-		ocspRevokedAt,
+	registry.MustRegister(outOfOrder, rootIncluded, selfSigned, leafIssued, leafAge, expiredCerts, chainNotAfter, chainNotBefore, chainInfo)
+
+	if len(certs) == 0 {
+		return
+	}
+
+	if isSelfSigned(certs[0]) {
+		selfSigned.Set(1)
+	}
+
+	leafIssued.Set(float64(certs[0].NotBefore.Unix()))
+	leafAge.Set(time.Since(certs[0].NotBefore).Seconds())
+
+	// An expired certificate that is presented can break clients that
+	// don't build an alternative path around it, even when the verified
+	// chains don't use it
+	var expired int
+	for i, cert := range certs {
+		if time.Now().After(cert.NotAfter) {
+			expired++
+		}
+		var verified bool
+		for _, chain := range verifiedChains {
+			if containsCert(chain, cert) {
+				verified = true
+				break
+			}
+		}
+		position := strconv.Itoa(i)
+		labels := append([]string{position, strconv.FormatBool(verified)}, labelValues(cert)...)
+		chainNotAfter.WithLabelValues(labels...).Set(float64(cert.NotAfter.Unix()))
+		chainNotBefore.WithLabelValues(labels...).Set(float64(cert.NotBefore.Unix()))
+		chainInfo.WithLabelValues(position, cert.SerialNumber.String(), cert.Subject.String(), cert.Issuer.String()).Set(1)
+	}
+	expiredCerts.Set(float64(expired))
+
+	// The chain is followed from the leaf through the certificates that
+	// issued each other
+	path := []*x509.Certificate{certs[0]}
+	for len(path) < len(certs) {
+		issuer := issuerOf(path[len(path)-1], [][]*x509.Certificate{certs})
+		if issuer == nil || containsCert(path, issuer) {
+			break
+		}
+		path = append(path, issuer)
+	}
+	for i, cert := range path {
+		if !cert.Equal(certs[i]) {
+			outOfOrder.Set(1)
+			break
+		}
+	}
+
+	for _, cert := range certs[1:] {
+		if isSelfSigned(cert) {
+			rootIncluded.Set(1)
+			break
+		}
+	}
+
+	if len(verifiedChains) == 0 {
+		return
+	}
+
+	var (
+		missingIntermediates = prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "chain_missing_intermediates"),
+				Help: "The number of intermediates in the verified chain that the target doesn't present",
+			},
+		)
 	)
+	registry.MustRegister(missingIntermediates)
+
+	// The chain that needs the fewest certificates that aren't presented is
+	// the one that clients are most likely to build
+	missing := -1
+	for _, chain := range verifiedChains {
+		var n int
+		// The last certificate is the trust anchor
+		for i := 1; i < len(chain)-1; i++ {
+			if !containsCert(certs, chain[i]) {
+				n++
+			}
+		}
+		if missing == -1 || n < missing {
+			missing = n
+		}
+	}
+	missingIntermediates.Set(float64(missing))
+}
+
+// containsCert returns true if the certificate is one of the certificates
+func containsCert(certs []*x509.Certificate, cert *x509.Certificate) bool {
+	for _, c := range certs {
+		if c.Equal(cert) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isSelfSigned returns true if the certificate is signed by its own key
+func isSelfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawSubject, cert.RawIssuer) && cert.CheckSignatureFrom(cert) == nil
+}
+
+// oidTLSFeature is the TLS Feature extension from RFC 7633
+var oidTLSFeature = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 24}
+
+// tlsFeatureStatusRequest is the status_request feature, which requires the
+// server to staple an OCSP response
+const tlsFeatureStatusRequest = 5
+
+// collectMustStapleMetrics collects whether the leaf certificate requires an
+// OCSP response to be stapled, and whether one was missing
+func collectMustStapleMetrics(certs []*x509.Certificate, stapled bool, registry *prometheus.Registry) error {
+	var (
+		mustStaple = prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "ocsp_must_staple"),
+				Help: "If the leaf certificate has the TLS Feature extension with status_request (OCSP must-staple)",
+			},
+		)
+		mustStapleMissing = prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "ocsp_must_staple_missing"),
+				Help: "If the leaf certificate is OCSP must-staple but an OCSP response wasn't stapled",
+			},
+		)
+	)
+	registry.MustRegister(mustStaple, mustStapleMissing)
+
+	if len(certs) == 0 {
+		return nil
+	}
+
+	for _, ext := range certs[0].Extensions {
+		if !ext.Id.Equal(oidTLSFeature) {
+			continue
+		}
+		var features []int
+		if _, err := asn1.Unmarshal(ext.Value, &features); err != nil {
+			return fmt.Errorf("error parsing the TLS Feature extension: %s", err)
+		}
+		for _, feature := range features {
+			if feature == tlsFeatureStatusRequest {
+				mustStaple.Set(1)
+				if !stapled {
+					mustStapleMissing.Set(1)
+				}
+				return nil
+			}
+		}
+	}
+
+	return nil
+}
+
+func collectOCSPMetrics(ocspResponse []byte, registry *prometheus.Registry) error {
+	var (
+		ocspStapled = prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "ocsp_response_stapled"),
+				Help: "If the connection state contains a stapled OCSP response",
+			},
+		)
+	)
+	registry.MustRegister(ocspStapled)
+
+	responseMetrics := newOCSPResponseMetrics(registry)
 
 	if len(ocspResponse) == 0 {
 		return nil
@@ -222,15 +878,72 @@ func collectOCSPMetrics(ocspResponse []byte, registry *prometheus.Registry) erro
 	}
 
 	ocspStapled.Set(1)
-	ocspStatus.Set(float64(resp.Status))
-	ocspProducedAt.Set(float64(resp.ProducedAt.Unix()))
-	ocspThisUpdate.Set(float64(resp.ThisUpdate.Unix()))
-	ocspNextUpdate.Set(float64(resp.NextUpdate.Unix()))
-	ocspRevokedAt.Set(float64(resp.RevokedAt.Unix()))
+	responseMetrics.set(resp)
 
 	return nil
 }
 
+// ocspResponseMetrics are the values in an OCSP response
+type ocspResponseMetrics struct {
+	status     prometheus.Gauge
+	producedAt prometheus.Gauge
+	thisUpdate prometheus.Gauge
+	nextUpdate prometheus.Gauge
+	revokedAt  prometheus.Gauge
+}
+
+func newOCSPResponseMetrics(registry *prometheus.Registry) *ocspResponseMetrics {
+	m := &ocspResponseMetrics{
+		status: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "ocsp_response_status"),
+				Help: "The status in the OCSP response 0=Good 1=Revoked 2=Unknown",
+			},
+		),
+		producedAt: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "ocsp_response_produced_at"),
+				Help: "The producedAt value in the OCSP response, expressed as a Unix Epoch Time",
+			},
+		),
+		thisUpdate: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "ocsp_response_this_update"),
+				Help: "The thisUpdate value in the OCSP response, expressed as a Unix Epoch Time",
+			},
+		),
+		nextUpdate: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "ocsp_response_next_update"),
+				Help: "The nextUpdate value in the OCSP response, expressed as a Unix Epoch Time",
+			},
+		),
+		revokedAt: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "ocsp_response_revoked_at"),
+				Help: "The revocationTime value in the OCSP response, expressed as a Unix Epoch Time",
+			},
+		),
+	}
+	registry.MustRegister(
+		m.status,
+		m.producedAt,
+		m.thisUpdate,
+		m.nextUpdate,
+		m.revokedAt,
+	)
+
+	return m
+}
+
+func (m *ocspResponseMetrics) set(resp *ocsp.Response) {
+	m.status.Set(float64(resp.Status))
+	m.producedAt.Set(float64(resp.ProducedAt.Unix()))
+	m.thisUpdate.Set(float64(resp.ThisUpdate.Unix()))
+	m.nextUpdate.Set(float64(resp.NextUpdate.Unix()))
+	m.revokedAt.Set(float64(resp.RevokedAt.Unix()))
+}
+
 func collectFileMetrics(logger log.Logger, files []string, registry *prometheus.Registry) error {
 	var (
 		totalCerts   []*x509.Certificate
@@ -433,6 +1146,99 @@ func collectKubeconfigMetrics(logger log.Logger, kubeconfig KubeConfig, registry
 	return nil
 }
 
+func collectSSHCertificateMetrics(certs []*ssh.Certificate, registry *prometheus.Registry) error {
+	var (
+		sshNotAfter = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "ssh_cert_not_after"),
+				Help: "ValidBefore expressed as a Unix Epoch Time for an SSH certificate",
+			},
+			[]string{"serial_no", "key_id", "principals", "type", "ca_fingerprint"},
+		)
+		sshNotBefore = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "ssh_cert_not_before"),
+				Help: "ValidAfter expressed as a Unix Epoch Time for an SSH certificate",
+			},
+			[]string{"serial_no", "key_id", "principals", "type", "ca_fingerprint"},
+		)
+	)
+	registry.MustRegister(sshNotAfter, sshNotBefore)
+
+	if len(certs) == 0 {
+		return fmt.Errorf("No certificates found")
+	}
+
+	for _, cert := range certs {
+		labels := sshLabelValues(cert)
+
+		// Certificates that never expire have the maximum ValidBefore
+		if cert.ValidBefore != ssh.CertTimeInfinity {
+			sshNotAfter.WithLabelValues(labels...).Set(float64(cert.ValidBefore))
+		}
+
+		sshNotBefore.WithLabelValues(labels...).Set(float64(cert.ValidAfter))
+	}
+
+	return nil
+}
+
+func collectSSHFileMetrics(logger log.Logger, files []string, registry *prometheus.Registry) error {
+	var (
+		total           int
+		sshFileNotAfter = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "file_ssh_cert_not_after"),
+				Help: "ValidBefore expressed as a Unix Epoch Time for an SSH certificate found in a file",
+			},
+			[]string{"file", "serial_no", "key_id", "principals", "type", "ca_fingerprint"},
+		)
+		sshFileNotBefore = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "file_ssh_cert_not_before"),
+				Help: "ValidAfter expressed as a Unix Epoch Time for an SSH certificate found in a file",
+			},
+			[]string{"file", "serial_no", "key_id", "principals", "type", "ca_fingerprint"},
+		)
+		sshFileCAInfo = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "file_ssh_ca_info"),
+				Help: "An SSH certificate authority key found in a file",
+			},
+			[]string{"file", "fingerprint", "key_type"},
+		)
+	)
+	registry.MustRegister(sshFileNotAfter, sshFileNotBefore, sshFileCAInfo)
+
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			level.Debug(logger).Log("msg", fmt.Sprintf("Error reading file %s: %s", f, err))
+			continue
+		}
+		certs, cas := decodeSSHKeys(logger, data)
+		total += len(certs) + len(cas)
+		for _, cert := range certs {
+			labels := append([]string{f}, sshLabelValues(cert)...)
+
+			if cert.ValidBefore != ssh.CertTimeInfinity {
+				sshFileNotAfter.WithLabelValues(labels...).Set(float64(cert.ValidBefore))
+			}
+
+			sshFileNotBefore.WithLabelValues(labels...).Set(float64(cert.ValidAfter))
+		}
+		for _, ca := range cas {
+			sshFileCAInfo.WithLabelValues(f, ssh.FingerprintSHA256(ca), ca.Type()).Set(1)
+		}
+	}
+
+	if total == 0 {
+		return fmt.Errorf("No SSH certificates or certificate authorities found")
+	}
+
+	return nil
+}
+
 func labelValues(cert *x509.Certificate) []string {
 	return []string{
 		cert.SerialNumber.String(),
@@ -445,6 +1251,197 @@ func labelValues(cert *x509.Certificate) []string {
 	}
 }
 
+// keyLabelValues returns the algorithm, size in bits and curve of the public
+// key of the certificate. The size and curve are empty when they aren't known.
+func keyLabelValues(cert *x509.Certificate) []string {
+	var bits, curve string
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		bits = strconv.Itoa(key.N.BitLen())
+	case *ecdsa.PublicKey:
+		bits = strconv.Itoa(key.Curve.Params().BitSize)
+		curve = key.Curve.Params().Name
+	case ed25519.PublicKey:
+		bits = "256"
+		curve = "Ed25519"
+	}
+
+	return []string{cert.PublicKeyAlgorithm.String(), bits, curve}
+}
+
+// lifetimeElapsedRatio returns the fraction of the validity period of the
+// certificate that has elapsed at the time, clamped between 0 and 1
+func lifetimeElapsedRatio(cert *x509.Certificate, now time.Time) float64 {
+	ratio := float64(now.Sub(cert.NotBefore)) / float64(cert.NotAfter.Sub(cert.NotBefore))
+	if ratio < 0 {
+		return 0
+	}
+	if ratio > 1 {
+		return 1
+	}
+	return ratio
+}
+
+// collectNotYetValidMetrics reports the certificates whose NotBefore is later
+// than the tolerance after the current time
+func collectNotYetValidMetrics(certs []*x509.Certificate, tolerance time.Duration, registry *prometheus.Registry) {
+	notYetValid := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: prometheus.BuildFQName(namespace, "", "cert_not_yet_valid"),
+			Help: "If the NotBefore of the certificate is in the future, by more than the clock skew tolerance",
+		},
+		[]string{"serial_no", "issuer_cn", "cn", "dnsnames", "ips", "emails", "ou"},
+	)
+	registry.MustRegister(notYetValid)
+
+	latest := time.Now().Add(tolerance)
+	for _, cert := range uniq(certs) {
+		var v float64
+		if cert.NotBefore.After(latest) {
+			v = 1
+		}
+		notYetValid.WithLabelValues(labelValues(cert)...).Set(v)
+	}
+}
+
+// collectExpiryThresholdMetrics reports whether the certificates expire within
+// each of the thresholds
+func collectExpiryThresholdMetrics(certs []*x509.Certificate, thresholds []config.ExpiryThreshold, registry *prometheus.Registry) {
+	expiresWithin := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: prometheus.BuildFQName(namespace, "", "cert_expires_within"),
+			Help: "If the NotAfter of the certificate is within the threshold of the current time, or has passed",
+		},
+		[]string{"serial_no", "issuer_cn", "cn", "dnsnames", "ips", "emails", "ou", "threshold"},
+	)
+	registry.MustRegister(expiresWithin)
+
+	now := time.Now()
+	for _, cert := range uniq(certs) {
+		labels := labelValues(cert)
+		for _, threshold := range thresholds {
+			var v float64
+			if cert.NotAfter.Before(now.Add(threshold.Duration)) {
+				v = 1
+			}
+			expiresWithin.WithLabelValues(append(labels, threshold.Name)...).Set(v)
+		}
+	}
+}
+
+// hasWeakSignature reports whether the certificate is signed with a hash that
+// collisions have been found for. Clients don't check the signatures of the
+// roots that they trust, so trust anchors are never weak. Other self-signed
+// certificates, like the leaves of internal services, can be.
+func hasWeakSignature(cert *x509.Certificate, anchors []*x509.Certificate) bool {
+	for _, anchor := range anchors {
+		if cert.Equal(anchor) {
+			return false
+		}
+	}
+
+	switch cert.SignatureAlgorithm {
+	case x509.MD2WithRSA, x509.MD5WithRSA, x509.SHA1WithRSA, x509.DSAWithSHA1, x509.ECDSAWithSHA1:
+		return true
+	}
+
+	return false
+}
+
+// keyUsages are the names of the key usage bits from RFC 5280, in the order of
+// the bits
+var keyUsages = []struct {
+	usage x509.KeyUsage
+	name  string
+}{
+	{x509.KeyUsageDigitalSignature, "digitalSignature"},
+	{x509.KeyUsageContentCommitment, "contentCommitment"},
+	{x509.KeyUsageKeyEncipherment, "keyEncipherment"},
+	{x509.KeyUsageDataEncipherment, "dataEncipherment"},
+	{x509.KeyUsageKeyAgreement, "keyAgreement"},
+	{x509.KeyUsageCertSign, "keyCertSign"},
+	{x509.KeyUsageCRLSign, "cRLSign"},
+	{x509.KeyUsageEncipherOnly, "encipherOnly"},
+	{x509.KeyUsageDecipherOnly, "decipherOnly"},
+}
+
+// keyUsageNames returns the names of the key usage bits that are set
+func keyUsageNames(usage x509.KeyUsage) []string {
+	var names []string
+	for _, ku := range keyUsages {
+		if usage&ku.usage != 0 {
+			names = append(names, ku.name)
+		}
+	}
+
+	return names
+}
+
+// extKeyUsages are the names of the extended key usages that crypto/x509
+// recognises
+var extKeyUsages = map[x509.ExtKeyUsage]string{
+	x509.ExtKeyUsageAny:                            "any",
+	x509.ExtKeyUsageServerAuth:                     "serverAuth",
+	x509.ExtKeyUsageClientAuth:                     "clientAuth",
+	x509.ExtKeyUsageCodeSigning:                    "codeSigning",
+	x509.ExtKeyUsageEmailProtection:                "emailProtection",
+	x509.ExtKeyUsageIPSECEndSystem:                 "ipsecEndSystem",
+	x509.ExtKeyUsageIPSECTunnel:                    "ipsecTunnel",
+	x509.ExtKeyUsageIPSECUser:                      "ipsecUser",
+	x509.ExtKeyUsageTimeStamping:                   "timeStamping",
+	x509.ExtKeyUsageOCSPSigning:                    "OCSPSigning",
+	x509.ExtKeyUsageMicrosoftServerGatedCrypto:     "msSGC",
+	x509.ExtKeyUsageNetscapeServerGatedCrypto:      "nsSGC",
+	x509.ExtKeyUsageMicrosoftCommercialCodeSigning: "msCodeCom",
+	x509.ExtKeyUsageMicrosoftKernelCodeSigning:     "msKernelCode",
+}
+
+// extKeyUsageNames returns the names of the extended key usages of the
+// certificate. Usages that crypto/x509 doesn't recognise are returned as
+// their OID.
+func extKeyUsageNames(cert *x509.Certificate) []string {
+	var names []string
+	for _, eku := range cert.ExtKeyUsage {
+		if name, ok := extKeyUsages[eku]; ok {
+			names = append(names, name)
+		}
+	}
+	for _, oid := range cert.UnknownExtKeyUsage {
+		names = append(names, oid.String())
+	}
+
+	return names
+}
+
+func sshLabelValues(cert *ssh.Certificate) []string {
+	return []string{
+		strconv.FormatUint(cert.Serial, 10),
+		cert.KeyId,
+		sshPrincipals(cert),
+		sshCertType(cert),
+		ssh.FingerprintSHA256(cert.SignatureKey),
+	}
+}
+
+func sshPrincipals(cert *ssh.Certificate) string {
+	if len(cert.ValidPrincipals) > 0 {
+		return "," + strings.Join(cert.ValidPrincipals, ",") + ","
+	}
+
+	return ""
+}
+
+func sshCertType(cert *ssh.Certificate) string {
+	switch cert.CertType {
+	case ssh.HostCert:
+		return "host"
+	case ssh.UserCert:
+		return "user"
+	default:
+		return "unknown"
+	}
+}
+
 func dnsNames(cert *x509.Certificate) string {
 	if len(cert.DNSNames) > 0 {
 		return "," + strings.Join(cert.DNSNames, ",") + ","
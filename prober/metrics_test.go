@@ -9,9 +9,11 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	dto "github.com/prometheus/client_model/go"
+	"github.com/ribbybibby/ssl_exporter/v2/test"
 	"golang.org/x/crypto/ocsp"
 )
 
@@ -47,6 +49,9 @@ func checkRegistryResult(expRes *registryResult, mfs []*dto.MetricFamily, t *tes
 				Name:  mf.GetName(),
 				Value: metric.GetGauge().GetValue(),
 			}
+			if mf.GetType() == dto.MetricType_COUNTER {
+				result.Value = metric.GetCounter().GetValue()
+			}
 			if len(metric.GetLabel()) > 0 {
 				labelValues := make(map[string]string)
 				for _, l := range metric.GetLabel() {
@@ -103,12 +108,318 @@ func checkCertificateMetrics(cert *x509.Certificate, registry *prometheus.Regist
 	checkRegistryResults(expectedResults, mfs, t)
 }
 
+func checkKeyInfoMetrics(cert *x509.Certificate, algorithm, bits, curve string, registry *prometheus.Registry, t *testing.T) {
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ips := ","
+	for _, ip := range cert.IPAddresses {
+		ips = ips + ip.String() + ","
+	}
+	expectedResults := []*registryResult{
+		&registryResult{
+			Name: "ssl_cert_key_info",
+			LabelValues: map[string]string{
+				"serial_no": cert.SerialNumber.String(),
+				"issuer_cn": cert.Issuer.CommonName,
+				"cn":        cert.Subject.CommonName,
+				"dnsnames":  "," + strings.Join(cert.DNSNames, ",") + ",",
+				"ips":       ips,
+				"emails":    "," + strings.Join(cert.EmailAddresses, ",") + ",",
+				"ou":        "," + strings.Join(cert.Subject.OrganizationalUnit, ",") + ",",
+				"algorithm": algorithm,
+				"bits":      bits,
+				"curve":     curve,
+			},
+			Value: 1,
+		},
+	}
+	checkRegistryResults(expectedResults, mfs, t)
+}
+
+func checkSignatureMetrics(cert *x509.Certificate, algorithm string, weak float64, registry *prometheus.Registry, t *testing.T) {
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ips := ","
+	for _, ip := range cert.IPAddresses {
+		ips = ips + ip.String() + ","
+	}
+	expectedLabels := map[string]string{
+		"serial_no": cert.SerialNumber.String(),
+		"issuer_cn": cert.Issuer.CommonName,
+		"cn":        cert.Subject.CommonName,
+		"dnsnames":  "," + strings.Join(cert.DNSNames, ",") + ",",
+		"ips":       ips,
+		"emails":    "," + strings.Join(cert.EmailAddresses, ",") + ",",
+		"ou":        "," + strings.Join(cert.Subject.OrganizationalUnit, ",") + ",",
+	}
+	infoLabels := map[string]string{"algorithm": algorithm}
+	for k, v := range expectedLabels {
+		infoLabels[k] = v
+	}
+	expectedResults := []*registryResult{
+		&registryResult{
+			Name:        "ssl_cert_signature_info",
+			LabelValues: infoLabels,
+			Value:       1,
+		},
+		&registryResult{
+			Name:        "ssl_cert_weak_signature",
+			LabelValues: expectedLabels,
+			Value:       weak,
+		},
+	}
+	checkRegistryResults(expectedResults, mfs, t)
+}
+
+func checkBasicConstraintsMetrics(cert *x509.Certificate, isCA, maxPathLen float64, registry *prometheus.Registry, t *testing.T) {
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ips := ","
+	for _, ip := range cert.IPAddresses {
+		ips = ips + ip.String() + ","
+	}
+	expectedLabels := map[string]string{
+		"serial_no": cert.SerialNumber.String(),
+		"issuer_cn": cert.Issuer.CommonName,
+		"cn":        cert.Subject.CommonName,
+		"dnsnames":  "," + strings.Join(cert.DNSNames, ",") + ",",
+		"ips":       ips,
+		"emails":    "," + strings.Join(cert.EmailAddresses, ",") + ",",
+		"ou":        "," + strings.Join(cert.Subject.OrganizationalUnit, ",") + ",",
+	}
+	expectedResults := []*registryResult{
+		&registryResult{
+			Name:        "ssl_cert_ca",
+			LabelValues: expectedLabels,
+			Value:       isCA,
+		},
+	}
+	if maxPathLen >= 0 {
+		expectedResults = append(expectedResults, &registryResult{
+			Name:        "ssl_cert_max_path_len",
+			LabelValues: expectedLabels,
+			Value:       maxPathLen,
+		})
+	}
+	checkRegistryResults(expectedResults, mfs, t)
+
+	// A negative path length means that the metric shouldn't be exported
+	if maxPathLen >= 0 {
+		return
+	}
+	for _, mf := range mfs {
+		if mf.GetName() != "ssl_cert_max_path_len" {
+			continue
+		}
+		for _, metric := range mf.Metric {
+			for _, l := range metric.GetLabel() {
+				if l.GetName() == "serial_no" && l.GetValue() == cert.SerialNumber.String() {
+					t.Errorf("Unexpected ssl_cert_max_path_len for serial %s", cert.SerialNumber)
+				}
+			}
+		}
+	}
+}
+
+func checkKeyUsageMetrics(cert *x509.Certificate, keyUsages, extKeyUsages []string, registry *prometheus.Registry, t *testing.T) {
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ips := ","
+	for _, ip := range cert.IPAddresses {
+		ips = ips + ip.String() + ","
+	}
+	usageLabels := func(usage string) map[string]string {
+		return map[string]string{
+			"serial_no": cert.SerialNumber.String(),
+			"issuer_cn": cert.Issuer.CommonName,
+			"cn":        cert.Subject.CommonName,
+			"dnsnames":  "," + strings.Join(cert.DNSNames, ",") + ",",
+			"ips":       ips,
+			"emails":    "," + strings.Join(cert.EmailAddresses, ",") + ",",
+			"ou":        "," + strings.Join(cert.Subject.OrganizationalUnit, ",") + ",",
+			"usage":     usage,
+		}
+	}
+	expectedResults := []*registryResult{}
+	for _, usage := range keyUsages {
+		expectedResults = append(expectedResults, &registryResult{
+			Name:        "ssl_cert_key_usage_info",
+			LabelValues: usageLabels(usage),
+			Value:       1,
+		})
+	}
+	for _, usage := range extKeyUsages {
+		expectedResults = append(expectedResults, &registryResult{
+			Name:        "ssl_cert_ext_key_usage_info",
+			LabelValues: usageLabels(usage),
+			Value:       1,
+		})
+	}
+	checkRegistryResults(expectedResults, mfs, t)
+}
+
+func checkSANMetrics(cert *x509.Certificate, sans, wildcard, ipSANs float64, registry *prometheus.Registry, t *testing.T) {
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ips string
+	if len(cert.IPAddresses) > 0 {
+		ips = ","
+		for _, ip := range cert.IPAddresses {
+			ips = ips + ip.String() + ","
+		}
+	}
+	expectedLabels := map[string]string{
+		"serial_no": cert.SerialNumber.String(),
+		"issuer_cn": cert.Issuer.CommonName,
+		"cn":        cert.Subject.CommonName,
+		"dnsnames":  "," + strings.Join(cert.DNSNames, ",") + ",",
+		"ips":       ips,
+		"emails":    "," + strings.Join(cert.EmailAddresses, ",") + ",",
+		"ou":        "," + strings.Join(cert.Subject.OrganizationalUnit, ",") + ",",
+	}
+	expectedResults := []*registryResult{
+		&registryResult{
+			Name:        "ssl_cert_sans",
+			LabelValues: expectedLabels,
+			Value:       sans,
+		},
+		&registryResult{
+			Name:        "ssl_cert_wildcard",
+			LabelValues: expectedLabels,
+			Value:       wildcard,
+		},
+		&registryResult{
+			Name:        "ssl_cert_has_ip_sans",
+			LabelValues: expectedLabels,
+			Value:       ipSANs,
+		},
+	}
+	checkRegistryResults(expectedResults, mfs, t)
+}
+
+func checkNotYetValidMetrics(cert *x509.Certificate, notYetValid float64, registry *prometheus.Registry, t *testing.T) {
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ips string
+	if len(cert.IPAddresses) > 0 {
+		ips = ","
+		for _, ip := range cert.IPAddresses {
+			ips = ips + ip.String() + ","
+		}
+	}
+	expectedResults := []*registryResult{
+		&registryResult{
+			Name: "ssl_cert_not_yet_valid",
+			LabelValues: map[string]string{
+				"serial_no": cert.SerialNumber.String(),
+				"issuer_cn": cert.Issuer.CommonName,
+				"cn":        cert.Subject.CommonName,
+				"dnsnames":  "," + strings.Join(cert.DNSNames, ",") + ",",
+				"ips":       ips,
+				"emails":    "," + strings.Join(cert.EmailAddresses, ",") + ",",
+				"ou":        "," + strings.Join(cert.Subject.OrganizationalUnit, ",") + ",",
+			},
+			Value: notYetValid,
+		},
+	}
+	checkRegistryResults(expectedResults, mfs, t)
+}
+
+func checkExpiresWithinMetrics(cert *x509.Certificate, expiresWithin map[string]float64, registry *prometheus.Registry, t *testing.T) {
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ips string
+	if len(cert.IPAddresses) > 0 {
+		ips = ","
+		for _, ip := range cert.IPAddresses {
+			ips = ips + ip.String() + ","
+		}
+	}
+	expectedResults := []*registryResult{}
+	for threshold, value := range expiresWithin {
+		expectedResults = append(expectedResults, &registryResult{
+			Name: "ssl_cert_expires_within",
+			LabelValues: map[string]string{
+				"serial_no": cert.SerialNumber.String(),
+				"issuer_cn": cert.Issuer.CommonName,
+				"cn":        cert.Subject.CommonName,
+				"dnsnames":  "," + strings.Join(cert.DNSNames, ",") + ",",
+				"ips":       ips,
+				"emails":    "," + strings.Join(cert.EmailAddresses, ",") + ",",
+				"ou":        "," + strings.Join(cert.Subject.OrganizationalUnit, ",") + ",",
+				"threshold": threshold,
+			},
+			Value: value,
+		})
+	}
+	checkRegistryResults(expectedResults, mfs, t)
+}
+
+// checkLifetimeElapsedMetrics checks that the ratio is between min and max,
+// because it depends on the time of the probe
+func checkLifetimeElapsedMetrics(min, max float64, registry *prometheus.Registry, t *testing.T) {
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() != "ssl_cert_lifetime_elapsed_ratio" {
+			continue
+		}
+		ratio := mf.Metric[0].GetGauge().GetValue()
+		if ratio < min || ratio > max {
+			t.Errorf("Expected ssl_cert_lifetime_elapsed_ratio between %f and %f, got %f", min, max, ratio)
+		}
+		return
+	}
+	t.Errorf("Expected ssl_cert_lifetime_elapsed_ratio")
+}
+
 func checkVerifiedChainMetrics(verifiedChains [][]*x509.Certificate, registry *prometheus.Registry, t *testing.T) {
 	mfs, err := registry.Gather()
 	if err != nil {
 		t.Fatal(err)
 	}
+	checkRegistryResults([]*registryResult{
+		&registryResult{
+			Name:  "ssl_verified_chains",
+			Value: float64(len(verifiedChains)),
+		},
+	}, mfs, t)
 	for i, chain := range verifiedChains {
+		first := chain[0]
+		for _, cert := range chain[1:] {
+			if cert.NotAfter.Before(first.NotAfter) {
+				first = cert
+			}
+		}
+		checkRegistryResults([]*registryResult{
+			&registryResult{
+				Name: "ssl_verified_chain_not_after",
+				LabelValues: map[string]string{
+					"chain_no":  strconv.Itoa(i),
+					"root_cn":   chain[len(chain)-1].Subject.CommonName,
+					"serial_no": first.SerialNumber.String(),
+					"issuer_cn": first.Issuer.CommonName,
+					"cn":        first.Subject.CommonName,
+				},
+				Value: float64(first.NotAfter.Unix()),
+			},
+		}, mfs, t)
 		for _, cert := range chain {
 			ips := ","
 			for _, ip := range cert.IPAddresses {
@@ -212,6 +523,682 @@ func checkTLSVersionMetrics(version string, registry *prometheus.Registry, t *te
 	checkRegistryResults(expectedResults, mfs, t)
 }
 
+func checkCipherSuiteMetrics(cipher string, registry *prometheus.Registry, t *testing.T) {
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedResults := []*registryResult{
+		&registryResult{
+			Name: "ssl_cipher_suite_info",
+			LabelValues: map[string]string{
+				"cipher": cipher,
+			},
+			Value: 1,
+		},
+	}
+	checkRegistryResults(expectedResults, mfs, t)
+}
+
+func checkALPNMetrics(protocol string, registry *prometheus.Registry, t *testing.T) {
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedResults := []*registryResult{
+		&registryResult{
+			Name: "ssl_alpn_protocol_info",
+			LabelValues: map[string]string{
+				"protocol": protocol,
+			},
+			Value: 1,
+		},
+	}
+	checkRegistryResults(expectedResults, mfs, t)
+}
+
+func checkPolicyMetrics(violations map[string]bool, registry *prometheus.Registry, t *testing.T) {
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedResults := []*registryResult{}
+	for rule, violated := range violations {
+		result := &registryResult{
+			Name: "ssl_policy_violation",
+			LabelValues: map[string]string{
+				"rule": rule,
+			},
+		}
+		if violated {
+			result.Value = 1
+		}
+		expectedResults = append(expectedResults, result)
+	}
+	checkRegistryResults(expectedResults, mfs, t)
+}
+
+func checkECHMetrics(published bool, accepted float64, registry *prometheus.Registry, t *testing.T) {
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedResults := []*registryResult{
+		&registryResult{
+			Name:  "ssl_ech_published",
+			Value: 0,
+		},
+	}
+	if published {
+		expectedResults = []*registryResult{
+			&registryResult{
+				Name:  "ssl_ech_published",
+				Value: 1,
+			},
+			&registryResult{
+				Name:  "ssl_ech_accepted",
+				Value: accepted,
+			},
+		}
+	}
+	checkRegistryResults(expectedResults, mfs, t)
+}
+
+func checkPQHybridMetrics(pqHybrid float64, registry *prometheus.Registry, t *testing.T) {
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedResults := []*registryResult{
+		&registryResult{
+			Name:  "ssl_pq_hybrid_key_exchange",
+			Value: pqHybrid,
+		},
+	}
+	checkRegistryResults(expectedResults, mfs, t)
+}
+
+func checkCurveMetrics(curve string, registry *prometheus.Registry, t *testing.T) {
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedResults := []*registryResult{
+		&registryResult{
+			Name: "ssl_curve_info",
+			LabelValues: map[string]string{
+				"curve": curve,
+			},
+			Value: 1,
+		},
+	}
+	checkRegistryResults(expectedResults, mfs, t)
+}
+
+func checkTLSHandshakeMetrics(registry *prometheus.Registry, t *testing.T) {
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() == "ssl_tls_handshake_duration_seconds" {
+			if v := mf.GetMetric()[0].GetGauge().GetValue(); v <= 0 {
+				t.Errorf("expected a positive ssl_tls_handshake_duration_seconds but got %f", v)
+			}
+			return
+		}
+	}
+	t.Errorf("ssl_tls_handshake_duration_seconds wasn't reported")
+}
+
+func checkPhaseDurationMetrics(phases []string, registry *prometheus.Registry, t *testing.T) {
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reported := map[string]bool{}
+	for _, mf := range mfs {
+		if mf.GetName() != "ssl_probe_duration_seconds" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "phase" {
+					reported[l.GetValue()] = true
+				}
+			}
+		}
+	}
+	for _, phase := range phases {
+		if !reported[phase] {
+			t.Errorf("ssl_probe_duration_seconds wasn't reported for the %s phase", phase)
+		}
+	}
+}
+
+func checkProtocolCheckMetrics(protocol string, success float64, registry *prometheus.Registry, t *testing.T) {
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedResults := []*registryResult{
+		&registryResult{
+			Name: "ssl_protocol_check_success",
+			LabelValues: map[string]string{
+				"protocol": protocol,
+			},
+			Value: success,
+		},
+	}
+	checkRegistryResults(expectedResults, mfs, t)
+}
+
+func checkGRPCHealthCheckMetrics(servingStatus string, registry *prometheus.Registry, t *testing.T) {
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedResults := []*registryResult{}
+	for _, status := range grpcServingStatuses {
+		var value float64
+		if status == servingStatus {
+			value = 1
+		}
+		expectedResults = append(expectedResults, &registryResult{
+			Name: "ssl_grpc_healthcheck_response",
+			LabelValues: map[string]string{
+				"serving_status": status,
+			},
+			Value: value,
+		})
+	}
+	checkRegistryResults(expectedResults, mfs, t)
+}
+
+func checkQUICVersionMetrics(version string, registry *prometheus.Registry, t *testing.T) {
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedResults := []*registryResult{
+		&registryResult{
+			Name: "ssl_quic_version_info",
+			LabelValues: map[string]string{
+				"version": version,
+			},
+			Value: 1,
+		},
+	}
+	checkRegistryResults(expectedResults, mfs, t)
+}
+
+func checkIPProtocolMetrics(ipProtocol float64, registry *prometheus.Registry, t *testing.T) {
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedResults := []*registryResult{
+		&registryResult{
+			Name:  "ssl_probe_ip_protocol",
+			Value: ipProtocol,
+		},
+	}
+	checkRegistryResults(expectedResults, mfs, t)
+}
+
+func checkDNSResolverMetrics(resolver string, registry *prometheus.Registry, t *testing.T) {
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedResults := []*registryResult{
+		&registryResult{
+			Name: "ssl_dns_resolver_info",
+			LabelValues: map[string]string{
+				"resolver": resolver,
+			},
+			Value: 1,
+		},
+	}
+	checkRegistryResults(expectedResults, mfs, t)
+
+	for _, mf := range mfs {
+		if mf.GetName() == "ssl_probe_dns_lookup_time_seconds" {
+			return
+		}
+	}
+	t.Errorf("ssl_probe_dns_lookup_time_seconds wasn't reported")
+}
+
+func checkDNSSECMetrics(valid float64, registry *prometheus.Registry, t *testing.T) {
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedResults := []*registryResult{
+		&registryResult{
+			Name:  "ssl_dnssec_valid",
+			Value: valid,
+		},
+	}
+	checkRegistryResults(expectedResults, mfs, t)
+}
+
+func checkAIAMetrics(incomplete, verifiable float64, registry *prometheus.Registry, t *testing.T) {
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedResults := []*registryResult{
+		&registryResult{
+			Name:  "ssl_chain_incomplete",
+			Value: incomplete,
+		},
+		&registryResult{
+			Name:  "ssl_chain_verifiable_after_aia",
+			Value: verifiable,
+		},
+	}
+	checkRegistryResults(expectedResults, mfs, t)
+}
+
+func checkChainMetrics(missing, outOfOrder, rootIncluded float64, registry *prometheus.Registry, t *testing.T) {
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedResults := []*registryResult{
+		&registryResult{
+			Name:  "ssl_chain_missing_intermediates",
+			Value: missing,
+		},
+		&registryResult{
+			Name:  "ssl_chain_out_of_order",
+			Value: outOfOrder,
+		},
+		&registryResult{
+			Name:  "ssl_chain_root_included",
+			Value: rootIncluded,
+		},
+	}
+	checkRegistryResults(expectedResults, mfs, t)
+}
+
+func checkSelfSignedMetrics(selfSigned float64, registry *prometheus.Registry, t *testing.T) {
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedResults := []*registryResult{
+		&registryResult{
+			Name:  "ssl_cert_self_signed",
+			Value: selfSigned,
+		},
+	}
+	checkRegistryResults(expectedResults, mfs, t)
+}
+
+func checkLeafIssuedMetrics(cert *x509.Certificate, registry *prometheus.Registry, t *testing.T) {
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedResults := []*registryResult{
+		&registryResult{
+			Name:  "ssl_cert_leaf_issued_timestamp",
+			Value: float64(cert.NotBefore.Unix()),
+		},
+	}
+	checkRegistryResults(expectedResults, mfs, t)
+
+	// The age depends on the time of the probe, so it's only checked to be
+	// within the time that has passed since
+	for _, mf := range mfs {
+		if mf.GetName() != "ssl_cert_leaf_age_seconds" {
+			continue
+		}
+		age := mf.Metric[0].GetGauge().GetValue()
+		if age < 0 || age > time.Since(cert.NotBefore).Seconds() {
+			t.Errorf("Unexpected ssl_cert_leaf_age_seconds %f", age)
+		}
+		return
+	}
+	t.Errorf("Expected ssl_cert_leaf_age_seconds")
+}
+
+func checkChainCertMetrics(expired float64, certs []*x509.Certificate, verified []bool, registry *prometheus.Registry, t *testing.T) {
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedResults := []*registryResult{
+		&registryResult{
+			Name:  "ssl_chain_expired_certs",
+			Value: expired,
+		},
+	}
+	for i, cert := range certs {
+		ips := ","
+		for _, ip := range cert.IPAddresses {
+			ips = ips + ip.String() + ","
+		}
+		expectedLabels := map[string]string{
+			"position":  strconv.Itoa(i),
+			"verified":  strconv.FormatBool(verified[i]),
+			"serial_no": cert.SerialNumber.String(),
+			"issuer_cn": cert.Issuer.CommonName,
+			"cn":        cert.Subject.CommonName,
+			"dnsnames":  "," + strings.Join(cert.DNSNames, ",") + ",",
+			"ips":       ips,
+			"emails":    "," + strings.Join(cert.EmailAddresses, ",") + ",",
+			"ou":        "," + strings.Join(cert.Subject.OrganizationalUnit, ",") + ",",
+		}
+		expectedResults = append(expectedResults,
+			&registryResult{
+				Name:        "ssl_chain_cert_not_after",
+				LabelValues: expectedLabels,
+				Value:       float64(cert.NotAfter.Unix()),
+			},
+			&registryResult{
+				Name:        "ssl_chain_cert_not_before",
+				LabelValues: expectedLabels,
+				Value:       float64(cert.NotBefore.Unix()),
+			},
+			&registryResult{
+				Name: "ssl_chain_cert_info",
+				LabelValues: map[string]string{
+					"position":  strconv.Itoa(i),
+					"serial_no": cert.SerialNumber.String(),
+					"subject":   cert.Subject.String(),
+					"issuer":    cert.Issuer.String(),
+				},
+				Value: 1,
+			},
+		)
+	}
+	checkRegistryResults(expectedResults, mfs, t)
+}
+
+func checkTrustStoreMetrics(verified map[string]float64, registry *prometheus.Registry, t *testing.T) {
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedResults := []*registryResult{}
+	for store, value := range verified {
+		expectedResults = append(expectedResults, &registryResult{
+			Name:        "ssl_verified",
+			LabelValues: map[string]string{"store": store},
+			Value:       value,
+		})
+	}
+	checkRegistryResults(expectedResults, mfs, t)
+}
+
+func checkExpectedNamesMetrics(covers float64, covered map[string]float64, registry *prometheus.Registry, t *testing.T) {
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedResults := []*registryResult{
+		&registryResult{
+			Name:  "ssl_cert_covers_expected_names",
+			Value: covers,
+		},
+	}
+	for name, value := range covered {
+		expectedResults = append(expectedResults, &registryResult{
+			Name:        "ssl_cert_expected_name_covered",
+			LabelValues: map[string]string{"name": name},
+			Value:       value,
+		})
+	}
+	checkRegistryResults(expectedResults, mfs, t)
+}
+
+func checkFingerprintMetrics(match float64, registry *prometheus.Registry, t *testing.T) {
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedResults := []*registryResult{
+		&registryResult{
+			Name:  "ssl_cert_fingerprint_match",
+			Value: match,
+		},
+	}
+	checkRegistryResults(expectedResults, mfs, t)
+}
+
+func checkCertChangeMetrics(changed, changes float64, registry *prometheus.Registry, t *testing.T) {
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedResults := []*registryResult{
+		&registryResult{
+			Name:  "ssl_cert_changed",
+			Value: changed,
+		},
+		&registryResult{
+			Name:  "ssl_cert_changed_total",
+			Value: changes,
+		},
+	}
+	checkRegistryResults(expectedResults, mfs, t)
+}
+
+func checkSPKIPinMetrics(valid float64, matches map[string]float64, registry *prometheus.Registry, t *testing.T) {
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedResults := []*registryResult{
+		&registryResult{
+			Name:  "ssl_spki_pin_valid",
+			Value: valid,
+		},
+	}
+	for pin, value := range matches {
+		expectedResults = append(expectedResults, &registryResult{
+			Name:        "ssl_spki_pin_match",
+			LabelValues: map[string]string{"pin": pin},
+			Value:       value,
+		})
+	}
+	checkRegistryResults(expectedResults, mfs, t)
+}
+
+func checkIssuerMatchMetrics(match float64, registry *prometheus.Registry, t *testing.T) {
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedResults := []*registryResult{
+		&registryResult{
+			Name:  "ssl_cert_issuer_match",
+			Value: match,
+		},
+	}
+	checkRegistryResults(expectedResults, mfs, t)
+}
+
+func checkSerialMatchMetrics(serial, authorityKeyID float64, registry *prometheus.Registry, t *testing.T) {
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedResults := []*registryResult{
+		&registryResult{
+			Name:  "ssl_cert_serial_match",
+			Value: serial,
+		},
+		&registryResult{
+			Name:  "ssl_cert_authority_key_id_match",
+			Value: authorityKeyID,
+		},
+	}
+	checkRegistryResults(expectedResults, mfs, t)
+}
+
+func checkCAAMetrics(compliant float64, domain string, records []test.CAA, registry *prometheus.Registry, t *testing.T) {
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedResults := []*registryResult{
+		&registryResult{
+			Name:  "ssl_caa_compliant",
+			Value: compliant,
+		},
+	}
+	for _, record := range records {
+		expectedResults = append(expectedResults, &registryResult{
+			Name: "ssl_caa_record_info",
+			LabelValues: map[string]string{
+				"domain": domain,
+				"flags":  strconv.Itoa(int(record.Flags)),
+				"tag":    record.Tag,
+				"value":  record.Value,
+			},
+			Value: 1,
+		})
+	}
+	checkRegistryResults(expectedResults, mfs, t)
+}
+
+func checkDANEMetrics(valid float64, match []string, registry *prometheus.Registry, t *testing.T) {
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedResults := []*registryResult{
+		&registryResult{
+			Name:  "ssl_dane_valid",
+			Value: valid,
+		},
+	}
+	if match != nil {
+		expectedResults = append(expectedResults, &registryResult{
+			Name: "ssl_dane_match_info",
+			LabelValues: map[string]string{
+				"usage":         match[0],
+				"selector":      match[1],
+				"matching_type": match[2],
+			},
+			Value: 1,
+		})
+	}
+	checkRegistryResults(expectedResults, mfs, t)
+}
+
+func checkCompressionMetrics(accepted float64, registry *prometheus.Registry, t *testing.T) {
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedResults := []*registryResult{
+		&registryResult{
+			Name:  "ssl_tls_compression_accepted",
+			Value: accepted,
+		},
+	}
+	checkRegistryResults(expectedResults, mfs, t)
+}
+
+func checkExtendedMasterSecretMetrics(used float64, registry *prometheus.Registry, t *testing.T) {
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedResults := []*registryResult{
+		&registryResult{
+			Name:  "ssl_extended_master_secret",
+			Value: used,
+		},
+	}
+	checkRegistryResults(expectedResults, mfs, t)
+}
+
+func checkFallbackSCSVMetrics(supported float64, registry *prometheus.Registry, t *testing.T) {
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedResults := []*registryResult{
+		&registryResult{
+			Name:  "ssl_fallback_scsv_supported",
+			Value: supported,
+		},
+	}
+	checkRegistryResults(expectedResults, mfs, t)
+}
+
+func checkOCSPMustStapleMetrics(mustStaple, missing float64, registry *prometheus.Registry, t *testing.T) {
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedResults := []*registryResult{
+		&registryResult{
+			Name:  "ssl_ocsp_must_staple",
+			Value: mustStaple,
+		},
+		&registryResult{
+			Name:  "ssl_ocsp_must_staple_missing",
+			Value: missing,
+		},
+	}
+	checkRegistryResults(expectedResults, mfs, t)
+}
+
+func checkSCTMetrics(valid float64, sctInfo map[string]string, sctValid float64, registry *prometheus.Registry, t *testing.T) {
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedResults := []*registryResult{
+		&registryResult{
+			Name:  "ssl_cert_scts_valid",
+			Value: valid,
+		},
+		&registryResult{
+			Name:        "ssl_cert_sct_info",
+			LabelValues: sctInfo,
+			Value:       sctValid,
+		},
+	}
+	checkRegistryResults(expectedResults, mfs, t)
+}
+
+func checkSecureRenegotiationMetrics(supported float64, registry *prometheus.Registry, t *testing.T) {
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedResults := []*registryResult{
+		&registryResult{
+			Name:  "ssl_secure_renegotiation_supported",
+			Value: supported,
+		},
+	}
+	checkRegistryResults(expectedResults, mfs, t)
+}
+
+func checkSessionResumptionMetrics(supported float64, registry *prometheus.Registry, t *testing.T) {
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedResults := []*registryResult{
+		&registryResult{
+			Name:  "ssl_session_resumption_supported",
+			Value: supported,
+		},
+	}
+	checkRegistryResults(expectedResults, mfs, t)
+}
+
 func newCertificate(certPEM []byte) (*x509.Certificate, error) {
 	block, _ := pem.Decode(certPEM)
 	return x509.ParseCertificate(block.Bytes)
@@ -0,0 +1,62 @@
+package prober
+
+import (
+	"crypto/x509"
+	"regexp"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// collectExpectedNamesMetrics checks that the leaf certificate is valid for
+// each of the expected DNS names, so that a renewed certificate that drops a
+// name the target serves is reported
+func collectExpectedNamesMetrics(names []string, cert *x509.Certificate, registry *prometheus.Registry) {
+	var (
+		coversExpectedNames = prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "cert_covers_expected_names"),
+				Help: "If the leaf certificate is valid for all of the expected DNS names",
+			},
+		)
+		expectedNameCovered = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "cert_expected_name_covered"),
+				Help: "If the leaf certificate is valid for the expected DNS name",
+			},
+			[]string{"name"},
+		)
+	)
+	registry.MustRegister(coversExpectedNames, expectedNameCovered)
+
+	covers := 1.0
+	for _, name := range names {
+		// VerifyHostname matches wildcards and IP addresses in the same
+		// way as clients do
+		if err := cert.VerifyHostname(name); err != nil {
+			expectedNameCovered.WithLabelValues(name).Set(0)
+			covers = 0
+			continue
+		}
+		expectedNameCovered.WithLabelValues(name).Set(1)
+	}
+	coversExpectedNames.Set(covers)
+}
+
+// collectExpectedIssuerMetrics checks the issuer of the leaf certificate
+// against the expected issuer, so that a certificate from another CA, like the
+// CA of an intercepting proxy, is reported
+func collectExpectedIssuerMetrics(issuer *regexp.Regexp, cert *x509.Certificate, registry *prometheus.Registry) {
+	var (
+		issuerMatch = prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "cert_issuer_match"),
+				Help: "If the common name or distinguished name of the issuer of the leaf certificate matches the expected issuer",
+			},
+		)
+	)
+	registry.MustRegister(issuerMatch)
+
+	if issuer.MatchString(cert.Issuer.CommonName) || issuer.MatchString(cert.Issuer.String()) {
+		issuerMatch.Set(1)
+	}
+}
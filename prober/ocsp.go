@@ -0,0 +1,300 @@
+package prober
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/ribbybibby/ssl_exporter/v2/config"
+	"golang.org/x/crypto/ocsp"
+)
+
+// ocspMaxSize is the largest OCSP response that is read
+const ocspMaxSize = 1 << 20
+
+// ocspCache holds the responses of OCSP responders between probes, so that
+// frequent scrapes don't hit the rate limits of the responder
+var ocspCache = &ocspResponseCache{entries: map[string]ocspCacheEntry{}}
+
+type ocspCacheEntry struct {
+	response []byte
+	expires  time.Time
+}
+
+// ocspResponseCache is a cache of OCSP responses by responder and request
+type ocspResponseCache struct {
+	mu      sync.Mutex
+	entries map[string]ocspCacheEntry
+}
+
+// get returns a response that hasn't expired
+func (c *ocspResponseCache) get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+
+	return entry.response, true
+}
+
+// put caches the response until it expires, and removes the entries that
+// have expired
+func (c *ocspResponseCache) put(key string, response []byte, expires time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for k, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = ocspCacheEntry{response: response, expires: expires}
+}
+
+// ProbeOCSP requests the status of a certificate from the OCSP responder at
+// the target URL
+func ProbeOCSP(ctx context.Context, logger log.Logger, target string, module config.Module, registry *prometheus.Registry) error {
+	target, issuerFile, serialHex, err := parseOCSPTarget(target, module.OCSP)
+	if err != nil {
+		return err
+	}
+
+	issuer, err := readIssuer(issuerFile)
+	if err != nil {
+		return err
+	}
+
+	serial, ok := new(big.Int).SetString(strings.ReplaceAll(serialHex, ":", ""), 16)
+	if !ok {
+		return fmt.Errorf("invalid serial number: %q", serialHex)
+	}
+
+	request, err := newOCSPRequest(issuer, serial)
+	if err != nil {
+		return fmt.Errorf("creating ocsp request: %w", err)
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if module.OCSP.ProxyURL.URL != nil {
+		proxy = http.ProxyURL(module.OCSP.ProxyURL.URL)
+	}
+
+	tlsConfig, err := config.NewTLSConfig(&module.TLSConfig)
+	if err != nil {
+		return fmt.Errorf("creating TLS config: %w", err)
+	}
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig:   tlsConfig,
+			Proxy:             proxy,
+			DisableKeepAlives: true,
+		},
+	}
+
+	var (
+		cached = prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "ocsp_response_cached"),
+				Help: "If the OCSP response was cached by an earlier probe",
+			},
+		)
+		signatureValid = prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "ocsp_response_signature_valid"),
+				Help: "If the OCSP response is signed by the issuer, or by a responder that the issuer delegated to",
+			},
+		)
+	)
+	registry.MustRegister(cached, signatureValid)
+
+	var (
+		cacheKey = target + " " + hex.EncodeToString(request)
+		body     []byte
+		hit      bool
+	)
+	if module.OCSP.CacheMaxAge > 0 {
+		body, hit = ocspCache.get(cacheKey)
+	}
+	if hit {
+		cached.Set(1)
+	} else {
+		body, err = requestOCSPResponse(ctx, client, target, request, registry)
+		if err != nil {
+			return err
+		}
+	}
+
+	ocspResp, err := ocsp.ParseResponse(body, nil)
+	if err != nil {
+		return fmt.Errorf("parsing ocsp response: %w", err)
+	}
+	if ocspResp.SerialNumber == nil || ocspResp.SerialNumber.Cmp(serial) != 0 {
+		return fmt.Errorf("the ocsp response is for serial number %x rather than %x", ocspResp.SerialNumber, serial)
+	}
+
+	newOCSPResponseMetrics(registry).set(ocspResp)
+
+	// A response without a nextUpdate may be superseded at any time, so
+	// it isn't cached
+	if module.OCSP.CacheMaxAge > 0 && !hit && !ocspResp.NextUpdate.IsZero() {
+		expires := time.Now().Add(module.OCSP.CacheMaxAge)
+		if ocspResp.NextUpdate.Before(expires) {
+			expires = ocspResp.NextUpdate
+		}
+		ocspCache.put(cacheKey, body, expires)
+	}
+
+	// The response is parsed again to verify its signature, so that an
+	// invalid signature is reported rather than failing the probe
+	if _, err := ocsp.ParseResponse(body, issuer); err == nil {
+		signatureValid.Set(1)
+	}
+
+	return nil
+}
+
+// requestOCSPResponse posts the request to the responder and returns the
+// response
+func requestOCSPResponse(ctx context.Context, client *http.Client, target string, request []byte, registry *prometheus.Registry) ([]byte, error) {
+	var (
+		responderDuration = prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "ocsp_responder_duration_seconds"),
+				Help: "The time taken by the OCSP responder to respond in seconds",
+			},
+		)
+	)
+	registry.MustRegister(responderDuration)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(request))
+	if err != nil {
+		return nil, fmt.Errorf("creating http request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/ocsp-request")
+	req.Header.Set("Accept", "application/ocsp-response")
+	req.Header.Set("User-Agent", userAgent)
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("making http request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response code: %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, ocspMaxSize))
+	if err != nil {
+		return nil, fmt.Errorf("reading response body: %w", err)
+	}
+	responderDuration.Set(time.Since(start).Seconds())
+
+	return body, nil
+}
+
+// parseOCSPTarget returns the URL of the responder in the target, and the
+// issuer file and serial number of the certificate. They're taken from the
+// issuer_file and serial parameters of the target, which are removed from the
+// URL, so that the status of many certificates can be requested with one
+// module. The module supplies them when the parameters aren't set.
+func parseOCSPTarget(target string, probe config.OCSPProbe) (string, string, string, error) {
+	if !strings.HasPrefix(target, "http://") && !strings.HasPrefix(target, "https://") {
+		target = "http://" + target
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", "", "", fmt.Errorf("parsing target: %w", err)
+	}
+
+	var (
+		issuerFile = probe.IssuerFile
+		serial     = probe.Serial
+		query      = u.Query()
+	)
+	if query.Has("issuer_file") {
+		issuerFile = query.Get("issuer_file")
+		query.Del("issuer_file")
+	}
+	if query.Has("serial") {
+		serial = query.Get("serial")
+		query.Del("serial")
+	}
+	u.RawQuery = query.Encode()
+
+	return u.String(), issuerFile, serial, nil
+}
+
+// readIssuer reads the issuer certificate from a PEM file
+func readIssuer(file string) (*x509.Certificate, error) {
+	if file == "" {
+		return nil, fmt.Errorf("an issuer_file must be configured or set in the target")
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("reading issuer file: %w", err)
+	}
+
+	certs, err := decodeCertificates(data)
+	if err != nil {
+		return nil, fmt.Errorf("decoding issuer file: %w", err)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("the issuer file doesn't contain a certificate")
+	}
+
+	return certs[0], nil
+}
+
+// newOCSPRequest returns an OCSP request for the certificate with the serial
+// number issued by the issuer. ocsp.CreateRequest requires the certificate
+// itself.
+func newOCSPRequest(issuer *x509.Certificate, serial *big.Int) ([]byte, error) {
+	var publicKeyInfo struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(issuer.RawSubjectPublicKeyInfo, &publicKeyInfo); err != nil {
+		return nil, err
+	}
+
+	nameHash := sha1.Sum(issuer.RawSubject)
+	keyHash := sha1.Sum(publicKeyInfo.PublicKey.RightAlign())
+
+	req := &ocsp.Request{
+		HashAlgorithm:  crypto.SHA1,
+		IssuerNameHash: nameHash[:],
+		IssuerKeyHash:  keyHash[:],
+		SerialNumber:   serial,
+	}
+
+	return req.Marshal()
+}
@@ -0,0 +1,260 @@
+package prober
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/ribbybibby/ssl_exporter/v2/config"
+	"github.com/ribbybibby/ssl_exporter/v2/test"
+	"golang.org/x/crypto/ocsp"
+)
+
+// TestProbeOCSP tests the metrics returned by an ocsp probe
+func TestProbeOCSP(t *testing.T) {
+	issuer, issuerFile, key, teardown := setupOCSPIssuer(t)
+	defer teardown()
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testcases := []struct {
+		name           string
+		status         int
+		signer         *rsa.PrivateKey
+		signatureValid float64
+	}{
+		{name: "good", status: ocsp.Good, signer: key, signatureValid: 1},
+		{name: "revoked", status: ocsp.Revoked, signer: key, signatureValid: 1},
+		{name: "invalid signature", status: ocsp.Good, signer: otherKey, signatureValid: 0},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := test.SetupOCSPResponder(issuer, tc.signer, tc.status)
+			defer server.Close()
+
+			module := config.Module{
+				OCSP: config.OCSPProbe{
+					IssuerFile: issuerFile,
+					Serial:     "01:c8",
+				},
+			}
+
+			registry := prometheus.NewRegistry()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			if err := ProbeOCSP(ctx, newTestLogger(), server.URL, module, registry); err != nil {
+				t.Fatalf("error: %s", err)
+			}
+
+			mfs, err := registry.Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+			expectedResults := []*registryResult{
+				&registryResult{
+					Name:  "ssl_ocsp_response_status",
+					Value: float64(tc.status),
+				},
+				&registryResult{
+					Name:  "ssl_ocsp_response_signature_valid",
+					Value: tc.signatureValid,
+				},
+			}
+			checkRegistryResults(expectedResults, mfs, t)
+
+			for _, mf := range mfs {
+				if mf.GetName() == "ssl_ocsp_responder_duration_seconds" && mf.GetMetric()[0].GetGauge().GetValue() <= 0 {
+					t.Errorf("expected a positive ssl_ocsp_responder_duration_seconds")
+				}
+			}
+		})
+	}
+}
+
+// TestProbeOCSPTargetParameters tests that the serial number and issuer file
+// in the target take precedence over the ones in the module
+func TestProbeOCSPTargetParameters(t *testing.T) {
+	issuer, issuerFile, key, teardown := setupOCSPIssuer(t)
+	defer teardown()
+
+	server := test.SetupOCSPResponder(issuer, key, ocsp.Good)
+	defer server.Close()
+
+	testcases := []struct {
+		name   string
+		target string
+		module config.OCSPProbe
+	}{
+		{
+			name:   "serial",
+			target: server.URL + "?serial=01:c8",
+			module: config.OCSPProbe{IssuerFile: issuerFile, Serial: "zz"},
+		},
+		{
+			name:   "issuer file and serial",
+			target: server.URL + "/?issuer_file=" + url.QueryEscape(issuerFile) + "&serial=01c8",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			module := config.Module{
+				OCSP: tc.module,
+			}
+
+			registry := prometheus.NewRegistry()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			if err := ProbeOCSP(ctx, newTestLogger(), tc.target, module, registry); err != nil {
+				t.Fatalf("error: %s", err)
+			}
+
+			mfs, err := registry.Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+			expectedResults := []*registryResult{
+				&registryResult{
+					Name:  "ssl_ocsp_response_status",
+					Value: float64(ocsp.Good),
+				},
+			}
+			checkRegistryResults(expectedResults, mfs, t)
+		})
+	}
+}
+
+// TestProbeOCSPUnavailable tests that the probe fails when the responder
+// returns an error
+func TestProbeOCSPUnavailable(t *testing.T) {
+	_, issuerFile, _, teardown := setupOCSPIssuer(t)
+	defer teardown()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	module := config.Module{
+		OCSP: config.OCSPProbe{
+			IssuerFile: issuerFile,
+			Serial:     "01c8",
+		},
+	}
+
+	registry := prometheus.NewRegistry()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeOCSP(ctx, newTestLogger(), server.URL, module, registry); err == nil {
+		t.Fatalf("expected error but err was nil")
+	}
+}
+
+// TestProbeOCSPCache tests that responses are cached between probes when
+// cache_max_age is set
+func TestProbeOCSPCache(t *testing.T) {
+	issuer, issuerFile, key, teardown := setupOCSPIssuer(t)
+	defer teardown()
+
+	testcases := []struct {
+		name        string
+		cacheMaxAge time.Duration
+		requests    int32
+		cached      float64
+	}{
+		{name: "cached", cacheMaxAge: time.Hour, requests: 1, cached: 1},
+		{name: "expired", cacheMaxAge: time.Nanosecond, requests: 2, cached: 0},
+		{name: "disabled", requests: 2, cached: 0},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			responder := test.SetupOCSPResponder(issuer, key, ocsp.Good)
+			defer responder.Close()
+
+			var requests int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&requests, 1)
+				responder.Config.Handler.ServeHTTP(w, r)
+			}))
+			defer server.Close()
+
+			module := config.Module{
+				OCSP: config.OCSPProbe{
+					IssuerFile:  issuerFile,
+					Serial:      "01c8",
+					CacheMaxAge: tc.cacheMaxAge,
+				},
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			var registry *prometheus.Registry
+			for i := 0; i < 2; i++ {
+				registry = prometheus.NewRegistry()
+				if err := ProbeOCSP(ctx, newTestLogger(), server.URL, module, registry); err != nil {
+					t.Fatalf("error: %s", err)
+				}
+			}
+
+			if got := atomic.LoadInt32(&requests); got != tc.requests {
+				t.Errorf("expected %d requests to the responder, but got %d", tc.requests, got)
+			}
+
+			mfs, err := registry.Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+			expectedResults := []*registryResult{
+				&registryResult{
+					Name:  "ssl_ocsp_response_cached",
+					Value: tc.cached,
+				},
+				&registryResult{
+					Name:  "ssl_ocsp_response_status",
+					Value: float64(ocsp.Good),
+				},
+			}
+			checkRegistryResults(expectedResults, mfs, t)
+		})
+	}
+}
+
+// setupOCSPIssuer writes a CA certificate to a file
+func setupOCSPIssuer(t *testing.T) (*x509.Certificate, string, *rsa.PrivateKey, func()) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := test.GenerateCertificateTemplate(time.Now().AddDate(0, 0, 1))
+	template.IsCA = true
+	issuer, issuerPEM := test.GenerateSelfSignedCertificateWithPrivateKey(template, key)
+
+	issuerFile, err := test.WriteFile("ocsp_issuer.pem", issuerPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return issuer, issuerFile, key, func() { os.Remove(issuerFile) }
+}
@@ -0,0 +1,76 @@
+package prober
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// phase is a step of a probe, like connecting to the target or the TLS
+// handshake, that is limited by its own timeout within the deadline of the
+// probe
+type phase struct {
+	name     string
+	timeout  time.Duration
+	start    time.Time
+	deadline time.Time
+	// limited is true when the timeout of the phase ends before the
+	// deadline of the probe
+	limited bool
+}
+
+// newPhase starts a phase. The deadline of the phase is the deadline of the
+// context, unless the timeout is set and ends sooner.
+func newPhase(ctx context.Context, name string, timeout time.Duration) phase {
+	p := phase{name: name, timeout: timeout, start: time.Now()}
+	p.deadline, _ = ctx.Deadline()
+	if timeout > 0 {
+		if deadline := time.Now().Add(timeout); p.deadline.IsZero() || deadline.Before(p.deadline) {
+			p.deadline = deadline
+			p.limited = true
+		}
+	}
+
+	return p
+}
+
+// context returns a context that is cancelled at the deadline of the phase
+func (p phase) context(ctx context.Context) (context.Context, context.CancelFunc) {
+	if !p.limited {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithDeadline(ctx, p.deadline)
+}
+
+// elapsed returns the time since the phase started
+func (p phase) elapsed() time.Duration {
+	return time.Since(p.start)
+}
+
+// err attributes an error to the phase, and names the phase in the message
+// when it happened because the timeout of the phase was exceeded
+func (p phase) err(err error) error {
+	if err == nil {
+		return nil
+	}
+	if !p.limited || time.Now().Before(p.deadline) {
+		return &phaseError{phase: p.name, err: err}
+	}
+
+	return &phaseError{phase: p.name, err: fmt.Errorf("%s timeout of %s exceeded: %w", p.name, p.timeout, err)}
+}
+
+// phaseError is an error that happened during a phase of a probe
+type phaseError struct {
+	phase string
+	err   error
+}
+
+func (e *phaseError) Error() string {
+	return e.err.Error()
+}
+
+func (e *phaseError) Unwrap() error {
+	return e.err
+}
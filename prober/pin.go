@@ -0,0 +1,129 @@
+package prober
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// collectFingerprintMetrics checks the SHA-256 fingerprint of the leaf
+// certificate against the expected fingerprints, so that a certificate that is
+// swapped unexpectedly, or replaced by an intercepting proxy, is reported
+func collectFingerprintMetrics(fingerprints []string, cert *x509.Certificate, registry *prometheus.Registry) {
+	var (
+		fingerprintMatch = prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "cert_fingerprint_match"),
+				Help: "If the SHA-256 fingerprint of the leaf certificate is one of the expected fingerprints",
+			},
+		)
+	)
+	registry.MustRegister(fingerprintMatch)
+
+	sum := sha256.Sum256(cert.Raw)
+	fingerprint := hex.EncodeToString(sum[:])
+	for _, expected := range fingerprints {
+		if normalizeHex(expected) == fingerprint {
+			fingerprintMatch.Set(1)
+			return
+		}
+	}
+}
+
+// collectSerialMetrics checks the serial number and authority key identifier
+// of the leaf certificate against the expected values, so that a certificate
+// that is reissued without a change being approved is reported
+func collectSerialMetrics(serial, authorityKeyID string, cert *x509.Certificate, registry *prometheus.Registry) {
+	if serial != "" {
+		var (
+			serialMatch = prometheus.NewGauge(
+				prometheus.GaugeOpts{
+					Name: prometheus.BuildFQName(namespace, "", "cert_serial_match"),
+					Help: "If the serial number of the leaf certificate is the expected serial number",
+				},
+			)
+		)
+		registry.MustRegister(serialMatch)
+
+		if strings.TrimSpace(serial) == cert.SerialNumber.String() {
+			serialMatch.Set(1)
+		}
+	}
+
+	if authorityKeyID != "" {
+		var (
+			authorityKeyIDMatch = prometheus.NewGauge(
+				prometheus.GaugeOpts{
+					Name: prometheus.BuildFQName(namespace, "", "cert_authority_key_id_match"),
+					Help: "If the authority key identifier of the leaf certificate is the expected authority key identifier",
+				},
+			)
+		)
+		registry.MustRegister(authorityKeyIDMatch)
+
+		if normalizeHex(authorityKeyID) == hex.EncodeToString(cert.AuthorityKeyId) {
+			authorityKeyIDMatch.Set(1)
+		}
+	}
+}
+
+// normalizeHex returns a hex encoded value in lower case and without the
+// colons that tools like openssl separate the bytes with
+func normalizeHex(s string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(s), ":", ""))
+}
+
+// collectSPKIPinMetrics checks the SPKI hashes of the certificates presented by
+// the target and its verified chains against the expected hashes, in the way
+// that HPKP pins are checked. Pinning a key rather than a certificate survives
+// reissuing the certificate with the same key.
+func collectSPKIPinMetrics(pins []string, state tls.ConnectionState, registry *prometheus.Registry) {
+	var (
+		pinValid = prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "spki_pin_valid"),
+				Help: "If the public key of a certificate in the chain matches one of the expected SPKI hashes",
+			},
+		)
+		pinMatch = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "spki_pin_match"),
+				Help: "If the public key of a certificate in the chain matches the expected SPKI hash",
+			},
+			[]string{"pin"},
+		)
+	)
+	registry.MustRegister(pinValid, pinMatch)
+
+	hashes := map[string]bool{}
+	for _, cert := range state.PeerCertificates {
+		hashes[spkiHash(cert)] = true
+	}
+	for _, chain := range state.VerifiedChains {
+		for _, cert := range chain {
+			hashes[spkiHash(cert)] = true
+		}
+	}
+
+	for _, pin := range pins {
+		if !hashes[strings.TrimPrefix(strings.TrimSpace(pin), "sha256/")] {
+			pinMatch.WithLabelValues(pin).Set(0)
+			continue
+		}
+		pinMatch.WithLabelValues(pin).Set(1)
+		pinValid.Set(1)
+	}
+}
+
+// spkiHash returns the base64 encoded SHA-256 hash of the subject public key
+// info of the certificate, which is the pin-sha256 of HPKP
+func spkiHash(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
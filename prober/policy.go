@@ -0,0 +1,121 @@
+package prober
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/ribbybibby/ssl_exporter/v2/config"
+)
+
+// policyViolations returns the rules that are enabled in the policy, and
+// whether any of the versions, cipher suites or certificates violates each of
+// them. The key size rules aren't checked without certificates.
+func policyViolations(policy config.Policy, versions, cipherSuites []uint16, certs []*x509.Certificate) map[string]bool {
+	violations := map[string]bool{}
+
+	if policy.MinVersion != 0 {
+		violations["min_version"] = false
+		for _, version := range versions {
+			if version < uint16(policy.MinVersion) {
+				violations["min_version"] = true
+			}
+		}
+	}
+
+	cipherRules := []struct {
+		name    string
+		enabled bool
+		denies  func(name string) bool
+	}{
+		{"3des", policy.Deny3DES, func(name string) bool { return strings.Contains(name, "_3DES_") }},
+		{"rc4", policy.DenyRC4, func(name string) bool { return strings.Contains(name, "_RC4_") }},
+		{"cbc", policy.DenyCBC, func(name string) bool { return strings.Contains(name, "_CBC_") }},
+		{"rsa_key_exchange", policy.DenyRSAKeyExchange, func(name string) bool { return strings.HasPrefix(name, "TLS_RSA_") }},
+	}
+	for _, rule := range cipherRules {
+		if !rule.enabled {
+			continue
+		}
+		violations[rule.name] = false
+		for _, id := range cipherSuites {
+			if rule.denies(tls.CipherSuiteName(id)) {
+				violations[rule.name] = true
+			}
+		}
+	}
+
+	if len(certs) == 0 {
+		return violations
+	}
+
+	if policy.MinRSABits != 0 {
+		violations["min_rsa_bits"] = false
+		for _, cert := range certs {
+			if key, ok := cert.PublicKey.(*rsa.PublicKey); ok && key.N.BitLen() < policy.MinRSABits {
+				violations["min_rsa_bits"] = true
+			}
+		}
+	}
+
+	if policy.MinECDSABits != 0 {
+		violations["min_ecdsa_bits"] = false
+		for _, cert := range certs {
+			if key, ok := cert.PublicKey.(*ecdsa.PublicKey); ok && key.Curve.Params().BitSize < policy.MinECDSABits {
+				violations["min_ecdsa_bits"] = true
+			}
+		}
+	}
+
+	if policy.MaxLifetime != 0 {
+		lifetime := certs[0].NotAfter.Sub(certs[0].NotBefore)
+		violations["max_lifetime"] = lifetime > time.Duration(policy.MaxLifetime)
+	}
+
+	return violations
+}
+
+// collectPolicyMetrics checks the versions, cipher suites and certificates
+// against the policy. Nothing is collected when the policy doesn't have any
+// rules.
+func collectPolicyMetrics(policy config.Policy, versions, cipherSuites []uint16, certs []*x509.Certificate, registry *prometheus.Registry) error {
+	violations := policyViolations(policy, versions, cipherSuites, certs)
+
+	if len(policy.Rules) > 0 && len(certs) > 0 {
+		ruleViolations, err := rulesViolations(policy.Rules, certs)
+		if err != nil {
+			return err
+		}
+		for rule, violated := range ruleViolations {
+			violations[rule] = violated
+		}
+	}
+
+	if len(violations) == 0 {
+		return nil
+	}
+
+	var (
+		policyViolation = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "policy_violation"),
+				Help: "Does the target violate the policy rule",
+			},
+			[]string{"rule"},
+		)
+	)
+	registry.MustRegister(policyViolation)
+
+	for rule, violated := range violations {
+		policyViolation.WithLabelValues(rule).Set(0)
+		if violated {
+			policyViolation.WithLabelValues(rule).Set(1)
+		}
+	}
+
+	return nil
+}
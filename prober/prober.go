@@ -18,6 +18,14 @@ var (
 		"http_file":  ProbeHTTPFile,
 		"kubernetes": ProbeKubernetes,
 		"kubeconfig": ProbeKubeconfig,
+		"grpc":       ProbeGRPC,
+		"quic":       ProbeQUIC,
+		"dtls":       ProbeDTLS,
+		"ssh":        ProbeSSH,
+		"ssh_file":   ProbeSSHFile,
+		"scan":       ProbeScan,
+		"ocsp":       ProbeOCSP,
+		"ct":         ProbeCT,
 	}
 )
 
@@ -0,0 +1,935 @@
+package prober
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/ribbybibby/ssl_exporter/v2/config"
+)
+
+// protocolChecks maps an application protocol to a function that checks the
+// health of the server over an established TLS connection, given the name of
+// the server that is being probed
+var protocolChecks = map[string]func(logger log.Logger, conn net.Conn, serverName string, module config.Module) error{
+	"amqp":      checkAMQP,
+	"cassandra": checkCassandra,
+	"ftp":       checkFTP,
+	"kafka":     checkKafka,
+	"ldap":      checkLDAP,
+	"mongodb":   checkMongoDB,
+	"mqtt":      checkMQTT,
+	"redis":     checkRedis,
+	"sip":       checkSIP,
+	"smtp":      checkSMTP,
+	"stun":      checkSTUN,
+	"syslog":    checkSyslog,
+	"zookeeper": checkZooKeeper,
+}
+
+// protocolReadyMetrics are the names of the gauges that also report the
+// result of the check for those protocols that have one
+var protocolReadyMetrics = map[string]string{
+	"smtp": "smtp_ready",
+}
+
+// checkProtocol performs the configured protocol check and records the result.
+// A failed check doesn't fail the probe, so that the certificate metrics are
+// still reported for servers that are listening but unhealthy.
+func checkProtocol(logger log.Logger, conn net.Conn, serverName string, module config.Module, registry *prometheus.Registry) error {
+	check, ok := protocolChecks[module.TCP.Protocol]
+	if !ok {
+		return fmt.Errorf("protocol check is not supported for %s", module.TCP.Protocol)
+	}
+
+	var (
+		protocolCheckSuccess = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "protocol_check_success"),
+				Help: "If the application protocol check performed after the TLS handshake was a success",
+			},
+			[]string{"protocol"},
+		)
+	)
+	registry.MustRegister(protocolCheckSuccess)
+
+	var ready prometheus.Gauge
+	if name, ok := protocolReadyMetrics[module.TCP.Protocol]; ok {
+		ready = prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", name),
+				Help: fmt.Sprintf("If the %s server is ready to accept requests after the TLS handshake", module.TCP.Protocol),
+			},
+		)
+		registry.MustRegister(ready)
+	}
+
+	success := 1.0
+	if err := check(logger, conn, serverName, module); err != nil {
+		level.Error(logger).Log("msg", fmt.Sprintf("%s protocol check failed: %s", module.TCP.Protocol, err))
+		success = 0
+	}
+
+	protocolCheckSuccess.WithLabelValues(module.TCP.Protocol).Set(success)
+	if ready != nil {
+		ready.Set(success)
+	}
+
+	return nil
+}
+
+// readPassword reads the password for protocol checks that authenticate from
+// the configured password file
+func readPassword(module config.Module) (string, error) {
+	return readPasswordFile(module.TCP.PasswordFile)
+}
+
+// readPasswordFile reads a password from a file, without the trailing newline
+func readPasswordFile(file string) (string, error) {
+	if file == "" {
+		return "", nil
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("reading password file: %w", err)
+	}
+
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// checkLDAP performs an anonymous simple bind
+func checkLDAP(logger log.Logger, conn net.Conn, serverName string, module config.Module) error {
+	request := berElement(berTagSequence,
+		berElement(berTagInteger, []byte{0x01}),
+		berElement(ldapTagBindRequest,
+			berElement(berTagInteger, []byte{0x03}),
+			berElement(berTagOctetString),
+			berElement(ldapTagSimpleAuth),
+		),
+	)
+
+	level.Debug(logger).Log("msg", fmt.Sprintf("sending ldap bind request: %x", request))
+
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+
+	code, msg, err := readLDAPResult(conn, ldapTagBindResponse)
+	if err != nil {
+		return fmt.Errorf("reading ldap bind response: %w", err)
+	}
+	if code != ldapResultSuccess {
+		return fmt.Errorf("ldap bind failed with result code %d: %s", code, msg)
+	}
+
+	return nil
+}
+
+const (
+	amqpFrameMethod     = 0x01
+	amqpFrameEnd        = 0xce
+	amqpClassConnection = 10
+	amqpMethodStart     = 10
+)
+
+// checkAMQP sends the AMQP 0-9-1 protocol header and checks that the server
+// responds with a Connection.Start method frame
+func checkAMQP(logger log.Logger, conn net.Conn, serverName string, module config.Module) error {
+	header := []byte{'A', 'M', 'Q', 'P', 0x00, 0x00, 0x09, 0x01}
+
+	level.Debug(logger).Log("msg", fmt.Sprintf("sending amqp protocol header: %x", header))
+
+	if _, err := conn.Write(header); err != nil {
+		return err
+	}
+
+	frame := make([]byte, 7)
+	if _, err := io.ReadFull(conn, frame); err != nil {
+		return fmt.Errorf("reading amqp frame: %w", err)
+	}
+
+	// A server that doesn't support the requested version responds with
+	// the protocol header that it does support
+	if bytes.HasPrefix(frame, []byte("AMQP")) {
+		return fmt.Errorf("amqp server doesn't support protocol 0-9-1, it offered %x", frame[4:])
+	}
+	if frame[0] != amqpFrameMethod {
+		return fmt.Errorf("unexpected amqp frame type %d", frame[0])
+	}
+
+	size := binary.BigEndian.Uint32(frame[3:7])
+	if size < 4 || size > 1024*1024 {
+		return fmt.Errorf("invalid amqp frame size %d", size)
+	}
+	payload := make([]byte, size+1)
+	if _, err := io.ReadFull(conn, payload); err != nil {
+		return fmt.Errorf("reading amqp frame: %w", err)
+	}
+	if payload[size] != amqpFrameEnd {
+		return fmt.Errorf("invalid amqp frame end %x", payload[size])
+	}
+
+	class := binary.BigEndian.Uint16(payload[0:2])
+	method := binary.BigEndian.Uint16(payload[2:4])
+	if class != amqpClassConnection || method != amqpMethodStart {
+		return fmt.Errorf("expected amqp Connection.Start but got method %d.%d", class, method)
+	}
+
+	level.Debug(logger).Log("msg", "read amqp Connection.Start")
+
+	return nil
+}
+
+const (
+	mongoOpMsg           = 2013
+	mongoMaxMessageBytes = 48 * 1024 * 1024
+)
+
+// checkMongoDB runs the hello command, falling back to the legacy isMaster
+// command for servers that predate hello, and checks that it succeeded
+func checkMongoDB(logger log.Logger, conn net.Conn, serverName string, module config.Module) error {
+	var err error
+	for i, command := range []string{"hello", "isMaster"} {
+		level.Debug(logger).Log("msg", fmt.Sprintf("sending mongodb %s command", command))
+
+		var ok bool
+		ok, err = mongoCommand(conn, int32(i+1), command)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+		err = fmt.Errorf("mongodb %s command returned ok: 0", command)
+	}
+
+	return err
+}
+
+// mongoCommand sends an OP_MSG containing the command {<command>: 1, $db:
+// "admin"} and returns the value of the ok field in the reply
+func mongoCommand(conn net.Conn, requestID int32, command string) (bool, error) {
+	doc := bsonDocument(
+		bsonInt32(command, 1),
+		bsonString("$db", "admin"),
+	)
+
+	// Flag bits followed by a single body section
+	body := append([]byte{0x00, 0x00, 0x00, 0x00, 0x00}, doc...)
+
+	msg := make([]byte, 16, 16+len(body))
+	binary.LittleEndian.PutUint32(msg[0:4], uint32(16+len(body)))
+	binary.LittleEndian.PutUint32(msg[4:8], uint32(requestID))
+	binary.LittleEndian.PutUint32(msg[12:16], mongoOpMsg)
+	msg = append(msg, body...)
+
+	if _, err := conn.Write(msg); err != nil {
+		return false, err
+	}
+
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return false, fmt.Errorf("reading mongodb reply: %w", err)
+	}
+	length := binary.LittleEndian.Uint32(header[0:4])
+	if length < 16+5 || length > mongoMaxMessageBytes {
+		return false, fmt.Errorf("invalid mongodb reply length %d", length)
+	}
+	if opCode := binary.LittleEndian.Uint32(header[12:16]); opCode != mongoOpMsg {
+		return false, fmt.Errorf("unexpected mongodb reply opcode %d", opCode)
+	}
+	reply := make([]byte, length-16)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return false, fmt.Errorf("reading mongodb reply: %w", err)
+	}
+	if reply[4] != 0x00 {
+		return false, fmt.Errorf("unexpected mongodb reply section kind %d", reply[4])
+	}
+
+	value, err := bsonLookupNumber(reply[5:], "ok")
+	if err != nil {
+		return false, err
+	}
+
+	return value == 1, nil
+}
+
+// bsonDocument encodes the given elements as a BSON document
+func bsonDocument(elements ...[]byte) []byte {
+	var doc []byte
+	for _, e := range elements {
+		doc = append(doc, e...)
+	}
+	doc = append(doc, 0x00)
+
+	length := make([]byte, 4)
+	binary.LittleEndian.PutUint32(length, uint32(len(doc)+4))
+
+	return append(length, doc...)
+}
+
+// bsonInt32 encodes a BSON int32 element
+func bsonInt32(name string, v int32) []byte {
+	e := append(append([]byte{0x10}, name...), 0x00)
+
+	return binary.LittleEndian.AppendUint32(e, uint32(v))
+}
+
+// bsonString encodes a BSON string element
+func bsonString(name, v string) []byte {
+	e := append(append([]byte{0x02}, name...), 0x00)
+	e = binary.LittleEndian.AppendUint32(e, uint32(len(v)+1))
+
+	return append(append(e, v...), 0x00)
+}
+
+// bsonLookupNumber returns the value of the numeric or boolean element with
+// the given name from the top level of a BSON document
+func bsonLookupNumber(doc []byte, name string) (float64, error) {
+	if len(doc) < 5 {
+		return 0, fmt.Errorf("bson document is too short")
+	}
+	length := int(binary.LittleEndian.Uint32(doc[0:4]))
+	if length > len(doc) || length < 5 {
+		return 0, fmt.Errorf("invalid bson document length %d", length)
+	}
+	doc = doc[4 : length-1]
+
+	for len(doc) > 0 {
+		kind := doc[0]
+		end := bytes.IndexByte(doc[1:], 0x00)
+		if end < 0 {
+			return 0, fmt.Errorf("invalid bson element name")
+		}
+		key := string(doc[1 : end+1])
+		doc = doc[end+2:]
+
+		var size int
+		switch kind {
+		case 0x01, 0x09, 0x11, 0x12:
+			size = 8
+		case 0x08:
+			size = 1
+		case 0x0a:
+			size = 0
+		case 0x10:
+			size = 4
+		case 0x07:
+			size = 12
+		case 0x13:
+			size = 16
+		case 0x02, 0x03, 0x04, 0x05:
+			if len(doc) < 4 {
+				return 0, fmt.Errorf("bson element %s is too short", key)
+			}
+			size = int(binary.LittleEndian.Uint32(doc[0:4]))
+			switch kind {
+			case 0x02:
+				size += 4
+			case 0x05:
+				size += 5
+			}
+		default:
+			return 0, fmt.Errorf("unsupported bson type %x for element %s", kind, key)
+		}
+		if size < 0 || size > len(doc) {
+			return 0, fmt.Errorf("bson element %s is too short", key)
+		}
+
+		if key == name {
+			value := doc[:size]
+			switch kind {
+			case 0x01:
+				return math.Float64frombits(binary.LittleEndian.Uint64(value)), nil
+			case 0x08:
+				return float64(value[0]), nil
+			case 0x10:
+				return float64(int32(binary.LittleEndian.Uint32(value))), nil
+			case 0x12:
+				return float64(int64(binary.LittleEndian.Uint64(value))), nil
+			default:
+				return 0, fmt.Errorf("bson element %s is not a number", name)
+			}
+		}
+		doc = doc[size:]
+	}
+
+	return 0, fmt.Errorf("bson element %s not found", name)
+}
+
+// checkRedis authenticates, if a password is configured, and then checks that
+// the server responds to PING
+func checkRedis(logger log.Logger, conn net.Conn, serverName string, module config.Module) error {
+	password, err := readPassword(module)
+	if err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(conn)
+
+	if password != "" {
+		args := []string{"AUTH", password}
+		if module.TCP.Username != "" {
+			args = []string{"AUTH", module.TCP.Username, password}
+		}
+		level.Debug(logger).Log("msg", "sending redis AUTH command")
+		if err := redisCommand(conn, reader, "+OK", args...); err != nil {
+			return err
+		}
+	}
+
+	level.Debug(logger).Log("msg", "sending redis PING command")
+
+	return redisCommand(conn, reader, "+PONG", "PING")
+}
+
+// redisCommand sends a command to a redis server and checks for the expected
+// simple string reply
+func redisCommand(conn net.Conn, reader *bufio.Reader, expect string, args ...string) error {
+	cmd := fmt.Sprintf("*%d\r\n", len(args))
+	for _, arg := range args {
+		cmd += fmt.Sprintf("$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(conn, cmd); err != nil {
+		return err
+	}
+
+	reply, err := reader.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("reading redis %s reply: %w", args[0], err)
+	}
+	reply = strings.TrimRight(reply, "\r\n")
+	if reply != expect {
+		return fmt.Errorf("unexpected redis %s reply: %s", args[0], reply)
+	}
+
+	return nil
+}
+
+const (
+	kafkaAPIVersionsKey  = 18
+	kafkaClientID        = "ssl_exporter"
+	kafkaCorrelationID   = 1
+	kafkaMaxMessageBytes = 1024 * 1024
+)
+
+// checkKafka sends an ApiVersions request and checks that the broker responds
+// without an error
+func checkKafka(logger log.Logger, conn net.Conn, serverName string, module config.Module) error {
+	// Request header v1 (api key, api version, correlation id and client
+	// id) for ApiVersions v0, which has an empty body
+	request := binary.BigEndian.AppendUint16(nil, kafkaAPIVersionsKey)
+	request = binary.BigEndian.AppendUint16(request, 0)
+	request = binary.BigEndian.AppendUint32(request, kafkaCorrelationID)
+	request = binary.BigEndian.AppendUint16(request, uint16(len(kafkaClientID)))
+	request = append(request, kafkaClientID...)
+	request = append(binary.BigEndian.AppendUint32(nil, uint32(len(request))), request...)
+
+	level.Debug(logger).Log("msg", fmt.Sprintf("sending kafka ApiVersions request: %x", request))
+
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return fmt.Errorf("reading kafka ApiVersions response: %w", err)
+	}
+	length := binary.BigEndian.Uint32(header)
+	if length < 6 || length > kafkaMaxMessageBytes {
+		return fmt.Errorf("invalid kafka response length %d", length)
+	}
+	response := make([]byte, length)
+	if _, err := io.ReadFull(conn, response); err != nil {
+		return fmt.Errorf("reading kafka ApiVersions response: %w", err)
+	}
+
+	if correlationID := binary.BigEndian.Uint32(response[0:4]); correlationID != kafkaCorrelationID {
+		return fmt.Errorf("unexpected kafka correlation id %d", correlationID)
+	}
+	if code := int16(binary.BigEndian.Uint16(response[4:6])); code != 0 {
+		return fmt.Errorf("kafka ApiVersions request failed with error code %d", code)
+	}
+
+	return nil
+}
+
+const (
+	mqttPacketConnect    = 0x10
+	mqttPacketConnAck    = 0x20
+	mqttPacketDisconnect = 0xe0
+	mqttProtocolLevel    = 0x04
+	mqttFlagCleanSession = 0x02
+	mqttFlagPassword     = 0x40
+	mqttFlagUsername     = 0x80
+	mqttKeepAliveSeconds = 60
+)
+
+// checkMQTT sends an MQTT 3.1.1 CONNECT packet and checks that the broker
+// accepts the connection in its CONNACK
+func checkMQTT(logger log.Logger, conn net.Conn, serverName string, module config.Module) error {
+	password, err := readPassword(module)
+	if err != nil {
+		return err
+	}
+
+	// Use a random client id so that concurrent probes don't disconnect
+	// each other
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	clientID := "ssl_exporter-" + hex.EncodeToString(id)
+
+	flags := byte(mqttFlagCleanSession)
+	payload := mqttString(clientID)
+	if module.TCP.Username != "" {
+		flags |= mqttFlagUsername
+		payload = append(payload, mqttString(module.TCP.Username)...)
+	}
+	if password != "" {
+		flags |= mqttFlagPassword
+		payload = append(payload, mqttString(password)...)
+	}
+
+	body := append(mqttString("MQTT"), mqttProtocolLevel, flags)
+	body = binary.BigEndian.AppendUint16(body, mqttKeepAliveSeconds)
+	body = append(body, payload...)
+
+	packet := append([]byte{mqttPacketConnect}, mqttRemainingLength(len(body))...)
+	packet = append(packet, body...)
+
+	level.Debug(logger).Log("msg", fmt.Sprintf("sending mqtt CONNECT with client id %s", clientID))
+
+	if _, err := conn.Write(packet); err != nil {
+		return err
+	}
+
+	connAck := make([]byte, 4)
+	if _, err := io.ReadFull(conn, connAck); err != nil {
+		return fmt.Errorf("reading mqtt CONNACK: %w", err)
+	}
+	if connAck[0] != mqttPacketConnAck || connAck[1] != 0x02 {
+		return fmt.Errorf("unexpected mqtt packet %x", connAck[:2])
+	}
+	if code := connAck[3]; code != 0x00 {
+		return fmt.Errorf("mqtt broker refused the connection with return code %d", code)
+	}
+
+	level.Debug(logger).Log("msg", "mqtt broker accepted the connection")
+
+	_, err = conn.Write([]byte{mqttPacketDisconnect, 0x00})
+
+	return err
+}
+
+// mqttString encodes a length prefixed MQTT string
+func mqttString(s string) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, uint16(len(s))), s...)
+}
+
+// mqttRemainingLength encodes the remaining length of an MQTT packet
+func mqttRemainingLength(n int) []byte {
+	var b []byte
+	for {
+		digit := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			digit |= 0x80
+		}
+		b = append(b, digit)
+		if n == 0 {
+			return b
+		}
+	}
+}
+
+// checkFTP reads the greeting from an implicit FTPS server, which is sent
+// after the TLS handshake, and expects the server to be ready for a new user
+func checkFTP(logger log.Logger, conn net.Conn, serverName string, module config.Module) error {
+	reader := bufio.NewReader(conn)
+
+	code, lines, err := readTextReply(reader)
+	if err != nil {
+		return fmt.Errorf("reading ftp greeting: %w", err)
+	}
+
+	level.Debug(logger).Log("msg", fmt.Sprintf("read ftp greeting: %q", lines))
+
+	if code != "220" {
+		return fmt.Errorf("unexpected ftp greeting: %s", strings.Join(lines, " "))
+	}
+
+	if _, err := io.WriteString(conn, "QUIT\r\n"); err != nil {
+		return err
+	}
+
+	// The server is ready, so a missing reply to QUIT doesn't fail the check
+	if _, lines, err := readTextReply(reader); err == nil {
+		level.Debug(logger).Log("msg", fmt.Sprintf("read ftp QUIT reply: %q", lines))
+	}
+
+	return nil
+}
+
+// checkSMTP reads the greeting from an implicit TLS (SMTPS) server and then
+// exchanges EHLO to check that the MTA is accepting mail
+func checkSMTP(logger log.Logger, conn net.Conn, serverName string, module config.Module) error {
+	reader := bufio.NewReader(conn)
+
+	code, lines, err := readTextReply(reader)
+	if err != nil {
+		return fmt.Errorf("reading smtp greeting: %w", err)
+	}
+
+	level.Debug(logger).Log("msg", fmt.Sprintf("read smtp greeting: %q", lines))
+
+	if code != "220" {
+		return fmt.Errorf("unexpected smtp greeting: %s", strings.Join(lines, " "))
+	}
+
+	capabilities, err := smtpEHLO(logger, conn, reader, module.TCP.SMTP)
+	if err != nil {
+		return err
+	}
+	if err := smtpCheckCapabilities(capabilities, module.TCP.SMTP); err != nil {
+		return err
+	}
+
+	if _, err := io.WriteString(conn, "QUIT\r\n"); err != nil {
+		return err
+	}
+
+	// The server is ready, so a missing reply to QUIT doesn't fail the check
+	if _, lines, err := readTextReply(reader); err == nil {
+		level.Debug(logger).Log("msg", fmt.Sprintf("read smtp QUIT reply: %q", lines))
+	}
+
+	return nil
+}
+
+// readTextReply reads a reply in the format shared by FTP and SMTP, where the
+// lines of a multiline reply are separated from the code by a hyphen, apart
+// from the last, which uses a space. It returns the reply code and lines.
+func readTextReply(reader *bufio.Reader) (string, []string, error) {
+	var (
+		code  string
+		lines []string
+	)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return "", lines, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		lines = append(lines, line)
+
+		if code == "" {
+			if len(line) < 3 || (len(line) > 3 && line[3] != ' ' && line[3] != '-') {
+				return "", lines, fmt.Errorf("invalid reply line: %q", line)
+			}
+			code = line[:3]
+		}
+
+		// The lines in between the first and last may have any prefix
+		if line == code || strings.HasPrefix(line, code+" ") {
+			return code, lines, nil
+		}
+	}
+}
+
+// checkZooKeeper sends the srvr four letter word command, which is allowed by
+// the default command whitelist, and checks that the server reports that it
+// is serving requests
+func checkZooKeeper(logger log.Logger, conn net.Conn, serverName string, module config.Module) error {
+	level.Debug(logger).Log("msg", "sending zookeeper srvr command")
+
+	if _, err := io.WriteString(conn, "srvr"); err != nil {
+		return err
+	}
+
+	// The server closes the connection after writing the response
+	resp, err := io.ReadAll(io.LimitReader(conn, 64*1024))
+	if err != nil && len(resp) == 0 {
+		return fmt.Errorf("reading zookeeper srvr response: %w", err)
+	}
+
+	level.Debug(logger).Log("msg", fmt.Sprintf("read zookeeper srvr response: %q", resp))
+
+	for _, line := range strings.Split(string(resp), "\n") {
+		if strings.HasPrefix(line, "Mode: ") {
+			return nil
+		}
+	}
+	if len(resp) == 0 {
+		return fmt.Errorf("empty zookeeper srvr response, is srvr in the 4lw.commands.whitelist?")
+	}
+
+	return fmt.Errorf("zookeeper isn't serving requests: %s", strings.TrimSpace(string(resp)))
+}
+
+const (
+	cqlVersionRequest  = 0x04
+	cqlVersionResponse = 0x84
+	cqlOpError         = 0x00
+	cqlOpOptions       = 0x05
+	cqlOpSupported     = 0x06
+	// cqlMaxFrameBytes limits the body of the response, which is only
+	// expected to be a short SUPPORTED or ERROR frame
+	cqlMaxFrameBytes = 16 * 1024
+)
+
+// checkCassandra sends an OPTIONS request using version 4 of the CQL native
+// protocol and checks that the node responds with SUPPORTED
+func checkCassandra(logger log.Logger, conn net.Conn, serverName string, module config.Module) error {
+	// Version, flags, stream id, opcode and an empty body
+	request := []byte{cqlVersionRequest, 0x00, 0x00, 0x01, cqlOpOptions, 0x00, 0x00, 0x00, 0x00}
+
+	level.Debug(logger).Log("msg", fmt.Sprintf("sending cql OPTIONS request: %x", request))
+
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+
+	header := make([]byte, 9)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return fmt.Errorf("reading cql response: %w", err)
+	}
+	length := binary.BigEndian.Uint32(header[5:9])
+	if length > cqlMaxFrameBytes {
+		return fmt.Errorf("cql frame length %d exceeds the limit of %d bytes", length, cqlMaxFrameBytes)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(conn, body); err != nil {
+		return fmt.Errorf("reading cql response: %w", err)
+	}
+	if header[0] != cqlVersionResponse {
+		return fmt.Errorf("unexpected cql response version %x", header[0])
+	}
+
+	switch header[4] {
+	case cqlOpSupported:
+		return nil
+	case cqlOpError:
+		if len(body) >= 6 {
+			msgLength := int(binary.BigEndian.Uint16(body[4:6]))
+			if len(body) >= 6+msgLength {
+				return fmt.Errorf("cql node returned error %x: %s", body[0:4], body[6:6+msgLength])
+			}
+		}
+		return fmt.Errorf("cql node returned an error")
+	default:
+		return fmt.Errorf("unexpected cql response opcode %x", header[4])
+	}
+}
+
+// checkSIP sends a SIP OPTIONS request and checks for a final 200 response
+func checkSIP(logger log.Logger, conn net.Conn, serverName string, module config.Module) error {
+	id := make([]byte, 12)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	random := hex.EncodeToString(id)
+
+	local := conn.LocalAddr().String()
+	localHost, _, err := net.SplitHostPort(local)
+	if err != nil {
+		localHost = "localhost"
+	}
+	if ip := net.ParseIP(localHost); ip != nil && ip.To4() == nil {
+		localHost = "[" + localHost + "]"
+	}
+	if ip := net.ParseIP(serverName); ip != nil && ip.To4() == nil {
+		serverName = "[" + serverName + "]"
+	}
+
+	request := strings.Join([]string{
+		fmt.Sprintf("OPTIONS sip:%s SIP/2.0", serverName),
+		fmt.Sprintf("Via: SIP/2.0/TLS %s;branch=z9hG4bK%s;rport", local, random[:16]),
+		"Max-Forwards: 70",
+		fmt.Sprintf("From: <sip:ssl_exporter@%s>;tag=%s", localHost, random[16:]),
+		fmt.Sprintf("To: <sip:%s>", serverName),
+		fmt.Sprintf("Call-ID: %s@%s", random, localHost),
+		"CSeq: 1 OPTIONS",
+		fmt.Sprintf("Contact: <sip:ssl_exporter@%s;transport=tls>", localHost),
+		"Accept: application/sdp",
+		fmt.Sprintf("User-Agent: %s", userAgent),
+		"Content-Length: 0",
+		"",
+		"",
+	}, "\r\n")
+
+	level.Debug(logger).Log("msg", fmt.Sprintf("sending sip OPTIONS request: %q", request))
+
+	if _, err := io.WriteString(conn, request); err != nil {
+		return err
+	}
+
+	// Skip over any provisional responses to the final response
+	reader := bufio.NewReader(conn)
+	for {
+		status, err := reader.ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("reading sip response: %w", err)
+		}
+		status = strings.TrimRight(status, "\r\n")
+		if status == "" {
+			continue
+		}
+
+		level.Debug(logger).Log("msg", fmt.Sprintf("read sip status line: %s", status))
+
+		fields := strings.SplitN(status, " ", 3)
+		if len(fields) < 2 || fields[0] != "SIP/2.0" {
+			return fmt.Errorf("invalid sip status line: %s", status)
+		}
+		if strings.HasPrefix(fields[1], "1") {
+			// Discard the headers of the provisional response
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return fmt.Errorf("reading sip response: %w", err)
+				}
+				if strings.TrimRight(line, "\r\n") == "" {
+					break
+				}
+			}
+			continue
+		}
+		if fields[1] != "200" {
+			return fmt.Errorf("unexpected sip response: %s", status)
+		}
+
+		return nil
+	}
+}
+
+const (
+	stunMagicCookie        = 0x2112a442
+	stunBindingRequest     = 0x0001
+	stunBindingSuccess     = 0x0101
+	stunBindingError       = 0x0111
+	stunAttrErrorCode      = 0x0009
+	stunAttrXORMappedAddr  = 0x0020
+	stunHeaderLength       = 20
+	stunTransactionIDBytes = 12
+)
+
+// checkSTUN sends a STUN Binding request, as described in RFC 8489, and
+// expects a success response with the same transaction id. TURN servers answer
+// Binding requests on their TLS port, so this checks those too.
+func checkSTUN(logger log.Logger, conn net.Conn, serverName string, module config.Module) error {
+	request := make([]byte, stunHeaderLength)
+	binary.BigEndian.PutUint16(request[0:2], stunBindingRequest)
+	binary.BigEndian.PutUint32(request[4:8], stunMagicCookie)
+	if _, err := rand.Read(request[8:]); err != nil {
+		return err
+	}
+
+	level.Debug(logger).Log("msg", fmt.Sprintf("sending stun binding request with transaction id %x", request[8:]))
+
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+
+	header := make([]byte, stunHeaderLength)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return fmt.Errorf("reading stun response: %w", err)
+	}
+	if binary.BigEndian.Uint32(header[4:8]) != stunMagicCookie {
+		return fmt.Errorf("invalid stun magic cookie: %x", header[4:8])
+	}
+	if !bytes.Equal(header[8:], request[8:]) {
+		return fmt.Errorf("unexpected stun transaction id: %x", header[8:])
+	}
+	attrs := make([]byte, binary.BigEndian.Uint16(header[2:4]))
+	if _, err := io.ReadFull(conn, attrs); err != nil {
+		return fmt.Errorf("reading stun response attributes: %w", err)
+	}
+
+	msgType := binary.BigEndian.Uint16(header[0:2])
+	switch msgType {
+	case stunBindingSuccess:
+		if addr := stunAttribute(attrs, stunAttrXORMappedAddr); addr != nil {
+			level.Debug(logger).Log("msg", fmt.Sprintf("stun binding succeeded with mapped address %s", stunXORAddress(addr, header[8:])))
+		}
+		return nil
+	case stunBindingError:
+		if errorCode := stunAttribute(attrs, stunAttrErrorCode); len(errorCode) >= 4 {
+			return fmt.Errorf("stun binding failed with error %d: %s", int(errorCode[2]&0x07)*100+int(errorCode[3]), errorCode[4:])
+		}
+		return fmt.Errorf("stun binding failed")
+	default:
+		return fmt.Errorf("unexpected stun message type: %#04x", msgType)
+	}
+}
+
+// stunAttribute returns the value of the first attribute of the given type
+func stunAttribute(attrs []byte, attrType uint16) []byte {
+	for len(attrs) >= 4 {
+		length := int(binary.BigEndian.Uint16(attrs[2:4]))
+		if len(attrs) < 4+length {
+			return nil
+		}
+		if binary.BigEndian.Uint16(attrs[0:2]) == attrType {
+			return attrs[4 : 4+length]
+		}
+		// Attributes are padded to a multiple of four bytes
+		padded := 4 + (length+3)&^3
+		if len(attrs) < padded {
+			return nil
+		}
+		attrs = attrs[padded:]
+	}
+
+	return nil
+}
+
+// stunXORAddress decodes the value of an XOR-MAPPED-ADDRESS attribute, which is
+// 8 bytes long for an IPv4 address and 20 bytes long for an IPv6 address
+func stunXORAddress(value, transactionID []byte) string {
+	if len(value) != 8 && len(value) != 20 {
+		return ""
+	}
+	key := binary.BigEndian.AppendUint32(nil, stunMagicCookie)
+	key = append(key, transactionID...)
+
+	port := binary.BigEndian.Uint16(value[2:4]) ^ uint16(stunMagicCookie>>16)
+	ip := make(net.IP, len(value)-4)
+	for i := range ip {
+		ip[i] = value[4+i] ^ key[i]
+	}
+
+	return net.JoinHostPort(ip.String(), fmt.Sprint(port))
+}
+
+// checkSyslog writes an RFC 5424 test message to the collector using the
+// octet counting framing described in RFC 5425
+func checkSyslog(logger log.Logger, conn net.Conn, serverName string, module config.Module) error {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+
+	// Facility user, severity informational
+	msg := fmt.Sprintf("<14>1 %s %s ssl_exporter - - - ssl_exporter test message", time.Now().UTC().Format(time.RFC3339Nano), hostname)
+	frame := fmt.Sprintf("%d %s", len(msg), msg)
+
+	level.Debug(logger).Log("msg", fmt.Sprintf("sending syslog message: %s", frame))
+
+	_, err = io.WriteString(conn, frame)
+
+	return err
+}
@@ -0,0 +1,716 @@
+package prober
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/ribbybibby/ssl_exporter/v2/config"
+	"github.com/ribbybibby/ssl_exporter/v2/test"
+)
+
+// TestProbeTCPProtocolLDAP tests an anonymous bind against a mock LDAPS
+// server
+func TestProbeTCPProtocolLDAP(t *testing.T) {
+	server, certPEM, _, caFile, teardown, err := test.SetupTCPServer()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer teardown()
+
+	server.StartLDAPS(0x00)
+	defer server.Close()
+
+	module := config.Module{
+		TCP: config.TCPProbe{
+			Protocol: "ldap",
+		},
+		TLSConfig: config.TLSConfig{
+			CAFile: caFile,
+		},
+	}
+
+	registry := prometheus.NewRegistry()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	cert, err := newCertificate(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCertificateMetrics(cert, registry, t)
+	checkProtocolCheckMetrics("ldap", 1, registry, t)
+}
+
+// TestProbeTCPProtocolLDAPBindFailed tests that a failed bind is reported by
+// the protocol check metric without failing the probe
+func TestProbeTCPProtocolLDAPBindFailed(t *testing.T) {
+	server, certPEM, _, caFile, teardown, err := test.SetupTCPServer()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer teardown()
+
+	// invalidCredentials
+	server.StartLDAPS(0x31)
+	defer server.Close()
+
+	module := config.Module{
+		TCP: config.TCPProbe{
+			Protocol: "ldap",
+		},
+		TLSConfig: config.TLSConfig{
+			CAFile: caFile,
+		},
+	}
+
+	registry := prometheus.NewRegistry()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	cert, err := newCertificate(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCertificateMetrics(cert, registry, t)
+	checkProtocolCheckMetrics("ldap", 0, registry, t)
+}
+
+// TestReadBERElement tests that elements longer than the limit aren't read
+func TestReadBERElement(t *testing.T) {
+	testcases := []struct {
+		name   string
+		data   []byte
+		length int
+		fail   bool
+	}{
+		{name: "short form", data: []byte{0x04, 0x02, 0x61, 0x62}, length: 2},
+		{name: "long form", data: append([]byte{0x04, 0x81, 0x80}, make([]byte, 0x80)...), length: 0x80},
+		{name: "oversized", data: []byte{0x04, 0x84, 0xff, 0xff, 0xff, 0xff}, fail: true},
+		{name: "truncated", data: []byte{0x04, 0x02, 0x61}, fail: true},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, content, err := readBERElement(bytes.NewReader(tc.data))
+			if tc.fail {
+				if err == nil {
+					t.Fatalf("expected error, but err was nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: %s", err)
+			}
+			if len(content) != tc.length {
+				t.Errorf("expected %d bytes of content, got %d", tc.length, len(content))
+			}
+		})
+	}
+}
+
+// TestProbeTCPProtocolMongoDB tests the hello command against a mock MongoDB
+// server
+func TestProbeTCPProtocolMongoDB(t *testing.T) {
+	server, certPEM, _, caFile, teardown, err := test.SetupTCPServer()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer teardown()
+
+	server.StartMongoDB()
+	defer server.Close()
+
+	module := config.Module{
+		TCP: config.TCPProbe{
+			Protocol: "mongodb",
+		},
+		TLSConfig: config.TLSConfig{
+			CAFile: caFile,
+		},
+	}
+
+	registry := prometheus.NewRegistry()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	cert, err := newCertificate(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCertificateMetrics(cert, registry, t)
+	checkProtocolCheckMetrics("mongodb", 1, registry, t)
+}
+
+// TestProbeTCPProtocolRedis tests AUTH and PING against a mock redis server
+func TestProbeTCPProtocolRedis(t *testing.T) {
+	server, certPEM, _, caFile, teardown, err := test.SetupTCPServer()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer teardown()
+
+	server.StartRedis("s3cret")
+	defer server.Close()
+
+	passwordFile, err := test.WriteFile("password", []byte("s3cret\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwordFile)
+
+	module := config.Module{
+		TCP: config.TCPProbe{
+			Protocol:     "redis",
+			PasswordFile: passwordFile,
+		},
+		TLSConfig: config.TLSConfig{
+			CAFile: caFile,
+		},
+	}
+
+	registry := prometheus.NewRegistry()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	cert, err := newCertificate(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCertificateMetrics(cert, registry, t)
+	checkProtocolCheckMetrics("redis", 1, registry, t)
+}
+
+// TestProbeTCPProtocolRedisNoAuth tests that the protocol check fails when
+// the redis server requires a password that isn't provided
+func TestProbeTCPProtocolRedisNoAuth(t *testing.T) {
+	server, _, _, caFile, teardown, err := test.SetupTCPServer()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer teardown()
+
+	server.StartRedis("s3cret")
+	defer server.Close()
+
+	module := config.Module{
+		TCP: config.TCPProbe{
+			Protocol: "redis",
+		},
+		TLSConfig: config.TLSConfig{
+			CAFile: caFile,
+		},
+	}
+
+	registry := prometheus.NewRegistry()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	checkProtocolCheckMetrics("redis", 0, registry, t)
+}
+
+// TestProbeTCPProtocolKafka tests an ApiVersions request against a mock kafka
+// broker
+func TestProbeTCPProtocolKafka(t *testing.T) {
+	server, certPEM, _, caFile, teardown, err := test.SetupTCPServer()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer teardown()
+
+	server.StartKafka()
+	defer server.Close()
+
+	module := config.Module{
+		TCP: config.TCPProbe{
+			Protocol: "kafka",
+		},
+		TLSConfig: config.TLSConfig{
+			CAFile: caFile,
+		},
+	}
+
+	registry := prometheus.NewRegistry()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	cert, err := newCertificate(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCertificateMetrics(cert, registry, t)
+	checkProtocolCheckMetrics("kafka", 1, registry, t)
+}
+
+// TestProbeTCPProtocolAMQP tests the protocol header exchange against a mock
+// AMQP server
+func TestProbeTCPProtocolAMQP(t *testing.T) {
+	server, certPEM, _, caFile, teardown, err := test.SetupTCPServer()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer teardown()
+
+	server.StartAMQP()
+	defer server.Close()
+
+	module := config.Module{
+		TCP: config.TCPProbe{
+			Protocol: "amqp",
+		},
+		TLSConfig: config.TLSConfig{
+			CAFile: caFile,
+		},
+	}
+
+	registry := prometheus.NewRegistry()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	cert, err := newCertificate(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCertificateMetrics(cert, registry, t)
+	checkProtocolCheckMetrics("amqp", 1, registry, t)
+}
+
+// TestProbeTCPProtocolMQTT tests CONNECT against a mock MQTT broker
+func TestProbeTCPProtocolMQTT(t *testing.T) {
+	server, certPEM, _, caFile, teardown, err := test.SetupTCPServer()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer teardown()
+
+	server.StartMQTT(0x00)
+	defer server.Close()
+
+	module := config.Module{
+		TCP: config.TCPProbe{
+			Protocol: "mqtt",
+		},
+		TLSConfig: config.TLSConfig{
+			CAFile: caFile,
+		},
+	}
+
+	registry := prometheus.NewRegistry()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	cert, err := newCertificate(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCertificateMetrics(cert, registry, t)
+	checkProtocolCheckMetrics("mqtt", 1, registry, t)
+}
+
+// TestProbeTCPProtocolMQTTRefused tests that the protocol check fails when the
+// MQTT broker refuses the connection
+func TestProbeTCPProtocolMQTTRefused(t *testing.T) {
+	server, _, _, caFile, teardown, err := test.SetupTCPServer()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer teardown()
+
+	// Not authorized
+	server.StartMQTT(0x05)
+	defer server.Close()
+
+	module := config.Module{
+		TCP: config.TCPProbe{
+			Protocol: "mqtt",
+		},
+		TLSConfig: config.TLSConfig{
+			CAFile: caFile,
+		},
+	}
+
+	registry := prometheus.NewRegistry()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	checkProtocolCheckMetrics("mqtt", 0, registry, t)
+}
+
+// TestProbeTCPProtocolZooKeeper tests the srvr command against a mock
+// ZooKeeper server
+func TestProbeTCPProtocolZooKeeper(t *testing.T) {
+	server, certPEM, _, caFile, teardown, err := test.SetupTCPServer()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer teardown()
+
+	server.StartZooKeeper()
+	defer server.Close()
+
+	module := config.Module{
+		TCP: config.TCPProbe{
+			Protocol: "zookeeper",
+		},
+		TLSConfig: config.TLSConfig{
+			CAFile: caFile,
+		},
+	}
+
+	registry := prometheus.NewRegistry()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	cert, err := newCertificate(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCertificateMetrics(cert, registry, t)
+	checkProtocolCheckMetrics("zookeeper", 1, registry, t)
+}
+
+// TestProbeTCPProtocolCassandra tests an OPTIONS request against a mock
+// Cassandra node
+func TestProbeTCPProtocolCassandra(t *testing.T) {
+	server, certPEM, _, caFile, teardown, err := test.SetupTCPServer()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer teardown()
+
+	server.StartCassandra()
+	defer server.Close()
+
+	module := config.Module{
+		TCP: config.TCPProbe{
+			Protocol: "cassandra",
+		},
+		TLSConfig: config.TLSConfig{
+			CAFile: caFile,
+		},
+	}
+
+	registry := prometheus.NewRegistry()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	cert, err := newCertificate(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCertificateMetrics(cert, registry, t)
+	checkProtocolCheckMetrics("cassandra", 1, registry, t)
+}
+
+// TestProbeTCPProtocolFTP tests reading the greeting from a mock implicit
+// FTPS server
+func TestProbeTCPProtocolFTP(t *testing.T) {
+	server, certPEM, _, caFile, teardown, err := test.SetupTCPServer()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer teardown()
+
+	server.StartFTPS()
+	defer server.Close()
+
+	module := config.Module{
+		TCP: config.TCPProbe{
+			Protocol: "ftp",
+		},
+		TLSConfig: config.TLSConfig{
+			CAFile: caFile,
+		},
+	}
+
+	registry := prometheus.NewRegistry()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	cert, err := newCertificate(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCertificateMetrics(cert, registry, t)
+	checkProtocolCheckMetrics("ftp", 1, registry, t)
+}
+
+// TestProbeTCPProtocolSIP tests an OPTIONS request against a mock SIP server
+func TestProbeTCPProtocolSIP(t *testing.T) {
+	server, certPEM, _, caFile, teardown, err := test.SetupTCPServer()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer teardown()
+
+	server.StartSIP()
+	defer server.Close()
+
+	module := config.Module{
+		TCP: config.TCPProbe{
+			Protocol: "sip",
+		},
+		TLSConfig: config.TLSConfig{
+			CAFile: caFile,
+		},
+	}
+
+	registry := prometheus.NewRegistry()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	cert, err := newCertificate(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCertificateMetrics(cert, registry, t)
+	checkProtocolCheckMetrics("sip", 1, registry, t)
+}
+
+// TestProbeTCPProtocolSMTP tests EHLO against a mock SMTPS server
+func TestProbeTCPProtocolSMTP(t *testing.T) {
+	for _, ehloCode := range []int{250, 421} {
+		server, certPEM, _, caFile, teardown, err := test.SetupTCPServer()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		defer teardown()
+
+		server.StartSMTPS(ehloCode)
+		defer server.Close()
+
+		module := config.Module{
+			TCP: config.TCPProbe{
+				Protocol: "smtp",
+			},
+			TLSConfig: config.TLSConfig{
+				CAFile: caFile,
+			},
+		}
+
+		registry := prometheus.NewRegistry()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+			t.Fatalf("error: %s", err)
+		}
+
+		cert, err := newCertificate(certPEM)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkCertificateMetrics(cert, registry, t)
+
+		var success float64
+		if ehloCode == 250 {
+			success = 1
+		}
+		checkProtocolCheckMetrics("smtp", success, registry, t)
+
+		mfs, err := registry.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkRegistryResults([]*registryResult{
+			&registryResult{
+				Name:  "ssl_smtp_ready",
+				Value: success,
+			},
+		}, mfs, t)
+	}
+}
+
+// TestProbeTCPProtocolSTUN tests a Binding request against a mock STUN server
+func TestProbeTCPProtocolSTUN(t *testing.T) {
+	server, certPEM, _, caFile, teardown, err := test.SetupTCPServer()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer teardown()
+
+	server.StartSTUN()
+	defer server.Close()
+
+	module := config.Module{
+		TCP: config.TCPProbe{
+			Protocol: "stun",
+		},
+		TLSConfig: config.TLSConfig{
+			CAFile: caFile,
+		},
+	}
+
+	registry := prometheus.NewRegistry()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	cert, err := newCertificate(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCertificateMetrics(cert, registry, t)
+	checkProtocolCheckMetrics("stun", 1, registry, t)
+}
+
+// TestSTUNAttributes tests that truncated and oversized attributes in a STUN
+// response are rejected rather than read past
+func TestSTUNAttributes(t *testing.T) {
+	transactionID := make([]byte, stunTransactionIDBytes)
+
+	testcases := []struct {
+		name    string
+		attrs   []byte
+		address string
+	}{
+		{
+			name:    "ipv4",
+			attrs:   []byte{0x00, 0x20, 0x00, 0x08, 0x00, 0x01, 0x21, 0x13, 0x5e, 0x12, 0xa4, 0x43},
+			address: "127.0.0.1:1",
+		},
+		{
+			name:  "truncated value",
+			attrs: []byte{0x00, 0x20, 0x00, 0x08, 0x00, 0x01, 0x21, 0x13},
+		},
+		{
+			name:  "unpadded last attribute",
+			attrs: []byte{0x80, 0x22, 0x00, 0x01, 0x61},
+		},
+		{
+			name:  "unpadded attribute before a truncated header",
+			attrs: []byte{0x80, 0x22, 0x00, 0x01, 0x61, 0x00, 0x20, 0x00},
+		},
+		{
+			name:  "oversized address",
+			attrs: append([]byte{0x00, 0x20, 0x00, 0x18, 0x00, 0x02, 0x21, 0x12}, make([]byte, 20)...),
+		},
+		{
+			name:  "short ipv6 address",
+			attrs: append([]byte{0x00, 0x20, 0x00, 0x0c, 0x00, 0x02, 0x21, 0x12}, make([]byte, 8)...),
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			var address string
+			if value := stunAttribute(tc.attrs, stunAttrXORMappedAddr); value != nil {
+				address = stunXORAddress(value, transactionID)
+			}
+			if address != tc.address {
+				t.Errorf("expected address %q, got %q", tc.address, address)
+			}
+		})
+	}
+}
+
+// TestProbeTCPProtocolSyslog tests writing a message to a mock syslog
+// collector
+func TestProbeTCPProtocolSyslog(t *testing.T) {
+	server, certPEM, _, caFile, teardown, err := test.SetupTCPServer()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer teardown()
+
+	server.StartSyslog()
+	defer server.Close()
+
+	module := config.Module{
+		TCP: config.TCPProbe{
+			Protocol: "syslog",
+		},
+		TLSConfig: config.TLSConfig{
+			CAFile: caFile,
+		},
+	}
+
+	registry := prometheus.NewRegistry()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	cert, err := newCertificate(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCertificateMetrics(cert, registry, t)
+	checkProtocolCheckMetrics("syslog", 1, registry, t)
+}
@@ -0,0 +1,68 @@
+package prober
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/quic-go/quic-go"
+	"github.com/ribbybibby/ssl_exporter/v2/config"
+)
+
+// quicDefaultNextProtos are offered when the module doesn't configure any
+// protocols, as QUIC requires ALPN
+var quicDefaultNextProtos = []string{"h3"}
+
+// ProbeQUIC performs a quic probe
+func ProbeQUIC(ctx context.Context, logger log.Logger, target string, module config.Module, registry *prometheus.Registry) error {
+	tlsConfig, _, err := newTLSConfig(ctx, target, &module.TLSConfig, module.ClockSkewTolerance, registry)
+	if err != nil {
+		return err
+	}
+	if len(tlsConfig.NextProtos) == 0 {
+		tlsConfig.NextProtos = quicDefaultNextProtos
+	}
+
+	udpConn, addr, err := listenUDP(ctx, target, newDialOptions(module))
+	if err != nil {
+		return err
+	}
+	defer udpConn.Close()
+
+	// The TLS handshake is part of establishing a QUIC connection
+	handshakeStart := time.Now()
+	conn, err := quic.Dial(ctx, udpConn, addr, tlsConfig, &quic.Config{
+		Versions: []quic.Version{quic.Version1, quic.Version2},
+	})
+	collectTLSHandshakeMetrics(time.Since(handshakeStart), registry)
+	if err != nil {
+		return err
+	}
+	defer conn.CloseWithError(0, "")
+
+	state := conn.ConnectionState().TLS
+	collectCurveMetrics(state, registry)
+	if err := collectPolicyMetrics(module.Policy, []uint16{state.Version}, []uint16{state.CipherSuite}, state.PeerCertificates, registry); err != nil {
+		return err
+	}
+
+	return collectQUICVersionMetrics(conn.ConnectionState().Version, registry)
+}
+
+func collectQUICVersionMetrics(version quic.Version, registry *prometheus.Registry) error {
+	var (
+		quicVersion = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "quic_version_info"),
+				Help: "The QUIC version used",
+			},
+			[]string{"version"},
+		)
+	)
+	registry.MustRegister(quicVersion)
+
+	quicVersion.WithLabelValues(version.String()).Set(1)
+
+	return nil
+}
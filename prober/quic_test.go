@@ -0,0 +1,102 @@
+package prober
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/ribbybibby/ssl_exporter/v2/config"
+	"github.com/ribbybibby/ssl_exporter/v2/test"
+)
+
+// TestProbeQUIC tests the typical case
+func TestProbeQUIC(t *testing.T) {
+	server, certPEM, _, caFile, teardown, err := test.SetupQUICServer()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer teardown()
+
+	server.Start()
+	defer server.Close()
+
+	module := config.Module{
+		TLSConfig: config.TLSConfig{
+			CAFile: caFile,
+		},
+	}
+
+	registry := prometheus.NewRegistry()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeQUIC(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	cert, err := newCertificate(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCertificateMetrics(cert, registry, t)
+	checkOCSPMetrics([]byte{}, registry, t)
+	checkTLSVersionMetrics("TLS 1.3", registry, t)
+	checkALPNMetrics("h3", registry, t)
+	checkTLSHandshakeMetrics(registry, t)
+	checkQUICVersionMetrics("v1", registry, t)
+}
+
+// TestProbeQUICTimeout tests that the quic probe respects the timeout in the
+// context
+func TestProbeQUICTimeout(t *testing.T) {
+	server, _, _, caFile, teardown, err := test.SetupQUICServer()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer teardown()
+
+	module := config.Module{
+		TLSConfig: config.TLSConfig{
+			CAFile: caFile,
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	// Nothing responds to the client once the listener is closed
+	server.Listener.Close()
+
+	if err := ProbeQUIC(ctx, newTestLogger(), server.Listener.Addr().String(), module, prometheus.NewRegistry()); err == nil {
+		t.Fatalf("Expected error but returned error was nil")
+	}
+}
+
+// TestProbeQUICSourceIPAddress tests that the quic probe connects from the
+// source IP address
+func TestProbeQUICSourceIPAddress(t *testing.T) {
+	server, _, _, caFile, teardown, err := test.SetupQUICServer()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer teardown()
+
+	server.Start()
+	defer server.Close()
+
+	module := config.Module{
+		TLSConfig: config.TLSConfig{
+			CAFile: caFile,
+		},
+		SourceIPAddress: "127.0.0.1",
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeQUIC(ctx, newTestLogger(), server.Listener.Addr().String(), module, prometheus.NewRegistry()); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+}
@@ -0,0 +1,463 @@
+package prober
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ribbybibby/ssl_exporter/v2/config"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// resolvConf is the file that the nameservers of the system resolver are read
+// from
+var resolvConf = "/etc/resolv.conf"
+
+// newResolver returns a resolver that sends queries to the DNS server at the
+// address, or nil when the address is empty. The address is either host:port
+// for plain DNS, where the port defaults to 53, tls://host:port for DNS over
+// TLS, where the port defaults to 853, or a https:// URL for DNS over HTTPS.
+func newResolver(address string, cfg *config.TLSConfig) (*net.Resolver, error) {
+	if address == "" {
+		return nil, nil
+	}
+
+	u, err := url.Parse(address)
+	if err != nil || u.Host == "" {
+		u = &url.URL{Scheme: "udp", Host: address}
+	}
+
+	var dial func(ctx context.Context, network, address string) (net.Conn, error)
+	switch u.Scheme {
+	case "udp", "tcp":
+		host := withDefaultPort(u.Host, "53")
+		dial = func(ctx context.Context, network, _ string) (net.Conn, error) {
+			if u.Scheme == "tcp" {
+				network = "tcp"
+			}
+			dialer := &net.Dialer{}
+			return dialer.DialContext(ctx, network, host)
+		}
+	case "tls":
+		host := withDefaultPort(u.Host, "853")
+		tlsConfig, err := newResolverTLSConfig(host, cfg)
+		if err != nil {
+			return nil, err
+		}
+		// The resolver uses TCP framing for connections that aren't
+		// packet oriented, which is also the framing of DNS over TLS
+		dial = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			dialer := &tls.Dialer{Config: tlsConfig}
+			return dialer.DialContext(ctx, "tcp", host)
+		}
+	case "https":
+		tlsConfig, err := newResolverTLSConfig(withDefaultPort(u.Host, "443"), cfg)
+		if err != nil {
+			return nil, err
+		}
+		// The resolver is created for each dial, so its connections
+		// aren't kept open after the queries, where they'd never be
+		// reused
+		client := &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig:   tlsConfig,
+				ForceAttemptHTTP2: true,
+				DisableKeepAlives: true,
+			},
+		}
+		dial = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return &dohConn{ctx: ctx, client: client, url: u.String()}, nil
+		}
+	default:
+		return nil, fmt.Errorf("unsupported resolver scheme %s", u.Scheme)
+	}
+
+	return &net.Resolver{
+		PreferGo: true,
+		Dial:     dial,
+	}, nil
+}
+
+// newResolverTLSConfig returns the TLS configuration for DNS over TLS and
+// HTTPS resolvers. The server name defaults to the resolver host.
+func newResolverTLSConfig(address string, cfg *config.TLSConfig) (*tls.Config, error) {
+	tlsConfig, err := config.NewTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig.ServerName == "" {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.ServerName = host
+	}
+
+	return tlsConfig, nil
+}
+
+func withDefaultPort(host, port string) string {
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		return net.JoinHostPort(host, port)
+	}
+
+	return host
+}
+
+// dohConn sends the DNS messages written to it, which are framed as they are
+// over TCP, to a DNS over HTTPS server and returns the responses with the same
+// framing
+type dohConn struct {
+	ctx    context.Context
+	client *http.Client
+	url    string
+
+	mu       sync.Mutex
+	query    bytes.Buffer
+	response bytes.Buffer
+}
+
+func (c *dohConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.query.Write(b)
+	for c.query.Len() >= 2 {
+		length := int(binary.BigEndian.Uint16(c.query.Bytes()[:2]))
+		if c.query.Len() < 2+length {
+			break
+		}
+		message := make([]byte, length)
+		copy(message, c.query.Bytes()[2:2+length])
+		c.query.Next(2 + length)
+
+		response, err := c.roundTrip(message)
+		if err != nil {
+			return 0, err
+		}
+		binary.Write(&c.response, binary.BigEndian, uint16(len(response)))
+		c.response.Write(response)
+	}
+
+	return len(b), nil
+}
+
+// roundTrip sends a single DNS message with the POST method, as described in
+// RFC 8484
+func (c *dohConn) roundTrip(message []byte) ([]byte, error) {
+	request, err := http.NewRequestWithContext(c.ctx, http.MethodPost, c.url, bytes.NewReader(message))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/dns-message")
+	request.Header.Set("Accept", "application/dns-message")
+	request.Header.Set("User-Agent", userAgent)
+
+	resp, err := c.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected DNS over HTTPS response: %s", resp.Status)
+	}
+
+	return io.ReadAll(io.LimitReader(resp.Body, 65535))
+}
+
+func (c *dohConn) Read(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.response.Len() == 0 {
+		return 0, io.EOF
+	}
+
+	return c.response.Read(b)
+}
+
+func (c *dohConn) Close() error                       { return nil }
+func (c *dohConn) LocalAddr() net.Addr                { return &net.TCPAddr{} }
+func (c *dohConn) RemoteAddr() net.Addr               { return &net.TCPAddr{} }
+func (c *dohConn) SetDeadline(t time.Time) error      { return nil }
+func (c *dohConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *dohConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// lookupIPAddr resolves the host and records the lookup in the trace
+func lookupIPAddr(ctx context.Context, resolver *net.Resolver, host string, opts dialOptions) ([]net.IPAddr, error) {
+	name := opts.resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+		name = "system"
+	}
+
+	var (
+		ips           []net.IPAddr
+		authenticated bool
+		err           error
+	)
+	start := time.Now()
+	if opts.dnssec {
+		ips, authenticated, err = lookupIPAddrDNSSEC(ctx, host, opts)
+	} else {
+		ips, err = resolver.LookupIPAddr(ctx, host)
+	}
+	if opts.trace != nil {
+		opts.trace.resolved = true
+		opts.trace.resolver = name
+		opts.trace.dnsLookupTime = time.Since(start)
+		opts.trace.dnssec = opts.dnssec && err == nil
+		opts.trace.dnssecValid = authenticated
+	}
+	if err != nil {
+		return nil, &lookupError{err: err}
+	}
+	if opts.dnssec && !authenticated {
+		return nil, &lookupError{err: fmt.Errorf("the DNS records of %s aren't authenticated with DNSSEC", host)}
+	}
+	if len(ips) == 0 {
+		return nil, &lookupError{err: fmt.Errorf("no addresses found for %s", host)}
+	}
+
+	return ips, nil
+}
+
+// lookupError is an error resolving the addresses of a host
+type lookupError struct {
+	err error
+}
+
+func (e *lookupError) Error() string {
+	return e.err.Error()
+}
+
+func (e *lookupError) Unwrap() error {
+	return e.err
+}
+
+// lookupIPAddrDNSSEC resolves the host with queries for its A and AAAA records
+// and reports whether the resolver authenticated both responses with DNSSEC.
+// The resolver is trusted to validate the records, so it should be local or
+// be reached over an encrypted connection.
+func lookupIPAddrDNSSEC(ctx context.Context, host string, opts dialOptions) ([]net.IPAddr, bool, error) {
+	var ips []net.IPAddr
+	authenticated := true
+	for _, qtype := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+		resp, err := queryDNS(ctx, opts, host, qtype)
+		if err != nil {
+			return nil, false, err
+		}
+		authenticated = authenticated && resp.AuthenticData
+
+		for _, answer := range resp.Answers {
+			switch body := answer.Body.(type) {
+			case *dnsmessage.AResource:
+				ips = append(ips, net.IPAddr{IP: net.IP(body.A[:])})
+			case *dnsmessage.AAAAResource:
+				ips = append(ips, net.IPAddr{IP: net.IP(body.AAAA[:])})
+			}
+		}
+	}
+
+	return ips, authenticated, nil
+}
+
+func validateIPProtocol(preferred string) error {
+	switch preferred {
+	case "", "ip4", "ip6":
+		return nil
+	default:
+		return fmt.Errorf("unsupported preferred IP protocol %s", preferred)
+	}
+}
+
+// filterIPProtocol returns the addresses of the preferred IP protocol. The
+// addresses of the other protocol are returned when there aren't any, if
+// fallback is allowed. Without a preference, every address is returned.
+func filterIPProtocol(host string, ips []net.IPAddr, preferred string, fallback bool) ([]net.IPAddr, error) {
+	if preferred == "" {
+		return ips, nil
+	}
+
+	var matched, others []net.IPAddr
+	for _, ip := range ips {
+		if (ip.IP.To4() != nil) == (preferred == "ip4") {
+			matched = append(matched, ip)
+		} else {
+			others = append(others, ip)
+		}
+	}
+	if len(matched) > 0 {
+		return matched, nil
+	}
+	if fallback && len(others) > 0 {
+		return others, nil
+	}
+
+	return nil, fmt.Errorf("%s doesn't have any %s addresses", host, preferred)
+}
+
+// queryDNS sends a query for the records of the given type to the resolver in
+// the options, or the first nameserver of the system resolver, and returns the
+// response. This is for the record types that net.Resolver can't look up, and
+// for lookups that need to know if the response was authenticated, which is
+// reported by validating resolvers because the DNSSEC OK bit is set. A name
+// that doesn't exist returns a response without any answers.
+func queryDNS(ctx context.Context, opts dialOptions, name string, qtype dnsmessage.Type) (*dnsmessage.Message, error) {
+	address := opts.resolver
+	if address == "" {
+		nameserver, err := systemNameserver()
+		if err != nil {
+			return nil, err
+		}
+		address = nameserver
+	}
+
+	resolver, err := newResolver(address, &opts.resolverTLSConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasSuffix(name, ".") {
+		name = name + "."
+	}
+	qname, err := dnsmessage.NewName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var opt dnsmessage.ResourceHeader
+	if err := opt.SetEDNS0(1232, dnsmessage.RCodeSuccess, true); err != nil {
+		return nil, err
+	}
+
+	query := dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID:               uint16(rand.Uint32()),
+			RecursionDesired: true,
+		},
+		Questions: []dnsmessage.Question{
+			{
+				Name:  qname,
+				Type:  qtype,
+				Class: dnsmessage.ClassINET,
+			},
+		},
+		Additionals: []dnsmessage.Resource{
+			{
+				Header: opt,
+				Body:   &dnsmessage.OPTResource{},
+			},
+		},
+	}
+
+	// Truncated UDP responses are retried over TCP
+	var resp *dnsmessage.Message
+	for _, network := range []string{"udp", "tcp"} {
+		var truncated bool
+		resp, truncated, err = exchangeDNS(ctx, resolver, network, query)
+		if err != nil {
+			return nil, err
+		}
+		if !truncated {
+			break
+		}
+	}
+
+	switch resp.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		resp.Answers = nil
+	default:
+		return nil, fmt.Errorf("DNS query for %s failed: %s", name, resp.RCode)
+	}
+
+	return resp, nil
+}
+
+// exchangeDNS sends the query over a connection from the resolver and reads
+// the response. Messages are framed as they are over TCP, unless the
+// connection is packet oriented.
+func exchangeDNS(ctx context.Context, resolver *net.Resolver, network string, query dnsmessage.Message) (*dnsmessage.Message, bool, error) {
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, false, err
+	}
+
+	conn, err := resolver.Dial(ctx, network, "")
+	if err != nil {
+		return nil, false, err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return nil, false, err
+		}
+	}
+
+	var data []byte
+	if _, ok := conn.(net.PacketConn); ok {
+		if _, err := conn.Write(packed); err != nil {
+			return nil, false, err
+		}
+		data = make([]byte, 65535)
+		n, err := conn.Read(data)
+		if err != nil {
+			return nil, false, err
+		}
+		data = data[:n]
+	} else {
+		framed := binary.BigEndian.AppendUint16(nil, uint16(len(packed)))
+		if _, err := conn.Write(append(framed, packed...)); err != nil {
+			return nil, false, err
+		}
+		length := make([]byte, 2)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return nil, false, err
+		}
+		data = make([]byte, binary.BigEndian.Uint16(length))
+		if _, err := io.ReadFull(conn, data); err != nil {
+			return nil, false, err
+		}
+	}
+
+	var resp dnsmessage.Message
+	if err := resp.Unpack(data); err != nil {
+		return nil, false, err
+	}
+	if resp.ID != query.ID {
+		return nil, false, fmt.Errorf("DNS response ID %d doesn't match the query ID %d", resp.ID, query.ID)
+	}
+
+	return &resp, resp.Truncated, nil
+}
+
+// systemNameserver returns the first nameserver in resolv.conf
+func systemNameserver() (string, error) {
+	data, err := os.ReadFile(resolvConf)
+	if err != nil {
+		return "", fmt.Errorf("can't find a nameserver for the system resolver, set the resolver module option: %s", err)
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			return net.JoinHostPort(fields[1], "53"), nil
+		}
+	}
+
+	return "", fmt.Errorf("there aren't any nameservers in %s", resolvConf)
+}
@@ -0,0 +1,142 @@
+package prober
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/ribbybibby/ssl_exporter/v2/config"
+)
+
+// sessionTicketWait is how long to wait for the server to send a TLS 1.3
+// session ticket after the handshake
+var sessionTicketWait = time.Second
+
+// sessionCache is a client session cache that signals when a session is
+// stored
+type sessionCache struct {
+	tls.ClientSessionCache
+	stored chan struct{}
+}
+
+func newSessionCache() *sessionCache {
+	return &sessionCache{
+		ClientSessionCache: tls.NewLRUClientSessionCache(1),
+		stored:             make(chan struct{}, 1),
+	}
+}
+
+func (c *sessionCache) Put(sessionKey string, cs *tls.ClientSessionState) {
+	c.ClientSessionCache.Put(sessionKey, cs)
+	if cs != nil {
+		select {
+		case c.stored <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// collectSessionResumptionMetrics makes two connections to the address and
+// checks whether the second handshake resumes the session of the first. A
+// failed check doesn't fail the probe.
+func collectSessionResumptionMetrics(ctx context.Context, logger log.Logger, network, address string, tlsConfig *tls.Config, module config.Module, opts dialOptions, registry *prometheus.Registry) {
+	var (
+		resumptionSupported = prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "session_resumption_supported"),
+				Help: "If the target resumes a session from an earlier connection",
+			},
+		)
+	)
+	registry.MustRegister(resumptionSupported)
+
+	cache := newSessionCache()
+
+	cfg := tlsConfig.Clone()
+	cfg.ClientSessionCache = cache
+	// The certificates have already been collected from the probe's own
+	// connection, but the chain is still verified when it's verified with
+	// AIA or allowing for clock skew, which disables the built in
+	// verification
+	cfg.VerifyConnection = nil
+	if cfg.ServerName == "" && network != "unix" {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			level.Error(logger).Log("msg", fmt.Sprintf("Error checking session resumption: %s", err))
+			return
+		}
+		cfg.ServerName, err = toASCIIHost(host)
+		if err != nil {
+			level.Error(logger).Log("msg", fmt.Sprintf("Error checking session resumption: %s", err))
+			return
+		}
+	}
+	if !module.TLSConfig.InsecureSkipVerify {
+		switch {
+		case module.TLSConfig.AIAFetch:
+			cfg.VerifyConnection = func(state tls.ConnectionState) error {
+				_, _, err := verifyChainWithAIA(ctx, state.PeerCertificates, cfg.RootCAs, cfg.ServerName, module.ClockSkewTolerance)
+				return err
+			}
+		case module.ClockSkewTolerance > 0:
+			cfg.VerifyConnection = func(state tls.ConnectionState) error {
+				_, err := verifyChain(state.PeerCertificates, cfg.RootCAs, cfg.ServerName, module.ClockSkewTolerance)
+				return err
+			}
+		}
+	}
+
+	tlsConn, err := resumptionHandshake(ctx, network, address, cfg, module, opts)
+	if err != nil {
+		level.Error(logger).Log("msg", fmt.Sprintf("Error checking session resumption: %s", err))
+		return
+	}
+	// TLS 1.3 session tickets are sent after the handshake and are only
+	// processed by reading from the connection
+	go tlsConn.Read(make([]byte, 1))
+	select {
+	case <-cache.stored:
+	case <-time.After(sessionTicketWait):
+	case <-ctx.Done():
+	}
+	tlsConn.Close()
+
+	tlsConn, err = resumptionHandshake(ctx, network, address, cfg, module, opts)
+	if err != nil {
+		level.Error(logger).Log("msg", fmt.Sprintf("Error checking session resumption: %s", err))
+		return
+	}
+	defer tlsConn.Close()
+
+	if tlsConn.ConnectionState().DidResume {
+		resumptionSupported.Set(1)
+	}
+}
+
+// resumptionHandshake dials the address and performs a handshake
+func resumptionHandshake(ctx context.Context, network, address string, tlsConfig *tls.Config, module config.Module, opts dialOptions) (*tls.Conn, error) {
+	connect := newPhase(ctx, "connect", module.Timeouts.Connect)
+	dialCtx, cancel := connect.context(ctx)
+	conn, err := dialContext(dialCtx, network, address, opts)
+	cancel()
+	if err != nil {
+		return nil, connect.err(err)
+	}
+
+	handshake := newPhase(ctx, "handshake", module.Timeouts.Handshake)
+	handshakeCtx, cancel := handshake.context(ctx)
+	defer cancel()
+
+	tlsConn := tls.Client(conn, tlsConfig)
+	if err := tlsConn.HandshakeContext(handshakeCtx); err != nil {
+		conn.Close()
+		return nil, handshake.err(err)
+	}
+
+	return tlsConn, nil
+}
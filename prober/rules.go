@@ -0,0 +1,88 @@
+package prober
+
+import (
+	"crypto/x509"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/ribbybibby/ssl_exporter/v2/config"
+)
+
+// rulesViolations evaluates the expressions of the rules against the
+// certificates. A rule is violated when its expression is false.
+func rulesViolations(rules []config.PolicyRule, certs []*x509.Certificate) (map[string]bool, error) {
+	violations := map[string]bool{}
+
+	chain := make([]map[string]interface{}, 0, len(certs))
+	for _, cert := range certs {
+		chain = append(chain, ruleCertificate(cert))
+	}
+	vars := map[string]interface{}{
+		"cert":  chain[0],
+		"chain": chain,
+		"now":   time.Now(),
+	}
+
+	for _, rule := range rules {
+		// The rules that aren't loaded from the config are compiled
+		// here
+		prg := rule.Program
+		if prg == nil {
+			var err error
+			prg, err = config.CompilePolicyRule(rule.Expression)
+			if err != nil {
+				return nil, fmt.Errorf("error compiling policy rule %s: %s", rule.Name, err)
+			}
+		}
+		out, _, err := prg.Eval(vars)
+		if err != nil {
+			return nil, fmt.Errorf("error evaluating policy rule %s: %s", rule.Name, err)
+		}
+		passed, ok := out.Value().(bool)
+		if !ok {
+			return nil, fmt.Errorf("policy rule %s returned %s rather than a bool", rule.Name, out.Type().TypeName())
+		}
+		violations[rule.Name] = !passed
+	}
+
+	return violations, nil
+}
+
+// ruleCertificate returns the fields of the certificate that policy rules can
+// refer to
+func ruleCertificate(cert *x509.Certificate) map[string]interface{} {
+	key := keyLabelValues(cert)
+	bits, _ := strconv.Atoi(key[1])
+
+	ips := []string{}
+	for _, ip := range cert.IPAddresses {
+		ips = append(ips, ip.String())
+	}
+	uris := []string{}
+	for _, uri := range cert.URIs {
+		uris = append(uris, uri.String())
+	}
+
+	return map[string]interface{}{
+		"subject":             cert.Subject.String(),
+		"common_name":         cert.Subject.CommonName,
+		"issuer":              cert.Issuer.String(),
+		"issuer_common_name":  cert.Issuer.CommonName,
+		"serial":              cert.SerialNumber.String(),
+		"dns_names":           append([]string{}, cert.DNSNames...),
+		"ip_addresses":        ips,
+		"email_addresses":     append([]string{}, cert.EmailAddresses...),
+		"uris":                uris,
+		"not_before":          cert.NotBefore,
+		"not_after":           cert.NotAfter,
+		"lifetime":            cert.NotAfter.Sub(cert.NotBefore),
+		"key_algorithm":       key[0],
+		"key_bits":            bits,
+		"curve":               key[2],
+		"signature_algorithm": cert.SignatureAlgorithm.String(),
+		"key_usages":          append([]string{}, keyUsageNames(cert.KeyUsage)...),
+		"ext_key_usages":      append([]string{}, extKeyUsageNames(cert)...),
+		"is_ca":               cert.IsCA,
+	}
+}
@@ -0,0 +1,147 @@
+package prober
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/ribbybibby/ssl_exporter/v2/config"
+)
+
+// scanVersions are the TLS versions that the scan prober attempts a handshake
+// with
+var scanVersions = []uint16{
+	tls.VersionTLS10,
+	tls.VersionTLS11,
+	tls.VersionTLS12,
+	tls.VersionTLS13,
+}
+
+// ProbeScan performs a scan probe, which attempts a handshake with each TLS
+// version and cipher suite to find the ones that the target supports
+func ProbeScan(ctx context.Context, logger log.Logger, target string, module config.Module, registry *prometheus.Registry) error {
+	tlsConfig, _, err := newTLSConfig(ctx, target, &module.TLSConfig, module.ClockSkewTolerance, registry)
+	if err != nil {
+		return err
+	}
+	// The scan reports what the target supports, regardless of whether its
+	// certificates are valid, so they aren't verified or collected
+	tlsConfig.InsecureSkipVerify = true
+	tlsConfig.VerifyConnection = nil
+
+	opts := newDialOptions(module)
+
+	var (
+		versionSupported = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "tls_version_supported"),
+				Help: "Does the target support the TLS version",
+			},
+			[]string{"version"},
+		)
+		cipherSuiteSupported = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "cipher_suite_supported"),
+				Help: "Does the target support the cipher suite",
+			},
+			[]string{"cipher"},
+		)
+	)
+	registry.MustRegister(versionSupported, cipherSuiteSupported)
+
+	// Every cipher suite is offered when the versions are tested, so that
+	// servers which only support legacy suites are found
+	cipherSuites := scanCipherSuites()
+
+	var supportedVersions, supportedCipherSuites []uint16
+	for _, version := range scanVersions {
+		cfg := tlsConfig.Clone()
+		cfg.MinVersion = version
+		cfg.MaxVersion = version
+		cfg.CipherSuites = cipherSuites
+
+		supported, err := scanHandshake(ctx, logger, target, cfg, module, opts)
+		if err != nil {
+			return err
+		}
+		versionSupported.WithLabelValues(tlsVersionName(version)).Set(0)
+		if supported {
+			versionSupported.WithLabelValues(tlsVersionName(version)).Set(1)
+			supportedVersions = append(supportedVersions, version)
+		}
+	}
+
+	if len(module.Scan.CipherSuites) > 0 {
+		cipherSuites = module.Scan.CipherSuites
+	}
+
+	for _, id := range cipherSuites {
+		cfg := tlsConfig.Clone()
+		cfg.MinVersion = tls.VersionTLS10
+		cfg.MaxVersion = tls.VersionTLS12
+		cfg.CipherSuites = []uint16{id}
+
+		supported, err := scanHandshake(ctx, logger, target, cfg, module, opts)
+		if err != nil {
+			return err
+		}
+		cipherSuiteSupported.WithLabelValues(tls.CipherSuiteName(id)).Set(0)
+		if supported {
+			cipherSuiteSupported.WithLabelValues(tls.CipherSuiteName(id)).Set(1)
+			supportedCipherSuites = append(supportedCipherSuites, id)
+		}
+	}
+
+	if err := collectPolicyMetrics(module.Policy, supportedVersions, supportedCipherSuites, nil, registry); err != nil {
+		return err
+	}
+
+	return collectVulnerabilityMetrics(ctx, target, tlsConfig.ServerName, supportedVersions, module, opts, registry)
+}
+
+// scanCipherSuites returns all of the TLS 1.0-1.2 cipher suites implemented by
+// crypto/tls
+func scanCipherSuites() []uint16 {
+	var ids []uint16
+	for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		for _, version := range suite.SupportedVersions {
+			if version != tls.VersionTLS13 {
+				ids = append(ids, suite.ID)
+				break
+			}
+		}
+	}
+
+	return ids
+}
+
+// scanHandshake reports whether a handshake with the given config succeeds. An
+// error is only returned when the target can't be reached.
+func scanHandshake(ctx context.Context, logger log.Logger, target string, tlsConfig *tls.Config, module config.Module, opts dialOptions) (bool, error) {
+	connect := newPhase(ctx, "connect", module.Timeouts.Connect)
+	dialCtx, cancel := connect.context(ctx)
+	conn, err := dialContext(dialCtx, "tcp", target, opts)
+	cancel()
+	if err != nil {
+		return false, connect.err(err)
+	}
+	defer conn.Close()
+
+	handshake := newPhase(ctx, "handshake", module.Timeouts.Handshake)
+	handshakeCtx, cancel := handshake.context(ctx)
+	defer cancel()
+
+	tlsConn := tls.Client(conn, tlsConfig)
+	if err := tlsConn.HandshakeContext(handshakeCtx); err != nil {
+		if ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+		level.Debug(logger).Log("msg", fmt.Sprintf("handshake with %s failed: %s", target, err))
+		return false, nil
+	}
+
+	return true, nil
+}
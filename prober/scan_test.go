@@ -0,0 +1,231 @@
+package prober
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"log"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/ribbybibby/ssl_exporter/v2/config"
+	"github.com/ribbybibby/ssl_exporter/v2/test"
+)
+
+// TestProbeScan tests that the supported versions and cipher suites are
+// reported
+func TestProbeScan(t *testing.T) {
+	server, _, _, _, teardown, err := test.SetupHTTPSServer()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer teardown()
+
+	server.TLS.MinVersion = tls.VersionTLS12
+	server.TLS.MaxVersion = tls.VersionTLS12
+	server.TLS.CipherSuites = []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+	defer server.Close()
+
+	module := config.Module{
+		Scan: config.ScanProbe{
+			CipherSuites: []uint16{
+				tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+				tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			},
+		},
+		Policy: config.Policy{
+			MinVersion: tls.VersionTLS12,
+			DenyCBC:    true,
+		},
+	}
+	module.Scan.VulnerabilityChecks.Compression = true
+
+	registry := prometheus.NewRegistry()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeScan(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedResults := []*registryResult{
+		&registryResult{
+			Name:        "ssl_tls_version_supported",
+			LabelValues: map[string]string{"version": "TLS 1.0"},
+			Value:       0,
+		},
+		&registryResult{
+			Name:        "ssl_tls_version_supported",
+			LabelValues: map[string]string{"version": "TLS 1.1"},
+			Value:       0,
+		},
+		&registryResult{
+			Name:        "ssl_tls_version_supported",
+			LabelValues: map[string]string{"version": "TLS 1.2"},
+			Value:       1,
+		},
+		&registryResult{
+			Name:        "ssl_tls_version_supported",
+			LabelValues: map[string]string{"version": "TLS 1.3"},
+			Value:       0,
+		},
+		&registryResult{
+			Name:        "ssl_cipher_suite_supported",
+			LabelValues: map[string]string{"cipher": "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"},
+			Value:       1,
+		},
+		&registryResult{
+			Name:        "ssl_cipher_suite_supported",
+			LabelValues: map[string]string{"cipher": "TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"},
+			Value:       0,
+		},
+	}
+	checkRegistryResults(expectedResults, mfs, t)
+	checkPolicyMetrics(map[string]bool{
+		"min_version": false,
+		"cbc":         false,
+	}, registry, t)
+	checkCompressionMetrics(0, registry, t)
+}
+
+// TestProbeScanCompression tests that a target which accepts TLS compression
+// is reported
+func TestProbeScanCompression(t *testing.T) {
+	testcases := []struct {
+		name     string
+		accept   bool
+		accepted float64
+	}{
+		{name: "accepted", accept: true, accepted: 1},
+		{name: "rejected", accept: false, accepted: 0},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			server, err := test.SetupHelloServer(func(hello test.ClientHello) []byte {
+				var compressionMethod uint8
+				for _, method := range hello.CompressionMethods {
+					if tc.accept && method == 1 {
+						compressionMethod = method
+					}
+				}
+				return test.ServerHelloRecord(tls.VersionTLS12, tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, compressionMethod)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer server.Close()
+
+			module := config.Module{
+				Scan: config.ScanProbe{
+					CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+					VulnerabilityChecks: config.VulnerabilityChecks{
+						Compression: true,
+					},
+				},
+			}
+
+			registry := prometheus.NewRegistry()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			if err := ProbeScan(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+				t.Fatalf("error: %s", err)
+			}
+
+			checkCompressionMetrics(tc.accepted, registry, t)
+		})
+	}
+}
+
+// TestProbeScanFallbackSCSV tests that a target which rejects a downgraded
+// handshake with TLS_FALLBACK_SCSV is reported
+func TestProbeScanFallbackSCSV(t *testing.T) {
+	testcases := []struct {
+		name       string
+		minVersion uint16
+		reported   bool
+	}{
+		{name: "tls12 and tls13", minVersion: tls.VersionTLS12, reported: true},
+		{name: "tls13", minVersion: tls.VersionTLS13, reported: false},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			server, _, _, _, teardown, err := test.SetupHTTPSServer()
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+			defer teardown()
+
+			server.TLS.MinVersion = tc.minVersion
+			server.TLS.MaxVersion = tls.VersionTLS13
+			server.Config.ErrorLog = log.New(io.Discard, "", 0)
+			server.StartTLS()
+			defer server.Close()
+
+			module := config.Module{
+				Scan: config.ScanProbe{
+					CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+					VulnerabilityChecks: config.VulnerabilityChecks{
+						FallbackSCSV: true,
+					},
+				},
+			}
+
+			registry := prometheus.NewRegistry()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			if err := ProbeScan(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+				t.Fatalf("error: %s", err)
+			}
+
+			if tc.reported {
+				checkFallbackSCSVMetrics(1, registry, t)
+				return
+			}
+
+			mfs, err := registry.Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, mf := range mfs {
+				if mf.GetName() == "ssl_fallback_scsv_supported" {
+					t.Errorf("ssl_fallback_scsv_supported shouldn't be reported for a single version")
+				}
+			}
+		})
+	}
+}
+
+// TestProbeScanUnreachable tests that the probe fails when the target can't be
+// reached
+func TestProbeScanUnreachable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	registry := prometheus.NewRegistry()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeScan(ctx, newTestLogger(), addr, config.Module{}, registry); err == nil {
+		t.Fatalf("expected error but err was nil")
+	}
+}
@@ -0,0 +1,371 @@
+package prober
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/ribbybibby/ssl_exporter/v2/config"
+	"golang.org/x/crypto/cryptobyte"
+	cryptobyte_asn1 "golang.org/x/crypto/cryptobyte/asn1"
+	"golang.org/x/crypto/ocsp"
+)
+
+var (
+	// oidSCTList is the extension that embeds SCTs in a certificate, from
+	// RFC 6962
+	oidSCTList = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+	// oidOCSPSCTList is the single extension that includes SCTs in an OCSP
+	// response, from RFC 6962
+	oidOCSPSCTList = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 5}
+)
+
+const (
+	sctEntryTypeX509    = 0
+	sctEntryTypePrecert = 1
+
+	sctHashSHA256 = 4
+
+	sctSignatureRSA   = 1
+	sctSignatureECDSA = 3
+)
+
+// sct is a signed certificate timestamp, from RFC 6962
+type sct struct {
+	version            uint8
+	logID              [32]byte
+	timestamp          uint64
+	extensions         []byte
+	hashAlgorithm      uint8
+	signatureAlgorithm uint8
+	signature          []byte
+}
+
+// parseSCT parses a serialized SCT
+func parseSCT(data []byte) (*sct, error) {
+	var (
+		s     = cryptobyte.String(data)
+		id    []byte
+		exts  cryptobyte.String
+		sig   cryptobyte.String
+		entry = &sct{}
+	)
+	if !s.ReadUint8(&entry.version) || entry.version != 0 {
+		return nil, errors.New("unsupported SCT version")
+	}
+	if !s.ReadBytes(&id, 32) ||
+		!s.ReadUint64(&entry.timestamp) ||
+		!s.ReadUint16LengthPrefixed(&exts) ||
+		!s.ReadUint8(&entry.hashAlgorithm) ||
+		!s.ReadUint8(&entry.signatureAlgorithm) ||
+		!s.ReadUint16LengthPrefixed(&sig) ||
+		!s.Empty() {
+		return nil, errors.New("malformed SCT")
+	}
+	copy(entry.logID[:], id)
+	entry.extensions = exts
+	entry.signature = sig
+
+	return entry, nil
+}
+
+// parseSCTList parses a SignedCertificateTimestampList
+func parseSCTList(data []byte) ([]*sct, error) {
+	var (
+		s    = cryptobyte.String(data)
+		list cryptobyte.String
+	)
+	if !s.ReadUint16LengthPrefixed(&list) || !s.Empty() {
+		return nil, errors.New("malformed SCT list")
+	}
+
+	var scts []*sct
+	for !list.Empty() {
+		var serialized cryptobyte.String
+		if !list.ReadUint16LengthPrefixed(&serialized) {
+			return nil, errors.New("malformed SCT list")
+		}
+		entry, err := parseSCT(serialized)
+		if err != nil {
+			return nil, err
+		}
+		scts = append(scts, entry)
+	}
+
+	return scts, nil
+}
+
+// parseSCTListExtension parses the SCT list in the value of an extension,
+// which is wrapped in an OCTET STRING
+func parseSCTListExtension(value []byte) ([]*sct, error) {
+	var list []byte
+	if _, err := asn1.Unmarshal(value, &list); err != nil {
+		return nil, err
+	}
+
+	return parseSCTList(list)
+}
+
+// verify checks the signature of the SCT over the entry, with the key of the
+// log
+func (s *sct) verify(key crypto.PublicKey, entryType uint16, entry []byte) error {
+	if s.hashAlgorithm != sctHashSHA256 {
+		return fmt.Errorf("unsupported hash algorithm %d", s.hashAlgorithm)
+	}
+
+	signed := []byte{s.version, 0}
+	signed = binary.BigEndian.AppendUint64(signed, s.timestamp)
+	signed = binary.BigEndian.AppendUint16(signed, entryType)
+	signed = append(signed, entry...)
+	signed = binary.BigEndian.AppendUint16(signed, uint16(len(s.extensions)))
+	signed = append(signed, s.extensions...)
+	digest := sha256.Sum256(signed)
+
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		if s.signatureAlgorithm != sctSignatureECDSA || !ecdsa.VerifyASN1(key, digest[:], s.signature) {
+			return errors.New("invalid ECDSA signature")
+		}
+	case *rsa.PublicKey:
+		if s.signatureAlgorithm != sctSignatureRSA {
+			return errors.New("invalid RSA signature")
+		}
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], s.signature)
+	default:
+		return fmt.Errorf("unsupported key type %T", key)
+	}
+
+	return nil
+}
+
+// x509Entry returns the signed entry of an SCT for the certificate, which is
+// the certificate itself
+func x509Entry(cert *x509.Certificate) []byte {
+	var b cryptobyte.Builder
+	b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes(cert.Raw)
+	})
+
+	return b.BytesOrPanic()
+}
+
+// precertEntry returns the signed entry of an SCT that is embedded in the
+// certificate, which is the hash of the key of the issuer and the
+// TBSCertificate without the SCT list extension
+func precertEntry(cert, issuer *x509.Certificate) ([]byte, error) {
+	tbs, err := removeSCTListExtension(cert.RawTBSCertificate)
+	if err != nil {
+		return nil, err
+	}
+	issuerKeyHash := sha256.Sum256(issuer.RawSubjectPublicKeyInfo)
+
+	var b cryptobyte.Builder
+	b.AddBytes(issuerKeyHash[:])
+	b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes(tbs)
+	})
+
+	return b.Bytes()
+}
+
+// removeSCTListExtension returns the TBSCertificate without the SCT list
+// extension
+func removeSCTListExtension(raw []byte) ([]byte, error) {
+	var (
+		input = cryptobyte.String(raw)
+		tbs   cryptobyte.String
+	)
+	if !input.ReadASN1(&tbs, cryptobyte_asn1.SEQUENCE) {
+		return nil, errors.New("malformed TBSCertificate")
+	}
+
+	extensionsTag := cryptobyte_asn1.Tag(3).Constructed().ContextSpecific()
+
+	var b cryptobyte.Builder
+	b.AddASN1(cryptobyte_asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		for !tbs.Empty() {
+			var (
+				field cryptobyte.String
+				tag   cryptobyte_asn1.Tag
+			)
+			if !tbs.ReadAnyASN1Element(&field, &tag) {
+				b.SetError(errors.New("malformed TBSCertificate"))
+				return
+			}
+			if tag != extensionsTag {
+				b.AddBytes(field)
+				continue
+			}
+
+			var explicit, extensions cryptobyte.String
+			if !field.ReadASN1(&explicit, extensionsTag) || !explicit.ReadASN1(&extensions, cryptobyte_asn1.SEQUENCE) {
+				b.SetError(errors.New("malformed extensions"))
+				return
+			}
+			b.AddASN1(extensionsTag, func(b *cryptobyte.Builder) {
+				b.AddASN1(cryptobyte_asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+					for !extensions.Empty() {
+						var (
+							extension, contents cryptobyte.String
+							oid                 asn1.ObjectIdentifier
+						)
+						if !extensions.ReadASN1Element(&extension, cryptobyte_asn1.SEQUENCE) {
+							b.SetError(errors.New("malformed extension"))
+							return
+						}
+						element := extension
+						if !element.ReadASN1(&contents, cryptobyte_asn1.SEQUENCE) || !contents.ReadASN1ObjectIdentifier(&oid) {
+							b.SetError(errors.New("malformed extension"))
+							return
+						}
+						if !oid.Equal(oidSCTList) {
+							b.AddBytes(extension)
+						}
+					}
+				})
+			})
+		}
+	})
+
+	return b.Bytes()
+}
+
+// issuerOf returns the certificate in the chains that issued the leaf
+// certificate
+func issuerOf(leaf *x509.Certificate, chains [][]*x509.Certificate) *x509.Certificate {
+	for _, chain := range chains {
+		for _, cert := range chain {
+			if cert != leaf && leaf.CheckSignatureFrom(cert) == nil {
+				return cert
+			}
+		}
+	}
+
+	return nil
+}
+
+// collectSCTMetrics verifies the SCTs of the leaf certificate presented by the
+// target, which can be embedded in the certificate, included in the stapled
+// OCSP response or sent in the TLS extension, against the logs in the log
+// list. Invalid SCTs don't fail the probe.
+func collectSCTMetrics(logger log.Logger, state tls.ConnectionState, logs config.CTLogs, logListFile string, registry *prometheus.Registry) {
+	var (
+		sctsValid = prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "cert_scts_valid"),
+				Help: "The number of SCTs of the leaf certificate that are validly signed by a log in the log list",
+			},
+		)
+		sctInfo = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "cert_sct_info"),
+				Help: "The SCTs of the leaf certificate by log and where they were found. The value is 1 when the SCT is validly signed by the log.",
+			},
+			[]string{"source", "log_id", "log", "operator"},
+		)
+	)
+	registry.MustRegister(sctsValid, sctInfo)
+
+	if len(state.PeerCertificates) == 0 {
+		return
+	}
+	leaf := state.PeerCertificates[0]
+
+	// The log list is loaded with the config, so it's only loaded here for
+	// modules that weren't
+	if logs == nil {
+		var err error
+		logs, err = config.LoadCTLogList(logListFile)
+		if err != nil {
+			level.Error(logger).Log("msg", fmt.Sprintf("Error loading the CT log list: %s", err))
+			return
+		}
+	}
+
+	var valid int
+	check := func(source string, scts []*sct, entryType uint16, entry []byte) {
+		for _, s := range scts {
+			var (
+				result      float64
+				description string
+				operator    string
+			)
+			if l, ok := logs[s.logID]; ok {
+				description, operator = l.Description, l.Operator
+				if entry != nil {
+					if err := s.verify(l.Key, entryType, entry); err == nil {
+						result = 1
+						valid++
+					} else {
+						level.Debug(logger).Log("msg", fmt.Sprintf("SCT from %s (%s) isn't valid: %s", description, source, err))
+					}
+				}
+			}
+			sctInfo.WithLabelValues(source, base64.StdEncoding.EncodeToString(s.logID[:]), description, operator).Set(result)
+		}
+	}
+
+	for _, ext := range leaf.Extensions {
+		if !ext.Id.Equal(oidSCTList) {
+			continue
+		}
+		scts, err := parseSCTListExtension(ext.Value)
+		if err != nil {
+			level.Error(logger).Log("msg", fmt.Sprintf("Error parsing the SCTs in the certificate: %s", err))
+			break
+		}
+		// The SCTs are for the precertificate, so their signatures can
+		// only be verified with the issuer
+		var entry []byte
+		chains := append([][]*x509.Certificate{state.PeerCertificates}, state.VerifiedChains...)
+		if issuer := issuerOf(leaf, chains); issuer != nil {
+			entry, err = precertEntry(leaf, issuer)
+			if err != nil {
+				level.Error(logger).Log("msg", fmt.Sprintf("Error reconstructing the precertificate: %s", err))
+			}
+		} else {
+			level.Debug(logger).Log("msg", "Can't verify the SCTs in the certificate without its issuer")
+		}
+		check("certificate", scts, sctEntryTypePrecert, entry)
+	}
+
+	if len(state.OCSPResponse) > 0 {
+		if resp, err := ocsp.ParseResponse(state.OCSPResponse, nil); err == nil {
+			for _, ext := range resp.Extensions {
+				if !ext.Id.Equal(oidOCSPSCTList) {
+					continue
+				}
+				scts, err := parseSCTListExtension(ext.Value)
+				if err != nil {
+					level.Error(logger).Log("msg", fmt.Sprintf("Error parsing the SCTs in the OCSP response: %s", err))
+					break
+				}
+				check("ocsp", scts, sctEntryTypeX509, x509Entry(leaf))
+			}
+		}
+	}
+
+	var scts []*sct
+	for _, raw := range state.SignedCertificateTimestamps {
+		s, err := parseSCT(raw)
+		if err != nil {
+			level.Error(logger).Log("msg", fmt.Sprintf("Error parsing an SCT in the TLS extension: %s", err))
+			continue
+		}
+		scts = append(scts, s)
+	}
+	check("tls_extension", scts, sctEntryTypeX509, x509Entry(leaf))
+
+	sctsValid.Set(float64(valid))
+}
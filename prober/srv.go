@@ -0,0 +1,150 @@
+package prober
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/ribbybibby/ssl_exporter/v2/config"
+)
+
+// SRVTargetPrefix marks targets that are the name of an SRV record, like
+// srv+_ldaps._tcp.example.com, which is expanded into the hosts and ports that
+// are probed
+const SRVTargetPrefix = "srv+"
+
+// ProbeSRV returns a probe function that looks up the SRV record in the
+// target and probes every host and port in it with probeFn. The metrics of
+// each probe are labelled with the srv_target that they're for. The probe
+// fails if any of the targets fail.
+func ProbeSRV(probeFn ProbeFn) ProbeFn {
+	return func(ctx context.Context, logger log.Logger, target string, module config.Module, registry *prometheus.Registry) error {
+		name, err := toASCIIHost(strings.TrimPrefix(target, SRVTargetPrefix))
+		if err != nil {
+			return err
+		}
+
+		resolver, err := newResolver(module.Resolver, &module.ResolverTLSConfig)
+		if err != nil {
+			return err
+		}
+		if resolver == nil {
+			resolver = net.DefaultResolver
+		}
+
+		_, records, err := resolver.LookupSRV(ctx, "", "", name)
+		if err != nil {
+			return err
+		}
+		if len(records) == 0 {
+			return fmt.Errorf("no SRV records found for %s", name)
+		}
+
+		var (
+			srvProbeSuccess = prometheus.NewGaugeVec(
+				prometheus.GaugeOpts{
+					Name: prometheus.BuildFQName(namespace, "", "srv_probe_success"),
+					Help: "If the probe of a target in the SRV record was a success",
+				},
+				[]string{"srv_target"},
+			)
+		)
+		registry.MustRegister(srvProbeSuccess)
+
+		var (
+			wg   sync.WaitGroup
+			mu   sync.Mutex
+			errs []error
+		)
+		for _, record := range records {
+			srvTarget := net.JoinHostPort(strings.TrimSuffix(record.Target, "."), strconv.Itoa(int(record.Port)))
+			probeTarget := srvTarget
+			if module.Prober == "https" || module.Prober == "http" {
+				probeTarget = "https://" + srvTarget
+			}
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				targetRegistry := prometheus.NewRegistry()
+				targetLogger := log.With(logger, "srv_target", srvTarget)
+
+				err := probeFn(ctx, targetLogger, probeTarget, module, targetRegistry)
+				if err != nil {
+					level.Error(targetLogger).Log("msg", err)
+					srvProbeSuccess.WithLabelValues(srvTarget).Set(0)
+				} else {
+					srvProbeSuccess.WithLabelValues(srvTarget).Set(1)
+				}
+
+				mfs, gatherErr := targetRegistry.Gather()
+
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil {
+					errs = append(errs, fmt.Errorf("%s: %w", srvTarget, err))
+				}
+				if gatherErr != nil {
+					errs = append(errs, fmt.Errorf("%s: %w", srvTarget, gatherErr))
+					return
+				}
+				registry.MustRegister(&srvTargetCollector{target: srvTarget, mfs: mfs})
+			}()
+		}
+		wg.Wait()
+
+		return errors.Join(errs...)
+	}
+}
+
+// srvTargetCollector exposes the metrics gathered from the probe of a target
+// in an SRV record with an srv_target label
+type srvTargetCollector struct {
+	target string
+	mfs    []*dto.MetricFamily
+}
+
+// Describe doesn't describe any metrics, which makes the collector unchecked,
+// because the metrics aren't known until the probe is done
+func (c *srvTargetCollector) Describe(ch chan<- *prometheus.Desc) {}
+
+func (c *srvTargetCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, mf := range c.mfs {
+		for _, m := range mf.GetMetric() {
+			names := []string{"srv_target"}
+			values := []string{c.target}
+			for _, l := range m.GetLabel() {
+				names = append(names, l.GetName())
+				values = append(values, l.GetValue())
+			}
+			desc := prometheus.NewDesc(mf.GetName(), mf.GetHelp(), names, nil)
+
+			var (
+				metric prometheus.Metric
+				err    error
+			)
+			switch mf.GetType() {
+			case dto.MetricType_GAUGE:
+				metric, err = prometheus.NewConstMetric(desc, prometheus.GaugeValue, m.GetGauge().GetValue(), values...)
+			case dto.MetricType_COUNTER:
+				metric, err = prometheus.NewConstMetric(desc, prometheus.CounterValue, m.GetCounter().GetValue(), values...)
+			default:
+				metric, err = prometheus.NewConstMetric(desc, prometheus.UntypedValue, m.GetUntyped().GetValue(), values...)
+			}
+			if err != nil {
+				ch <- prometheus.NewInvalidMetric(desc, err)
+				continue
+			}
+			ch <- metric
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package prober
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/ribbybibby/ssl_exporter/v2/config"
+	"github.com/ribbybibby/ssl_exporter/v2/test"
+)
+
+// TestProbeSRV tests probing every target in an SRV record
+func TestProbeSRV(t *testing.T) {
+	var srvs []net.SRV
+	for i := 0; i < 2; i++ {
+		server, _, _, _, teardown, err := test.SetupTCPServer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer teardown()
+
+		server.StartTLS()
+		defer server.Close()
+
+		_, port, err := net.SplitHostPort(server.Listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := strconv.Atoi(port)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		srvs = append(srvs, net.SRV{Target: "probe.example.test.", Port: uint16(p), Priority: 10, Weight: 10})
+	}
+
+	dnsServer, err := test.SetupDNSServerWithSRV(map[string][]net.IP{
+		"probe.example.test.": []net.IP{net.ParseIP("127.0.0.1")},
+	}, map[string][]net.SRV{
+		"_ldaps._tcp.example.test.": srvs,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dnsServer.Close()
+
+	module := config.Module{
+		Prober:   "tcp",
+		Resolver: dnsServer.Conn.LocalAddr().String(),
+		TLSConfig: config.TLSConfig{
+			// Each server has a different self-signed certificate
+			InsecureSkipVerify: true,
+		},
+	}
+
+	registry := prometheus.NewRegistry()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeSRV(ProbeTCP)(ctx, newTestLogger(), SRVTargetPrefix+"_ldaps._tcp.example.test", module, registry); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, srv := range srvs {
+		srvTarget := net.JoinHostPort("probe.example.test", strconv.Itoa(int(srv.Port)))
+		checkRegistryResults([]*registryResult{
+			&registryResult{
+				Name: "ssl_srv_probe_success",
+				LabelValues: map[string]string{
+					"srv_target": srvTarget,
+				},
+				Value: 1,
+			},
+			&registryResult{
+				Name: "ssl_tls_version_info",
+				LabelValues: map[string]string{
+					"srv_target": srvTarget,
+					"version":    "TLS 1.3",
+				},
+				Value: 1,
+			},
+		}, mfs, t)
+	}
+}
@@ -0,0 +1,86 @@
+package prober
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/ribbybibby/ssl_exporter/v2/config"
+	"golang.org/x/crypto/ssh"
+)
+
+// errSSHHostKeyCaptured stops the SSH handshake once the server's host key
+// has been received, as there's no need to authenticate
+var errSSHHostKeyCaptured = errors.New("host key captured")
+
+// sshHostKeyAlgorithms prefers the certificate algorithms, so that servers
+// present their host certificate when they have one
+var sshHostKeyAlgorithms = []string{
+	ssh.CertAlgoED25519v01,
+	ssh.CertAlgoECDSA256v01,
+	ssh.CertAlgoECDSA384v01,
+	ssh.CertAlgoECDSA521v01,
+	ssh.CertAlgoRSASHA512v01,
+	ssh.CertAlgoRSASHA256v01,
+	ssh.CertAlgoRSAv01,
+	ssh.KeyAlgoED25519,
+	ssh.KeyAlgoECDSA256,
+	ssh.KeyAlgoECDSA384,
+	ssh.KeyAlgoECDSA521,
+	ssh.KeyAlgoRSASHA512,
+	ssh.KeyAlgoRSASHA256,
+	ssh.KeyAlgoRSA,
+}
+
+// ProbeSSH performs a ssh probe
+func ProbeSSH(ctx context.Context, logger log.Logger, target string, module config.Module, registry *prometheus.Registry) error {
+	opts := newDialOptions(module)
+	// An outer TLS session is only used for TLS targets
+	opts.outerTLSConfig = nil
+	opts.trace = &dialTrace{}
+
+	durations := newPhaseDurationMetrics(registry)
+
+	connect := newPhase(ctx, "connect", module.Timeouts.Connect)
+	dialCtx, cancel := connect.context(ctx)
+	conn, err := dialContext(dialCtx, "tcp", target, opts)
+	cancel()
+	collectDNSMetrics(opts.trace, registry)
+	collectDialPhaseMetrics(durations, opts.trace, connect.elapsed())
+	if err != nil {
+		return connect.err(err)
+	}
+	defer conn.Close()
+
+	deadline, _ := ctx.Deadline()
+	if err := conn.SetDeadline(deadline); err != nil {
+		return fmt.Errorf("Error setting deadline")
+	}
+
+	var hostKey ssh.PublicKey
+	sshConfig := &ssh.ClientConfig{
+		HostKeyAlgorithms: sshHostKeyAlgorithms,
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			hostKey = key
+			return errSSHHostKeyCaptured
+		},
+	}
+
+	_, _, _, err = ssh.NewClientConn(conn, target, sshConfig)
+	if hostKey == nil {
+		return err
+	}
+
+	level.Debug(logger).Log("msg", fmt.Sprintf("received ssh host key of type %s", hostKey.Type()))
+
+	cert, ok := hostKey.(*ssh.Certificate)
+	if !ok {
+		return fmt.Errorf("The server presented a %s host key rather than a certificate", hostKey.Type())
+	}
+
+	return collectSSHCertificateMetrics([]*ssh.Certificate{cert}, registry)
+}
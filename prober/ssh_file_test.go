@@ -0,0 +1,182 @@
+package prober
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/ribbybibby/ssl_exporter/v2/config"
+	"github.com/ribbybibby/ssl_exporter/v2/test"
+	"golang.org/x/crypto/ssh"
+)
+
+// TestProbeSSHFileKnownHosts tests a known_hosts file with a certificate
+// authority and a plain host key
+func TestProbeSSHFileKnownHosts(t *testing.T) {
+	ca, err := test.GenerateSSHSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey, err := test.GenerateSSHSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	knownHosts := fmt.Sprintf("# comment\n@cert-authority *.example.com %s\nhost.example.com %s\n",
+		ssh.MarshalAuthorizedKey(ca.PublicKey()),
+		ssh.MarshalAuthorizedKey(hostKey.PublicKey()),
+	)
+	file, err := createTestSSHFile(knownHosts, "known_hosts*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file)
+
+	registry := prometheus.NewRegistry()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeSSHFile(ctx, newTestLogger(), file, config.Module{}, registry); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	checkSSHFileCAMetrics(ca.PublicKey(), file, registry, t)
+	checkSSHFileCACount(1, registry, t)
+}
+
+// TestProbeSSHFileCertificate tests a file containing an SSH certificate
+func TestProbeSSHFileCertificate(t *testing.T) {
+	ca, err := test.GenerateSSHSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := test.GenerateSSHSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := test.GenerateSSHCertificate(key.PublicKey(), ca, ssh.UserCert, time.Now().AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := createTestSSHFile(string(ssh.MarshalAuthorizedKey(cert)), "id_ed25519-cert*.pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file)
+
+	registry := prometheus.NewRegistry()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeSSHFile(ctx, newTestLogger(), file, config.Module{}, registry); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	checkSSHFileCertificateMetrics(cert, file, registry, t)
+}
+
+// TestProbeSSHFileNoKeys tests that the probe fails for a file without any
+// certificates or certificate authorities
+func TestProbeSSHFileNoKeys(t *testing.T) {
+	hostKey, err := test.GenerateSSHSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := createTestSSHFile("host.example.com "+string(ssh.MarshalAuthorizedKey(hostKey.PublicKey())), "known_hosts*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeSSHFile(ctx, newTestLogger(), file, config.Module{}, prometheus.NewRegistry()); err == nil {
+		t.Fatalf("expected error, but err was nil")
+	}
+}
+
+// Create file with the given contents
+func createTestSSHFile(contents, filename string) (string, error) {
+	tmpFile, err := ioutil.TempFile("", filename)
+	if err != nil {
+		return "", err
+	}
+	if _, err := tmpFile.WriteString(contents); err != nil {
+		return tmpFile.Name(), err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return tmpFile.Name(), err
+	}
+
+	return tmpFile.Name(), nil
+}
+
+// Check metrics
+func checkSSHFileCertificateMetrics(cert *ssh.Certificate, file string, registry *prometheus.Registry, t *testing.T) {
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	labels := map[string]string{
+		"file":           file,
+		"serial_no":      strconv.FormatUint(cert.Serial, 10),
+		"key_id":         cert.KeyId,
+		"principals":     ",127.0.0.1,example.com,",
+		"type":           "user",
+		"ca_fingerprint": ssh.FingerprintSHA256(cert.SignatureKey),
+	}
+	expectedResults := []*registryResult{
+		&registryResult{
+			Name:        "ssl_file_ssh_cert_not_after",
+			LabelValues: labels,
+			Value:       float64(cert.ValidBefore),
+		},
+		&registryResult{
+			Name:        "ssl_file_ssh_cert_not_before",
+			LabelValues: labels,
+			Value:       float64(cert.ValidAfter),
+		},
+	}
+	checkRegistryResults(expectedResults, mfs, t)
+}
+
+func checkSSHFileCAMetrics(ca ssh.PublicKey, file string, registry *prometheus.Registry, t *testing.T) {
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedResults := []*registryResult{
+		&registryResult{
+			Name: "ssl_file_ssh_ca_info",
+			LabelValues: map[string]string{
+				"file":        file,
+				"fingerprint": ssh.FingerprintSHA256(ca),
+				"key_type":    ca.Type(),
+			},
+			Value: 1,
+		},
+	}
+	checkRegistryResults(expectedResults, mfs, t)
+}
+
+func checkSSHFileCACount(count int, registry *prometheus.Registry, t *testing.T) {
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() == "ssl_file_ssh_ca_info" && len(mf.GetMetric()) != count {
+			t.Errorf("expected %d certificate authorities, but found %d", count, len(mf.GetMetric()))
+		}
+	}
+}
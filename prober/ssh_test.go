@@ -0,0 +1,84 @@
+package prober
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/ribbybibby/ssl_exporter/v2/config"
+	"github.com/ribbybibby/ssl_exporter/v2/test"
+	"golang.org/x/crypto/ssh"
+)
+
+// TestProbeSSH tests the typical case
+func TestProbeSSH(t *testing.T) {
+	server, cert, err := test.SetupSSHServer()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	server.Start()
+	defer server.Close()
+
+	registry := prometheus.NewRegistry()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeSSH(ctx, newTestLogger(), server.Listener.Addr().String(), config.Module{}, registry); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	checkSSHCertificateMetrics(cert, registry, t)
+}
+
+// TestProbeSSHNoCertificate tests that the probe fails when the server
+// presents a plain host key
+func TestProbeSSHNoCertificate(t *testing.T) {
+	server, _, err := test.SetupSSHServer()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	server.Config = test.NewSSHServerConfig()
+	server.Config.AddHostKey(server.HostKey)
+
+	server.Start()
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeSSH(ctx, newTestLogger(), server.Listener.Addr().String(), config.Module{}, prometheus.NewRegistry()); err == nil {
+		t.Fatalf("expected error, but err was nil")
+	}
+}
+
+func checkSSHCertificateMetrics(cert *ssh.Certificate, registry *prometheus.Registry, t *testing.T) {
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	labels := map[string]string{
+		"serial_no":      strconv.FormatUint(cert.Serial, 10),
+		"key_id":         cert.KeyId,
+		"principals":     ",127.0.0.1,example.com,",
+		"type":           "host",
+		"ca_fingerprint": ssh.FingerprintSHA256(cert.SignatureKey),
+	}
+	expectedResults := []*registryResult{
+		&registryResult{
+			Name:        "ssl_ssh_cert_not_after",
+			LabelValues: labels,
+			Value:       float64(cert.ValidBefore),
+		},
+		&registryResult{
+			Name:        "ssl_ssh_cert_not_before",
+			LabelValues: labels,
+			Value:       float64(cert.ValidAfter),
+		},
+	}
+	checkRegistryResults(expectedResults, mfs, t)
+}
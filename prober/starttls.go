@@ -0,0 +1,915 @@
+package prober
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/ribbybibby/ssl_exporter/v2/config"
+)
+
+// startTLSFuncs maps protocols that require more than a simple exchange of
+// lines or bytes to the function that negotiates TLS for them, given the name
+// of the server that is being probed. The returned connection is the one that
+// the TLS handshake should be performed over.
+var startTLSFuncs = map[string]func(logger log.Logger, conn net.Conn, serverName string, module config.Module, registry *prometheus.Registry) (net.Conn, error){
+	"ldap":        startTLSLDAP,
+	"mssql":       startTLSMSSQL,
+	"mysql":       startTLSMySQL,
+	"openvpn":     startTLSOpenVPN,
+	"rdp":         startTLSRDP,
+	"smtp":        startTLSSMTP,
+	"xmpp":        startTLSXMPP("jabber:client"),
+	"xmpp-server": startTLSXMPP("jabber:server"),
+}
+
+// startTLSSMTP sends EHLO, checks the capabilities advertised by the server
+// and then sends the STARTTLS command
+func startTLSSMTP(logger log.Logger, conn net.Conn, serverName string, module config.Module, registry *prometheus.Registry) (net.Conn, error) {
+	reader := bufio.NewReader(conn)
+
+	code, lines, err := readTextReply(reader)
+	if err != nil {
+		return nil, fmt.Errorf("reading smtp greeting: %w", err)
+	}
+
+	level.Debug(logger).Log("msg", fmt.Sprintf("read smtp greeting: %q", lines))
+
+	if code != "220" {
+		return nil, fmt.Errorf("unexpected smtp greeting: %s", strings.Join(lines, " "))
+	}
+
+	capabilities, err := smtpEHLO(logger, conn, reader, module.TCP.SMTP)
+	if err != nil {
+		return nil, err
+	}
+
+	// The capabilities are reported before they're checked, so that they
+	// explain why a check failed
+	if err := collectSMTPCapabilityMetrics(capabilities, registry); err != nil {
+		return nil, err
+	}
+
+	if err := smtpCheckCapabilities(capabilities, module.TCP.SMTP); err != nil {
+		return nil, err
+	}
+
+	if !smtpHasCapability(capabilities, "STARTTLS") {
+		return nil, fmt.Errorf("STARTTLS isn't advertised by the smtp server")
+	}
+
+	level.Debug(logger).Log("msg", "sending line: STARTTLS")
+
+	if _, err := io.WriteString(conn, "STARTTLS\r\n"); err != nil {
+		return nil, err
+	}
+
+	code, lines, err = readTextReply(reader)
+	if err != nil {
+		return nil, fmt.Errorf("reading smtp STARTTLS reply: %w", err)
+	}
+
+	level.Debug(logger).Log("msg", fmt.Sprintf("read smtp STARTTLS reply: %q", lines))
+
+	if code != "220" {
+		return nil, fmt.Errorf("unexpected smtp STARTTLS reply: %s", strings.Join(lines, " "))
+	}
+
+	return conn, nil
+}
+
+// smtpEHLO sends EHLO with the configured name and returns the capabilities
+// advertised by the server
+func smtpEHLO(logger log.Logger, conn net.Conn, reader *bufio.Reader, cfg config.SMTPConfig) ([]string, error) {
+	name := cfg.EHLOName
+	if name == "" {
+		name = "prober"
+	}
+
+	level.Debug(logger).Log("msg", fmt.Sprintf("sending line: EHLO %s", name))
+
+	if _, err := fmt.Fprintf(conn, "EHLO %s\r\n", name); err != nil {
+		return nil, err
+	}
+
+	code, lines, err := readTextReply(reader)
+	if err != nil {
+		return nil, fmt.Errorf("reading smtp EHLO reply: %w", err)
+	}
+
+	level.Debug(logger).Log("msg", fmt.Sprintf("read smtp EHLO reply: %q", lines))
+
+	if code != "250" {
+		return nil, fmt.Errorf("unexpected smtp EHLO reply: %s", strings.Join(lines, " "))
+	}
+
+	// The first line of the reply is the greeting. Each following line
+	// starts with the keyword of an extension, which may have parameters.
+	var capabilities []string
+	for _, line := range lines[1:] {
+		if len(line) < 4 {
+			continue
+		}
+		if fields := strings.Fields(line[4:]); len(fields) > 0 {
+			capabilities = append(capabilities, strings.ToUpper(fields[0]))
+		}
+	}
+
+	return capabilities, nil
+}
+
+// smtpCheckCapabilities checks the capabilities advertised by the server
+// against the required and forbidden capabilities
+func smtpCheckCapabilities(capabilities []string, cfg config.SMTPConfig) error {
+	for _, capability := range cfg.RequireCapabilities {
+		if !smtpHasCapability(capabilities, capability) {
+			return fmt.Errorf("required smtp capability %s isn't advertised", capability)
+		}
+	}
+	for _, capability := range cfg.ForbidCapabilities {
+		if smtpHasCapability(capabilities, capability) {
+			return fmt.Errorf("forbidden smtp capability %s is advertised", capability)
+		}
+	}
+
+	return nil
+}
+
+func smtpHasCapability(capabilities []string, capability string) bool {
+	for _, c := range capabilities {
+		if strings.EqualFold(c, capability) {
+			return true
+		}
+	}
+
+	return false
+}
+
+const (
+	mysqlClientLongPassword     = 0x00000001
+	mysqlClientProtocol41       = 0x00000200
+	mysqlClientSSL              = 0x00000800
+	mysqlClientSecureConnection = 0x00008000
+	mysqlMaxPacketSize          = 0x01000000
+	mysqlCharsetUTF8            = 0x21
+)
+
+// startTLSMySQL reads the initial handshake packet from a MySQL server and
+// responds with an SSLRequest packet, after which the server expects the TLS
+// handshake
+func startTLSMySQL(logger log.Logger, conn net.Conn, serverName string, module config.Module, registry *prometheus.Registry) (net.Conn, error) {
+	seq, payload, err := readMySQLPacket(conn)
+	if err != nil {
+		return nil, fmt.Errorf("reading mysql handshake packet: %w", err)
+	}
+	if len(payload) == 0 {
+		return nil, fmt.Errorf("empty mysql handshake packet")
+	}
+	if payload[0] == 0xff {
+		return nil, mysqlError(payload)
+	}
+	if payload[0] != 10 {
+		return nil, fmt.Errorf("unsupported mysql protocol version %d", payload[0])
+	}
+
+	// The server version is a null terminated string that follows the
+	// protocol version
+	end := 1
+	for end < len(payload) && payload[end] != 0x00 {
+		end++
+	}
+	level.Debug(logger).Log("msg", fmt.Sprintf("mysql server version: %s", payload[1:end]))
+
+	// Skip the null terminator, the connection id (4), the first part of the
+	// auth plugin data (8) and a filler byte (1) to get to the lower two
+	// bytes of the capability flags
+	capOffset := end + 1 + 4 + 8 + 1
+	if len(payload) < capOffset+2 {
+		return nil, fmt.Errorf("mysql handshake packet is too short")
+	}
+	capabilities := binary.LittleEndian.Uint16(payload[capOffset : capOffset+2])
+	if capabilities&mysqlClientSSL == 0 {
+		return nil, fmt.Errorf("mysql server doesn't support SSL")
+	}
+
+	request := make([]byte, 32)
+	binary.LittleEndian.PutUint32(request[0:4], mysqlClientLongPassword|mysqlClientProtocol41|mysqlClientSSL|mysqlClientSecureConnection)
+	binary.LittleEndian.PutUint32(request[4:8], mysqlMaxPacketSize)
+	request[8] = mysqlCharsetUTF8
+
+	level.Debug(logger).Log("msg", fmt.Sprintf("sending mysql SSLRequest: %x", request))
+
+	return conn, writeMySQLPacket(conn, seq+1, request)
+}
+
+// mysqlError returns the error in an ERR packet, which the server sends in
+// place of the initial handshake when it refuses the connection. The SQL state
+// only follows the error code when it's marked with '#'.
+func mysqlError(payload []byte) error {
+	if len(payload) < 3 {
+		return fmt.Errorf("mysql server returned error")
+	}
+	code := binary.LittleEndian.Uint16(payload[1:3])
+	message := payload[3:]
+	if len(message) >= 6 && message[0] == '#' {
+		message = message[6:]
+	}
+
+	return fmt.Errorf("mysql server returned error %d: %s", code, message)
+}
+
+// readMySQLPacket reads a single packet from a MySQL connection, returning
+// its sequence id and payload
+func readMySQLPacket(r io.Reader) (byte, []byte, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+	length := int(uint32(header[0]) | uint32(header[1])<<8 | uint32(header[2])<<16)
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+
+	return header[3], payload, nil
+}
+
+// writeMySQLPacket writes a payload to a MySQL connection with the given
+// sequence id
+func writeMySQLPacket(w io.Writer, seq byte, payload []byte) error {
+	length := len(payload)
+	packet := append([]byte{byte(length), byte(length >> 8), byte(length >> 16), seq}, payload...)
+
+	_, err := w.Write(packet)
+
+	return err
+}
+
+const (
+	tdsPacketPreLogin    = 0x12
+	tdsPacketTabular     = 0x04
+	tdsStatusEOM         = 0x01
+	tdsHeaderLength      = 8
+	tdsMaxPacketLength   = 4096
+	tdsPreLoginVersion   = 0x00
+	tdsPreLoginEncrypt   = 0x01
+	tdsPreLoginTerminate = 0xff
+	tdsEncryptOn         = 0x01
+	tdsEncryptNotSup     = 0x02
+)
+
+// startTLSMSSQL performs the TDS pre-login exchange with a SQL Server. The TLS
+// handshake that follows is carried inside TDS pre-login packets, so the
+// returned connection wraps and unwraps the handshake records.
+func startTLSMSSQL(logger log.Logger, conn net.Conn, serverName string, module config.Module, registry *prometheus.Registry) (net.Conn, error) {
+	// The VERSION and ENCRYPTION option tokens, a terminator, followed by
+	// the option data
+	preLogin := []byte{
+		tdsPreLoginVersion, 0x00, 0x0b, 0x00, 0x06,
+		tdsPreLoginEncrypt, 0x00, 0x11, 0x00, 0x01,
+		tdsPreLoginTerminate,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		tdsEncryptOn,
+	}
+
+	level.Debug(logger).Log("msg", fmt.Sprintf("sending mssql pre-login: %x", preLogin))
+
+	tds := &tdsConn{Conn: conn, packetType: tdsPacketPreLogin}
+	if _, err := tds.Write(preLogin); err != nil {
+		return nil, err
+	}
+
+	resp, err := tds.readMessage()
+	if err != nil {
+		return nil, fmt.Errorf("reading mssql pre-login response: %w", err)
+	}
+
+	level.Debug(logger).Log("msg", fmt.Sprintf("read mssql pre-login response: %x", resp))
+
+	encryption, err := tdsPreLoginOption(resp, tdsPreLoginEncrypt)
+	if err != nil {
+		return nil, err
+	}
+	if len(encryption) != 1 {
+		return nil, fmt.Errorf("invalid mssql encryption option: %x", encryption)
+	}
+	if encryption[0] == tdsEncryptNotSup {
+		return nil, fmt.Errorf("mssql server doesn't support encryption")
+	}
+
+	return tds, nil
+}
+
+// tdsPreLoginOption returns the data for the given option token in a TDS
+// pre-login message
+func tdsPreLoginOption(msg []byte, token byte) ([]byte, error) {
+	for i := 0; i < len(msg) && msg[i] != tdsPreLoginTerminate; i += 5 {
+		if i+5 > len(msg) {
+			break
+		}
+		if msg[i] != token {
+			continue
+		}
+		offset := int(binary.BigEndian.Uint16(msg[i+1 : i+3]))
+		length := int(binary.BigEndian.Uint16(msg[i+3 : i+5]))
+		if offset+length > len(msg) {
+			return nil, fmt.Errorf("mssql pre-login option %x is out of bounds", token)
+		}
+		return msg[offset : offset+length], nil
+	}
+
+	return nil, fmt.Errorf("mssql pre-login option %x not found", token)
+}
+
+// tdsConn frames writes in TDS packets of the given type and strips the TDS
+// headers from reads
+type tdsConn struct {
+	net.Conn
+	packetType byte
+	packetID   byte
+	buf        []byte
+}
+
+// Write sends b as a single TDS message, split across as many packets as are
+// required
+func (c *tdsConn) Write(b []byte) (int, error) {
+	n := 0
+	for n < len(b) {
+		size := len(b) - n
+		status := byte(tdsStatusEOM)
+		if size > tdsMaxPacketLength-tdsHeaderLength {
+			size = tdsMaxPacketLength - tdsHeaderLength
+			status = 0x00
+		}
+		c.packetID++
+
+		length := tdsHeaderLength + size
+		packet := []byte{c.packetType, status, byte(length >> 8), byte(length), 0x00, 0x00, c.packetID, 0x00}
+		packet = append(packet, b[n:n+size]...)
+		if _, err := c.Conn.Write(packet); err != nil {
+			return n, err
+		}
+		n += size
+	}
+
+	return n, nil
+}
+
+// Read returns the payloads of the TDS packets read from the connection
+func (c *tdsConn) Read(b []byte) (int, error) {
+	if len(c.buf) == 0 {
+		payload, _, err := c.readPacket()
+		if err != nil {
+			return 0, err
+		}
+		c.buf = payload
+	}
+	n := copy(b, c.buf)
+	c.buf = c.buf[n:]
+
+	return n, nil
+}
+
+// readMessage reads packets from the connection until the end of the message
+func (c *tdsConn) readMessage() ([]byte, error) {
+	var msg []byte
+	for {
+		payload, status, err := c.readPacket()
+		if err != nil {
+			return nil, err
+		}
+		msg = append(msg, payload...)
+		if status&tdsStatusEOM != 0 {
+			return msg, nil
+		}
+	}
+}
+
+// readPacket reads a single TDS packet from the connection, returning its
+// payload and status
+func (c *tdsConn) readPacket() ([]byte, byte, error) {
+	header := make([]byte, tdsHeaderLength)
+	if _, err := io.ReadFull(c.Conn, header); err != nil {
+		return nil, 0, err
+	}
+	if header[0] != tdsPacketTabular && header[0] != tdsPacketPreLogin {
+		return nil, 0, fmt.Errorf("unexpected tds packet type %x", header[0])
+	}
+	length := int(binary.BigEndian.Uint16(header[2:4]))
+	if length < tdsHeaderLength {
+		return nil, 0, fmt.Errorf("invalid tds packet length %d", length)
+	}
+	payload := make([]byte, length-tdsHeaderLength)
+	if _, err := io.ReadFull(c.Conn, payload); err != nil {
+		return nil, 0, err
+	}
+
+	return payload, header[1], nil
+}
+
+const (
+	ldapStartTLSOID         = "1.3.6.1.4.1.1466.20037"
+	berTagInteger           = 0x02
+	berTagOctetString       = 0x04
+	berTagEnumerated        = 0x0a
+	berTagSequence          = 0x30
+	ldapTagBindRequest      = 0x60
+	ldapTagBindResponse     = 0x61
+	ldapTagSimpleAuth       = 0x80
+	ldapTagExtendedRequest  = 0x77
+	ldapTagExtendedResponse = 0x78
+	ldapTagExtendedName     = 0x80
+	ldapResultSuccess       = 0x00
+	// berMaxLength limits the contents of an element, as the responses are
+	// only expected to be short results
+	berMaxLength = 64 * 1024
+)
+
+// startTLSLDAP sends the StartTLS extended request defined in RFC 4511 and
+// waits for a successful extended response
+func startTLSLDAP(logger log.Logger, conn net.Conn, serverName string, module config.Module, registry *prometheus.Registry) (net.Conn, error) {
+	request := berElement(berTagSequence,
+		berElement(berTagInteger, []byte{0x01}),
+		berElement(ldapTagExtendedRequest,
+			berElement(ldapTagExtendedName, []byte(ldapStartTLSOID)),
+		),
+	)
+
+	level.Debug(logger).Log("msg", fmt.Sprintf("sending ldap StartTLS request: %x", request))
+
+	if _, err := conn.Write(request); err != nil {
+		return nil, err
+	}
+
+	code, msg, err := readLDAPResult(conn, ldapTagExtendedResponse)
+	if err != nil {
+		return nil, fmt.Errorf("reading ldap StartTLS response: %w", err)
+	}
+	if code != ldapResultSuccess {
+		return nil, fmt.Errorf("ldap StartTLS failed with result code %d: %s", code, msg)
+	}
+
+	level.Debug(logger).Log("msg", "ldap StartTLS request succeeded")
+
+	return conn, nil
+}
+
+// readLDAPResult reads an LDAP message from r and returns the result code
+// and diagnostic message from the response with the given tag
+func readLDAPResult(r io.Reader, tag byte) (int, string, error) {
+	msgTag, msg, err := readBERElement(r)
+	if err != nil {
+		return 0, "", err
+	}
+	if msgTag != berTagSequence {
+		return 0, "", fmt.Errorf("unexpected ldap message tag %x", msgTag)
+	}
+
+	// Skip the message id
+	if _, _, msg, err = parseBERElement(msg); err != nil {
+		return 0, "", err
+	}
+
+	opTag, op, _, err := parseBERElement(msg)
+	if err != nil {
+		return 0, "", err
+	}
+	if opTag != tag {
+		return 0, "", fmt.Errorf("unexpected ldap protocol op %x", opTag)
+	}
+
+	codeTag, code, op, err := parseBERElement(op)
+	if err != nil {
+		return 0, "", err
+	}
+	if codeTag != berTagEnumerated || len(code) == 0 {
+		return 0, "", fmt.Errorf("invalid ldap result code")
+	}
+
+	// The matched DN is followed by the diagnostic message
+	var diagnostic []byte
+	if _, _, op, err = parseBERElement(op); err == nil {
+		_, diagnostic, _, _ = parseBERElement(op)
+	}
+
+	return int(code[len(code)-1]), string(diagnostic), nil
+}
+
+// berElement encodes a BER element with the given tag from the concatenated
+// contents
+func berElement(tag byte, contents ...[]byte) []byte {
+	var content []byte
+	for _, c := range contents {
+		content = append(content, c...)
+	}
+
+	return append(append([]byte{tag}, berLength(len(content))...), content...)
+}
+
+// berLength encodes a BER length
+func berLength(n int) []byte {
+	if n < 0x80 {
+		return []byte{byte(n)}
+	}
+	var b []byte
+	for ; n > 0; n >>= 8 {
+		b = append([]byte{byte(n)}, b...)
+	}
+
+	return append([]byte{0x80 | byte(len(b))}, b...)
+}
+
+// readBERElement reads a single BER element from r, returning its tag and
+// contents
+func readBERElement(r io.Reader) (byte, []byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+	length := int(header[1])
+	if header[1]&0x80 != 0 {
+		n := int(header[1] & 0x7f)
+		if n == 0 || n > 4 {
+			return 0, nil, fmt.Errorf("unsupported ber length encoding %x", header[1])
+		}
+		b := make([]byte, n)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return 0, nil, err
+		}
+		length = 0
+		for _, c := range b {
+			length = length<<8 | int(c)
+		}
+	}
+	if length > berMaxLength {
+		return 0, nil, fmt.Errorf("ber element length %d exceeds the limit of %d bytes", length, berMaxLength)
+	}
+	content := make([]byte, length)
+	if _, err := io.ReadFull(r, content); err != nil {
+		return 0, nil, err
+	}
+
+	return header[0], content, nil
+}
+
+// parseBERElement parses the first BER element in b, returning its tag,
+// contents and the remaining bytes
+func parseBERElement(b []byte) (byte, []byte, []byte, error) {
+	r := bytes.NewReader(b)
+	tag, content, err := readBERElement(r)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+
+	return tag, content, b[len(b)-r.Len():], nil
+}
+
+const (
+	xmppStreamsNamespace = "http://etherx.jabber.org/streams"
+	xmppTLSNamespace     = "urn:ietf:params:xml:ns:xmpp-tls"
+)
+
+// startTLSXMPP returns a function that opens an XMPP stream in the given
+// namespace and negotiates TLS as described in RFC 6120
+func startTLSXMPP(namespace string) func(logger log.Logger, conn net.Conn, serverName string, module config.Module, registry *prometheus.Registry) (net.Conn, error) {
+	return func(logger log.Logger, conn net.Conn, serverName string, module config.Module, registry *prometheus.Registry) (net.Conn, error) {
+		var domain bytes.Buffer
+		if err := xml.EscapeText(&domain, []byte(serverName)); err != nil {
+			return nil, err
+		}
+
+		stream := fmt.Sprintf("<?xml version='1.0'?><stream:stream to='%s' xmlns='%s' xmlns:stream='%s' version='1.0'>", domain.String(), namespace, xmppStreamsNamespace)
+
+		level.Debug(logger).Log("msg", fmt.Sprintf("sending xmpp stream header: %s", stream))
+
+		if _, err := io.WriteString(conn, stream); err != nil {
+			return nil, err
+		}
+
+		decoder := xml.NewDecoder(conn)
+
+		if err := xmppExpect(logger, decoder, xmppStreamsNamespace, "features"); err != nil {
+			return nil, err
+		}
+
+		// Look for the starttls feature amongst the stream features
+		var supported bool
+		for depth := 0; depth >= 0; {
+			token, err := decoder.Token()
+			if err != nil {
+				return nil, fmt.Errorf("reading xmpp stream features: %w", err)
+			}
+			switch t := token.(type) {
+			case xml.StartElement:
+				if depth == 0 && t.Name.Space == xmppTLSNamespace && t.Name.Local == "starttls" {
+					supported = true
+				}
+				depth++
+			case xml.EndElement:
+				depth--
+			}
+		}
+		if !supported {
+			return nil, fmt.Errorf("xmpp server doesn't support starttls")
+		}
+
+		starttls := fmt.Sprintf("<starttls xmlns='%s'/>", xmppTLSNamespace)
+
+		level.Debug(logger).Log("msg", fmt.Sprintf("sending xmpp starttls: %s", starttls))
+
+		if _, err := io.WriteString(conn, starttls); err != nil {
+			return nil, err
+		}
+
+		if err := xmppExpect(logger, decoder, xmppTLSNamespace, "proceed"); err != nil {
+			return nil, err
+		}
+
+		return conn, nil
+	}
+}
+
+// xmppExpect reads from the XML stream until an element with the given name is
+// started. An error is returned if a stream error or a failure is received
+// instead.
+func xmppExpect(logger log.Logger, decoder *xml.Decoder, space, local string) error {
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return fmt.Errorf("reading xmpp stream: %w", err)
+		}
+		t, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		level.Debug(logger).Log("msg", fmt.Sprintf("read xmpp element: %s %s", t.Name.Space, t.Name.Local))
+
+		if t.Name.Space == space && t.Name.Local == local {
+			return nil
+		}
+		if t.Name.Local == "failure" || (t.Name.Space == xmppStreamsNamespace && t.Name.Local == "error") {
+			return fmt.Errorf("xmpp server returned %s while waiting for %s", t.Name.Local, local)
+		}
+	}
+}
+
+const (
+	rdpNegotiationRequest  = 0x01
+	rdpNegotiationResponse = 0x02
+	rdpNegotiationFailure  = 0x03
+	rdpProtocolSSL         = 0x00000001
+	rdpProtocolHybrid      = 0x00000002
+)
+
+// startTLSRDP sends an X.224 Connection Request that asks for TLS or CredSSP
+// (which also begins with a TLS handshake) security and checks that the
+// server selected one of them in its Connection Confirm
+func startTLSRDP(logger log.Logger, conn net.Conn, serverName string, module config.Module, registry *prometheus.Registry) (net.Conn, error) {
+	// TPKT header, X.224 Connection Request and the RDP Negotiation Request
+	request := []byte{
+		0x03, 0x00, 0x00, 0x13,
+		0x0e, 0xe0, 0x00, 0x00, 0x00, 0x00, 0x00,
+		rdpNegotiationRequest, 0x00, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+	binary.LittleEndian.PutUint32(request[15:19], rdpProtocolSSL|rdpProtocolHybrid)
+
+	level.Debug(logger).Log("msg", fmt.Sprintf("sending rdp connection request: %x", request))
+
+	if _, err := conn.Write(request); err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, fmt.Errorf("reading rdp connection confirm: %w", err)
+	}
+	if header[0] != 0x03 {
+		return nil, fmt.Errorf("unexpected tpkt version %x", header[0])
+	}
+	length := int(binary.BigEndian.Uint16(header[2:4]))
+	if length < 4 {
+		return nil, fmt.Errorf("invalid tpkt length %d", length)
+	}
+	confirm := make([]byte, length-4)
+	if _, err := io.ReadFull(conn, confirm); err != nil {
+		return nil, fmt.Errorf("reading rdp connection confirm: %w", err)
+	}
+
+	level.Debug(logger).Log("msg", fmt.Sprintf("read rdp connection confirm: %x", confirm))
+
+	// The negotiation response follows the 7 byte X.224 Connection Confirm
+	if len(confirm) < 7 || confirm[1]&0xf0 != 0xd0 {
+		return nil, fmt.Errorf("invalid x.224 connection confirm")
+	}
+	if len(confirm) < 15 {
+		return nil, fmt.Errorf("rdp server only supports standard rdp security")
+	}
+	negotiation := confirm[7:15]
+	switch negotiation[0] {
+	case rdpNegotiationResponse:
+		selected := binary.LittleEndian.Uint32(negotiation[4:8])
+		if selected&(rdpProtocolSSL|rdpProtocolHybrid) == 0 {
+			return nil, fmt.Errorf("rdp server selected unsupported protocol %x", selected)
+		}
+		level.Debug(logger).Log("msg", fmt.Sprintf("rdp server selected protocol %x", selected))
+	case rdpNegotiationFailure:
+		return nil, fmt.Errorf("rdp negotiation failed with code %x", binary.LittleEndian.Uint32(negotiation[4:8]))
+	default:
+		return nil, fmt.Errorf("unexpected rdp negotiation type %x", negotiation[0])
+	}
+
+	return conn, nil
+}
+
+const (
+	openvpnControlHardResetClientV2 = 7
+	openvpnControlHardResetServerV2 = 8
+	openvpnControlV1                = 4
+	openvpnAckV1                    = 5
+	openvpnMaxPayload               = 1024
+)
+
+// startTLSOpenVPN performs the hard reset exchange that starts an OpenVPN
+// control channel over TCP. The TLS handshake that follows is carried inside
+// P_CONTROL_V1 packets, so the returned connection wraps and acknowledges the
+// handshake records. Servers that use tls-auth or tls-crypt aren't supported.
+func startTLSOpenVPN(logger log.Logger, conn net.Conn, serverName string, module config.Module, registry *prometheus.Registry) (net.Conn, error) {
+	c := &openvpnConn{Conn: conn}
+	if _, err := rand.Read(c.sessionID[:]); err != nil {
+		return nil, err
+	}
+
+	level.Debug(logger).Log("msg", fmt.Sprintf("sending openvpn P_CONTROL_HARD_RESET_CLIENT_V2 with session id %x", c.sessionID))
+
+	if err := c.writeControl(openvpnControlHardResetClientV2, nil); err != nil {
+		return nil, err
+	}
+
+	for {
+		opcode, packetID, _, err := c.readPacket()
+		if err != nil {
+			return nil, fmt.Errorf("reading openvpn hard reset response: %w", err)
+		}
+		if opcode == openvpnAckV1 {
+			continue
+		}
+		if opcode != openvpnControlHardResetServerV2 {
+			return nil, fmt.Errorf("unexpected openvpn opcode %d, does the server require tls-auth or tls-crypt?", opcode)
+		}
+
+		level.Debug(logger).Log("msg", fmt.Sprintf("read openvpn P_CONTROL_HARD_RESET_SERVER_V2 with session id %x", c.remoteSessionID))
+
+		c.acks = append(c.acks, packetID)
+		c.nextRemoteID = packetID + 1
+
+		return c, nil
+	}
+}
+
+// openvpnConn carries the bytes written and read inside OpenVPN P_CONTROL_V1
+// packets on a TCP connection, acknowledging the packets that it reads
+type openvpnConn struct {
+	net.Conn
+	sessionID       [8]byte
+	remoteSessionID [8]byte
+	packetID        uint32
+	nextRemoteID    uint32
+	acks            []uint32
+	buf             []byte
+}
+
+// Write sends b in as many P_CONTROL_V1 packets as are required
+func (c *openvpnConn) Write(b []byte) (int, error) {
+	n := 0
+	for n < len(b) {
+		size := len(b) - n
+		if size > openvpnMaxPayload {
+			size = openvpnMaxPayload
+		}
+		if err := c.writeControl(openvpnControlV1, b[n:n+size]); err != nil {
+			return n, err
+		}
+		n += size
+	}
+
+	return n, nil
+}
+
+// Read returns the payloads of the P_CONTROL_V1 packets read from the
+// connection
+func (c *openvpnConn) Read(b []byte) (int, error) {
+	for len(c.buf) == 0 {
+		opcode, packetID, payload, err := c.readPacket()
+		if err != nil {
+			return 0, err
+		}
+		if opcode != openvpnControlV1 {
+			continue
+		}
+
+		// Acknowledge every control packet, including retransmissions
+		c.acks = append(c.acks, packetID)
+		if err := c.writeAck(); err != nil {
+			return 0, err
+		}
+		if packetID != c.nextRemoteID {
+			continue
+		}
+		c.nextRemoteID++
+		c.buf = payload
+	}
+	n := copy(b, c.buf)
+	c.buf = c.buf[n:]
+
+	return n, nil
+}
+
+// writeControl writes a control packet with the given opcode and payload, with
+// any pending acknowledgements
+func (c *openvpnConn) writeControl(opcode byte, payload []byte) error {
+	packet := append([]byte{opcode << 3}, c.sessionID[:]...)
+	packet = c.appendAcks(packet)
+	packet = binary.BigEndian.AppendUint32(packet, c.packetID)
+	packet = append(packet, payload...)
+	c.packetID++
+
+	return c.writePacket(packet)
+}
+
+// writeAck writes a P_ACK_V1 packet with the pending acknowledgements
+func (c *openvpnConn) writeAck() error {
+	packet := append([]byte{openvpnAckV1 << 3}, c.sessionID[:]...)
+
+	return c.writePacket(c.appendAcks(packet))
+}
+
+// appendAcks appends the ack array for the pending acknowledgements to packet
+func (c *openvpnConn) appendAcks(packet []byte) []byte {
+	acks := c.acks
+	if len(acks) > 4 {
+		acks = acks[:4]
+	}
+	c.acks = c.acks[len(acks):]
+
+	packet = append(packet, byte(len(acks)))
+	for _, id := range acks {
+		packet = binary.BigEndian.AppendUint32(packet, id)
+	}
+	if len(acks) > 0 {
+		packet = append(packet, c.remoteSessionID[:]...)
+	}
+
+	return packet
+}
+
+// writePacket writes a packet with the length prefix used over TCP
+func (c *openvpnConn) writePacket(packet []byte) error {
+	_, err := c.Conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(packet))), packet...))
+
+	return err
+}
+
+// readPacket reads a packet from the connection and returns its opcode,
+// packet id and payload. Acknowledgements have no packet id or payload.
+func (c *openvpnConn) readPacket() (byte, uint32, []byte, error) {
+	length := make([]byte, 2)
+	if _, err := io.ReadFull(c.Conn, length); err != nil {
+		return 0, 0, nil, err
+	}
+	packet := make([]byte, binary.BigEndian.Uint16(length))
+	if _, err := io.ReadFull(c.Conn, packet); err != nil {
+		return 0, 0, nil, err
+	}
+	if len(packet) < 10 {
+		return 0, 0, nil, fmt.Errorf("openvpn packet is too short")
+	}
+
+	opcode := packet[0] >> 3
+	copy(c.remoteSessionID[:], packet[1:9])
+
+	// Skip the acknowledgements and the session id that follows them
+	acks := int(packet[9])
+	offset := 10 + acks*4
+	if acks > 0 {
+		offset += 8
+	}
+	if opcode == openvpnAckV1 {
+		return opcode, 0, nil, nil
+	}
+	if len(packet) < offset+4 {
+		return 0, 0, nil, fmt.Errorf("openvpn packet is too short")
+	}
+
+	return opcode, binary.BigEndian.Uint32(packet[offset : offset+4]), packet[offset+4:], nil
+}
@@ -9,6 +9,7 @@ import (
 	"io"
 	"net"
 	"regexp"
+	"strings"
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
@@ -18,34 +19,146 @@ import (
 
 // ProbeTCP performs a tcp probe
 func ProbeTCP(ctx context.Context, logger log.Logger, target string, module config.Module, registry *prometheus.Registry) error {
-	tlsConfig, err := newTLSConfig(target, registry, &module.TLSConfig)
+	network, address, tlsTarget := "tcp", target, target
+	if strings.HasPrefix(target, "unix://") {
+		// There isn't a host name in the path of a unix socket, so the
+		// server name must be set in the tls_config
+		network, address, tlsTarget = "unix", strings.TrimPrefix(target, "unix://"), ""
+	}
+
+	tlsConfig, aia, err := newTLSConfig(ctx, tlsTarget, &module.TLSConfig, module.ClockSkewTolerance, registry)
 	if err != nil {
 		return err
 	}
 
-	dialer := &net.Dialer{}
-	conn, err := dialer.DialContext(ctx, "tcp", target)
+	opts := newDialOptions(module)
+	opts.proxyURL = module.TCP.ProxyURL.URL
+	opts.proxyBasicAuth = module.TCP.ProxyBasicAuth
+	opts.socks5Proxy = module.TCP.SOCKS5Proxy
+	opts.proxyProtocol = module.TCP.ProxyProtocol
+	opts.trace = &dialTrace{}
+
+	durations := newPhaseDurationMetrics(registry)
+
+	var echOffered bool
+	if module.ECH && network == "tcp" {
+		echOffered = setupECH(ctx, logger, address, tlsConfig, opts, registry)
+	}
+
+	connect := newPhase(ctx, "connect", module.Timeouts.Connect)
+	dialCtx, cancel := connect.context(ctx)
+	conn, err := dialContext(dialCtx, network, address, opts)
+	cancel()
+	collectDNSMetrics(opts.trace, registry)
+	collectDialPhaseMetrics(durations, opts.trace, connect.elapsed())
 	if err != nil {
-		return err
+		return connect.err(err)
 	}
 	defer conn.Close()
 
+	collectIPProtocolMetrics(conn.RemoteAddr(), registry)
+
 	deadline, _ := ctx.Deadline()
-	if err := conn.SetDeadline(deadline); err != nil {
-		return fmt.Errorf("Error setting deadline")
-	}
 
 	if module.TCP.StartTLS != "" {
-		err = startTLS(logger, conn, module.TCP.StartTLS)
+		starttls := newPhase(ctx, "starttls", module.Timeouts.StartTLS)
+		if err := conn.SetDeadline(starttls.deadline); err != nil {
+			return fmt.Errorf("Error setting deadline")
+		}
+		conn, err = startTLS(logger, conn, module.TCP.StartTLS, tlsConfig.ServerName, module, registry)
+		durations.WithLabelValues(starttls.name).Set(starttls.elapsed().Seconds())
 		if err != nil {
-			return err
+			return starttls.err(err)
 		}
 	}
 
-	tlsConn := tls.Client(conn, tlsConfig)
+	handshake := newPhase(ctx, "handshake", module.Timeouts.Handshake)
+	if err := conn.SetDeadline(handshake.deadline); err != nil {
+		return fmt.Errorf("Error setting deadline")
+	}
+
+	hello := &helloConn{Conn: conn}
+	tlsConn := tls.Client(hello, tlsConfig)
 	defer tlsConn.Close()
 
-	return tlsConn.Handshake()
+	err = tlsConn.Handshake()
+	handshakeDuration := handshake.elapsed()
+	collectTLSHandshakeMetrics(handshakeDuration, registry)
+	durations.WithLabelValues(handshake.name).Set(handshakeDuration.Seconds())
+	if echOffered {
+		collectECHMetrics(err == nil && echAccepted(tlsConn.ConnectionState()), registry)
+	}
+	if err != nil {
+		return handshake.err(err)
+	}
+
+	if err := conn.SetDeadline(deadline); err != nil {
+		return fmt.Errorf("Error setting deadline")
+	}
+
+	state := aia.fill(tlsConn.ConnectionState())
+	collectCurveMetrics(state, registry)
+	collectServerHelloMetrics(hello.serverHello(), state.Version, registry)
+	if err := collectPolicyMetrics(module.Policy, []uint16{state.Version}, []uint16{state.CipherSuite}, state.PeerCertificates, registry); err != nil {
+		return err
+	}
+
+	if module.DANE {
+		collectDANEMetrics(ctx, logger, address, tlsConfig.ServerName, state, opts, registry)
+	}
+
+	if module.CAA {
+		collectCAAMetrics(ctx, logger, address, state.PeerCertificates[0], module.CAAIssuers, opts, registry)
+	}
+
+	if module.SCT {
+		collectSCTMetrics(logger, state, module.CTLogs, module.CTLogListFile, registry)
+	}
+
+	if len(module.TrustStores) > 0 {
+		collectTrustStoreMetrics(logger, module.TrustStores, state, tlsConfig.ServerName, registry)
+	}
+
+	if len(module.ExpectedDNSNames) > 0 {
+		collectExpectedNamesMetrics(module.ExpectedDNSNames, state.PeerCertificates[0], registry)
+	}
+
+	if len(module.ExpectedFingerprints) > 0 {
+		collectFingerprintMetrics(module.ExpectedFingerprints, state.PeerCertificates[0], registry)
+	}
+
+	if len(module.ExpectedSPKIHashes) > 0 {
+		collectSPKIPinMetrics(module.ExpectedSPKIHashes, state, registry)
+	}
+
+	if module.ExpectedIssuer.Regexp != nil {
+		collectExpectedIssuerMetrics(module.ExpectedIssuer.Regexp, state.PeerCertificates[0], registry)
+	}
+
+	if module.ExpectedSerial != "" || module.ExpectedAuthorityKeyID != "" {
+		collectSerialMetrics(module.ExpectedSerial, module.ExpectedAuthorityKeyID, state.PeerCertificates[0], registry)
+	}
+
+	collectNotYetValidMetrics(state.PeerCertificates, module.ClockSkewTolerance, registry)
+
+	if module.CertChanges {
+		collectCertChangeMetrics(module.Name, target, state, registry)
+	}
+
+	if len(module.ExpiryThresholds) > 0 {
+		collectExpiryThresholdMetrics(state.PeerCertificates, module.ExpiryThresholds, registry)
+	}
+
+	// The STARTTLS exchange isn't repeated for the resumption check
+	if module.SessionResumption && module.TCP.StartTLS == "" {
+		collectSessionResumptionMetrics(ctx, logger, network, address, tlsConfig, module, opts, registry)
+	}
+
+	if module.TCP.Protocol != "" {
+		return checkProtocol(logger, tlsConn, tlsConfig.ServerName, module, registry)
+	}
+
+	return nil
 }
 
 type queryResponse struct {
@@ -53,6 +166,9 @@ type queryResponse struct {
 	send        string
 	sendBytes   []byte
 	expectBytes []byte
+	// fail is a regex that fails the exchange if it matches a line read
+	// whilst waiting for the expect regex, which is checked first
+	fail string
 }
 
 var (
@@ -63,23 +179,6 @@ var (
 	// See openssl s_client for more examples:
 	//  https://github.com/openssl/openssl/blob/openssl-3.0.0-alpha3/apps/s_client.c#L2229-L2728
 	startTLSqueryResponses = map[string][]queryResponse{
-		"smtp": []queryResponse{
-			queryResponse{
-				expect: "^220",
-			},
-			queryResponse{
-				send: "EHLO prober",
-			},
-			queryResponse{
-				expect: "^250(-| )STARTTLS",
-			},
-			queryResponse{
-				send: "STARTTLS",
-			},
-			queryResponse{
-				expect: "^220",
-			},
-		},
 		"ftp": []queryResponse{
 			queryResponse{
 				expect: "^220",
@@ -111,6 +210,33 @@ var (
 				expect: "OK",
 			},
 		},
+		"irc": []queryResponse{
+			queryResponse{
+				send: "STARTTLS",
+			},
+			queryResponse{
+				expect: "^:\\S+ 670 ",
+				fail:   "^:\\S+ 691 ",
+			},
+		},
+		"nats": []queryResponse{
+			queryResponse{
+				expect: `^INFO .*"tls_(required|available)":\s*true`,
+				fail:   "^INFO ",
+			},
+		},
+		"nntp": []queryResponse{
+			queryResponse{
+				expect: "^20[01]",
+			},
+			queryResponse{
+				send: "STARTTLS",
+			},
+			queryResponse{
+				expect: "^382",
+				fail:   "^[45]",
+			},
+		},
 		"postgres": []queryResponse{
 			queryResponse{
 				sendBytes: []byte{0x00, 0x00, 0x00, 0x08, 0x04, 0xd2, 0x16, 0x2f},
@@ -121,27 +247,39 @@ var (
 		},
 		"pop3": []queryResponse{
 			queryResponse{
-				expect: "OK",
+				expect: "^\\+OK",
 			},
 			queryResponse{
 				send: "STLS",
 			},
 			queryResponse{
-				expect: "OK",
+				expect: "^\\+OK",
+				fail:   "^-ERR",
 			},
 		},
 	}
 )
 
-// startTLS will send the STARTTLS command for the given protocol
-func startTLS(logger log.Logger, conn net.Conn, proto string) error {
-	var err error
+// startTLS will send the STARTTLS command for the given protocol. It returns
+// the connection that the TLS handshake should be performed over.
+func startTLS(logger log.Logger, conn net.Conn, proto, serverName string, module config.Module, registry *prometheus.Registry) (net.Conn, error) {
+	if fn, ok := startTLSFuncs[proto]; ok {
+		return fn(logger, conn, serverName, module, registry)
+	}
 
 	qr, ok := startTLSqueryResponses[proto]
 	if !ok {
-		return fmt.Errorf("STARTTLS is not supported for %s", proto)
+		return nil, fmt.Errorf("STARTTLS is not supported for %s", proto)
 	}
 
+	return conn, queryResponses(logger, conn, qr)
+}
+
+// queryResponses performs the given exchange of lines and bytes with the
+// server
+func queryResponses(logger log.Logger, conn net.Conn, qr []queryResponse) error {
+	var err error
+
 	scanner := bufio.NewScanner(conn)
 	for _, qr := range qr {
 		if qr.expect != "" {
@@ -156,6 +294,15 @@ func startTLS(logger log.Logger, conn net.Conn, proto string) error {
 					level.Debug(logger).Log("msg", fmt.Sprintf("regex: %s matched: %s", qr.expect, scanner.Text()))
 					break
 				}
+				if qr.fail != "" {
+					failed, err := regexp.Match(qr.fail, scanner.Bytes())
+					if err != nil {
+						return err
+					}
+					if failed {
+						return fmt.Errorf("regex: %s matched: %s", qr.fail, scanner.Text())
+					}
+				}
 			}
 			if scanner.Err() != nil {
 				return scanner.Err()
@@ -168,7 +315,7 @@ func startTLS(logger log.Logger, conn net.Conn, proto string) error {
 			buffer := make([]byte, len(qr.expectBytes))
 			_, err = io.ReadFull(conn, buffer)
 			if err != nil {
-				return nil
+				return err
 			}
 			level.Debug(logger).Log("msg", fmt.Sprintf("read bytes: %x", buffer))
 			if bytes.Compare(buffer, qr.expectBytes) != 0 {
@@ -3,20 +3,44 @@ package prober
 import (
 	"bytes"
 	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
 	"crypto/tls"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
 	"math/big"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/ribbybibby/ssl_exporter/v2/config"
 	"github.com/ribbybibby/ssl_exporter/v2/test"
 	"golang.org/x/crypto/ocsp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"gopkg.in/yaml.v3"
 
 	"github.com/prometheus/client_golang/prometheus"
+	pconfig "github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
 )
 
 // TestProbeTCP tests the typical case
@@ -53,6 +77,709 @@ func TestProbeTCP(t *testing.T) {
 	checkCertificateMetrics(cert, registry, t)
 	checkOCSPMetrics([]byte{}, registry, t)
 	checkTLSVersionMetrics("TLS 1.3", registry, t)
+	checkTLSHandshakeMetrics(registry, t)
+	checkPhaseDurationMetrics([]string{"connect", "handshake"}, registry, t)
+	checkIPProtocolMetrics(4, registry, t)
+}
+
+// TestProbeTCPCipherSuite tests that the negotiated cipher suite is reported
+func TestProbeTCPExtendedMasterSecret(t *testing.T) {
+	for _, version := range []uint16{tls.VersionTLS12, tls.VersionTLS13} {
+		t.Run(tlsVersionName(version), func(t *testing.T) {
+			server, _, _, caFile, teardown, err := test.SetupTCPServer()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer teardown()
+
+			server.TLS.MinVersion = version
+			server.TLS.MaxVersion = version
+
+			server.StartTLS()
+			defer server.Close()
+
+			module := config.Module{
+				TLSConfig: config.TLSConfig{
+					CAFile: caFile,
+				},
+			}
+
+			registry := prometheus.NewRegistry()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+				t.Fatalf("error: %s", err)
+			}
+
+			checkExtendedMasterSecretMetrics(1, registry, t)
+		})
+	}
+}
+
+func TestProbeTCPJA3S(t *testing.T) {
+	server, _, _, caFile, teardown, err := test.SetupTCPServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	server.StartTLS()
+	defer server.Close()
+
+	module := config.Module{
+		TLSConfig: config.TLSConfig{
+			CAFile: caFile,
+		},
+	}
+
+	registry := prometheus.NewRegistry()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() != "ssl_ja3s_info" {
+			continue
+		}
+		if len(mf.GetMetric()) != 1 {
+			t.Fatalf("expected one ssl_ja3s_info series but got %d", len(mf.GetMetric()))
+		}
+		if fingerprint := mf.GetMetric()[0].GetLabel()[0].GetValue(); !regexp.MustCompile("^[0-9a-f]{32}$").MatchString(fingerprint) {
+			t.Errorf("unexpected JA3S fingerprint %s", fingerprint)
+		}
+		return
+	}
+	t.Errorf("ssl_ja3s_info wasn't reported")
+}
+
+func TestProbeTCPSecureRenegotiation(t *testing.T) {
+	testcases := []struct {
+		name     string
+		version  uint16
+		reported bool
+	}{
+		{name: "tls12", version: tls.VersionTLS12, reported: true},
+		{name: "tls13", version: tls.VersionTLS13, reported: false},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			server, _, _, caFile, teardown, err := test.SetupTCPServer()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer teardown()
+
+			server.TLS.MinVersion = tc.version
+			server.TLS.MaxVersion = tc.version
+
+			server.StartTLS()
+			defer server.Close()
+
+			module := config.Module{
+				TLSConfig: config.TLSConfig{
+					CAFile: caFile,
+				},
+			}
+
+			registry := prometheus.NewRegistry()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+				t.Fatalf("error: %s", err)
+			}
+
+			if tc.reported {
+				checkSecureRenegotiationMetrics(1, registry, t)
+				return
+			}
+
+			mfs, err := registry.Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, mf := range mfs {
+				if mf.GetName() == "ssl_secure_renegotiation_supported" {
+					t.Errorf("ssl_secure_renegotiation_supported shouldn't be reported for TLS 1.3")
+				}
+			}
+		})
+	}
+}
+
+func TestProbeTCPCipherSuite(t *testing.T) {
+	server, _, _, caFile, teardown, err := test.SetupTCPServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	server.TLS.MinVersion = tls.VersionTLS12
+	server.TLS.MaxVersion = tls.VersionTLS12
+	server.TLS.CipherSuites = []uint16{tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384}
+
+	server.StartTLS()
+	defer server.Close()
+
+	module := config.Module{
+		TLSConfig: config.TLSConfig{
+			CAFile:             caFile,
+			InsecureSkipVerify: false,
+		},
+	}
+
+	registry := prometheus.NewRegistry()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	checkTLSVersionMetrics("TLS 1.2", registry, t)
+	checkCipherSuiteMetrics("TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384", registry, t)
+}
+
+// TestProbeTCPPolicy tests that the negotiated connection is checked against
+// the policy
+func TestProbeTCPPolicy(t *testing.T) {
+	server, _, _, caFile, teardown, err := test.SetupTCPServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	server.TLS.MinVersion = tls.VersionTLS12
+	server.TLS.MaxVersion = tls.VersionTLS12
+	server.TLS.CipherSuites = []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA}
+
+	server.StartTLS()
+	defer server.Close()
+
+	module := config.Module{
+		TLSConfig: config.TLSConfig{
+			CAFile: caFile,
+		},
+		Policy: config.Policy{
+			MinVersion:         tls.VersionTLS13,
+			Deny3DES:           true,
+			DenyRC4:            true,
+			DenyCBC:            true,
+			DenyRSAKeyExchange: true,
+			MinRSABits:         3072,
+			MinECDSABits:       256,
+		},
+	}
+
+	registry := prometheus.NewRegistry()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	checkPolicyMetrics(map[string]bool{
+		"min_version":      true,
+		"3des":             false,
+		"rc4":              false,
+		"cbc":              true,
+		"rsa_key_exchange": false,
+		"min_rsa_bits":     true,
+		"min_ecdsa_bits":   false,
+	}, registry, t)
+}
+
+// TestProbeTCPPolicyMaxLifetime tests that a leaf that is valid for longer
+// than the maximum lifetime violates the policy
+func TestProbeTCPPolicyMaxLifetime(t *testing.T) {
+	testcases := []struct {
+		name        string
+		maxLifetime model.Duration
+		violated    bool
+	}{
+		{name: "within", maxLifetime: model.Duration(398 * 24 * time.Hour)},
+		{name: "exceeded", maxLifetime: model.Duration(time.Hour), violated: true},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			server, _, _, caFile, teardown, err := test.SetupTCPServer()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer teardown()
+
+			server.StartTLS()
+			defer server.Close()
+
+			module := config.Module{
+				TLSConfig: config.TLSConfig{
+					CAFile: caFile,
+				},
+				Policy: config.Policy{
+					MaxLifetime: tc.maxLifetime,
+				},
+			}
+
+			registry := prometheus.NewRegistry()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+				t.Fatalf("error: %s", err)
+			}
+
+			checkPolicyMetrics(map[string]bool{"max_lifetime": tc.violated}, registry, t)
+		})
+	}
+}
+
+// TestProbeTCPPolicyRules tests that the certificates are checked against the
+// expressions of the policy rules
+func TestProbeTCPPolicyRules(t *testing.T) {
+	server, _, _, caFile, teardown, err := test.SetupTCPServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	server.StartTLS()
+	defer server.Close()
+
+	module := config.Module{
+		TLSConfig: config.TLSConfig{
+			CAFile: caFile,
+		},
+		Policy: config.Policy{
+			Rules: []config.PolicyRule{
+				{Name: "max_lifetime", Expression: `cert.lifetime <= duration("2160h")`},
+				{Name: "no_wildcards", Expression: `!cert.dns_names.exists(n, n.startsWith("*."))`},
+				{Name: "server_auth", Expression: `"serverAuth" in cert.ext_key_usages`},
+				{Name: "issuer", Expression: `cert.issuer_common_name == "R3"`},
+				{Name: "key_size", Expression: `chain.all(c, c.key_algorithm != "RSA" || c.key_bits >= 3072)`},
+				{Name: "expires_after_30d", Expression: `cert.not_after - now > duration("720h")`},
+			},
+		},
+	}
+
+	registry := prometheus.NewRegistry()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	checkPolicyMetrics(map[string]bool{
+		"max_lifetime":      false,
+		"no_wildcards":      false,
+		"server_auth":       false,
+		"issuer":            true,
+		"key_size":          true,
+		"expires_after_30d": true,
+	}, registry, t)
+}
+
+// TestProbeTCPPolicyRulesInvalid tests that a policy rule with an invalid
+// expression can't be loaded from the config, unless it's only invalid for the
+// certificates that it's evaluated with, and that the probe fails when it's
+// evaluated
+func TestProbeTCPPolicyRulesInvalid(t *testing.T) {
+	testcases := []struct {
+		name       string
+		expression string
+		loads      bool
+	}{
+		{name: "syntax", expression: `cert.dns_names.exists(`},
+		{name: "not a bool", expression: `cert.common_name`},
+		{name: "unknown field", expression: `cert.unknown == "x"`, loads: true},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := yaml.Marshal(map[string]string{"name": "invalid", "expression": tc.expression})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var rule config.PolicyRule
+			err = yaml.Unmarshal(data, &rule)
+			if !tc.loads && err == nil {
+				t.Fatalf("expected error loading the rule, but err was nil")
+			}
+			if tc.loads && err != nil {
+				t.Fatalf("error loading the rule: %s", err)
+			}
+
+			server, _, _, caFile, teardown, err := test.SetupTCPServer()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer teardown()
+
+			server.StartTLS()
+			defer server.Close()
+
+			module := config.Module{
+				TLSConfig: config.TLSConfig{
+					CAFile: caFile,
+				},
+				Policy: config.Policy{
+					Rules: []config.PolicyRule{
+						{Name: "invalid", Expression: tc.expression},
+					},
+				},
+			}
+
+			registry := prometheus.NewRegistry()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err == nil {
+				t.Fatalf("expected error, but err was nil")
+			}
+		})
+	}
+}
+
+// TestProbeTCPCurve tests that the negotiated key exchange group is reported
+func TestProbeTCPCurve(t *testing.T) {
+	if !curveSupported {
+		t.Skip("the key exchange group isn't available in the connection state")
+	}
+
+	testCases := []struct {
+		name     string
+		version  uint16
+		curve    tls.CurveID
+		label    string
+		pqHybrid float64
+	}{
+		{"tls12", tls.VersionTLS12, tls.CurveP384, "CurveP384", 0},
+		{"tls13", tls.VersionTLS13, tls.X25519, "X25519", 0},
+		// X25519MLKEM768, which isn't defined by every version of
+		// crypto/tls, is offered by default
+		{"tls13 pq hybrid", tls.VersionTLS13, tls.CurveID(0x11ec), "X25519MLKEM768", 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server, _, _, caFile, teardown, err := test.SetupTCPServer()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer teardown()
+
+			server.TLS.MinVersion = tc.version
+			server.TLS.MaxVersion = tc.version
+			server.TLS.CurvePreferences = []tls.CurveID{tc.curve}
+
+			server.StartTLS()
+			defer server.Close()
+
+			module := config.Module{
+				TLSConfig: config.TLSConfig{
+					CAFile: caFile,
+				},
+			}
+
+			registry := prometheus.NewRegistry()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+				t.Fatalf("error: %s", err)
+			}
+
+			checkCurveMetrics(tc.label, registry, t)
+			checkPQHybridMetrics(tc.pqHybrid, registry, t)
+		})
+	}
+}
+
+// TestProbeTCPVersion tests that the handshake is limited to the configured
+// TLS versions
+func TestProbeTCPVersion(t *testing.T) {
+	testCases := []struct {
+		name          string
+		serverVersion uint16
+		minVersion    uint16
+		maxVersion    uint16
+		expectVersion string
+	}{
+		{"max_version", tls.VersionTLS13, 0, tls.VersionTLS12, ""},
+		{"min_version", tls.VersionTLS12, tls.VersionTLS13, 0, ""},
+		{"min_and_max_version", tls.VersionTLS12, tls.VersionTLS12, tls.VersionTLS12, "TLS 1.2"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server, _, _, caFile, teardown, err := test.SetupTCPServer()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer teardown()
+
+			server.TLS.MinVersion = tc.serverVersion
+			server.TLS.MaxVersion = tc.serverVersion
+
+			server.StartTLS()
+			defer server.Close()
+
+			module := config.Module{
+				TLSConfig: config.TLSConfig{
+					CAFile:     caFile,
+					MinVersion: pconfig.TLSVersion(tc.minVersion),
+					MaxVersion: pconfig.TLSVersion(tc.maxVersion),
+				},
+			}
+
+			registry := prometheus.NewRegistry()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			err = ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry)
+			if tc.expectVersion == "" {
+				if err == nil {
+					t.Fatalf("expected error but err was nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: %s", err)
+			}
+
+			checkTLSVersionMetrics(tc.expectVersion, registry, t)
+		})
+	}
+}
+
+// TestProbeTCPCipherSuites tests that only the configured cipher suites are
+// offered in the handshake
+func TestProbeTCPClientHelloProfile(t *testing.T) {
+	testCases := []struct {
+		name       string
+		profile    string
+		tls13      bool
+		expectErr  bool
+		expectALPN string
+	}{
+		{name: "chrome tls13", profile: "chrome", tls13: true, expectALPN: "h2"},
+		{name: "chrome legacy", profile: "chrome", expectErr: true},
+		{name: "firefox legacy", profile: "firefox", expectErr: true},
+		{name: "java8 tls13", profile: "java8", tls13: true, expectErr: true},
+		{name: "java8 legacy", profile: "java8"},
+		{name: "openssl1.0 legacy", profile: "openssl1.0"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server, _, _, caFile, teardown, err := test.SetupTCPServer()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer teardown()
+
+			// The legacy server only supports a CBC suite that browsers
+			// don't offer
+			server.TLS.NextProtos = []string{"h2", "http/1.1"}
+			if !tc.tls13 {
+				server.TLS.MinVersion = tls.VersionTLS12
+				server.TLS.MaxVersion = tls.VersionTLS12
+				server.TLS.CipherSuites = []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256}
+			}
+
+			server.StartTLS()
+			defer server.Close()
+
+			module := config.Module{
+				TLSConfig: config.TLSConfig{
+					CAFile:             caFile,
+					ClientHelloProfile: config.ClientHelloProfile(tc.profile),
+				},
+			}
+
+			registry := prometheus.NewRegistry()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			err = ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry)
+			if tc.expectErr {
+				if err == nil {
+					t.Fatalf("expected error but err was nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: %s", err)
+			}
+
+			if tc.expectALPN != "" {
+				checkALPNMetrics(tc.expectALPN, registry, t)
+			}
+		})
+	}
+}
+
+func TestProbeTCPCipherSuites(t *testing.T) {
+	testCases := []struct {
+		name         string
+		cipherSuites []uint16
+		expectErr    bool
+	}{
+		{"supported", []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA, tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384}, false},
+		{"unsupported", []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server, _, _, caFile, teardown, err := test.SetupTCPServer()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer teardown()
+
+			server.TLS.MinVersion = tls.VersionTLS12
+			server.TLS.MaxVersion = tls.VersionTLS12
+			server.TLS.CipherSuites = []uint16{tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384}
+
+			server.StartTLS()
+			defer server.Close()
+
+			module := config.Module{
+				TLSConfig: config.TLSConfig{
+					CAFile:       caFile,
+					CipherSuites: tc.cipherSuites,
+				},
+			}
+
+			registry := prometheus.NewRegistry()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			err = ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry)
+			if tc.expectErr {
+				if err == nil {
+					t.Fatalf("expected error but err was nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: %s", err)
+			}
+
+			checkCipherSuiteMetrics("TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384", registry, t)
+		})
+	}
+}
+
+// TestProbeTCPCurvePreferences tests that only the configured key exchange
+// groups are offered in the handshake
+func TestProbeTCPCurvePreferences(t *testing.T) {
+	testCases := []struct {
+		name      string
+		curves    []tls.CurveID
+		expectErr bool
+	}{
+		{"supported", []tls.CurveID{tls.CurveP256, tls.CurveP384}, false},
+		{"unsupported", []tls.CurveID{tls.CurveP256}, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server, _, _, caFile, teardown, err := test.SetupTCPServer()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer teardown()
+
+			server.TLS.CurvePreferences = []tls.CurveID{tls.CurveP384}
+
+			server.StartTLS()
+			defer server.Close()
+
+			module := config.Module{
+				TLSConfig: config.TLSConfig{
+					CAFile:           caFile,
+					CurvePreferences: tc.curves,
+				},
+			}
+
+			registry := prometheus.NewRegistry()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			err = ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry)
+			if tc.expectErr {
+				if err == nil {
+					t.Fatalf("expected error but err was nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: %s", err)
+			}
+
+			if curveSupported {
+				checkCurveMetrics("CurveP384", registry, t)
+			}
+		})
+	}
+}
+
+// TestProbeTCPALPN tests that the configured ALPN protocols are offered and
+// the selected protocol is reported
+func TestProbeTCPALPN(t *testing.T) {
+	server, _, _, caFile, teardown, err := test.SetupTCPServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	server.TLS.NextProtos = []string{"x-custom"}
+
+	server.StartTLS()
+	defer server.Close()
+
+	module := config.Module{
+		TLSConfig: config.TLSConfig{
+			CAFile:        caFile,
+			ALPNProtocols: []string{"x-other", "x-custom"},
+		},
+	}
+
+	registry := prometheus.NewRegistry()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	checkALPNMetrics("x-custom", registry, t)
 }
 
 // TestProbeTCPInvalidName tests hitting the server on an address which isn't
@@ -244,6 +971,7 @@ func TestProbeTCPStartTLSSMTP(t *testing.T) {
 	checkCertificateMetrics(cert, registry, t)
 	checkOCSPMetrics([]byte{}, registry, t)
 	checkTLSVersionMetrics("TLS 1.3", registry, t)
+	checkPhaseDurationMetrics([]string{"connect", "starttls", "handshake"}, registry, t)
 }
 
 // TestProbeTCPStartTLSSMTPWithDashInResponse tests STARTTLS against a mock SMTP server
@@ -286,24 +1014,29 @@ func TestProbeTCPStartTLSSMTPWithDashInResponse(t *testing.T) {
 	checkTLSVersionMetrics("TLS 1.3", registry, t)
 }
 
-// TestProbeTCPStartTLSFTP tests STARTTLS against a mock FTP server
-func TestProbeTCPStartTLSFTP(t *testing.T) {
+// TestProbeTCPStartTLSSMTPCapabilities tests STARTTLS with a configured
+// EHLO name and required capabilities
+func TestProbeTCPStartTLSSMTPCapabilities(t *testing.T) {
 	server, certPEM, _, caFile, teardown, err := test.SetupTCPServer()
 	if err != nil {
 		t.Fatalf(err.Error())
 	}
 	defer teardown()
 
-	server.StartFTP()
+	server.StartSMTPWithEHLOName("probe.example.com")
 	defer server.Close()
 
 	module := config.Module{
 		TCP: config.TCPProbe{
-			StartTLS: "ftp",
+			StartTLS: "smtp",
+			SMTP: config.SMTPConfig{
+				EHLOName:            "probe.example.com",
+				RequireCapabilities: []string{"AUTH", "size"},
+				ForbidCapabilities:  []string{"PIPELINING"},
+			},
 		},
 		TLSConfig: config.TLSConfig{
-			CAFile:             caFile,
-			InsecureSkipVerify: false,
+			CAFile: caFile,
 		},
 	}
 
@@ -321,24 +1054,88 @@ func TestProbeTCPStartTLSFTP(t *testing.T) {
 		t.Fatal(err)
 	}
 	checkCertificateMetrics(cert, registry, t)
-	checkOCSPMetrics([]byte{}, registry, t)
 	checkTLSVersionMetrics("TLS 1.3", registry, t)
-}
 
-// TestProbeTCPStartTLSIMAP tests STARTTLS against a mock IMAP server
-func TestProbeTCPStartTLSIMAP(t *testing.T) {
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, capability := range []string{"SIZE", "AUTH", "STARTTLS"} {
+		checkRegistryResults([]*registryResult{
+			&registryResult{
+				Name: "ssl_smtp_capability_info",
+				LabelValues: map[string]string{
+					"capability": capability,
+				},
+				Value: 1,
+			},
+		}, mfs, t)
+	}
+}
+
+// TestProbeTCPStartTLSSMTPForbiddenCapability tests that STARTTLS fails when
+// a forbidden capability is advertised
+func TestProbeTCPStartTLSSMTPForbiddenCapability(t *testing.T) {
+	server, _, _, caFile, teardown, err := test.SetupTCPServer()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer teardown()
+
+	server.StartSMTPWithEHLOName("prober")
+	defer server.Close()
+
+	module := config.Module{
+		TCP: config.TCPProbe{
+			StartTLS: "smtp",
+			SMTP: config.SMTPConfig{
+				ForbidCapabilities: []string{"AUTH"},
+			},
+		},
+		TLSConfig: config.TLSConfig{
+			CAFile: caFile,
+		},
+	}
+
+	registry := prometheus.NewRegistry()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err == nil {
+		t.Fatalf("expected error, but err was nil")
+	}
+
+	// The capabilities that failed the check are still reported
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRegistryResults([]*registryResult{
+		&registryResult{
+			Name: "ssl_smtp_capability_info",
+			LabelValues: map[string]string{
+				"capability": "AUTH",
+			},
+			Value: 1,
+		},
+	}, mfs, t)
+}
+
+// TestProbeTCPStartTLSFTP tests STARTTLS against a mock FTP server
+func TestProbeTCPStartTLSFTP(t *testing.T) {
 	server, certPEM, _, caFile, teardown, err := test.SetupTCPServer()
 	if err != nil {
 		t.Fatalf(err.Error())
 	}
 	defer teardown()
 
-	server.StartIMAP()
+	server.StartFTP()
 	defer server.Close()
 
 	module := config.Module{
 		TCP: config.TCPProbe{
-			StartTLS: "imap",
+			StartTLS: "ftp",
 		},
 		TLSConfig: config.TLSConfig{
 			CAFile:             caFile,
@@ -364,20 +1161,20 @@ func TestProbeTCPStartTLSIMAP(t *testing.T) {
 	checkTLSVersionMetrics("TLS 1.3", registry, t)
 }
 
-// TestProbeTCPStartTLSPOP3 tests STARTTLS against a mock POP3 server
-func TestProbeTCPStartTLSPOP3(t *testing.T) {
+// TestProbeTCPStartTLSIMAP tests STARTTLS against a mock IMAP server
+func TestProbeTCPStartTLSIMAP(t *testing.T) {
 	server, certPEM, _, caFile, teardown, err := test.SetupTCPServer()
 	if err != nil {
 		t.Fatalf(err.Error())
 	}
 	defer teardown()
 
-	server.StartPOP3()
+	server.StartIMAP()
 	defer server.Close()
 
 	module := config.Module{
 		TCP: config.TCPProbe{
-			StartTLS: "pop3",
+			StartTLS: "imap",
 		},
 		TLSConfig: config.TLSConfig{
 			CAFile:             caFile,
@@ -403,20 +1200,20 @@ func TestProbeTCPStartTLSPOP3(t *testing.T) {
 	checkTLSVersionMetrics("TLS 1.3", registry, t)
 }
 
-// TestProbeTCPStartTLSPostgreSQL tests STARTTLS against a mock PostgreSQL server
-func TestProbeTCPStartTLSPostgreSQL(t *testing.T) {
+// TestProbeTCPStartTLSPOP3 tests STARTTLS against a mock POP3 server
+func TestProbeTCPStartTLSPOP3(t *testing.T) {
 	server, certPEM, _, caFile, teardown, err := test.SetupTCPServer()
 	if err != nil {
 		t.Fatalf(err.Error())
 	}
 	defer teardown()
 
-	server.StartPostgreSQL()
+	server.StartPOP3()
 	defer server.Close()
 
 	module := config.Module{
 		TCP: config.TCPProbe{
-			StartTLS: "postgres",
+			StartTLS: "pop3",
 		},
 		TLSConfig: config.TLSConfig{
 			CAFile:             caFile,
@@ -442,19 +1239,22 @@ func TestProbeTCPStartTLSPostgreSQL(t *testing.T) {
 	checkTLSVersionMetrics("TLS 1.3", registry, t)
 }
 
-// TestProbeTCPTimeout tests that the TCP probe respects the timeout in the
-// context
-func TestProbeTCPTimeout(t *testing.T) {
+// TestProbeTCPStartTLSPOP3NoSTLS tests that the probe fails when a POP3
+// server refuses the STLS command
+func TestProbeTCPStartTLSPOP3NoSTLS(t *testing.T) {
 	server, _, _, caFile, teardown, err := test.SetupTCPServer()
 	if err != nil {
-		t.Fatal(err)
+		t.Fatalf(err.Error())
 	}
 	defer teardown()
 
-	server.StartTLSWait(time.Second * 3)
+	server.StartPOP3NoSTLS()
 	defer server.Close()
 
 	module := config.Module{
+		TCP: config.TCPProbe{
+			StartTLS: "pop3",
+		},
 		TLSConfig: config.TLSConfig{
 			CAFile:             caFile,
 			InsecureSkipVerify: false,
@@ -463,41 +1263,107 @@ func TestProbeTCPTimeout(t *testing.T) {
 
 	registry := prometheus.NewRegistry()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
 	if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err == nil {
-		t.Fatalf("Expected error but returned error was nil")
+		t.Fatalf("expected error but err was nil")
 	}
 }
 
-// TestProbeTCPOCSP tests a TCP probe with OCSP stapling
-func TestProbeTCPOCSP(t *testing.T) {
-	server, certPEM, keyPEM, caFile, teardown, err := test.SetupTCPServer()
+// TestProbeTCPStartTLSIRC tests STARTTLS against a mock IRC server
+func TestProbeTCPStartTLSIRC(t *testing.T) {
+	server, certPEM, _, caFile, teardown, err := test.SetupTCPServer()
 	if err != nil {
-		t.Fatal(err)
+		t.Fatalf(err.Error())
 	}
 	defer teardown()
 
+	server.StartIRC()
+	defer server.Close()
+
+	module := config.Module{
+		TCP: config.TCPProbe{
+			StartTLS: "irc",
+		},
+		TLSConfig: config.TLSConfig{
+			CAFile:             caFile,
+			InsecureSkipVerify: false,
+		},
+	}
+
+	registry := prometheus.NewRegistry()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
 	cert, err := newCertificate(certPEM)
 	if err != nil {
 		t.Fatal(err)
 	}
-	key, err := newKey(keyPEM)
+	checkCertificateMetrics(cert, registry, t)
+	checkOCSPMetrics([]byte{}, registry, t)
+	checkTLSVersionMetrics("TLS 1.3", registry, t)
+}
+
+// TestProbeTCPStartTLSNATS tests STARTTLS against a mock NATS server
+func TestProbeTCPStartTLSNATS(t *testing.T) {
+	server, certPEM, _, caFile, teardown, err := test.SetupTCPServer()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer teardown()
+
+	server.StartNATS()
+	defer server.Close()
+
+	module := config.Module{
+		TCP: config.TCPProbe{
+			StartTLS: "nats",
+		},
+		TLSConfig: config.TLSConfig{
+			CAFile:             caFile,
+			InsecureSkipVerify: false,
+		},
+	}
+
+	registry := prometheus.NewRegistry()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	cert, err := newCertificate(certPEM)
 	if err != nil {
 		t.Fatal(err)
 	}
+	checkCertificateMetrics(cert, registry, t)
+	checkOCSPMetrics([]byte{}, registry, t)
+	checkTLSVersionMetrics("TLS 1.3", registry, t)
+}
 
-	resp, err := ocsp.CreateResponse(cert, cert, ocsp.Response{SerialNumber: big.NewInt(64), Status: 1}, key)
+// TestProbeTCPStartTLSNNTP tests STARTTLS against a mock NNTP server
+func TestProbeTCPStartTLSNNTP(t *testing.T) {
+	server, certPEM, _, caFile, teardown, err := test.SetupTCPServer()
 	if err != nil {
 		t.Fatalf(err.Error())
 	}
-	server.TLS.Certificates[0].OCSPStaple = resp
+	defer teardown()
 
-	server.StartTLS()
+	server.StartNNTP()
 	defer server.Close()
 
 	module := config.Module{
+		TCP: config.TCPProbe{
+			StartTLS: "nntp",
+		},
 		TLSConfig: config.TLSConfig{
 			CAFile:             caFile,
 			InsecureSkipVerify: false,
@@ -513,75 +1379,104 @@ func TestProbeTCPOCSP(t *testing.T) {
 		t.Fatalf("error: %s", err)
 	}
 
+	cert, err := newCertificate(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
 	checkCertificateMetrics(cert, registry, t)
-	checkOCSPMetrics(resp, registry, t)
+	checkOCSPMetrics([]byte{}, registry, t)
 	checkTLSVersionMetrics("TLS 1.3", registry, t)
 }
 
-// TestProbeTCPVerifiedChains tests the verified chain metrics returned by a tcp
-// probe
-func TestProbeTCPVerifiedChains(t *testing.T) {
-	rootPrivateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+// TestProbeTCPStartTLSPostgreSQL tests STARTTLS against a mock PostgreSQL server
+func TestProbeTCPStartTLSPostgreSQL(t *testing.T) {
+	server, certPEM, _, caFile, teardown, err := test.SetupTCPServer()
 	if err != nil {
 		t.Fatalf(err.Error())
 	}
+	defer teardown()
 
-	rootCertExpiry := time.Now().AddDate(0, 0, 5)
-	rootCertTmpl := test.GenerateCertificateTemplate(rootCertExpiry)
-	rootCertTmpl.IsCA = true
-	rootCertTmpl.SerialNumber = big.NewInt(1)
-	rootCert, rootCertPem := test.GenerateSelfSignedCertificateWithPrivateKey(rootCertTmpl, rootPrivateKey)
+	server.StartPostgreSQL()
+	defer server.Close()
 
-	olderRootCertExpiry := time.Now().AddDate(0, 0, 3)
-	olderRootCertTmpl := test.GenerateCertificateTemplate(olderRootCertExpiry)
-	olderRootCertTmpl.IsCA = true
-	olderRootCertTmpl.SerialNumber = big.NewInt(2)
-	olderRootCert, olderRootCertPem := test.GenerateSelfSignedCertificateWithPrivateKey(olderRootCertTmpl, rootPrivateKey)
+	module := config.Module{
+		TCP: config.TCPProbe{
+			StartTLS: "postgres",
+		},
+		TLSConfig: config.TLSConfig{
+			CAFile:             caFile,
+			InsecureSkipVerify: false,
+		},
+	}
 
-	oldestRootCertExpiry := time.Now().AddDate(0, 0, 1)
-	oldestRootCertTmpl := test.GenerateCertificateTemplate(oldestRootCertExpiry)
-	oldestRootCertTmpl.IsCA = true
-	oldestRootCertTmpl.SerialNumber = big.NewInt(3)
-	oldestRootCert, oldestRootCertPem := test.GenerateSelfSignedCertificateWithPrivateKey(oldestRootCertTmpl, rootPrivateKey)
+	registry := prometheus.NewRegistry()
 
-	serverCertExpiry := time.Now().AddDate(0, 0, 4)
-	serverCertTmpl := test.GenerateCertificateTemplate(serverCertExpiry)
-	serverCertTmpl.SerialNumber = big.NewInt(4)
-	serverCert, serverCertPem, serverKey := test.GenerateSignedCertificate(serverCertTmpl, olderRootCert, rootPrivateKey)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
-	verifiedChains := [][]*x509.Certificate{
-		[]*x509.Certificate{
-			serverCert,
-			rootCert,
-		},
-		[]*x509.Certificate{
-			serverCert,
-			olderRootCert,
+	if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	cert, err := newCertificate(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCertificateMetrics(cert, registry, t)
+	checkOCSPMetrics([]byte{}, registry, t)
+	checkTLSVersionMetrics("TLS 1.3", registry, t)
+}
+
+// TestProbeTCPStartTLSPostgreSQLNoSSL tests that the probe fails when a
+// PostgreSQL server refuses the SSLRequest
+func TestProbeTCPStartTLSPostgreSQLNoSSL(t *testing.T) {
+	server, _, _, caFile, teardown, err := test.SetupTCPServer()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer teardown()
+
+	server.StartPostgreSQLNoSSL()
+	defer server.Close()
+
+	module := config.Module{
+		TCP: config.TCPProbe{
+			StartTLS: "postgres",
 		},
-		[]*x509.Certificate{
-			serverCert,
-			oldestRootCert,
+		TLSConfig: config.TLSConfig{
+			CAFile:             caFile,
+			InsecureSkipVerify: false,
 		},
 	}
 
-	caCertPem := bytes.Join([][]byte{oldestRootCertPem, olderRootCertPem, rootCertPem}, []byte(""))
+	registry := prometheus.NewRegistry()
 
-	server, caFile, teardown, err := test.SetupTCPServerWithCertAndKey(
-		caCertPem,
-		serverCertPem,
-		serverKey,
-	)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err == nil {
+		t.Fatalf("expected error but err was nil")
+	}
+}
+
+// TestProbeTCPStartTLSMySQL tests STARTTLS against a mock MySQL server
+func TestProbeTCPStartTLSMySQL(t *testing.T) {
+	server, certPEM, _, caFile, teardown, err := test.SetupTCPServer()
 	if err != nil {
 		t.Fatalf(err.Error())
 	}
 	defer teardown()
 
-	server.StartTLS()
+	server.StartMySQL()
 	defer server.Close()
 
 	module := config.Module{
+		TCP: config.TCPProbe{
+			StartTLS: "mysql",
+		},
 		TLSConfig: config.TLSConfig{
-			CAFile: caFile,
+			CAFile:             caFile,
+			InsecureSkipVerify: false,
 		},
 	}
 
@@ -594,8 +1489,2949 @@ func TestProbeTCPVerifiedChains(t *testing.T) {
 		t.Fatalf("error: %s", err)
 	}
 
-	checkCertificateMetrics(serverCert, registry, t)
-	checkOCSPMetrics([]byte{}, registry, t)
-	checkVerifiedChainMetrics(verifiedChains, registry, t)
-	checkTLSVersionMetrics("TLS 1.3", registry, t)
+	cert, err := newCertificate(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCertificateMetrics(cert, registry, t)
+	checkOCSPMetrics([]byte{}, registry, t)
+	checkTLSVersionMetrics("TLS 1.3", registry, t)
+}
+
+// TestMySQLError tests parsing the ERR packets that a MySQL server sends in
+// place of the initial handshake
+func TestMySQLError(t *testing.T) {
+	testcases := []struct {
+		name    string
+		payload []byte
+		err     string
+	}{
+		{
+			name:    "without sql state",
+			payload: append([]byte{0xff, 0x10, 0x04}, "Too many connections"...),
+			err:     "mysql server returned error 1040: Too many connections",
+		},
+		{
+			name:    "with sql state",
+			payload: append([]byte{0xff, 0x15, 0x04}, "#28000Access denied"...),
+			err:     "mysql server returned error 1045: Access denied",
+		},
+		{
+			name:    "truncated",
+			payload: []byte{0xff, 0x10},
+			err:     "mysql server returned error",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := mysqlError(tc.payload); err.Error() != tc.err {
+				t.Errorf("expected error %q, got %q", tc.err, err)
+			}
+		})
+	}
+}
+
+// TestProbeTCPStartTLSMSSQL tests STARTTLS against a mock MSSQL server
+func TestProbeTCPStartTLSMSSQL(t *testing.T) {
+	server, certPEM, _, caFile, teardown, err := test.SetupTCPServer()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer teardown()
+
+	server.StartMSSQL()
+	defer server.Close()
+
+	module := config.Module{
+		TCP: config.TCPProbe{
+			StartTLS: "mssql",
+		},
+		TLSConfig: config.TLSConfig{
+			CAFile:             caFile,
+			InsecureSkipVerify: false,
+		},
+	}
+
+	registry := prometheus.NewRegistry()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	cert, err := newCertificate(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCertificateMetrics(cert, registry, t)
+	checkOCSPMetrics([]byte{}, registry, t)
+	checkTLSVersionMetrics("TLS 1.3", registry, t)
+}
+
+// TestProbeTCPStartTLSOpenVPN tests STARTTLS against a mock OpenVPN server
+func TestProbeTCPStartTLSOpenVPN(t *testing.T) {
+	server, certPEM, _, caFile, teardown, err := test.SetupTCPServer()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer teardown()
+
+	server.StartOpenVPN()
+	defer server.Close()
+
+	module := config.Module{
+		TCP: config.TCPProbe{
+			StartTLS: "openvpn",
+		},
+		TLSConfig: config.TLSConfig{
+			CAFile:             caFile,
+			InsecureSkipVerify: false,
+		},
+	}
+
+	registry := prometheus.NewRegistry()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	cert, err := newCertificate(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCertificateMetrics(cert, registry, t)
+	checkOCSPMetrics([]byte{}, registry, t)
+	checkTLSVersionMetrics("TLS 1.3", registry, t)
+}
+
+// TestProbeTCPStartTLSLDAP tests STARTTLS against a mock LDAP server
+func TestProbeTCPStartTLSLDAP(t *testing.T) {
+	server, certPEM, _, caFile, teardown, err := test.SetupTCPServer()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer teardown()
+
+	server.StartLDAP()
+	defer server.Close()
+
+	module := config.Module{
+		TCP: config.TCPProbe{
+			StartTLS: "ldap",
+		},
+		TLSConfig: config.TLSConfig{
+			CAFile:             caFile,
+			InsecureSkipVerify: false,
+		},
+	}
+
+	registry := prometheus.NewRegistry()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	cert, err := newCertificate(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCertificateMetrics(cert, registry, t)
+	checkOCSPMetrics([]byte{}, registry, t)
+	checkTLSVersionMetrics("TLS 1.3", registry, t)
+}
+
+// TestProbeTCPStartTLSXMPP tests STARTTLS against a mock XMPP server
+func TestProbeTCPStartTLSXMPP(t *testing.T) {
+	server, certPEM, _, caFile, teardown, err := test.SetupTCPServer()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer teardown()
+
+	server.StartXMPP()
+	defer server.Close()
+
+	module := config.Module{
+		TCP: config.TCPProbe{
+			StartTLS: "xmpp",
+		},
+		TLSConfig: config.TLSConfig{
+			CAFile:             caFile,
+			InsecureSkipVerify: false,
+		},
+	}
+
+	registry := prometheus.NewRegistry()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	cert, err := newCertificate(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCertificateMetrics(cert, registry, t)
+	checkOCSPMetrics([]byte{}, registry, t)
+	checkTLSVersionMetrics("TLS 1.3", registry, t)
+}
+
+// TestProbeTCPStartTLSRDP tests STARTTLS against a mock RDP server
+func TestProbeTCPStartTLSRDP(t *testing.T) {
+	server, certPEM, _, caFile, teardown, err := test.SetupTCPServer()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer teardown()
+
+	server.StartRDP()
+	defer server.Close()
+
+	module := config.Module{
+		TCP: config.TCPProbe{
+			StartTLS: "rdp",
+		},
+		TLSConfig: config.TLSConfig{
+			CAFile:             caFile,
+			InsecureSkipVerify: false,
+		},
+	}
+
+	registry := prometheus.NewRegistry()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	cert, err := newCertificate(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCertificateMetrics(cert, registry, t)
+	checkOCSPMetrics([]byte{}, registry, t)
+	checkTLSVersionMetrics("TLS 1.3", registry, t)
+}
+
+// TestProbeTCPTimeout tests that the TCP probe respects the timeout in the
+// context
+func TestProbeTCPTimeout(t *testing.T) {
+	server, _, _, caFile, teardown, err := test.SetupTCPServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	server.StartTLSWait(time.Second * 3)
+	defer server.Close()
+
+	module := config.Module{
+		TLSConfig: config.TLSConfig{
+			CAFile:             caFile,
+			InsecureSkipVerify: false,
+		},
+	}
+
+	registry := prometheus.NewRegistry()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err == nil {
+		t.Fatalf("Expected error but returned error was nil")
+	}
+}
+
+// TestProbeTCPPhaseTimeouts tests that the STARTTLS and handshake timeouts fail
+// the probe before the timeout of the module
+func TestProbeTCPPhaseTimeouts(t *testing.T) {
+	testCases := []struct {
+		name     string
+		startTLS string
+		timeouts config.Timeouts
+		expected string
+	}{
+		{
+			name:     "starttls",
+			startTLS: "smtp",
+			timeouts: config.Timeouts{StartTLS: 200 * time.Millisecond},
+			expected: "starttls timeout of 200ms exceeded",
+		},
+		{
+			name:     "handshake",
+			timeouts: config.Timeouts{Handshake: 200 * time.Millisecond},
+			expected: "handshake timeout of 200ms exceeded",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server, _, _, caFile, teardown, err := test.SetupTCPServer()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer teardown()
+
+			server.StartTLSWait(time.Second)
+			defer server.Close()
+
+			module := config.Module{
+				Timeouts: tc.timeouts,
+				TCP: config.TCPProbe{
+					StartTLS: tc.startTLS,
+				},
+				TLSConfig: config.TLSConfig{
+					CAFile: caFile,
+				},
+			}
+
+			registry := prometheus.NewRegistry()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			err = ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry)
+			if err == nil {
+				t.Fatalf("Expected error but returned error was nil")
+			}
+			if !strings.Contains(err.Error(), tc.expected) {
+				t.Fatalf("Expected error %q but got %q", tc.expected, err)
+			}
+		})
+	}
+}
+
+// TestProbeTCPOCSP tests a TCP probe with OCSP stapling
+func TestProbeTCPOCSP(t *testing.T) {
+	server, certPEM, keyPEM, caFile, teardown, err := test.SetupTCPServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	cert, err := newCertificate(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := newKey(keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := ocsp.CreateResponse(cert, cert, ocsp.Response{SerialNumber: big.NewInt(64), Status: 1}, key)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	server.TLS.Certificates[0].OCSPStaple = resp
+
+	server.StartTLS()
+	defer server.Close()
+
+	module := config.Module{
+		TLSConfig: config.TLSConfig{
+			CAFile:             caFile,
+			InsecureSkipVerify: false,
+		},
+	}
+
+	registry := prometheus.NewRegistry()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	checkCertificateMetrics(cert, registry, t)
+	checkOCSPMetrics(resp, registry, t)
+	checkTLSVersionMetrics("TLS 1.3", registry, t)
+}
+
+// TestProbeTCPOCSPMustStaple tests that a must-staple certificate without a
+// stapled OCSP response is reported
+func TestProbeTCPOCSPMustStaple(t *testing.T) {
+	testcases := []struct {
+		name       string
+		mustStaple bool
+		staple     bool
+		missing    float64
+	}{
+		{name: "must staple stapled", mustStaple: true, staple: true, missing: 0},
+		{name: "must staple not stapled", mustStaple: true, staple: false, missing: 1},
+		{name: "not must staple", mustStaple: false, staple: false, missing: 0},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+			if err != nil {
+				t.Fatal(err)
+			}
+			keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)})
+
+			template := test.GenerateCertificateTemplate(time.Now().AddDate(0, 0, 1))
+			template.IsCA = true
+			if tc.mustStaple {
+				features, err := asn1.Marshal([]int{5})
+				if err != nil {
+					t.Fatal(err)
+				}
+				template.ExtraExtensions = []pkix.Extension{
+					{Id: asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 24}, Value: features},
+				}
+			}
+			cert, certPEM := test.GenerateSelfSignedCertificateWithPrivateKey(template, privateKey)
+
+			server, caFile, teardown, err := test.SetupTCPServerWithCertAndKey(certPEM, certPEM, keyPEM)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer teardown()
+
+			if tc.staple {
+				resp, err := ocsp.CreateResponse(cert, cert, ocsp.Response{SerialNumber: cert.SerialNumber, Status: ocsp.Good}, privateKey)
+				if err != nil {
+					t.Fatal(err)
+				}
+				server.TLS.Certificates[0].OCSPStaple = resp
+			}
+
+			server.StartTLS()
+			defer server.Close()
+
+			module := config.Module{
+				TLSConfig: config.TLSConfig{
+					CAFile: caFile,
+				},
+			}
+
+			registry := prometheus.NewRegistry()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+				t.Fatalf("error: %s", err)
+			}
+
+			var mustStaple float64
+			if tc.mustStaple {
+				mustStaple = 1
+			}
+			checkOCSPMustStapleMetrics(mustStaple, tc.missing, registry, t)
+		})
+	}
+}
+
+// TestProbeTCPSCT tests the SCT metrics returned by a tcp probe, for SCTs that
+// are embedded in the certificate and sent in the TLS extension
+func TestProbeTCPSCT(t *testing.T) {
+	ctLog, err := test.NewCTLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	logList, err := ctLog.LogList("Test Operator", "Test Log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logListFile, err := test.WriteFile("ct_log_list.json", logList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(logListFile)
+
+	otherLog, err := test.NewCTLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	logKey, err := x509.MarshalPKIXPublicKey(&ctLog.Key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logID := sha256.Sum256(logKey)
+	otherLogKey, err := x509.MarshalPKIXPublicKey(&otherLog.Key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherLogID := sha256.Sum256(otherLogKey)
+
+	testcases := []struct {
+		name     string
+		embedded bool
+		log      *test.CTLog
+		valid    float64
+		sctInfo  map[string]string
+	}{
+		{
+			name:  "tls extension",
+			log:   ctLog,
+			valid: 1,
+			sctInfo: map[string]string{
+				"source":   "tls_extension",
+				"log_id":   base64.StdEncoding.EncodeToString(logID[:]),
+				"log":      "Test Log",
+				"operator": "Test Operator",
+			},
+		},
+		{
+			name:     "embedded",
+			embedded: true,
+			log:      ctLog,
+			valid:    1,
+			sctInfo: map[string]string{
+				"source":   "certificate",
+				"log_id":   base64.StdEncoding.EncodeToString(logID[:]),
+				"log":      "Test Log",
+				"operator": "Test Operator",
+			},
+		},
+		{
+			name:  "unknown log",
+			log:   otherLog,
+			valid: 0,
+			sctInfo: map[string]string{
+				"source":   "tls_extension",
+				"log_id":   base64.StdEncoding.EncodeToString(otherLogID[:]),
+				"log":      "",
+				"operator": "",
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			caKey, err := rsa.GenerateKey(rand.Reader, 2048)
+			if err != nil {
+				t.Fatal(err)
+			}
+			caTemplate := test.GenerateCertificateTemplate(time.Now().AddDate(0, 0, 1))
+			caTemplate.IsCA = true
+			caTemplate.SerialNumber = big.NewInt(1)
+			ca, caPEM := test.GenerateSelfSignedCertificateWithPrivateKey(caTemplate, caKey)
+
+			key, err := rsa.GenerateKey(rand.Reader, 2048)
+			if err != nil {
+				t.Fatal(err)
+			}
+			keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+			template := test.GenerateCertificateTemplate(time.Now().AddDate(0, 0, 1))
+			template.SubjectKeyId = []byte{2}
+			der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var tlsSCTs [][]byte
+			if tc.embedded {
+				// The precertificate is the certificate without the
+				// SCT list extension
+				precert, err := x509.ParseCertificate(der)
+				if err != nil {
+					t.Fatal(err)
+				}
+				sct, err := tc.log.SignPrecertificate(precert.RawTBSCertificate, ca)
+				if err != nil {
+					t.Fatal(err)
+				}
+				ext, err := test.SCTListExtension(sct)
+				if err != nil {
+					t.Fatal(err)
+				}
+				template.ExtraExtensions = []pkix.Extension{ext}
+				der, err = x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+				if err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				sct, err := tc.log.SignCertificate(der)
+				if err != nil {
+					t.Fatal(err)
+				}
+				tlsSCTs = [][]byte{sct}
+			}
+			certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+
+			server, caFile, teardown, err := test.SetupTCPServerWithCertAndKey(caPEM, certPEM, keyPEM)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer teardown()
+			server.TLS.Certificates[0].SignedCertificateTimestamps = tlsSCTs
+
+			server.StartTLS()
+			defer server.Close()
+
+			module := config.Module{
+				TLSConfig: config.TLSConfig{
+					CAFile: caFile,
+				},
+				SCT:           true,
+				CTLogListFile: logListFile,
+			}
+
+			registry := prometheus.NewRegistry()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+				t.Fatalf("error: %s", err)
+			}
+
+			checkSCTMetrics(tc.valid, tc.sctInfo, tc.valid, registry, t)
+		})
+	}
+}
+
+// TestLoadCTLogList tests loading the bundled log list and log list files
+func TestLoadCTLogList(t *testing.T) {
+	ctLog, err := test.NewCTLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	logList, err := ctLog.LogList("Test Operator", "Test Log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logListFile, err := test.WriteFile("ct_log_list.json", logList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(logListFile)
+
+	invalidFile, err := test.WriteFile("invalid_ct_log_list.json", []byte("not a log list"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(invalidFile)
+
+	if _, err := config.LoadCTLogList(""); err != nil {
+		t.Fatalf("error loading the bundled log list: %s", err)
+	}
+
+	logs, err := config.LoadCTLogList(logListFile)
+	if err != nil {
+		t.Fatalf("error loading the log list file: %s", err)
+	}
+	if len(logs) != 1 {
+		t.Fatalf("expected one log, got %d", len(logs))
+	}
+
+	for _, file := range []string{invalidFile, invalidFile + ".missing"} {
+		if _, err := config.LoadCTLogList(file); err == nil {
+			t.Fatalf("expected error loading %s, but err was nil", file)
+		}
+	}
+}
+
+// TestProbeTCPAIAFetch tests that a tcp probe completes the chain presented by
+// the target with the intermediate at the caIssuers URL of the leaf, and that
+// the completed chain is checked against the SPKI pins
+func TestProbeTCPAIAFetch(t *testing.T) {
+	var intermediateDER []byte
+	aiaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/pkix-cert")
+		w.Write(intermediateDER)
+	}))
+	defer aiaServer.Close()
+
+	chain := newTestChain(t, aiaServer.URL+"/intermediate.der")
+	intermediateDER = chain.intermediate.Raw
+
+	testcases := []struct {
+		name       string
+		chain      []byte
+		aiaFetch   bool
+		incomplete float64
+		shouldFail bool
+	}{
+		{name: "incomplete", chain: chain.leafPEM, aiaFetch: true, incomplete: 1},
+		{name: "complete", chain: chain.pem(chain.leafPEM, chain.intermediatePEM), aiaFetch: true, incomplete: 0},
+		{name: "disabled", chain: chain.leafPEM, aiaFetch: false, shouldFail: true},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			server, caFile, teardown, err := test.SetupTCPServerWithCertAndKey(chain.rootPEM, tc.chain, chain.keyPEM)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer teardown()
+
+			server.StartTLS()
+			defer server.Close()
+
+			// The root is only in the chain verified with AIA
+			sum := sha256.Sum256(chain.root.RawSubjectPublicKeyInfo)
+			pin := base64.StdEncoding.EncodeToString(sum[:])
+
+			module := config.Module{
+				TLSConfig: config.TLSConfig{
+					CAFile:   caFile,
+					AIAFetch: tc.aiaFetch,
+				},
+				ExpectedSPKIHashes: []string{pin},
+			}
+
+			registry := prometheus.NewRegistry()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			err = ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry)
+			if tc.shouldFail {
+				if err == nil {
+					t.Fatalf("expected error but err was nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: %s", err)
+			}
+
+			checkAIAMetrics(tc.incomplete, 1, registry, t)
+			checkVerifiedChainMetrics([][]*x509.Certificate{{chain.leaf, chain.intermediate, chain.root}}, registry, t)
+			checkSPKIPinMetrics(1, map[string]float64{pin: 1}, registry, t)
+		})
+	}
+}
+
+// TestProbeTCPChain tests the metrics for the order and completeness of the
+// chain presented to a tcp probe
+func TestProbeTCPChain(t *testing.T) {
+	var intermediateDER []byte
+	aiaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(intermediateDER)
+	}))
+	defer aiaServer.Close()
+
+	chain := newTestChain(t, aiaServer.URL+"/intermediate.der")
+	intermediateDER = chain.intermediate.Raw
+
+	testcases := []struct {
+		name         string
+		chain        []byte
+		missing      float64
+		outOfOrder   float64
+		rootIncluded float64
+	}{
+		{name: "complete", chain: chain.pem(chain.leafPEM, chain.intermediatePEM)},
+		{name: "missing intermediate", chain: chain.leafPEM, missing: 1},
+		{name: "out of order", chain: chain.pem(chain.leafPEM, chain.rootPEM, chain.intermediatePEM), outOfOrder: 1, rootIncluded: 1},
+		{name: "root included", chain: chain.pem(chain.leafPEM, chain.intermediatePEM, chain.rootPEM), rootIncluded: 1},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			server, caFile, teardown, err := test.SetupTCPServerWithCertAndKey(chain.rootPEM, tc.chain, chain.keyPEM)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer teardown()
+
+			server.StartTLS()
+			defer server.Close()
+
+			module := config.Module{
+				TLSConfig: config.TLSConfig{
+					CAFile:   caFile,
+					AIAFetch: true,
+				},
+			}
+
+			registry := prometheus.NewRegistry()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+				t.Fatalf("error: %s", err)
+			}
+
+			checkChainMetrics(tc.missing, tc.outOfOrder, tc.rootIncluded, registry, t)
+		})
+	}
+}
+
+// TestProbeTCPSelfSigned tests that a self-signed leaf is detected and that a
+// leaf issued by a CA isn't
+func TestProbeTCPSelfSigned(t *testing.T) {
+	chain := newTestChain(t, "")
+	selfSignedPEM, selfSignedKeyPEM := test.GenerateTestCertificate(time.Now().AddDate(0, 0, 1))
+
+	testcases := []struct {
+		name       string
+		caPEM      []byte
+		certPEM    []byte
+		keyPEM     []byte
+		selfSigned float64
+	}{
+		{name: "issued", caPEM: chain.rootPEM, certPEM: chain.pem(chain.leafPEM, chain.intermediatePEM), keyPEM: chain.keyPEM},
+		{name: "self-signed", caPEM: selfSignedPEM, certPEM: selfSignedPEM, keyPEM: selfSignedKeyPEM, selfSigned: 1},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			server, caFile, teardown, err := test.SetupTCPServerWithCertAndKey(tc.caPEM, tc.certPEM, tc.keyPEM)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer teardown()
+
+			server.StartTLS()
+			defer server.Close()
+
+			module := config.Module{
+				TLSConfig: config.TLSConfig{
+					CAFile: caFile,
+				},
+			}
+
+			registry := prometheus.NewRegistry()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+				t.Fatalf("error: %s", err)
+			}
+
+			checkSelfSignedMetrics(tc.selfSigned, registry, t)
+		})
+	}
+}
+
+// TestProbeTCPLeafIssued tests the time that the leaf was issued and its age
+func TestProbeTCPLeafIssued(t *testing.T) {
+	server, certPEM, _, caFile, teardown, err := test.SetupTCPServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	server.StartTLS()
+	defer server.Close()
+
+	module := config.Module{
+		TLSConfig: config.TLSConfig{
+			CAFile: caFile,
+		},
+	}
+
+	registry := prometheus.NewRegistry()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	block, _ := pem.Decode(certPEM)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkLeafIssuedMetrics(cert, registry, t)
+}
+
+// TestProbeTCPChainExpired tests a target that presents an expired cross-sign
+// of the root, which is trusted, so that the chain is verified without it
+func TestProbeTCPChainExpired(t *testing.T) {
+	chain := newTestChain(t, "")
+
+	oldRootKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldRootTemplate := test.GenerateCertificateTemplate(time.Now().AddDate(0, 0, 1))
+	oldRootTemplate.IsCA = true
+	oldRootTemplate.SerialNumber = big.NewInt(4)
+	oldRootTemplate.SubjectKeyId = []byte{4}
+	oldRootTemplate.Subject.CommonName = "old root"
+	oldRoot, _ := test.GenerateSelfSignedCertificateWithPrivateKey(oldRootTemplate, oldRootKey)
+
+	crossSignTemplate := test.GenerateCertificateTemplate(time.Now().AddDate(0, 0, -1))
+	crossSignTemplate.NotBefore = time.Now().AddDate(0, 0, -2)
+	crossSignTemplate.IsCA = true
+	crossSignTemplate.SerialNumber = big.NewInt(5)
+	crossSignTemplate.Subject = chain.root.Subject
+	crossSignTemplate.SubjectKeyId = chain.root.SubjectKeyId
+	crossSignDER, err := x509.CreateCertificate(rand.Reader, crossSignTemplate, oldRoot, chain.root.PublicKey, oldRootKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crossSign, err := x509.ParseCertificate(crossSignDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crossSignPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: crossSignDER})
+
+	server, caFile, teardown, err := test.SetupTCPServerWithCertAndKey(chain.rootPEM, chain.pem(chain.leafPEM, chain.intermediatePEM, crossSignPEM), chain.keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	server.StartTLS()
+	defer server.Close()
+
+	module := config.Module{
+		TLSConfig: config.TLSConfig{
+			CAFile: caFile,
+		},
+	}
+
+	registry := prometheus.NewRegistry()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	checkChainCertMetrics(1, []*x509.Certificate{chain.leaf, chain.intermediate, crossSign}, []bool{true, true, false}, registry, t)
+}
+
+// TestProbeTCPTrustStores tests verifying the chain against trust stores that
+// do and don't contain its root
+func TestProbeTCPTrustStores(t *testing.T) {
+	chain := newTestChain(t, "")
+
+	jksFile, err := test.WriteFile("cacerts", test.JavaKeyStore(chain.root))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(jksFile)
+
+	otherRootPEM, _ := test.GenerateTestCertificate(time.Now().AddDate(0, 0, 1))
+	otherFile, err := test.WriteFile("other.pem", otherRootPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(otherFile)
+
+	server, caFile, teardown, err := test.SetupTCPServerWithCertAndKey(chain.rootPEM, chain.pem(chain.leafPEM, chain.intermediatePEM), chain.keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	server.StartTLS()
+	defer server.Close()
+
+	module := config.Module{
+		TLSConfig: config.TLSConfig{
+			CAFile: caFile,
+		},
+		TrustStores: []config.TrustStore{
+			{Name: "pem", CAFile: caFile},
+			{Name: "java", CAFile: jksFile},
+			{Name: "other", CAFile: otherFile},
+		},
+	}
+
+	registry := prometheus.NewRegistry()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	checkTrustStoreMetrics(map[string]float64{"pem": 1, "java": 1, "other": 0}, registry, t)
+}
+
+// TestProbeTCPTrustStoresInvalid tests that a trust store with a CA file that
+// is missing or doesn't contain a certificate isn't loaded
+func TestProbeTCPTrustStoresInvalid(t *testing.T) {
+	emptyFile, err := test.WriteFile("empty.pem", []byte("not a certificate"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(emptyFile)
+
+	testcases := []struct {
+		name   string
+		caFile string
+	}{
+		{name: "missing", caFile: emptyFile + ".missing"},
+		{name: "empty", caFile: emptyFile},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := yaml.Marshal(map[string]string{"name": tc.name, "ca_file": tc.caFile})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var store config.TrustStore
+			if err := yaml.Unmarshal(data, &store); err == nil {
+				t.Fatalf("expected error loading the trust store, but err was nil")
+			}
+		})
+	}
+}
+
+// TestProbeTCPExpectedDNSNames tests that the certificate is checked against
+// the expected DNS names
+func TestProbeTCPExpectedDNSNames(t *testing.T) {
+	testcases := []struct {
+		name    string
+		names   []string
+		covers  float64
+		covered map[string]float64
+	}{
+		{
+			name:    "covered",
+			names:   []string{"example.ribbybibby.me", "example-2.ribbybibby.me", "127.0.0.1"},
+			covers:  1,
+			covered: map[string]float64{"example.ribbybibby.me": 1, "example-2.ribbybibby.me": 1, "127.0.0.1": 1},
+		},
+		{
+			name:    "missing",
+			names:   []string{"example.ribbybibby.me", "other.ribbybibby.me"},
+			covered: map[string]float64{"example.ribbybibby.me": 1, "other.ribbybibby.me": 0},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			server, _, _, caFile, teardown, err := test.SetupTCPServer()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer teardown()
+
+			server.StartTLS()
+			defer server.Close()
+
+			module := config.Module{
+				TLSConfig: config.TLSConfig{
+					CAFile: caFile,
+				},
+				ExpectedDNSNames: tc.names,
+			}
+
+			registry := prometheus.NewRegistry()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+				t.Fatalf("error: %s", err)
+			}
+
+			checkExpectedNamesMetrics(tc.covers, tc.covered, registry, t)
+		})
+	}
+}
+
+// TestProbeTCPExpectedFingerprints tests that the fingerprint of the
+// certificate is checked against the expected fingerprints
+func TestProbeTCPExpectedFingerprints(t *testing.T) {
+	certPEM, keyPEM := test.GenerateTestCertificate(time.Now().AddDate(0, 0, 1))
+	block, _ := pem.Decode(certPEM)
+	sum := sha256.Sum256(block.Bytes)
+	var colons []string
+	for _, b := range sum {
+		colons = append(colons, fmt.Sprintf("%02X", b))
+	}
+
+	testcases := []struct {
+		name         string
+		fingerprints []string
+		match        float64
+	}{
+		{name: "hex", fingerprints: []string{hex.EncodeToString(sum[:])}, match: 1},
+		{name: "colons", fingerprints: []string{strings.Repeat("00", 32), strings.Join(colons, ":")}, match: 1},
+		{name: "mismatch", fingerprints: []string{strings.Repeat("00", 32)}},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			server, caFile, teardown, err := test.SetupTCPServerWithCertAndKey(certPEM, certPEM, keyPEM)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer teardown()
+
+			server.StartTLS()
+			defer server.Close()
+
+			module := config.Module{
+				TLSConfig: config.TLSConfig{
+					CAFile: caFile,
+				},
+				ExpectedFingerprints: tc.fingerprints,
+			}
+
+			registry := prometheus.NewRegistry()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+				t.Fatalf("error: %s", err)
+			}
+
+			checkFingerprintMetrics(tc.match, registry, t)
+		})
+	}
+}
+
+// TestProbeTCPExpectedSPKIHashes tests that the public keys of the chain are
+// checked against the expected SPKI hashes, including the root that the target
+// doesn't present
+func TestProbeTCPExpectedSPKIHashes(t *testing.T) {
+	chain := newTestChain(t, "")
+
+	spki := func(cert *x509.Certificate) string {
+		sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		return base64.StdEncoding.EncodeToString(sum[:])
+	}
+	other := base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))
+
+	testcases := []struct {
+		name    string
+		pins    []string
+		valid   float64
+		matches map[string]float64
+	}{
+		{
+			name:    "leaf",
+			pins:    []string{spki(chain.leaf)},
+			valid:   1,
+			matches: map[string]float64{spki(chain.leaf): 1},
+		},
+		{
+			name:    "root",
+			pins:    []string{other, "sha256/" + spki(chain.root)},
+			valid:   1,
+			matches: map[string]float64{other: 0, "sha256/" + spki(chain.root): 1},
+		},
+		{
+			name:    "mismatch",
+			pins:    []string{other},
+			matches: map[string]float64{other: 0},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			server, caFile, teardown, err := test.SetupTCPServerWithCertAndKey(chain.rootPEM, chain.pem(chain.leafPEM, chain.intermediatePEM), chain.keyPEM)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer teardown()
+
+			server.StartTLS()
+			defer server.Close()
+
+			module := config.Module{
+				TLSConfig: config.TLSConfig{
+					CAFile: caFile,
+				},
+				ExpectedSPKIHashes: tc.pins,
+			}
+
+			registry := prometheus.NewRegistry()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+				t.Fatalf("error: %s", err)
+			}
+
+			checkSPKIPinMetrics(tc.valid, tc.matches, registry, t)
+		})
+	}
+}
+
+// TestProbeTCPExpectedIssuer tests that the issuer of the certificate is
+// matched against the expected issuer
+func TestProbeTCPExpectedIssuer(t *testing.T) {
+	chain := newTestChain(t, "")
+
+	testcases := []struct {
+		name   string
+		issuer string
+		match  float64
+	}{
+		{name: "common name", issuer: "intermediate", match: 1},
+		{name: "distinguished name", issuer: "CN=intermediate,.*,O=ribbybibby", match: 1},
+		{name: "partial", issuer: "inter"},
+		{name: "mismatch", issuer: "R[0-9]+"},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			server, caFile, teardown, err := test.SetupTCPServerWithCertAndKey(chain.rootPEM, chain.pem(chain.leafPEM, chain.intermediatePEM), chain.keyPEM)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer teardown()
+
+			server.StartTLS()
+			defer server.Close()
+
+			module := config.Module{
+				TLSConfig: config.TLSConfig{
+					CAFile: caFile,
+				},
+				ExpectedIssuer: config.Regexp{Regexp: regexp.MustCompile("^(?:" + tc.issuer + ")$")},
+			}
+
+			registry := prometheus.NewRegistry()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+				t.Fatalf("error: %s", err)
+			}
+
+			checkIssuerMatchMetrics(tc.match, registry, t)
+		})
+	}
+}
+
+// TestProbeTCPExpectedSerial tests that the serial number and authority key
+// identifier of the certificate are checked against the expected values
+func TestProbeTCPExpectedSerial(t *testing.T) {
+	chain := newTestChain(t, "")
+
+	testcases := []struct {
+		name                 string
+		serial               string
+		authorityKeyID       string
+		serialMatch, akMatch float64
+	}{
+		{name: "match", serial: chain.leaf.SerialNumber.String(), authorityKeyID: strings.ToUpper(hex.EncodeToString(chain.leaf.AuthorityKeyId)), serialMatch: 1, akMatch: 1},
+		{name: "reissued", serial: "2", authorityKeyID: hex.EncodeToString(chain.leaf.AuthorityKeyId), akMatch: 1},
+		{name: "other issuer", serial: chain.leaf.SerialNumber.String(), authorityKeyID: "01", serialMatch: 1},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			server, caFile, teardown, err := test.SetupTCPServerWithCertAndKey(chain.rootPEM, chain.pem(chain.leafPEM, chain.intermediatePEM), chain.keyPEM)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer teardown()
+
+			server.StartTLS()
+			defer server.Close()
+
+			module := config.Module{
+				TLSConfig: config.TLSConfig{
+					CAFile: caFile,
+				},
+				ExpectedSerial:         tc.serial,
+				ExpectedAuthorityKeyID: tc.authorityKeyID,
+			}
+
+			registry := prometheus.NewRegistry()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+				t.Fatalf("error: %s", err)
+			}
+
+			checkSerialMatchMetrics(tc.serialMatch, tc.akMatch, registry, t)
+		})
+	}
+}
+
+// TestProbeTCPKeyInfo tests the metrics that describe the public key of the
+// certificate
+func TestProbeTCPKeyInfo(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 3072)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testcases := []struct {
+		name      string
+		key       crypto.Signer
+		algorithm string
+		bits      string
+		curve     string
+	}{
+		{name: "rsa", key: rsaKey, algorithm: "RSA", bits: "3072"},
+		{name: "ecdsa", key: ecdsaKey, algorithm: "ECDSA", bits: "384", curve: "P-384"},
+		{name: "ed25519", key: ed25519Key, algorithm: "Ed25519", bits: "256", curve: "Ed25519"},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			template := test.GenerateCertificateTemplate(time.Now().AddDate(0, 0, 1))
+			template.IsCA = true
+			der, err := x509.CreateCertificate(rand.Reader, template, template, tc.key.Public(), tc.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cert, err := x509.ParseCertificate(der)
+			if err != nil {
+				t.Fatal(err)
+			}
+			certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+			keyDER, err := x509.MarshalPKCS8PrivateKey(tc.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+
+			server, caFile, teardown, err := test.SetupTCPServerWithCertAndKey(certPEM, certPEM, keyPEM)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer teardown()
+
+			server.StartTLS()
+			defer server.Close()
+
+			module := config.Module{
+				TLSConfig: config.TLSConfig{
+					CAFile: caFile,
+				},
+			}
+
+			registry := prometheus.NewRegistry()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+				t.Fatalf("error: %s", err)
+			}
+
+			checkKeyInfoMetrics(cert, tc.algorithm, tc.bits, tc.curve, registry, t)
+		})
+	}
+}
+
+// TestProbeTCPWeakSignature tests a certificate that is signed with SHA-1 by
+// a root that is signed with SHA-256
+func TestProbeTCPWeakSignature(t *testing.T) {
+	rootKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootTemplate := test.GenerateCertificateTemplate(time.Now().AddDate(0, 0, 1))
+	rootTemplate.IsCA = true
+	rootTemplate.SerialNumber = big.NewInt(1)
+	rootTemplate.Subject.CommonName = "root"
+	root, rootPEM := test.GenerateSelfSignedCertificateWithPrivateKey(rootTemplate, rootKey)
+
+	testcases := []struct {
+		name      string
+		algorithm x509.SignatureAlgorithm
+		weak      float64
+	}{
+		{name: "sha256", algorithm: x509.SHA256WithRSA},
+		{name: "sha1", algorithm: x509.SHA1WithRSA, weak: 1},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			template := test.GenerateCertificateTemplate(time.Now().AddDate(0, 0, 1))
+			template.SerialNumber = big.NewInt(2)
+			template.SignatureAlgorithm = tc.algorithm
+			leaf, leafPEM, keyPEM := test.GenerateSignedCertificate(template, root, rootKey)
+
+			server, caFile, teardown, err := test.SetupTCPServerWithCertAndKey(rootPEM, bytes.Join([][]byte{leafPEM, rootPEM}, nil), keyPEM)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer teardown()
+
+			server.StartTLS()
+			defer server.Close()
+
+			// SHA-1 signatures aren't verified by crypto/x509
+			module := config.Module{
+				TLSConfig: config.TLSConfig{
+					CAFile:             caFile,
+					InsecureSkipVerify: true,
+				},
+			}
+
+			registry := prometheus.NewRegistry()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+				t.Fatalf("error: %s", err)
+			}
+
+			checkSignatureMetrics(leaf, tc.algorithm.String(), tc.weak, registry, t)
+			checkSignatureMetrics(root, "SHA256-RSA", 0, registry, t)
+		})
+	}
+}
+
+// TestProbeTCPWeakSignatureSelfSigned tests that a self-signed certificate
+// signed with SHA-1 is weak, unless it's the root of a verified chain
+func TestProbeTCPWeakSignatureSelfSigned(t *testing.T) {
+	rootKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootTemplate := test.GenerateCertificateTemplate(time.Now().AddDate(0, 0, 1))
+	rootTemplate.IsCA = true
+	rootTemplate.SerialNumber = big.NewInt(1)
+	rootTemplate.Subject.CommonName = "root"
+	rootTemplate.SignatureAlgorithm = x509.SHA1WithRSA
+	root, rootPEM := test.GenerateSelfSignedCertificateWithPrivateKey(rootTemplate, rootKey)
+
+	leafTemplate := test.GenerateCertificateTemplate(time.Now().AddDate(0, 0, 1))
+	leafTemplate.SerialNumber = big.NewInt(2)
+	leaf, leafPEM, leafKeyPEM := test.GenerateSignedCertificate(leafTemplate, root, rootKey)
+
+	selfSignedKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	selfSignedTemplate := test.GenerateCertificateTemplate(time.Now().AddDate(0, 0, 1))
+	selfSignedTemplate.SerialNumber = big.NewInt(3)
+	selfSignedTemplate.SignatureAlgorithm = x509.SHA1WithRSA
+	selfSigned, selfSignedPEM := test.GenerateSelfSignedCertificateWithPrivateKey(selfSignedTemplate, selfSignedKey)
+	selfSignedKeyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(selfSignedKey)})
+
+	testcases := []struct {
+		name     string
+		caPEM    []byte
+		certPEM  []byte
+		keyPEM   []byte
+		insecure bool
+		cert     *x509.Certificate
+		weak     float64
+	}{
+		{
+			name:     "self-signed leaf",
+			caPEM:    selfSignedPEM,
+			certPEM:  selfSignedPEM,
+			keyPEM:   selfSignedKeyPEM,
+			insecure: true,
+			cert:     selfSigned,
+			weak:     1,
+		},
+		{
+			name:    "trust anchor",
+			caPEM:   rootPEM,
+			certPEM: bytes.Join([][]byte{leafPEM, rootPEM}, nil),
+			keyPEM:  leafKeyPEM,
+			cert:    root,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			server, caFile, teardown, err := test.SetupTCPServerWithCertAndKey(tc.caPEM, tc.certPEM, tc.keyPEM)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer teardown()
+
+			server.StartTLS()
+			defer server.Close()
+
+			module := config.Module{
+				TLSConfig: config.TLSConfig{
+					CAFile:             caFile,
+					InsecureSkipVerify: tc.insecure,
+				},
+			}
+
+			registry := prometheus.NewRegistry()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+				t.Fatalf("error: %s", err)
+			}
+
+			checkSignatureMetrics(tc.cert, "SHA1-RSA", tc.weak, registry, t)
+			if !tc.insecure {
+				checkSignatureMetrics(leaf, "SHA256-RSA", 0, registry, t)
+			}
+		})
+	}
+}
+
+// TestProbeTCPBasicConstraints tests the CA flag and path length constraint
+// of the certificates presented by the target
+func TestProbeTCPBasicConstraints(t *testing.T) {
+	testcases := []struct {
+		name       string
+		maxPathLen int
+		zero       bool
+		expected   float64
+	}{
+		{name: "unlimited", maxPathLen: -1, expected: -1},
+		{name: "zero", maxPathLen: 0, zero: true, expected: 0},
+		{name: "limited", maxPathLen: 2, expected: 2},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			rootKey, err := rsa.GenerateKey(rand.Reader, 2048)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rootTemplate := test.GenerateCertificateTemplate(time.Now().AddDate(0, 0, 1))
+			rootTemplate.IsCA = true
+			rootTemplate.MaxPathLen = tc.maxPathLen
+			rootTemplate.MaxPathLenZero = tc.zero
+			rootTemplate.SerialNumber = big.NewInt(1)
+			rootTemplate.Subject.CommonName = "root"
+			root, rootPEM := test.GenerateSelfSignedCertificateWithPrivateKey(rootTemplate, rootKey)
+
+			template := test.GenerateCertificateTemplate(time.Now().AddDate(0, 0, 1))
+			template.SerialNumber = big.NewInt(2)
+			leaf, leafPEM, keyPEM := test.GenerateSignedCertificate(template, root, rootKey)
+
+			server, caFile, teardown, err := test.SetupTCPServerWithCertAndKey(rootPEM, bytes.Join([][]byte{leafPEM, rootPEM}, nil), keyPEM)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer teardown()
+
+			server.StartTLS()
+			defer server.Close()
+
+			module := config.Module{
+				TLSConfig: config.TLSConfig{
+					CAFile: caFile,
+				},
+			}
+
+			registry := prometheus.NewRegistry()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+				t.Fatalf("error: %s", err)
+			}
+
+			checkBasicConstraintsMetrics(leaf, 0, -1, registry, t)
+			checkBasicConstraintsMetrics(root, 1, tc.expected, registry, t)
+		})
+	}
+}
+
+// TestProbeTCPKeyUsage tests a certificate that can only be used for client
+// authentication
+func TestProbeTCPKeyUsage(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := test.GenerateCertificateTemplate(time.Now().AddDate(0, 0, 1))
+	template.KeyUsage = x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	template.UnknownExtKeyUsage = []asn1.ObjectIdentifier{{1, 3, 6, 1, 4, 1, 311, 20, 2, 2}}
+	cert, certPEM := test.GenerateSelfSignedCertificateWithPrivateKey(template, key)
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	server, caFile, teardown, err := test.SetupTCPServerWithCertAndKey(certPEM, certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	server.StartTLS()
+	defer server.Close()
+
+	// The certificate can't be verified for server authentication
+	module := config.Module{
+		TLSConfig: config.TLSConfig{
+			CAFile:             caFile,
+			InsecureSkipVerify: true,
+		},
+	}
+
+	registry := prometheus.NewRegistry()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	checkKeyUsageMetrics(cert, []string{"digitalSignature", "keyEncipherment"}, []string{"clientAuth", "1.3.6.1.4.1.311.20.2.2"}, registry, t)
+}
+
+// TestProbeTCPSANs tests the number of SANs of a certificate and whether
+// they include wildcards and IP addresses
+func TestProbeTCPSANs(t *testing.T) {
+	testcases := []struct {
+		name     string
+		dnsNames []string
+		ips      []net.IP
+		sans     float64
+		wildcard float64
+		ipSANs   float64
+	}{
+		{name: "dns names", dnsNames: []string{"example.ribbybibby.me", "example-2.ribbybibby.me"}, sans: 4},
+		{name: "wildcard", dnsNames: []string{"example.ribbybibby.me", "*.ribbybibby.me"}, sans: 4, wildcard: 1},
+		{name: "ip addresses", dnsNames: []string{"example.ribbybibby.me"}, ips: []net.IP{net.ParseIP("127.0.0.1")}, sans: 4, ipSANs: 1},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			key, err := rsa.GenerateKey(rand.Reader, 2048)
+			if err != nil {
+				t.Fatal(err)
+			}
+			template := test.GenerateCertificateTemplate(time.Now().AddDate(0, 0, 1))
+			template.IsCA = true
+			template.DNSNames = tc.dnsNames
+			template.IPAddresses = tc.ips
+			cert, certPEM := test.GenerateSelfSignedCertificateWithPrivateKey(template, key)
+			keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+			server, caFile, teardown, err := test.SetupTCPServerWithCertAndKey(certPEM, certPEM, keyPEM)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer teardown()
+
+			server.StartTLS()
+			defer server.Close()
+
+			module := config.Module{
+				TLSConfig: config.TLSConfig{
+					CAFile:             caFile,
+					InsecureSkipVerify: true,
+				},
+			}
+
+			registry := prometheus.NewRegistry()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+				t.Fatalf("error: %s", err)
+			}
+
+			checkSANMetrics(cert, tc.sans, tc.wildcard, tc.ipSANs, registry, t)
+		})
+	}
+}
+
+func TestProbeTCPLifetimeElapsed(t *testing.T) {
+	testcases := []struct {
+		name      string
+		notBefore time.Time
+		notAfter  time.Time
+		min, max  float64
+	}{
+		{name: "halfway", notBefore: time.Now().AddDate(0, 0, -30), notAfter: time.Now().AddDate(0, 0, 30), min: 0.49, max: 0.51},
+		{name: "expired", notBefore: time.Now().AddDate(0, 0, -2), notAfter: time.Now().AddDate(0, 0, -1), min: 1, max: 1},
+		{name: "not yet valid", notBefore: time.Now().AddDate(0, 0, 1), notAfter: time.Now().AddDate(0, 0, 2), min: 0, max: 0},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			key, err := rsa.GenerateKey(rand.Reader, 2048)
+			if err != nil {
+				t.Fatal(err)
+			}
+			template := test.GenerateCertificateTemplate(tc.notAfter)
+			template.IsCA = true
+			template.NotBefore = tc.notBefore
+			_, certPEM := test.GenerateSelfSignedCertificateWithPrivateKey(template, key)
+			keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+			server, caFile, teardown, err := test.SetupTCPServerWithCertAndKey(certPEM, certPEM, keyPEM)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer teardown()
+
+			server.StartTLS()
+			defer server.Close()
+
+			module := config.Module{
+				TLSConfig: config.TLSConfig{
+					CAFile:             caFile,
+					InsecureSkipVerify: true,
+				},
+			}
+
+			registry := prometheus.NewRegistry()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+				t.Fatalf("error: %s", err)
+			}
+
+			checkLifetimeElapsedMetrics(tc.min, tc.max, registry, t)
+		})
+	}
+}
+
+// TestProbeTCPNotYetValid tests that a certificate that isn't valid yet fails
+// the probe, unless it's valid within the clock skew tolerance, and that it's
+// reported when it isn't verified
+func TestProbeTCPNotYetValid(t *testing.T) {
+	testcases := []struct {
+		name        string
+		notBefore   time.Time
+		tolerance   time.Duration
+		insecure    bool
+		notYetValid float64
+		shouldFail  bool
+	}{
+		{name: "valid", notBefore: time.Now().Add(-time.Hour)},
+		{name: "not yet valid", notBefore: time.Now().Add(time.Hour), shouldFail: true},
+		{name: "not yet valid without verification", notBefore: time.Now().Add(time.Hour), insecure: true, notYetValid: 1},
+		{name: "outside tolerance", notBefore: time.Now().Add(3 * time.Hour), tolerance: 2 * time.Hour, shouldFail: true},
+		{name: "within tolerance", notBefore: time.Now().Add(time.Hour), tolerance: 2 * time.Hour},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			key, err := rsa.GenerateKey(rand.Reader, 2048)
+			if err != nil {
+				t.Fatal(err)
+			}
+			template := test.GenerateCertificateTemplate(time.Now().AddDate(0, 0, 1))
+			template.IsCA = true
+			template.NotBefore = tc.notBefore
+			cert, certPEM := test.GenerateSelfSignedCertificateWithPrivateKey(template, key)
+			keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+			server, caFile, teardown, err := test.SetupTCPServerWithCertAndKey(certPEM, certPEM, keyPEM)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer teardown()
+
+			server.StartTLS()
+			defer server.Close()
+
+			module := config.Module{
+				TLSConfig: config.TLSConfig{
+					CAFile:             caFile,
+					InsecureSkipVerify: tc.insecure,
+				},
+				ClockSkewTolerance: tc.tolerance,
+			}
+
+			registry := prometheus.NewRegistry()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			err = ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry)
+			if tc.shouldFail {
+				if err == nil {
+					t.Fatalf("expected error, but err was nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: %s", err)
+			}
+
+			checkNotYetValidMetrics(cert, tc.notYetValid, registry, t)
+		})
+	}
+}
+
+func TestProbeTCPExpiryThresholds(t *testing.T) {
+	server, certPEM, _, caFile, teardown, err := test.SetupTCPServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	server.StartTLS()
+	defer server.Close()
+
+	module := config.Module{
+		TLSConfig: config.TLSConfig{
+			CAFile: caFile,
+		},
+		ExpiryThresholds: []config.ExpiryThreshold{
+			{Name: "1h", Duration: time.Hour},
+			{Name: "7d", Duration: 7 * 24 * time.Hour},
+		},
+	}
+
+	registry := prometheus.NewRegistry()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	block, _ := pem.Decode(certPEM)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The certificate expires in a day
+	checkExpiresWithinMetrics(cert, map[string]float64{"1h": 0, "7d": 1}, registry, t)
+}
+
+// testChain is a leaf certificate issued by an intermediate of a root
+type testChain struct {
+	root, intermediate, leaf                  *x509.Certificate
+	rootPEM, intermediatePEM, leafPEM, keyPEM []byte
+}
+
+// newTestChain creates a chain, where the caIssuers URL of the leaf is
+// aiaURL
+func newTestChain(t *testing.T, aiaURL string) *testChain {
+	rootKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootTemplate := test.GenerateCertificateTemplate(time.Now().AddDate(0, 0, 1))
+	rootTemplate.IsCA = true
+	rootTemplate.SerialNumber = big.NewInt(1)
+	root, rootPEM := test.GenerateSelfSignedCertificateWithPrivateKey(rootTemplate, rootKey)
+
+	intermediateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	intermediateTemplate := test.GenerateCertificateTemplate(time.Now().AddDate(0, 0, 1))
+	intermediateTemplate.IsCA = true
+	intermediateTemplate.SerialNumber = big.NewInt(2)
+	intermediateTemplate.SubjectKeyId = []byte{2}
+	intermediateTemplate.Subject.CommonName = "intermediate"
+	intermediateDER, err := x509.CreateCertificate(rand.Reader, intermediateTemplate, root, &intermediateKey.PublicKey, rootKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	intermediate, err := x509.ParseCertificate(intermediateDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := test.GenerateCertificateTemplate(time.Now().AddDate(0, 0, 1))
+	template.SerialNumber = big.NewInt(3)
+	template.SubjectKeyId = []byte{3}
+	if aiaURL != "" {
+		template.IssuingCertificateURL = []string{aiaURL}
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, template, intermediate, &key.PublicKey, intermediateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(leafDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testChain{
+		root:            root,
+		intermediate:    intermediate,
+		leaf:            leaf,
+		rootPEM:         rootPEM,
+		intermediatePEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: intermediateDER}),
+		leafPEM:         pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leafDER}),
+		keyPEM:          pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}),
+	}
+}
+
+// pem concatenates the PEM encoded certificates
+func (c *testChain) pem(certs ...[]byte) []byte {
+	return bytes.Join(certs, nil)
+}
+
+// TestProbeTCPVerifiedChains tests the verified chain metrics returned by a tcp
+// probe
+func TestProbeTCPVerifiedChains(t *testing.T) {
+	rootPrivateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	rootCertExpiry := time.Now().AddDate(0, 0, 5)
+	rootCertTmpl := test.GenerateCertificateTemplate(rootCertExpiry)
+	rootCertTmpl.IsCA = true
+	rootCertTmpl.SerialNumber = big.NewInt(1)
+	rootCert, rootCertPem := test.GenerateSelfSignedCertificateWithPrivateKey(rootCertTmpl, rootPrivateKey)
+
+	olderRootCertExpiry := time.Now().AddDate(0, 0, 3)
+	olderRootCertTmpl := test.GenerateCertificateTemplate(olderRootCertExpiry)
+	olderRootCertTmpl.IsCA = true
+	olderRootCertTmpl.SerialNumber = big.NewInt(2)
+	olderRootCert, olderRootCertPem := test.GenerateSelfSignedCertificateWithPrivateKey(olderRootCertTmpl, rootPrivateKey)
+
+	oldestRootCertExpiry := time.Now().AddDate(0, 0, 1)
+	oldestRootCertTmpl := test.GenerateCertificateTemplate(oldestRootCertExpiry)
+	oldestRootCertTmpl.IsCA = true
+	oldestRootCertTmpl.SerialNumber = big.NewInt(3)
+	oldestRootCert, oldestRootCertPem := test.GenerateSelfSignedCertificateWithPrivateKey(oldestRootCertTmpl, rootPrivateKey)
+
+	serverCertExpiry := time.Now().AddDate(0, 0, 4)
+	serverCertTmpl := test.GenerateCertificateTemplate(serverCertExpiry)
+	serverCertTmpl.SerialNumber = big.NewInt(4)
+	serverCert, serverCertPem, serverKey := test.GenerateSignedCertificate(serverCertTmpl, olderRootCert, rootPrivateKey)
+
+	verifiedChains := [][]*x509.Certificate{
+		[]*x509.Certificate{
+			serverCert,
+			rootCert,
+		},
+		[]*x509.Certificate{
+			serverCert,
+			olderRootCert,
+		},
+		[]*x509.Certificate{
+			serverCert,
+			oldestRootCert,
+		},
+	}
+
+	caCertPem := bytes.Join([][]byte{oldestRootCertPem, olderRootCertPem, rootCertPem}, []byte(""))
+
+	server, caFile, teardown, err := test.SetupTCPServerWithCertAndKey(
+		caCertPem,
+		serverCertPem,
+		serverKey,
+	)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer teardown()
+
+	server.StartTLS()
+	defer server.Close()
+
+	module := config.Module{
+		TLSConfig: config.TLSConfig{
+			CAFile: caFile,
+		},
+	}
+
+	registry := prometheus.NewRegistry()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	checkCertificateMetrics(serverCert, registry, t)
+	checkOCSPMetrics([]byte{}, registry, t)
+	checkVerifiedChainMetrics(verifiedChains, registry, t)
+	checkTLSVersionMetrics("TLS 1.3", registry, t)
+}
+
+// TestProbeTCPProxyProtocol tests sending the PROXY protocol header before the
+// TLS handshake
+func TestProbeTCPProxyProtocol(t *testing.T) {
+	for _, version := range []string{"v1", "v2"} {
+		t.Run(version, func(t *testing.T) {
+			server, certPEM, _, caFile, teardown, err := test.SetupTCPServer()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer teardown()
+
+			server.Listener = test.NewProxyProtocolListener(server.Listener, version)
+			server.StartTLS()
+			defer server.Close()
+
+			module := config.Module{
+				TCP: config.TCPProbe{
+					ProxyProtocol: version,
+				},
+				TLSConfig: config.TLSConfig{
+					CAFile: caFile,
+				},
+			}
+
+			registry := prometheus.NewRegistry()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+				t.Fatalf("error: %s", err)
+			}
+
+			cert, err := newCertificate(certPEM)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkCertificateMetrics(cert, registry, t)
+		})
+	}
+}
+
+// TestProbeTCPProxy tests tunnelling a STARTTLS probe through a HTTP proxy
+// that requires basic authentication
+func TestProbeTCPProxy(t *testing.T) {
+	server, certPEM, _, caFile, teardown, err := test.SetupTCPServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	server.StartSMTP()
+	defer server.Close()
+
+	proxyServer, err := test.SetupHTTPProxyServerWithBasicAuth("user", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyServer.Start()
+	defer proxyServer.Close()
+
+	proxyURL, err := url.Parse(proxyServer.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	passwordFile, err := test.WriteFile("proxy_password", []byte("wrong\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwordFile)
+
+	module := config.Module{
+		TCP: config.TCPProbe{
+			StartTLS: "smtp",
+			ProxyURL: config.URL{URL: proxyURL},
+			ProxyBasicAuth: config.BasicAuth{
+				Username:     "user",
+				PasswordFile: passwordFile,
+			},
+		},
+		TLSConfig: config.TLSConfig{
+			CAFile: caFile,
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// The proxy should reject the wrong password
+	if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, prometheus.NewRegistry()); err == nil {
+		t.Fatalf("expected error but err was nil")
+	}
+
+	if err := os.WriteFile(passwordFile, []byte("secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	registry := prometheus.NewRegistry()
+
+	if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	cert, err := newCertificate(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCertificateMetrics(cert, registry, t)
+}
+
+// TestProbeTCPSOCKS5Proxy tests connecting through a SOCKS5 proxy that
+// requires authentication
+func TestProbeTCPSOCKS5Proxy(t *testing.T) {
+	server, certPEM, _, caFile, teardown, err := test.SetupTCPServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	server.StartTLS()
+	defer server.Close()
+
+	proxyServer, err := test.SetupSOCKS5Server("user", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxyServer.Close()
+
+	passwordFile, err := test.WriteFile("socks5_password", []byte("wrong\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwordFile)
+
+	module := config.Module{
+		TCP: config.TCPProbe{
+			SOCKS5Proxy: config.SOCKS5Proxy{
+				Address:      proxyServer.Listener.Addr().String(),
+				Username:     "user",
+				PasswordFile: passwordFile,
+			},
+		},
+		TLSConfig: config.TLSConfig{
+			CAFile: caFile,
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// The proxy should reject the wrong password
+	if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, prometheus.NewRegistry()); err == nil {
+		t.Fatalf("expected error but err was nil")
+	}
+
+	if err := os.WriteFile(passwordFile, []byte("secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	registry := prometheus.NewRegistry()
+
+	if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	cert, err := newCertificate(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCertificateMetrics(cert, registry, t)
+}
+
+// TestProbeTCPSSHTunnel tests connecting to the target through an SSH jump
+// host
+func TestProbeTCPSSHTunnel(t *testing.T) {
+	server, certPEM, _, caFile, teardown, err := test.SetupTCPServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	server.StartTLS()
+	defer server.Close()
+
+	_, clientKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientSigner, err := ssh.NewSignerFromKey(clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyBlock, err := ssh.MarshalPrivateKey(clientKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile, err := test.WriteFile("ssh_tunnel_key", pem.EncodeToMemory(keyBlock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(keyFile)
+
+	jumpServer, err := test.SetupSSHJumpServer(clientSigner.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jumpServer.StartJump()
+	defer jumpServer.Close()
+
+	module := config.Module{
+		SSHTunnel: config.SSHTunnel{
+			Host:    jumpServer.Listener.Addr().String(),
+			User:    "prober",
+			KeyFile: keyFile,
+		},
+		TLSConfig: config.TLSConfig{
+			CAFile: caFile,
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// The host key of the jump host can't be verified without a known hosts
+	// file
+	if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, prometheus.NewRegistry()); err == nil {
+		t.Fatalf("expected error but err was nil")
+	}
+
+	knownHostsFile, err := test.WriteFile("known_hosts", []byte(knownhosts.Line([]string{jumpServer.Listener.Addr().String()}, jumpServer.HostKey.PublicKey())+"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(knownHostsFile)
+	module.SSHTunnel.KnownHostsFile = knownHostsFile
+
+	registry := prometheus.NewRegistry()
+
+	if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	cert, err := newCertificate(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCertificateMetrics(cert, registry, t)
+}
+
+// TestProbeTCPOuterTLS tests probing a target inside an outer TLS session with
+// a gateway
+func TestProbeTCPOuterTLS(t *testing.T) {
+	server, certPEM, _, caFile, teardown, err := test.SetupTCPServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	server.StartTLS()
+	defer server.Close()
+
+	wrapper, wrapperCAFile, wrapperTeardown, err := test.SetupTLSWrapper(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wrapperTeardown()
+	defer wrapper.Close()
+
+	module := config.Module{
+		OuterTLSConfig: &config.TLSConfig{
+			CAFile: wrapperCAFile,
+		},
+		TLSConfig: config.TLSConfig{
+			CAFile: caFile,
+		},
+	}
+
+	registry := prometheus.NewRegistry()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeTCP(ctx, newTestLogger(), wrapper.Listener.Addr().String(), module, registry); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	cert, err := newCertificate(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCertificateMetrics(cert, registry, t)
+	checkTLSVersionMetrics("TLS 1.3", registry, t)
+}
+
+// TestProbeTCPUnixSocket tests probing a server listening on a unix domain
+// socket
+func TestProbeTCPUnixSocket(t *testing.T) {
+	server, certPEM, _, caFile, teardown, err := test.SetupTCPServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	socket := filepath.Join(t.TempDir(), "tls.sock")
+	server.Listener.Close()
+	server.Listener, err = net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server.StartTLS()
+	defer server.Close()
+
+	module := config.Module{
+		TLSConfig: config.TLSConfig{
+			CAFile:     caFile,
+			ServerName: "127.0.0.1",
+		},
+	}
+
+	registry := prometheus.NewRegistry()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeTCP(ctx, newTestLogger(), "unix://"+socket, module, registry); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	cert, err := newCertificate(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCertificateMetrics(cert, registry, t)
+	checkTLSVersionMetrics("TLS 1.3", registry, t)
+}
+
+// TestProbeTCPSourceIPAddress tests binding the connection to a local address
+func TestProbeTCPSourceIPAddress(t *testing.T) {
+	server, certPEM, _, caFile, teardown, err := test.SetupTCPServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	server.StartTLS()
+	defer server.Close()
+
+	module := config.Module{
+		// An address that isn't assigned to the host can't be bound
+		SourceIPAddress: "192.0.2.1",
+		TLSConfig: config.TLSConfig{
+			CAFile: caFile,
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, prometheus.NewRegistry()); err == nil {
+		t.Fatalf("expected error but err was nil")
+	}
+
+	module.SourceIPAddress = "127.0.0.1"
+
+	registry := prometheus.NewRegistry()
+
+	if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	cert, err := newCertificate(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCertificateMetrics(cert, registry, t)
+}
+
+// TestProbeTCPSourceInterface tests that the probe fails when the source
+// interface doesn't exist
+func TestProbeTCPSourceInterface(t *testing.T) {
+	module := config.Module{
+		SourceInterface: "doesnotexist0",
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeTCP(ctx, newTestLogger(), "127.0.0.1:443", module, prometheus.NewRegistry()); err == nil {
+		t.Fatalf("expected error but err was nil")
+	}
+}
+
+// TestProbeTCPIPv6 tests that the IP protocol is reported for IPv6 targets
+func TestProbeTCPIPv6(t *testing.T) {
+	server, _, _, _, teardown, err := test.SetupTCPServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	server.Listener.Close()
+	server.Listener, err = net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 isn't available: %s", err)
+	}
+
+	server.StartTLS()
+	defer server.Close()
+
+	module := config.Module{
+		TLSConfig: config.TLSConfig{
+			InsecureSkipVerify: true,
+		},
+	}
+
+	registry := prometheus.NewRegistry()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeTCP(ctx, newTestLogger(), server.Listener.Addr().String(), module, registry); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	checkIPProtocolMetrics(6, registry, t)
+}
+
+// TestProbeTCPDualStack tests that the probe falls back to IPv4 when a host
+// that resolves to both families isn't listening on IPv6
+func TestProbeTCPDualStack(t *testing.T) {
+	addrs, err := net.LookupIP("localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var hasIPv4, hasIPv6 bool
+	for _, addr := range addrs {
+		if addr.To4() != nil {
+			hasIPv4 = true
+		} else {
+			hasIPv6 = true
+		}
+	}
+	if !hasIPv4 || !hasIPv6 {
+		t.Skip("localhost doesn't resolve to both IPv4 and IPv6 addresses")
+	}
+
+	server, _, _, _, teardown, err := test.SetupTCPServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	server.StartTLS()
+	defer server.Close()
+
+	_, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	module := config.Module{
+		TLSConfig: config.TLSConfig{
+			InsecureSkipVerify: true,
+		},
+	}
+
+	registry := prometheus.NewRegistry()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeTCP(ctx, newTestLogger(), net.JoinHostPort("localhost", port), module, registry); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	checkIPProtocolMetrics(4, registry, t)
+}
+
+// TestProbeTCPPreferredIPProtocol tests restricting the probe to the addresses
+// of one IP protocol
+func TestProbeTCPPreferredIPProtocol(t *testing.T) {
+	server, _, _, _, teardown, err := test.SetupTCPServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	server.StartTLS()
+	defer server.Close()
+
+	_, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := net.JoinHostPort("localhost", port)
+
+	fallback := false
+	module := config.Module{
+		PreferredIPProtocol: "ip6",
+		IPProtocolFallback:  &fallback,
+		TLSConfig: config.TLSConfig{
+			InsecureSkipVerify: true,
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// The server only listens on IPv4
+	if err := ProbeTCP(ctx, newTestLogger(), target, module, prometheus.NewRegistry()); err == nil {
+		t.Fatalf("expected error but err was nil")
+	}
+
+	module.PreferredIPProtocol = "ip4"
+
+	registry := prometheus.NewRegistry()
+
+	if err := ProbeTCP(ctx, newTestLogger(), target, module, registry); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	checkIPProtocolMetrics(4, registry, t)
+}
+
+// TestProbeTCPResolver tests resolving the target with the DNS server in the
+// module
+func TestProbeTCPResolver(t *testing.T) {
+	server, certPEM, _, caFile, teardown, err := test.SetupTCPServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	server.StartTLS()
+	defer server.Close()
+
+	dnsServer, err := test.SetupDNSServer(map[string][]net.IP{
+		"probe.example.test.": []net.IP{net.ParseIP("127.0.0.1")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dnsServer.Close()
+
+	_, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	module := config.Module{
+		Resolver: dnsServer.Conn.LocalAddr().String(),
+		TLSConfig: config.TLSConfig{
+			CAFile:     caFile,
+			ServerName: "127.0.0.1",
+		},
+	}
+
+	registry := prometheus.NewRegistry()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeTCP(ctx, newTestLogger(), net.JoinHostPort("probe.example.test", port), module, registry); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	cert, err := newCertificate(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCertificateMetrics(cert, registry, t)
+	checkPhaseDurationMetrics([]string{"resolve", "connect", "handshake"}, registry, t)
+}
+
+// TestProbeTCPEncryptedResolver tests resolving the target with DNS over TLS
+// and DNS over HTTPS resolvers
+func TestProbeTCPEncryptedResolver(t *testing.T) {
+	dnsServer, err := test.SetupDNSServer(map[string][]net.IP{
+		"probe.example.test.": []net.IP{net.ParseIP("127.0.0.1")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dnsServer.Close()
+
+	certPEM, keyPEM := test.GenerateTestCertificate(time.Now().AddDate(0, 0, 1))
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolverCAFile, err := test.WriteFile("resolver_certfile.pem", certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(resolverCAFile)
+
+	dotListener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dotListener.Close()
+	dnsServer.ServeTLS(dotListener)
+
+	dohServer := httptest.NewUnstartedServer(dnsServer)
+	dohServer.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	dohServer.StartTLS()
+	defer dohServer.Close()
+
+	resolvers := []string{
+		"tls://" + dotListener.Addr().String(),
+		dohServer.URL + "/dns-query",
+	}
+	for _, resolver := range resolvers {
+		t.Run(resolver, func(t *testing.T) {
+			server, _, _, caFile, teardown, err := test.SetupTCPServer()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer teardown()
+
+			server.StartTLS()
+			defer server.Close()
+
+			_, port, err := net.SplitHostPort(server.Listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			module := config.Module{
+				Resolver: resolver,
+				ResolverTLSConfig: config.TLSConfig{
+					CAFile: resolverCAFile,
+				},
+				TLSConfig: config.TLSConfig{
+					CAFile:     caFile,
+					ServerName: "127.0.0.1",
+				},
+			}
+
+			registry := prometheus.NewRegistry()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			if err := ProbeTCP(ctx, newTestLogger(), net.JoinHostPort("probe.example.test", port), module, registry); err != nil {
+				t.Fatalf("error: %s", err)
+			}
+
+			checkDNSResolverMetrics(resolver, registry, t)
+		})
+	}
+}
+
+// TestProbeTCPDNSSEC tests that the target is only probed when the resolver
+// authenticates its records
+func TestProbeTCPDNSSEC(t *testing.T) {
+	dnsServer, err := test.SetupDNSServer(map[string][]net.IP{
+		"probe.example.test.": []net.IP{net.ParseIP("127.0.0.1")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dnsServer.Close()
+
+	for _, authenticated := range []bool{true, false} {
+		t.Run(fmt.Sprintf("authenticated=%t", authenticated), func(t *testing.T) {
+			dnsServer.SetAuthenticated(authenticated)
+
+			server, _, _, caFile, teardown, err := test.SetupTCPServer()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer teardown()
+
+			// The target isn't dialed when the records aren't authenticated
+			if authenticated {
+				server.StartTLS()
+				defer server.Close()
+			} else {
+				defer server.Listener.Close()
+			}
+
+			_, port, err := net.SplitHostPort(server.Listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			module := config.Module{
+				Resolver: dnsServer.Conn.LocalAddr().String(),
+				DNSSEC:   true,
+				TLSConfig: config.TLSConfig{
+					CAFile:     caFile,
+					ServerName: "127.0.0.1",
+				},
+			}
+
+			registry := prometheus.NewRegistry()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			err = ProbeTCP(ctx, newTestLogger(), net.JoinHostPort("probe.example.test", port), module, registry)
+			if authenticated && err != nil {
+				t.Fatalf("error: %s", err)
+			}
+			if !authenticated && err == nil {
+				t.Fatalf("expected error but err is nil")
+			}
+
+			if authenticated {
+				checkDNSSECMetrics(1, registry, t)
+			} else {
+				checkDNSSECMetrics(0, registry, t)
+			}
+		})
+	}
+}
+
+// TestProbeTCPDANE tests validating the certificate presented by the target
+// against its TLSA records
+func TestProbeTCPDANE(t *testing.T) {
+	certPEM, keyPEM := test.GenerateTestCertificate(time.Now().AddDate(0, 0, 1))
+	cert, err := newCertificate(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spkiSHA256 := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	certSHA512 := sha512.Sum512(cert.Raw)
+
+	dnsServer, err := test.SetupDNSServer(map[string][]net.IP{
+		"probe.example.test.": []net.IP{net.ParseIP("127.0.0.1")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dnsServer.Close()
+
+	testCases := []struct {
+		name    string
+		records []test.TLSA
+		valid   float64
+		match   []string
+	}{
+		{
+			name:    "DANE-EE SPKI SHA2-256",
+			records: []test.TLSA{{Usage: 3, Selector: 1, MatchingType: 1, Data: spkiSHA256[:]}},
+			valid:   1,
+			match:   []string{"DANE-EE", "SPKI", "SHA2-256"},
+		},
+		{
+			name:    "DANE-EE Cert Full",
+			records: []test.TLSA{{Usage: 3, Selector: 0, MatchingType: 0, Data: cert.Raw}},
+			valid:   1,
+			match:   []string{"DANE-EE", "Cert", "Full"},
+		},
+		{
+			name: "PKIX-EE Cert SHA2-512",
+			records: []test.TLSA{
+				{Usage: 3, Selector: 1, MatchingType: 1, Data: make([]byte, 32)},
+				{Usage: 1, Selector: 0, MatchingType: 2, Data: certSHA512[:]},
+			},
+			valid: 1,
+			match: []string{"PKIX-EE", "Cert", "SHA2-512"},
+		},
+		{
+			name:    "mismatch",
+			records: []test.TLSA{{Usage: 3, Selector: 1, MatchingType: 1, Data: make([]byte, 32)}},
+			valid:   0,
+		},
+		{
+			name:  "no records",
+			valid: 0,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server, caFile, teardown, err := test.SetupTCPServerWithCertAndKey(certPEM, certPEM, keyPEM)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer teardown()
+
+			server.StartTLS()
+			defer server.Close()
+
+			_, port, err := net.SplitHostPort(server.Listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			dnsServer.SetTLSA("_"+port+"._tcp.probe.example.test.", tc.records)
+
+			module := config.Module{
+				Resolver: dnsServer.Conn.LocalAddr().String(),
+				DANE:     true,
+				TLSConfig: config.TLSConfig{
+					CAFile:     caFile,
+					ServerName: "127.0.0.1",
+				},
+			}
+
+			registry := prometheus.NewRegistry()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			if err := ProbeTCP(ctx, newTestLogger(), net.JoinHostPort("probe.example.test", port), module, registry); err != nil {
+				t.Fatalf("error: %s", err)
+			}
+
+			checkDANEMetrics(tc.valid, tc.match, registry, t)
+		})
+	}
+}
+
+// TestProbeTCPCAA tests checking that the issuer of the certificate presented
+// by the target is authorized by its CAA records
+func TestProbeTCPCAA(t *testing.T) {
+	dnsServer, err := test.SetupDNSServer(map[string][]net.IP{
+		"probe.example.test.": []net.IP{net.ParseIP("127.0.0.1")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dnsServer.Close()
+
+	testCases := []struct {
+		name      string
+		records   []test.CAA
+		compliant float64
+	}{
+		{
+			name:      "authorized",
+			records:   []test.CAA{{Tag: "issue", Value: "ca.ribbybibby.me; accounturi=https://ca.ribbybibby.me/acct/1"}},
+			compliant: 1,
+		},
+		{
+			name: "not authorized",
+			records: []test.CAA{
+				{Tag: "issue", Value: "letsencrypt.org"},
+				{Tag: "iodef", Value: "mailto:security@example.test"},
+			},
+			compliant: 0,
+		},
+		{
+			name:      "no CAs authorized",
+			records:   []test.CAA{{Tag: "issue", Value: ";"}},
+			compliant: 0,
+		},
+		{
+			name: "unknown critical property",
+			records: []test.CAA{
+				{Tag: "issue", Value: "ca.ribbybibby.me"},
+				{Flags: 128, Tag: "tbs", Value: "unknown"},
+			},
+			compliant: 0,
+		},
+		{
+			name:      "no records",
+			compliant: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// The records of the parent domain apply to the target
+			dnsServer.SetCAA("example.test.", tc.records)
+
+			server, _, _, caFile, teardown, err := test.SetupTCPServer()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer teardown()
+
+			server.StartTLS()
+			defer server.Close()
+
+			_, port, err := net.SplitHostPort(server.Listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			module := config.Module{
+				Resolver: dnsServer.Conn.LocalAddr().String(),
+				CAA:      true,
+				CAAIssuers: map[string]string{
+					"ribbybibby": "ca.ribbybibby.me",
+				},
+				TLSConfig: config.TLSConfig{
+					CAFile:     caFile,
+					ServerName: "127.0.0.1",
+				},
+			}
+
+			registry := prometheus.NewRegistry()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			if err := ProbeTCP(ctx, newTestLogger(), net.JoinHostPort("probe.example.test", port), module, registry); err != nil {
+				t.Fatalf("error: %s", err)
+			}
+
+			checkCAAMetrics(tc.compliant, "example.test", tc.records, registry, t)
+		})
+	}
+}
+
+// TestProbeTCPIDN tests that Unicode host names in the target and server name
+// are converted to punycode
+func TestProbeTCPIDN(t *testing.T) {
+	server, _, _, caFile, teardown, err := test.SetupTCPServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	serverNames := make(chan string, 1)
+	server.TLS.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		serverNames <- hello.ServerName
+		return nil, nil
+	}
+
+	server.StartTLS()
+	defer server.Close()
+
+	dnsServer, err := test.SetupDNSServer(map[string][]net.IP{
+		"xn--bcher-kva.example.test.": []net.IP{net.ParseIP("127.0.0.1")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dnsServer.Close()
+
+	_, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	module := config.Module{
+		Resolver: dnsServer.Conn.LocalAddr().String(),
+		TLSConfig: config.TLSConfig{
+			CAFile:             caFile,
+			ServerName:         "münchen.example.test",
+			InsecureSkipVerify: true,
+		},
+	}
+
+	registry := prometheus.NewRegistry()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ProbeTCP(ctx, newTestLogger(), net.JoinHostPort("bücher.example.test", port), module, registry); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	if serverName := <-serverNames; serverName != "xn--mnchen-3ya.example.test" {
+		t.Errorf("expected server name xn--mnchen-3ya.example.test but got %s", serverName)
+	}
 }
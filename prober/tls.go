@@ -1,36 +1,151 @@
 package prober
 
 import (
+	"bytes"
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/pem"
+	"errors"
+	"fmt"
 	"net"
+	"sync"
+	"time"
 
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/ribbybibby/ssl_exporter/v2/config"
+	"golang.org/x/crypto/ssh"
 )
 
 // newTLSConfig sets up TLS config and instruments it with a function that
-// collects metrics for the verified chain
-func newTLSConfig(target string, registry *prometheus.Registry, cfg *config.TLSConfig) (*tls.Config, error) {
+// collects metrics for the verified chain. The chains that are verified with
+// AIA are kept in the returned aiaChains. Certificates that aren't valid yet
+// are accepted when they're valid within the clock skew tolerance.
+func newTLSConfig(ctx context.Context, target string, cfg *config.TLSConfig, tolerance time.Duration, registry *prometheus.Registry) (*tls.Config, *aiaChains, error) {
 	tlsConfig, err := config.NewTLSConfig(cfg)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	if tlsConfig.ServerName == "" && target != "" {
 		targetAddress, _, err := net.SplitHostPort(target)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		tlsConfig.ServerName = targetAddress
 	}
 
+	// Unicode server names are sent in their punycode form
+	tlsConfig.ServerName, err = toASCIIHost(tlsConfig.ServerName)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
 		return collectConnectionStateMetrics(state, registry)
 	}
 
-	return tlsConfig, nil
+	// Completing the chain with AIA, or allowing for clock skew, requires
+	// verifying it here, as the handshake fails before VerifyConnection is
+	// called when the built in verification fails
+	verified := &aiaChains{}
+	if (cfg.AIAFetch || tolerance > 0) && !tlsConfig.InsecureSkipVerify {
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+			serverName := state.ServerName
+			if serverName == "" {
+				serverName = tlsConfig.ServerName
+			}
+			var (
+				chains [][]*x509.Certificate
+				err    error
+			)
+			if cfg.AIAFetch {
+				chains, err = verifyWithAIA(ctx, state.PeerCertificates, tlsConfig.RootCAs, serverName, tolerance, registry)
+			} else {
+				chains, err = verifyChain(state.PeerCertificates, tlsConfig.RootCAs, serverName, tolerance)
+			}
+			if err != nil {
+				return err
+			}
+			verified.set(chains)
+			state.VerifiedChains = chains
+
+			return collectConnectionStateMetrics(state, registry)
+		}
+	}
+
+	return tlsConfig, verified, nil
+}
+
+// verifyChain verifies the certificates presented by the target like
+// crypto/tls, allowing for the clock skew tolerance
+func verifyChain(certs []*x509.Certificate, roots *x509.CertPool, serverName string, tolerance time.Duration) ([][]*x509.Certificate, error) {
+	if len(certs) == 0 {
+		return nil, errors.New("tls: the target didn't present a certificate")
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	chains, err := verifyWithTolerance(certs[0], x509.VerifyOptions{
+		DNSName:       serverName,
+		Roots:         roots,
+		Intermediates: intermediates,
+	}, tolerance)
+	if err != nil {
+		return nil, &tls.CertificateVerificationError{UnverifiedCertificates: certs, Err: err}
+	}
+
+	return chains, nil
+}
+
+// verifyWithTolerance verifies the certificate, and verifies it again as
+// though it was the tolerance after the current time when it isn't valid yet,
+// so that certificates that are only ahead of the clock of the exporter are
+// accepted
+func verifyWithTolerance(cert *x509.Certificate, opts x509.VerifyOptions, tolerance time.Duration) ([][]*x509.Certificate, error) {
+	chains, err := cert.Verify(opts)
+	var invalid x509.CertificateInvalidError
+	if err != nil && tolerance > 0 && errors.As(err, &invalid) && invalid.Reason == x509.Expired {
+		opts.CurrentTime = time.Now().Add(tolerance)
+		if skewed, skewedErr := cert.Verify(opts); skewedErr == nil {
+			return skewed, nil
+		}
+	}
+
+	return chains, err
+}
+
+// aiaChains holds the chains that were verified with AIA, or that allowed for
+// clock skew. crypto/tls only records the chains that it verifies itself in
+// the state of a connection.
+type aiaChains struct {
+	mu     sync.Mutex
+	chains [][]*x509.Certificate
+}
+
+func (a *aiaChains) set(chains [][]*x509.Certificate) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.chains = chains
+}
+
+// fill returns the state with the chains that were verified with AIA, when
+// they were verified for its leaf certificate
+func (a *aiaChains) fill(state tls.ConnectionState) tls.ConnectionState {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if len(state.VerifiedChains) == 0 && len(a.chains) > 0 && len(state.PeerCertificates) > 0 && a.chains[0][0].Equal(state.PeerCertificates[0]) {
+		state.VerifiedChains = a.chains
+	}
+
+	return state
 }
 
 func uniq(certs []*x509.Certificate) []*x509.Certificate {
@@ -70,3 +185,54 @@ func decodeCertificates(data []byte) ([]*x509.Certificate, error) {
 
 	return certs, nil
 }
+
+// decodeSSHKeys returns the SSH certificates and certificate authority keys
+// found in known_hosts, authorized_keys or public key formatted data. Plain
+// keys are treated as certificate authorities when they are marked with
+// @cert-authority or cert-authority, or when they have no hosts or options,
+// which is the format of SSH CA public key files.
+func decodeSSHKeys(logger log.Logger, data []byte) ([]*ssh.Certificate, []ssh.PublicKey) {
+	var (
+		certs []*ssh.Certificate
+		cas   []ssh.PublicKey
+	)
+
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		var (
+			key  ssh.PublicKey
+			isCA bool
+			err  error
+		)
+		if line[0] == '@' {
+			var marker string
+			marker, _, key, _, _, err = ssh.ParseKnownHosts(line)
+			isCA = marker == "cert-authority"
+		} else {
+			var options []string
+			key, _, options, _, err = ssh.ParseAuthorizedKey(line)
+			isCA = len(options) == 0
+			for _, option := range options {
+				if option == "cert-authority" {
+					isCA = true
+				}
+			}
+		}
+		if err != nil {
+			level.Debug(logger).Log("msg", fmt.Sprintf("Error parsing ssh key: %s", err))
+			continue
+		}
+
+		if cert, ok := key.(*ssh.Certificate); ok {
+			certs = append(certs, cert)
+		} else if isCA {
+			cas = append(cas, key)
+		}
+	}
+
+	return certs, cas
+}
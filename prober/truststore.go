@@ -0,0 +1,62 @@
+package prober
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/ribbybibby/ssl_exporter/v2/config"
+)
+
+// collectTrustStoreMetrics verifies the certificates presented by the target
+// against each of the trust stores, so that a chain that the exporter trusts
+// but the root stores of some clients don't is reported
+func collectTrustStoreMetrics(logger log.Logger, stores []config.TrustStore, state tls.ConnectionState, serverName string, registry *prometheus.Registry) {
+	var (
+		verified = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "verified"),
+				Help: "If the certificates presented by the target are verified with the roots in the trust store",
+			},
+			[]string{"store"},
+		)
+	)
+	registry.MustRegister(verified)
+
+	if len(state.PeerCertificates) == 0 {
+		return
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	for _, store := range stores {
+		// The roots are loaded with the config, so they're only loaded
+		// here for modules that weren't
+		roots := store.Roots
+		if roots == nil {
+			var err error
+			roots, err = config.LoadTrustStore(store)
+			if err != nil {
+				level.Error(logger).Log("msg", "Error loading trust store", "store", store.Name, "err", err)
+				continue
+			}
+		}
+
+		opts := x509.VerifyOptions{
+			DNSName:       serverName,
+			Roots:         roots,
+			Intermediates: intermediates,
+		}
+		if _, err := state.PeerCertificates[0].Verify(opts); err != nil {
+			level.Debug(logger).Log("msg", "The certificates aren't verified with the trust store", "store", store.Name, "err", err)
+			verified.WithLabelValues(store.Name).Set(0)
+			continue
+		}
+		verified.WithLabelValues(store.Name).Set(1)
+	}
+}
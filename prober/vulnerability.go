@@ -0,0 +1,97 @@
+package prober
+
+import (
+	"context"
+	"crypto/tls"
+	"slices"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/ribbybibby/ssl_exporter/v2/config"
+)
+
+// collectVulnerabilityMetrics performs the enabled vulnerability checks
+// against the target, given the versions that it supports. An error is only
+// returned when the target can't be reached.
+func collectVulnerabilityMetrics(ctx context.Context, target, serverName string, versions []uint16, module config.Module, opts dialOptions, registry *prometheus.Registry) error {
+	checks := module.Scan.VulnerabilityChecks
+
+	if checks.Compression {
+		if err := collectCompressionMetrics(ctx, target, serverName, module, opts, registry); err != nil {
+			return err
+		}
+	}
+
+	if checks.FallbackSCSV {
+		if err := collectFallbackSCSVMetrics(ctx, target, serverName, versions, module, opts, registry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// collectCompressionMetrics offers DEFLATE compression to the target, which
+// crypto/tls doesn't implement
+func collectCompressionMetrics(ctx context.Context, target, serverName string, module config.Module, opts dialOptions, registry *prometheus.Registry) error {
+	var (
+		compressionAccepted = prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "tls_compression_accepted"),
+				Help: "If the target accepts TLS compression",
+			},
+		)
+	)
+	registry.MustRegister(compressionAccepted)
+
+	hello, _, err := sendClientHello(ctx, "tcp", target, &clientHello{
+		version:            tls.VersionTLS12,
+		cipherSuites:       scanCipherSuites(),
+		compressionMethods: []uint8{compressionDeflate, compressionNone},
+		serverName:         serverName,
+	}, module, opts)
+	if err != nil {
+		return err
+	}
+	if hello != nil && hello.compressionMethod != compressionNone {
+		compressionAccepted.Set(1)
+	}
+
+	return nil
+}
+
+// collectFallbackSCSVMetrics offers the second highest version supported by
+// the target with TLS_FALLBACK_SCSV, which the target should reject. Nothing
+// is reported when the target supports fewer than two versions.
+func collectFallbackSCSVMetrics(ctx context.Context, target, serverName string, versions []uint16, module config.Module, opts dialOptions, registry *prometheus.Registry) error {
+	if len(versions) < 2 {
+		return nil
+	}
+	sorted := slices.Clone(versions)
+	slices.Sort(sorted)
+	fallbackVersion := sorted[len(sorted)-2]
+
+	var (
+		fallbackSCSVSupported = prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "fallback_scsv_supported"),
+				Help: "If the target rejects a downgraded handshake that signals TLS_FALLBACK_SCSV",
+			},
+		)
+	)
+	registry.MustRegister(fallbackSCSVSupported)
+
+	_, alert, err := sendClientHello(ctx, "tcp", target, &clientHello{
+		version:            fallbackVersion,
+		cipherSuites:       append(scanCipherSuites(), fallbackSCSV),
+		compressionMethods: []uint8{compressionNone},
+		serverName:         serverName,
+	}, module, opts)
+	if err != nil {
+		return err
+	}
+	if alert == alertInappropriateFallback {
+		fallbackSCSVSupported.Set(1)
+	}
+
+	return nil
+}
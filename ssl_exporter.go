@@ -5,7 +5,10 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/alecthomas/kingpin/v2"
@@ -18,6 +21,7 @@ import (
 	promlogflag "github.com/prometheus/common/promlog/flag"
 	"github.com/prometheus/common/version"
 	"github.com/ribbybibby/ssl_exporter/v2/config"
+	"github.com/ribbybibby/ssl_exporter/v2/history"
 	"github.com/ribbybibby/ssl_exporter/v2/prober"
 )
 
@@ -39,6 +43,7 @@ func probeHandler(logger log.Logger, w http.ResponseWriter, r *http.Request, con
 		http.Error(w, fmt.Sprintf("Unknown module %q", moduleName), http.StatusBadRequest)
 		return
 	}
+	module.Name = moduleName
 
 	timeout := module.Timeout
 	if timeout == 0 {
@@ -74,11 +79,18 @@ func probeHandler(logger log.Logger, w http.ResponseWriter, r *http.Request, con
 		}
 	}
 
+	if names := r.URL.Query()["expected_dns_name"]; len(names) > 0 {
+		module.ExpectedDNSNames = names
+	}
+
 	probeFunc, ok := prober.Probers[module.Prober]
 	if !ok {
 		http.Error(w, fmt.Sprintf("Unknown prober %q", module.Prober), http.StatusBadRequest)
 		return
 	}
+	if strings.HasPrefix(target, prober.SRVTargetPrefix) {
+		probeFunc = prober.ProbeSRV(probeFunc)
+	}
 
 	var (
 		probeSuccess = prometheus.NewGauge(
@@ -94,21 +106,60 @@ func probeHandler(logger log.Logger, w http.ResponseWriter, r *http.Request, con
 			},
 			[]string{"prober"},
 		)
+		probeAttempts = prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "probe_attempts"),
+				Help: "The number of attempts made to probe the target",
+			},
+		)
+		probeErrorInfo = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "", "probe_error_info"),
+				Help: "The type of the error that failed the probe",
+			},
+			[]string{"type"},
+		)
 	)
-
-	registry := prometheus.NewRegistry()
-	registry.MustRegister(probeSuccess, proberType)
 	proberType.WithLabelValues(module.Prober).Set(1)
 
 	logger = log.With(logger, "target", target, "prober", module.Prober, "timeout", timeout)
 
-	err := probeFunc(ctx, logger, target, module, registry)
+	// Every attempt reports its metrics to a new registry, so that only the
+	// metrics of the last attempt are served
+	var (
+		registry *prometheus.Registry
+		err      error
+	)
+	interval := module.RetryInterval
+	for attempt := 1; ; attempt++ {
+		registry = prometheus.NewRegistry()
+		registry.MustRegister(probeSuccess, proberType, probeAttempts)
+		probeAttempts.Set(float64(attempt))
+
+		err = probeFunc(ctx, logger, target, module, registry)
+		if err == nil || attempt > module.Retries {
+			break
+		}
+		level.Debug(logger).Log("msg", fmt.Sprintf("probe attempt %d failed, retrying in %s: %s", attempt, interval, err))
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(interval):
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		interval *= 2
+	}
 	if err != nil {
 		level.Error(logger).Log("msg", err)
 		probeSuccess.Set(0)
+		registry.MustRegister(probeErrorInfo)
+		probeErrorInfo.WithLabelValues(prober.ErrorType(err)).Set(1)
 	} else {
 		probeSuccess.Set(1)
 	}
+	prober.History.ObserveOutcome(moduleName, target, err)
 
 	// Serve
 	h := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
@@ -124,7 +175,10 @@ func main() {
 		listenAddress = kingpin.Flag("web.listen-address", "Address to listen on for web interface and telemetry.").Default(":9219").String()
 		metricsPath   = kingpin.Flag("web.metrics-path", "Path under which to expose metrics").Default("/metrics").String()
 		probePath     = kingpin.Flag("web.probe-path", "Path under which to expose the probe endpoint").Default("/probe").String()
+		historyPath   = kingpin.Flag("web.history-path", "Path under which to expose the history of probes").Default("/history").String()
 		configFile    = kingpin.Flag("config.file", "SSL exporter configuration file").Default("").String()
+		historyFile   = kingpin.Flag("history.file", "File that the history of probes is kept in, so that it survives restarts. The history is only kept in memory when it isn't set.").Default("").String()
+		historyRetain = kingpin.Flag("history.retention", "How long the history of a target is kept after it was last probed. It's kept until the exporter restarts, or forever in a history file, when it's 0.").Default("168h").Duration()
 		promlogConfig = promlog.Config{}
 		err           error
 	)
@@ -145,6 +199,29 @@ func main() {
 		}
 	}
 
+	prober.History = history.New(*historyRetain)
+	if *historyFile != "" {
+		prober.History, err = history.Open(*historyFile, *historyRetain, logger)
+		if err != nil {
+			level.Error(logger).Log("msg", fmt.Sprintf("Error opening history file: %s", err))
+			os.Exit(1)
+		}
+
+		// The history is written to the file in the background, so the
+		// records that have changed since are written when the exporter
+		// is stopped
+		term := make(chan os.Signal, 1)
+		signal.Notify(term, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-term
+			if err := prober.History.Close(); err != nil {
+				level.Error(logger).Log("msg", fmt.Sprintf("Error closing history file: %s", err))
+				os.Exit(1)
+			}
+			os.Exit(0)
+		}()
+	}
+
 	level.Info(logger).Log("msg", fmt.Sprintf("Starting %s_exporter %s", namespace, version.Info()))
 	level.Info(logger).Log("msg", fmt.Sprintf("Build context %s", version.BuildContext()))
 
@@ -152,6 +229,7 @@ func main() {
 	http.HandleFunc(*probePath, func(w http.ResponseWriter, r *http.Request) {
 		probeHandler(logger, w, r, conf)
 	})
+	http.Handle(*historyPath, prober.History)
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		_, _ = w.Write([]byte(`<html>
 						 <head><title>SSL Exporter</title></head>
@@ -159,6 +237,7 @@ func main() {
 						 <h1>SSL Exporter</h1>
 						 <p><a href="` + *probePath + `?target=example.com:443">Probe example.com:443 for SSL cert metrics</a></p>
 						 <p><a href='` + *metricsPath + `'>Metrics</a></p>
+						 <p><a href='` + *historyPath + `'>History</a></p>
 						 </body>
 						 </html>`))
 	})
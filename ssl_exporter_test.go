@@ -1,14 +1,22 @@
 package main
 
 import (
+	"crypto/tls"
+	"encoding/json"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-kit/log"
 	"github.com/ribbybibby/ssl_exporter/v2/config"
+	"github.com/ribbybibby/ssl_exporter/v2/history"
+	"github.com/ribbybibby/ssl_exporter/v2/prober"
 	"github.com/ribbybibby/ssl_exporter/v2/test"
 )
 
@@ -197,6 +205,299 @@ func TestProbeHandlerDefaultTarget(t *testing.T) {
 	}
 }
 
+// TestProbeHandlerExpectedDNSNames tests that the expected_dns_name
+// parameters replace the expected DNS names of the module
+func TestProbeHandlerExpectedDNSNames(t *testing.T) {
+	server, _, _, caFile, teardown, err := test.SetupHTTPSServer()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer teardown()
+
+	server.StartTLS()
+	defer server.Close()
+
+	conf := &config.Config{
+		Modules: map[string]config.Module{
+			"https": config.Module{
+				Prober: "https",
+				TLSConfig: config.TLSConfig{
+					CAFile: caFile,
+				},
+				ExpectedDNSNames: []string{"example.ribbybibby.me"},
+			},
+		},
+	}
+
+	rr, err := probe(server.URL, "https", conf)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if ok := strings.Contains(rr.Body.String(), "ssl_cert_covers_expected_names 1"); !ok {
+		t.Errorf("expected `ssl_cert_covers_expected_names 1`")
+	}
+
+	rr, err = probe(server.URL+"&expected_dns_name=example.ribbybibby.me&expected_dns_name=other.ribbybibby.me", "https", conf)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if ok := strings.Contains(rr.Body.String(), "ssl_cert_covers_expected_names 0"); !ok {
+		t.Errorf("expected `ssl_cert_covers_expected_names 0`")
+	}
+	if ok := strings.Contains(rr.Body.String(), "ssl_cert_expected_name_covered{name=\"other.ribbybibby.me\"} 0"); !ok {
+		t.Errorf("expected `ssl_cert_expected_name_covered{name=\"other.ribbybibby.me\"} 0`")
+	}
+}
+
+// TestProbeHandlerRetries tests that a failed probe is retried
+func TestProbeHandlerRetries(t *testing.T) {
+	certPEM, keyPEM := test.GenerateTestCertificate(time.Now().AddDate(0, 0, 1))
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer ln.Close()
+
+	// The first connection is closed before the TLS handshake
+	go func() {
+		for i := 0; ; i++ {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			if i == 0 {
+				conn.Close()
+				continue
+			}
+			tlsConn := tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{cert}})
+			tlsConn.Handshake()
+			tlsConn.Close()
+		}
+	}()
+
+	conf := &config.Config{
+		Modules: map[string]config.Module{
+			"tcp": config.Module{
+				Prober:        "tcp",
+				Retries:       2,
+				RetryInterval: 10 * time.Millisecond,
+				TLSConfig: config.TLSConfig{
+					InsecureSkipVerify: true,
+				},
+			},
+		},
+	}
+
+	rr, err := probe(ln.Addr().String(), "tcp", conf)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	// Check probe success
+	if ok := strings.Contains(rr.Body.String(), "ssl_probe_success 1"); !ok {
+		t.Errorf("expected `ssl_probe_success 1`")
+	}
+
+	// Check the number of attempts
+	if ok := strings.Contains(rr.Body.String(), "ssl_probe_attempts 2"); !ok {
+		t.Errorf("expected `ssl_probe_attempts 2`")
+	}
+}
+
+// TestProbeHandlerErrorInfo tests that the type of the error that failed the
+// probe is reported
+func TestProbeHandlerErrorInfo(t *testing.T) {
+	server, _, _, caFile, teardown, err := test.SetupHTTPSServer()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer teardown()
+
+	server.StartTLS()
+	defer server.Close()
+
+	expiredCertPEM, expiredKeyPEM := test.GenerateTestCertificate(time.Now().AddDate(0, 0, -1))
+	expiredServer, expiredCAFile, expiredTeardown, err := test.SetupHTTPSServerWithCertAndKey(expiredCertPEM, expiredCertPEM, expiredKeyPEM)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer expiredTeardown()
+
+	expiredServer.StartTLS()
+	defer expiredServer.Close()
+
+	plainServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer plainServer.Close()
+
+	dnsServer, err := test.SetupDNSServer(map[string][]net.IP{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dnsServer.Close()
+
+	conf := &config.Config{
+		Modules: map[string]config.Module{
+			"https": config.Module{
+				Prober: "https",
+				TLSConfig: config.TLSConfig{
+					CAFile: caFile,
+				},
+			},
+			"https_expired": config.Module{
+				Prober: "https",
+				TLSConfig: config.TLSConfig{
+					CAFile: expiredCAFile,
+				},
+			},
+			"https_no_ca": config.Module{
+				Prober: "https",
+			},
+			"https_server_name": config.Module{
+				Prober: "https",
+				TLSConfig: config.TLSConfig{
+					CAFile:     caFile,
+					ServerName: "other.ribbybibby.me",
+				},
+			},
+			"tcp": config.Module{
+				Prober: "tcp",
+			},
+			"tcp_resolver": config.Module{
+				Prober:   "tcp",
+				Resolver: dnsServer.Conn.LocalAddr().String(),
+			},
+		},
+	}
+
+	testcases := []struct {
+		name      string
+		target    string
+		module    string
+		errorType string
+	}{
+		{name: "expired", target: expiredServer.URL, module: "https_expired", errorType: "verify_expired"},
+		{name: "unknown authority", target: server.URL, module: "https_no_ca", errorType: "verify_unknown_authority"},
+		{name: "hostname mismatch", target: server.URL, module: "https_server_name", errorType: "hostname_mismatch"},
+		{name: "dns", target: "missing.example.test:443", module: "tcp_resolver", errorType: "dns"},
+		{name: "connect", target: "127.0.0.1:1", module: "tcp", errorType: "connect"},
+		{name: "handshake", target: plainServer.Listener.Addr().String(), module: "tcp", errorType: "handshake"},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr, err := probe(tc.target, tc.module, conf)
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+
+			expected := "ssl_probe_error_info{type=\"" + tc.errorType + "\"} 1"
+			if ok := strings.Contains(rr.Body.String(), expected); !ok {
+				t.Errorf("expected `%s`, got: %s", expected, rr.Body.String())
+			}
+		})
+	}
+
+	rr, err := probe(server.URL, "https", conf)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if ok := strings.Contains(rr.Body.String(), "ssl_probe_error_info"); ok {
+		t.Errorf("unexpected ssl_probe_error_info for a successful probe")
+	}
+}
+
+// TestProbeHandlerHistory tests that the outcome of a probe and the certificate
+// presented to it are kept in the history file, across restarts
+func TestProbeHandlerHistory(t *testing.T) {
+	server, _, _, caFile, teardown, err := test.SetupHTTPSServer()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer teardown()
+
+	server.StartTLS()
+	defer server.Close()
+
+	dir, err := os.MkdirTemp("", "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	historyFile := filepath.Join(dir, "history.db")
+
+	defer func(h *history.History) { prober.History = h }(prober.History)
+	prober.History, err = history.Open(historyFile, 0, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := &config.Config{
+		Modules: map[string]config.Module{
+			"https": config.Module{
+				Prober: "https",
+				TLSConfig: config.TLSConfig{
+					CAFile: caFile,
+				},
+				CertChanges: true,
+			},
+		},
+	}
+
+	if _, err := probe(server.URL, "https", conf); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err := prober.History.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	prober.History, err = history.Open(historyFile, 0, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer prober.History.Close()
+
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "/history?target="+url.QueryEscape(server.URL), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prober.History.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rr.Code)
+	}
+
+	var records []history.Record
+	if err := json.Unmarshal(rr.Body.Bytes(), &records); err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("expected 1 record in the history, got %d", len(records))
+	}
+	record := records[0]
+	if record.Module != "https" {
+		t.Errorf("expected the record of the https module, got %q", record.Module)
+	}
+	if !record.Success {
+		t.Errorf("expected a successful probe in the history")
+	}
+	// The server name isn't sent for an IP address
+	cert, ok := record.Certificates[""]
+	if !ok {
+		t.Fatalf("expected the certificate without a server name in the history")
+	}
+	if cert.Fingerprint == "" {
+		t.Errorf("expected the fingerprint of the certificate in the history")
+	}
+	if cert.NotAfter.IsZero() {
+		t.Errorf("expected the expiry of the certificate in the history")
+	}
+}
+
 func probe(target, module string, conf *config.Config) (*httptest.ResponseRecorder, error) {
 	uri := "/probe?target=" + target
 	if module != "" {
@@ -0,0 +1,138 @@
+package test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"time"
+)
+
+// OIDSCTList is the extension that embeds SCTs in a certificate
+var OIDSCTList = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+
+// CTLog signs SCTs like a certificate transparency log
+type CTLog struct {
+	Key *ecdsa.PrivateKey
+}
+
+// NewCTLog creates a log with a new key
+func NewCTLog() (*CTLog, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CTLog{Key: key}, nil
+}
+
+// LogList returns a log list in the v3 JSON format that contains the log
+func (l *CTLog) LogList(operator, description string) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(&l.Key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	id := sha256.Sum256(der)
+
+	type log struct {
+		Description string `json:"description"`
+		LogID       string `json:"log_id"`
+		Key         string `json:"key"`
+		URL         string `json:"url"`
+	}
+	type logOperator struct {
+		Name string `json:"name"`
+		Logs []log  `json:"logs"`
+	}
+
+	return json.Marshal(map[string]interface{}{
+		"version": "1.0",
+		"operators": []logOperator{
+			{
+				Name: operator,
+				Logs: []log{
+					{
+						Description: description,
+						LogID:       base64.StdEncoding.EncodeToString(id[:]),
+						Key:         base64.StdEncoding.EncodeToString(der),
+						URL:         "https://ct.example.com/",
+					},
+				},
+			},
+		},
+	})
+}
+
+// SignCertificate returns an SCT for the DER encoded certificate, as it's
+// sent in the TLS extension
+func (l *CTLog) SignCertificate(der []byte) ([]byte, error) {
+	entry := []byte{byte(len(der) >> 16), byte(len(der) >> 8), byte(len(der))}
+	entry = append(entry, der...)
+
+	return l.sign(0, entry)
+}
+
+// SignPrecertificate returns an SCT for the DER encoded TBSCertificate of a
+// precertificate issued by the issuer, to embed in the certificate
+func (l *CTLog) SignPrecertificate(tbs []byte, issuer *x509.Certificate) ([]byte, error) {
+	issuerKeyHash := sha256.Sum256(issuer.RawSubjectPublicKeyInfo)
+	entry := append(issuerKeyHash[:], byte(len(tbs)>>16), byte(len(tbs)>>8), byte(len(tbs)))
+	entry = append(entry, tbs...)
+
+	return l.sign(1, entry)
+}
+
+func (l *CTLog) sign(entryType uint16, entry []byte) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(&l.Key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	id := sha256.Sum256(der)
+	timestamp := uint64(time.Now().UnixMilli())
+
+	signed := []byte{0, 0}
+	signed = binary.BigEndian.AppendUint64(signed, timestamp)
+	signed = binary.BigEndian.AppendUint16(signed, entryType)
+	signed = append(signed, entry...)
+	signed = append(signed, 0, 0)
+	digest := sha256.Sum256(signed)
+
+	signature, err := ecdsa.SignASN1(rand.Reader, l.Key, digest[:])
+	if err != nil {
+		return nil, err
+	}
+
+	sct := []byte{0}
+	sct = append(sct, id[:]...)
+	sct = binary.BigEndian.AppendUint64(sct, timestamp)
+	sct = append(sct, 0, 0)
+	// SHA-256 and ECDSA
+	sct = append(sct, 4, 3)
+	sct = binary.BigEndian.AppendUint16(sct, uint16(len(signature)))
+
+	return append(sct, signature...), nil
+}
+
+// SCTListExtension returns the extension that embeds the SCTs in a
+// certificate
+func SCTListExtension(scts ...[]byte) (pkix.Extension, error) {
+	var list []byte
+	for _, sct := range scts {
+		list = binary.BigEndian.AppendUint16(list, uint16(len(sct)))
+		list = append(list, sct...)
+	}
+	list = append(binary.BigEndian.AppendUint16(nil, uint16(len(list))), list...)
+
+	value, err := asn1.Marshal(list)
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+
+	return pkix.Extension{Id: OIDSCTList, Value: value}, nil
+}
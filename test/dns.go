@@ -0,0 +1,356 @@
+package test
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DNSServer answers A, AAAA and SRV queries for a fixed set of records over UDP,
+// and optionally over TLS and HTTPS
+type DNSServer struct {
+	Conn net.PacketConn
+	// Records map fully qualified names to their addresses
+	Records map[string][]net.IP
+	// SRVRecords map fully qualified names to their SRV records
+	SRVRecords map[string][]net.SRV
+
+	mu            sync.RWMutex
+	tlsaRecords   map[string][]TLSA
+	caaRecords    map[string][]CAA
+	httpsRecords  map[string][]HTTPS
+	authenticated bool
+}
+
+// TLSA is a TLSA record, as described in RFC 6698
+type TLSA struct {
+	Usage        uint8
+	Selector     uint8
+	MatchingType uint8
+	Data         []byte
+}
+
+// CAA is a CAA record, as described in RFC 8659
+type CAA struct {
+	Flags uint8
+	Tag   string
+	Value string
+}
+
+// HTTPS is an HTTPS record, as described in RFC 9460. Only the ech SvcParam
+// is supported.
+type HTTPS struct {
+	Priority      uint16
+	Target        string
+	ECHConfigList []byte
+}
+
+const (
+	// These are the resource record types that dnsmessage doesn't define
+	typeTLSA  dnsmessage.Type = 52
+	typeHTTPS dnsmessage.Type = 65
+	typeCAA   dnsmessage.Type = 257
+)
+
+// SetupDNSServer starts a DNS server that answers with the given records
+func SetupDNSServer(records map[string][]net.IP) (*DNSServer, error) {
+	return SetupDNSServerWithSRV(records, nil)
+}
+
+// SetupDNSServerWithSRV starts a DNS server that answers with the given
+// address and SRV records
+func SetupDNSServerWithSRV(records map[string][]net.IP, srvRecords map[string][]net.SRV) (*DNSServer, error) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	server := &DNSServer{
+		Conn:       conn,
+		Records:    records,
+		SRVRecords: srvRecords,
+	}
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			resp, err := server.answer(buf[:n])
+			if err != nil {
+				continue
+			}
+			conn.WriteTo(resp, addr)
+		}
+	}()
+
+	return server, nil
+}
+
+// SetTLSA sets the TLSA records for the fully qualified name
+func (s *DNSServer) SetTLSA(name string, records []TLSA) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.tlsaRecords == nil {
+		s.tlsaRecords = map[string][]TLSA{}
+	}
+	s.tlsaRecords[name] = records
+}
+
+// SetCAA sets the CAA records for the fully qualified name
+func (s *DNSServer) SetCAA(name string, records []CAA) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.caaRecords == nil {
+		s.caaRecords = map[string][]CAA{}
+	}
+	s.caaRecords[name] = records
+}
+
+// SetHTTPS sets the HTTPS records for the fully qualified name
+func (s *DNSServer) SetHTTPS(name string, records []HTTPS) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.httpsRecords == nil {
+		s.httpsRecords = map[string][]HTTPS{}
+	}
+	s.httpsRecords[name] = records
+}
+
+// SetAuthenticated sets whether responses are marked as authenticated with
+// DNSSEC, like they are by a validating resolver
+func (s *DNSServer) SetAuthenticated(authenticated bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.authenticated = authenticated
+}
+
+// Close stops the server
+func (s *DNSServer) Close() {
+	s.Conn.Close()
+}
+
+// ServeTLS answers queries on the DNS over TLS connections accepted by the
+// listener, until it's closed
+func (s *DNSServer) ServeTLS(ln net.Listener) {
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+
+				conn.SetDeadline(time.Now().Add(5 * time.Second))
+				for {
+					length := make([]byte, 2)
+					if _, err := io.ReadFull(conn, length); err != nil {
+						return
+					}
+					query := make([]byte, binary.BigEndian.Uint16(length))
+					if _, err := io.ReadFull(conn, query); err != nil {
+						return
+					}
+					resp, err := s.answer(query)
+					if err != nil {
+						return
+					}
+					binary.Write(conn, binary.BigEndian, uint16(len(resp)))
+					conn.Write(resp)
+				}
+			}()
+		}
+	}()
+}
+
+// ServeHTTP answers DNS over HTTPS POST requests
+func (s *DNSServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/dns-message" {
+		http.Error(w, "unsupported request", http.StatusBadRequest)
+		return
+	}
+	query, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	resp, err := s.answer(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/dns-message")
+	w.Write(resp)
+}
+
+// answer builds the response to a query
+func (s *DNSServer) answer(query []byte) ([]byte, error) {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(query)
+	if err != nil {
+		return nil, err
+	}
+	question, err := parser.Question()
+	if err != nil {
+		return nil, err
+	}
+
+	name := strings.ToLower(question.Name.String())
+	ips, ok := s.Records[name]
+	srvs, srvOK := s.SRVRecords[name]
+	s.mu.RLock()
+	tlsas, tlsaOK := s.tlsaRecords[name]
+	caas, caaOK := s.caaRecords[name]
+	httpss, httpsOK := s.httpsRecords[name]
+	authenticated := s.authenticated
+	s.mu.RUnlock()
+	ok = ok || srvOK || tlsaOK || caaOK || httpsOK
+
+	rcode := dnsmessage.RCodeSuccess
+	if !ok {
+		rcode = dnsmessage.RCodeNameError
+	}
+
+	builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{
+		ID:                 header.ID,
+		Response:           true,
+		Authoritative:      true,
+		RecursionDesired:   header.RecursionDesired,
+		RecursionAvailable: true,
+		AuthenticData:      authenticated,
+		RCode:              rcode,
+	})
+	builder.EnableCompression()
+	if err := builder.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := builder.Question(question); err != nil {
+		return nil, err
+	}
+	if err := builder.StartAnswers(); err != nil {
+		return nil, err
+	}
+
+	for _, ip := range ips {
+		rh := dnsmessage.ResourceHeader{
+			Name:  question.Name,
+			Class: dnsmessage.ClassINET,
+			TTL:   60,
+		}
+		switch {
+		case question.Type == dnsmessage.TypeA && ip.To4() != nil:
+			var a [4]byte
+			copy(a[:], ip.To4())
+			if err := builder.AResource(rh, dnsmessage.AResource{A: a}); err != nil {
+				return nil, err
+			}
+		case question.Type == dnsmessage.TypeAAAA && ip.To4() == nil:
+			var aaaa [16]byte
+			copy(aaaa[:], ip.To16())
+			if err := builder.AAAAResource(rh, dnsmessage.AAAAResource{AAAA: aaaa}); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if question.Type == dnsmessage.TypeSRV {
+		for _, srv := range srvs {
+			target, err := dnsmessage.NewName(srv.Target)
+			if err != nil {
+				return nil, err
+			}
+			rh := dnsmessage.ResourceHeader{
+				Name:  question.Name,
+				Class: dnsmessage.ClassINET,
+				TTL:   60,
+			}
+			if err := builder.SRVResource(rh, dnsmessage.SRVResource{
+				Priority: srv.Priority,
+				Weight:   srv.Weight,
+				Port:     srv.Port,
+				Target:   target,
+			}); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if question.Type == typeTLSA {
+		for _, tlsa := range tlsas {
+			rh := dnsmessage.ResourceHeader{
+				Name:  question.Name,
+				Class: dnsmessage.ClassINET,
+				TTL:   60,
+			}
+			data := append([]byte{tlsa.Usage, tlsa.Selector, tlsa.MatchingType}, tlsa.Data...)
+			if err := builder.UnknownResource(rh, dnsmessage.UnknownResource{
+				Type: typeTLSA,
+				Data: data,
+			}); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if question.Type == typeHTTPS {
+		for _, https := range httpss {
+			rh := dnsmessage.ResourceHeader{
+				Name:  question.Name,
+				Class: dnsmessage.ClassINET,
+				TTL:   60,
+			}
+			// The TargetName is encoded without compression
+			data := binary.BigEndian.AppendUint16(nil, https.Priority)
+			for _, label := range strings.Split(strings.TrimSuffix(https.Target, "."), ".") {
+				if label != "" {
+					data = append(append(data, byte(len(label))), label...)
+				}
+			}
+			data = append(data, 0)
+			if https.ECHConfigList != nil {
+				data = binary.BigEndian.AppendUint16(data, 5)
+				data = binary.BigEndian.AppendUint16(data, uint16(len(https.ECHConfigList)))
+				data = append(data, https.ECHConfigList...)
+			}
+			if err := builder.UnknownResource(rh, dnsmessage.UnknownResource{
+				Type: typeHTTPS,
+				Data: data,
+			}); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if question.Type == typeCAA {
+		for _, caa := range caas {
+			rh := dnsmessage.ResourceHeader{
+				Name:  question.Name,
+				Class: dnsmessage.ClassINET,
+				TTL:   60,
+			}
+			data := append([]byte{caa.Flags, byte(len(caa.Tag))}, caa.Tag...)
+			data = append(data, caa.Value...)
+			if err := builder.UnknownResource(rh, dnsmessage.UnknownResource{
+				Type: typeCAA,
+				Data: data,
+			}); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return builder.Finish()
+}
@@ -0,0 +1,90 @@
+package test
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"os"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pion/dtls/v3"
+)
+
+// DTLSServer allows manipulation of the dtls.Config before starting the
+// listener
+type DTLSServer struct {
+	Listener net.Listener
+	stopCh   chan struct{}
+	logger   log.Logger
+}
+
+// Start accepts a single connection and performs the DTLS handshake
+func (d *DTLSServer) Start() {
+	go func() {
+		defer func() {
+			d.stopCh <- struct{}{}
+		}()
+
+		conn, err := d.Listener.Accept()
+		if err != nil {
+			level.Error(d.logger).Log("msg", err)
+			return
+		}
+		defer conn.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := conn.(*dtls.Conn).HandshakeContext(ctx); err != nil {
+			level.Error(d.logger).Log("msg", err)
+		}
+	}()
+}
+
+// Close stops the server and closes the listener
+func (d *DTLSServer) Close() {
+	<-d.stopCh
+	d.Listener.Close()
+}
+
+// SetupDTLSServer sets up a server for testing with a generated cert and key
+// pair
+func SetupDTLSServer() (*DTLSServer, []byte, []byte, string, func(), error) {
+	var teardown func()
+
+	testcertPEM, testkeyPEM := GenerateTestCertificate(time.Now().AddDate(0, 0, 1))
+
+	caFile, err := WriteFile("certfile.pem", testcertPEM)
+	if err != nil {
+		return nil, testcertPEM, testkeyPEM, caFile, teardown, err
+	}
+
+	teardown = func() {
+		os.Remove(caFile)
+	}
+
+	testCert, err := tls.X509KeyPair(testcertPEM, testkeyPEM)
+	if err != nil {
+		return nil, testcertPEM, testkeyPEM, caFile, teardown, err
+	}
+
+	dtlsConfig := &dtls.Config{
+		Certificates:         []tls.Certificate{testCert},
+		ExtendedMasterSecret: dtls.RequireExtendedMasterSecret,
+	}
+
+	ln, err := dtls.Listen("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, dtlsConfig)
+	if err != nil {
+		return nil, testcertPEM, testkeyPEM, caFile, teardown, err
+	}
+
+	server := &DTLSServer{
+		Listener: ln,
+		stopCh:   make(chan (struct{})),
+		logger:   log.NewLogfmtLogger(log.NewSyncWriter(os.Stdout)),
+	}
+
+	return server, testcertPEM, testkeyPEM, caFile, teardown, nil
+}
@@ -0,0 +1,42 @@
+package test
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/binary"
+)
+
+// GenerateECHConfig generates an X25519 key for the encrypted client hello and
+// returns the ECHConfig that publishes it under the public name, the private
+// key and the ECHConfigList that contains the config, which is published in
+// DNS
+func GenerateECHConfig(configID uint8, publicName string) ([]byte, []byte, []byte, error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	publicKey := key.PublicKey().Bytes()
+
+	// ECHConfigContents, with the DHKEM(X25519, HKDF-SHA256) KEM, the
+	// HKDF-SHA256 KDF and the AES-128-GCM AEAD
+	contents := []byte{configID}
+	contents = binary.BigEndian.AppendUint16(contents, 0x0020)
+	contents = binary.BigEndian.AppendUint16(contents, uint16(len(publicKey)))
+	contents = append(contents, publicKey...)
+	contents = binary.BigEndian.AppendUint16(contents, 4)
+	contents = binary.BigEndian.AppendUint16(contents, 0x0001)
+	contents = binary.BigEndian.AppendUint16(contents, 0x0001)
+	contents = append(contents, 0, byte(len(publicName)))
+	contents = append(contents, publicName...)
+	contents = binary.BigEndian.AppendUint16(contents, 0)
+
+	config := binary.BigEndian.AppendUint16(nil, 0xfe0d)
+	config = binary.BigEndian.AppendUint16(config, uint16(len(contents)))
+	config = append(config, contents...)
+
+	configList := binary.BigEndian.AppendUint16(nil, uint16(len(config)))
+	configList = append(configList, config...)
+
+	return config, key.Bytes(), configList, nil
+}
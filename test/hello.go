@@ -0,0 +1,137 @@
+package test
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"net"
+)
+
+// ClientHello is the part of a ClientHello message that is parsed by the hello
+// server
+type ClientHello struct {
+	Version            uint16
+	CipherSuites       []uint16
+	CompressionMethods []uint8
+}
+
+// HelloServer responds to the ClientHello on each connection with the records
+// returned by respond and closes it, to test the handshakes that crypto/tls
+// doesn't make
+type HelloServer struct {
+	Listener net.Listener
+	respond  func(hello ClientHello) []byte
+}
+
+// SetupHelloServer starts a hello server
+func SetupHelloServer(respond func(hello ClientHello) []byte) (*HelloServer, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &HelloServer{
+		Listener: ln,
+		respond:  respond,
+	}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+
+	return s, nil
+}
+
+// Close stops the server
+func (s *HelloServer) Close() {
+	s.Listener.Close()
+}
+
+func (s *HelloServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return
+	}
+	record := make([]byte, binary.BigEndian.Uint16(header[3:5]))
+	if _, err := io.ReadFull(conn, record); err != nil {
+		return
+	}
+
+	hello, ok := parseClientHello(record)
+	if !ok {
+		conn.Write(AlertRecord(50))
+		return
+	}
+
+	conn.Write(s.respond(hello))
+}
+
+// parseClientHello parses a ClientHello message that fits in one record
+func parseClientHello(data []byte) (ClientHello, bool) {
+	var hello ClientHello
+
+	// The message type and length, version and random
+	if len(data) < 4+2+32+1 || data[0] != 1 {
+		return hello, false
+	}
+	hello.Version = binary.BigEndian.Uint16(data[4:6])
+	data = data[38:]
+
+	sessionIDLength := int(data[0])
+	if len(data) < 1+sessionIDLength+2 {
+		return hello, false
+	}
+	data = data[1+sessionIDLength:]
+
+	cipherSuitesLength := int(binary.BigEndian.Uint16(data[0:2]))
+	if len(data) < 2+cipherSuitesLength+1 {
+		return hello, false
+	}
+	for i := 0; i < cipherSuitesLength; i += 2 {
+		hello.CipherSuites = append(hello.CipherSuites, binary.BigEndian.Uint16(data[2+i:4+i]))
+	}
+	data = data[2+cipherSuitesLength:]
+
+	compressionMethodsLength := int(data[0])
+	if len(data) < 1+compressionMethodsLength {
+		return hello, false
+	}
+	hello.CompressionMethods = data[1 : 1+compressionMethodsLength]
+
+	return hello, true
+}
+
+// ServerHelloRecord returns a handshake record containing a ServerHello with
+// the renegotiation_info extension
+func ServerHelloRecord(version, cipherSuite uint16, compressionMethod uint8) []byte {
+	random := make([]byte, 32)
+	rand.Read(random)
+
+	body := binary.BigEndian.AppendUint16(nil, version)
+	body = append(body, random...)
+	body = append(body, 0)
+	body = binary.BigEndian.AppendUint16(body, cipherSuite)
+	body = append(body, compressionMethod)
+	body = append(body, 0x00, 0x05, 0xff, 0x01, 0x00, 0x01, 0x00)
+
+	message := []byte{2, byte(len(body) >> 16), byte(len(body) >> 8), byte(len(body))}
+	message = append(message, body...)
+
+	record := []byte{22, 0x03, 0x03}
+	record = binary.BigEndian.AppendUint16(record, uint16(len(message)))
+
+	return append(record, message...)
+}
+
+// AlertRecord returns a record containing a fatal alert
+func AlertRecord(description uint8) []byte {
+	return []byte{21, 0x03, 0x03, 0x00, 0x02, 2, description}
+}
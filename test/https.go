@@ -2,6 +2,7 @@ package test
 
 import (
 	"crypto/tls"
+	"encoding/base64"
 	"fmt"
 	"io"
 	"net"
@@ -53,7 +54,21 @@ func SetupHTTPSServerWithCertAndKey(caPEM, certPEM, keyPEM []byte) (*httptest.Se
 
 // SetupHTTPProxyServer sets up a proxy server
 func SetupHTTPProxyServer() (*httptest.Server, error) {
+	return SetupHTTPProxyServerWithBasicAuth("", "")
+}
+
+// SetupHTTPProxyServerWithBasicAuth sets up a proxy server that requires the
+// given credentials in the Proxy-Authorization header, if the username isn't
+// empty
+func SetupHTTPProxyServerWithBasicAuth(username, password string) (*httptest.Server, error) {
 	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if username != "" {
+			auth := "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+			if r.Header.Get("Proxy-Authorization") != auth {
+				http.Error(w, "Proxy authentication required", http.StatusProxyAuthRequired)
+				return
+			}
+		}
 		if r.Method == http.MethodConnect {
 			destConn, err := net.DialTimeout("tcp", r.Host, 10*time.Second)
 			if err != nil {
@@ -0,0 +1,47 @@
+package test
+
+import (
+	"crypto"
+	"crypto/x509"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// SetupOCSPResponder starts an OCSP responder that responds to every request
+// with the status, in a response for the issuer that is signed by the signer
+func SetupOCSPResponder(issuer *x509.Certificate, signer crypto.Signer, status int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		req, err := ocsp.ParseRequest(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		template := ocsp.Response{
+			Status:       status,
+			SerialNumber: req.SerialNumber,
+			ThisUpdate:   time.Now().Add(-time.Hour).Truncate(time.Second),
+			NextUpdate:   time.Now().Add(time.Hour).Truncate(time.Second),
+		}
+		if status == ocsp.Revoked {
+			template.RevokedAt = time.Now().Add(-2 * time.Hour).Truncate(time.Second)
+		}
+
+		resp, err := ocsp.CreateResponse(issuer, issuer, template, signer)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/ocsp-response")
+		w.Write(resp)
+	}))
+}
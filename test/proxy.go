@@ -0,0 +1,112 @@
+package test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+var proxyProtocolV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// ProxyProtocolListener wraps a listener and requires each connection to
+// start with a PROXY protocol header that matches the connection's addresses,
+// like a backend behind a load balancer would. Connections without a valid
+// header are closed.
+type ProxyProtocolListener struct {
+	net.Listener
+	// Version is the PROXY protocol version that is accepted: v1 or v2
+	Version string
+}
+
+// NewProxyProtocolListener returns a listener that requires the PROXY protocol
+// header of the given version
+func NewProxyProtocolListener(ln net.Listener, version string) *ProxyProtocolListener {
+	return &ProxyProtocolListener{
+		Listener: ln,
+		Version:  version,
+	}
+}
+
+// Accept waits for a connection with a valid PROXY protocol header and
+// returns it with the header removed
+func (l *ProxyProtocolListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		reader := bufio.NewReader(conn)
+		if err := l.readHeader(conn, reader); err != nil {
+			conn.Close()
+			continue
+		}
+
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+}
+
+func (l *ProxyProtocolListener) readHeader(conn net.Conn, reader *bufio.Reader) error {
+	var src, dst string
+
+	switch l.Version {
+	case "v1":
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 6 || fields[0] != "PROXY" {
+			return fmt.Errorf("invalid PROXY header: %q", line)
+		}
+		src = net.JoinHostPort(fields[2], fields[4])
+		dst = net.JoinHostPort(fields[3], fields[5])
+	case "v2":
+		header := make([]byte, 16)
+		if _, err := io.ReadFull(reader, header); err != nil {
+			return err
+		}
+		if !bytes.Equal(header[:12], proxyProtocolV2Signature) || header[12] != 0x21 {
+			return fmt.Errorf("invalid PROXY header: %x", header)
+		}
+		addrs := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+		if _, err := io.ReadFull(reader, addrs); err != nil {
+			return err
+		}
+		size := 4
+		if header[13] == 0x21 {
+			size = 16
+		} else if header[13] != 0x11 {
+			return fmt.Errorf("unsupported PROXY address family: %x", header[13])
+		}
+		if len(addrs) < 2*size+4 {
+			return fmt.Errorf("PROXY addresses are too short")
+		}
+		src = net.JoinHostPort(net.IP(addrs[:size]).String(), strconv.Itoa(int(binary.BigEndian.Uint16(addrs[2*size:]))))
+		dst = net.JoinHostPort(net.IP(addrs[size:2*size]).String(), strconv.Itoa(int(binary.BigEndian.Uint16(addrs[2*size+2:]))))
+	default:
+		return fmt.Errorf("unsupported PROXY protocol version %s", l.Version)
+	}
+
+	if src != conn.RemoteAddr().String() || dst != conn.LocalAddr().String() {
+		return fmt.Errorf("PROXY header addresses %s -> %s don't match the connection", src, dst)
+	}
+
+	return nil
+}
+
+// bufferedConn reads from a buffered reader that may hold data that has
+// already been read from the connection
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
@@ -0,0 +1,91 @@
+package test
+
+import (
+	"context"
+	"crypto/tls"
+	"os"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/quic-go/quic-go"
+)
+
+// QUICServer allows manipulation of the tls.Config before starting the
+// listener
+type QUICServer struct {
+	Listener *quic.Listener
+	stopCh   chan struct{}
+	logger   log.Logger
+}
+
+// Start accepts a single connection, which is returned by the listener once
+// the QUIC handshake is complete
+func (q *QUICServer) Start() {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		conn, err := q.Listener.Accept(ctx)
+		if err != nil {
+			level.Error(q.logger).Log("msg", err)
+			q.stopCh <- struct{}{}
+			return
+		}
+
+		// Wait for the client to close the connection
+		select {
+		case <-conn.Context().Done():
+		case <-ctx.Done():
+		}
+		conn.CloseWithError(0, "")
+
+		q.stopCh <- struct{}{}
+	}()
+}
+
+// Close stops the server and closes the listener
+func (q *QUICServer) Close() {
+	<-q.stopCh
+	q.Listener.Close()
+}
+
+// SetupQUICServer sets up a server for testing with a generated cert and key
+// pair
+func SetupQUICServer() (*QUICServer, []byte, []byte, string, func(), error) {
+	var teardown func()
+
+	testcertPEM, testkeyPEM := GenerateTestCertificate(time.Now().AddDate(0, 0, 1))
+
+	caFile, err := WriteFile("certfile.pem", testcertPEM)
+	if err != nil {
+		return nil, testcertPEM, testkeyPEM, caFile, teardown, err
+	}
+
+	teardown = func() {
+		os.Remove(caFile)
+	}
+
+	testCert, err := tls.X509KeyPair(testcertPEM, testkeyPEM)
+	if err != nil {
+		return nil, testcertPEM, testkeyPEM, caFile, teardown, err
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{testCert},
+		NextProtos:   []string{"h3"},
+	}
+
+	ln, err := quic.ListenAddr("127.0.0.1:0", tlsConfig, nil)
+	if err != nil {
+		return nil, testcertPEM, testkeyPEM, caFile, teardown, err
+	}
+
+	server := &QUICServer{
+		Listener: ln,
+		stopCh:   make(chan (struct{})),
+		logger:   log.NewLogfmtLogger(log.NewSyncWriter(os.Stdout)),
+	}
+
+	return server, testcertPEM, testkeyPEM, caFile, teardown, nil
+}
@@ -0,0 +1,196 @@
+package test
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// SOCKS5Server is a SOCKS5 proxy that supports the CONNECT command
+type SOCKS5Server struct {
+	Listener net.Listener
+	username string
+	password string
+}
+
+// SetupSOCKS5Server starts a SOCKS5 proxy that requires the given credentials,
+// if the username isn't empty
+func SetupSOCKS5Server(username, password string) (*SOCKS5Server, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	server := &SOCKS5Server{
+		Listener: ln,
+		username: username,
+		password: password,
+	}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+
+	return server, nil
+}
+
+// Close stops the proxy
+func (s *SOCKS5Server) Close() {
+	s.Listener.Close()
+}
+
+func (s *SOCKS5Server) serve(conn net.Conn) {
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		return
+	}
+
+	target, err := s.handshake(conn)
+	if err != nil {
+		return
+	}
+
+	destConn, err := net.DialTimeout("tcp", target, 5*time.Second)
+	if err != nil {
+		// General failure
+		conn.Write([]byte{0x05, 0x01, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer destConn.Close()
+
+	if _, err := conn.Write([]byte{0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0}); err != nil {
+		return
+	}
+
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		return
+	}
+
+	go func() {
+		io.Copy(destConn, conn)
+		destConn.Close()
+	}()
+	io.Copy(conn, destConn)
+}
+
+// handshake negotiates authentication and returns the address of the CONNECT
+// request
+func (s *SOCKS5Server) handshake(conn net.Conn) (string, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", err
+	}
+	if header[0] != 0x05 {
+		return "", fmt.Errorf("unsupported SOCKS version %d", header[0])
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", err
+	}
+
+	method := byte(0x00)
+	if s.username != "" {
+		method = 0x02
+	}
+	offered := false
+	for _, m := range methods {
+		if m == method {
+			offered = true
+		}
+	}
+	if !offered {
+		conn.Write([]byte{0x05, 0xff})
+		return "", fmt.Errorf("no acceptable authentication methods")
+	}
+	if _, err := conn.Write([]byte{0x05, method}); err != nil {
+		return "", err
+	}
+
+	if method == 0x02 {
+		username, password, err := readSOCKS5Credentials(conn)
+		if err != nil {
+			return "", err
+		}
+		if username != s.username || password != s.password {
+			conn.Write([]byte{0x01, 0x01})
+			return "", fmt.Errorf("invalid credentials")
+		}
+		if _, err := conn.Write([]byte{0x01, 0x00}); err != nil {
+			return "", err
+		}
+	}
+
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn, request); err != nil {
+		return "", err
+	}
+	if request[1] != 0x01 {
+		return "", fmt.Errorf("unsupported SOCKS command %d", request[1])
+	}
+
+	var host string
+	switch request[3] {
+	case 0x01, 0x04:
+		size := net.IPv4len
+		if request[3] == 0x04 {
+			size = net.IPv6len
+		}
+		ip := make([]byte, size)
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return "", err
+		}
+		host = net.IP(ip).String()
+	case 0x03:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return "", err
+		}
+		name := make([]byte, length[0])
+		if _, err := io.ReadFull(conn, name); err != nil {
+			return "", err
+		}
+		host = string(name)
+	default:
+		return "", fmt.Errorf("unsupported SOCKS address type %d", request[3])
+	}
+
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return "", err
+	}
+
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+// readSOCKS5Credentials reads a username/password authentication request
+func readSOCKS5Credentials(conn net.Conn) (string, string, error) {
+	var fields [2]string
+
+	version := make([]byte, 1)
+	if _, err := io.ReadFull(conn, version); err != nil {
+		return "", "", err
+	}
+	for i := range fields {
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return "", "", err
+		}
+		field := make([]byte, length[0])
+		if _, err := io.ReadFull(conn, field); err != nil {
+			return "", "", err
+		}
+		fields[i] = string(field)
+	}
+
+	return fields[0], fields[1], nil
+}
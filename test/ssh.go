@@ -0,0 +1,231 @@
+package test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"golang.org/x/crypto/ssh"
+)
+
+// SSHServer allows manipulation of the ssh.ServerConfig before starting the
+// listener
+type SSHServer struct {
+	Listener net.Listener
+	Config   *ssh.ServerConfig
+	// HostKey is the plain host key that the certificate is issued for
+	HostKey ssh.Signer
+	stopCh  chan struct{}
+	logger  log.Logger
+}
+
+// Start starts a listener that performs the SSH key exchange with a single
+// client
+func (s *SSHServer) Start() {
+	go func() {
+		conn, err := s.Listener.Accept()
+		if err != nil {
+			panic(fmt.Sprintf("Error accepting on socket: %s", err))
+		}
+		defer conn.Close()
+
+		if err := conn.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+			panic("Error setting deadline")
+		}
+
+		// The handshake fails when the client disconnects without
+		// authenticating
+		ssh.NewServerConn(conn, s.Config)
+
+		s.stopCh <- struct{}{}
+	}()
+}
+
+// StartJump starts a listener that forwards the direct-tcpip channels of a
+// single client, like a jump host
+func (s *SSHServer) StartJump() {
+	go func() {
+		conn, err := s.Listener.Accept()
+		if err != nil {
+			panic(fmt.Sprintf("Error accepting on socket: %s", err))
+		}
+		defer conn.Close()
+
+		if err := conn.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+			panic("Error setting deadline")
+		}
+
+		_, chans, reqs, err := ssh.NewServerConn(conn, s.Config)
+		if err != nil {
+			level.Error(s.logger).Log("msg", err)
+			s.stopCh <- struct{}{}
+			return
+		}
+		go ssh.DiscardRequests(reqs)
+
+		for newChannel := range chans {
+			if newChannel.ChannelType() != "direct-tcpip" {
+				newChannel.Reject(ssh.UnknownChannelType, "unsupported channel type")
+				continue
+			}
+			var payload struct {
+				Host       string
+				Port       uint32
+				OriginHost string
+				OriginPort uint32
+			}
+			if err := ssh.Unmarshal(newChannel.ExtraData(), &payload); err != nil {
+				newChannel.Reject(ssh.ConnectionFailed, err.Error())
+				continue
+			}
+			destConn, err := net.Dial("tcp", net.JoinHostPort(payload.Host, strconv.Itoa(int(payload.Port))))
+			if err != nil {
+				newChannel.Reject(ssh.ConnectionFailed, err.Error())
+				continue
+			}
+			channel, requests, err := newChannel.Accept()
+			if err != nil {
+				destConn.Close()
+				continue
+			}
+			go ssh.DiscardRequests(requests)
+			go func() {
+				io.Copy(destConn, channel)
+				destConn.Close()
+			}()
+			go func() {
+				io.Copy(channel, destConn)
+				channel.Close()
+			}()
+		}
+
+		s.stopCh <- struct{}{}
+	}()
+}
+
+// Close stops the server and closes the listener
+func (s *SSHServer) Close() {
+	<-s.stopCh
+	s.Listener.Close()
+}
+
+// SetupSSHServer sets up a server for testing that presents a host
+// certificate, which is returned, issued by a generated CA
+func SetupSSHServer() (*SSHServer, *ssh.Certificate, error) {
+	hostKey, err := GenerateSSHSigner()
+	if err != nil {
+		return nil, nil, err
+	}
+	caKey, err := GenerateSSHSigner()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cert, err := GenerateSSHCertificate(hostKey.PublicKey(), caKey, ssh.HostCert, time.Now().AddDate(0, 0, 1))
+	if err != nil {
+		return nil, nil, err
+	}
+	certSigner, err := ssh.NewCertSigner(cert, hostKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sshConfig := NewSSHServerConfig()
+	sshConfig.AddHostKey(certSigner)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	server := &SSHServer{
+		Listener: ln,
+		Config:   sshConfig,
+		HostKey:  hostKey,
+		stopCh:   make(chan (struct{})),
+		logger:   log.NewLogfmtLogger(log.NewSyncWriter(os.Stdout)),
+	}
+
+	return server, cert, nil
+}
+
+// SetupSSHJumpServer sets up a jump host for testing that accepts the given
+// client key for any user
+func SetupSSHJumpServer(clientKey ssh.PublicKey) (*SSHServer, error) {
+	hostKey, err := GenerateSSHSigner()
+	if err != nil {
+		return nil, err
+	}
+
+	sshConfig := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if !bytes.Equal(key.Marshal(), clientKey.Marshal()) {
+				return nil, fmt.Errorf("unknown public key for %s", conn.User())
+			}
+			return nil, nil
+		},
+	}
+	sshConfig.AddHostKey(hostKey)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	server := &SSHServer{
+		Listener: ln,
+		Config:   sshConfig,
+		HostKey:  hostKey,
+		stopCh:   make(chan (struct{})),
+		logger:   log.NewLogfmtLogger(log.NewSyncWriter(os.Stdout)),
+	}
+
+	return server, nil
+}
+
+// GenerateSSHCertificate generates an SSH certificate for the given key that
+// is signed by the CA and valid until the given time
+func GenerateSSHCertificate(key ssh.PublicKey, ca ssh.Signer, certType uint32, validBefore time.Time) (*ssh.Certificate, error) {
+	cert := &ssh.Certificate{
+		Key:             key,
+		Serial:          1234,
+		CertType:        certType,
+		KeyId:           "test",
+		ValidPrincipals: []string{"127.0.0.1", "example.com"},
+		ValidAfter:      uint64(time.Now().Add(-time.Hour).Unix()),
+		ValidBefore:     uint64(validBefore.Unix()),
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		return nil, err
+	}
+
+	return cert, nil
+}
+
+// NewSSHServerConfig returns a server config without host keys that rejects
+// every client
+func NewSSHServerConfig() *ssh.ServerConfig {
+	return &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			return nil, fmt.Errorf("authentication is not supported")
+		},
+	}
+}
+
+// GenerateSSHSigner generates an ed25519 SSH key
+func GenerateSSHSigner() (ssh.Signer, error) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	return ssh.NewSignerFromKey(privateKey)
+}
@@ -1,12 +1,17 @@
 package test
 
 import (
+	"bufio"
 	"bytes"
 	"crypto/tls"
+	"encoding/binary"
+	"encoding/xml"
 	"fmt"
 	"io"
+	"math"
 	"net"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/go-kit/log"
@@ -144,6 +149,48 @@ func (t *TCPServer) StartSMTPWithDashInResponse() {
 	}()
 }
 
+// StartSMTPWithEHLOName starts a listener that negotiates a TLS connection
+// with an smtp client that sends EHLO with the given name. The client may
+// close the connection after EHLO.
+func (t *TCPServer) StartSMTPWithEHLOName(name string) {
+	go func() {
+		conn, err := t.Listener.Accept()
+		if err != nil {
+			panic(fmt.Sprintf("Error accepting on socket: %s", err))
+		}
+		defer conn.Close()
+
+		if err := conn.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+			panic("Error setting deadline")
+		}
+
+		reader := bufio.NewReader(conn)
+
+		fmt.Fprintf(conn, "220 ESMTP StartTLS pseudo-server\r\n")
+		line, err := reader.ReadString('\n')
+		if err != nil || line != "EHLO "+name+"\r\n" {
+			panic(fmt.Sprintf("Error in dialog. Unexpected EHLO %q", line))
+		}
+		fmt.Fprintf(conn, "250-pseudo-server.example.net\r\n")
+		fmt.Fprintf(conn, "250-SIZE 10240000\r\n")
+		fmt.Fprintf(conn, "250-auth PLAIN LOGIN\r\n")
+		fmt.Fprintf(conn, "250 STARTTLS\r\n")
+
+		line, err = reader.ReadString('\n')
+		if err == nil && line == "STARTTLS\r\n" {
+			fmt.Fprintf(conn, "220 2.0.0 Ready to start TLS\r\n")
+
+			tlsConn := tls.Server(conn, t.TLS)
+			if err := tlsConn.Handshake(); err != nil {
+				level.Error(t.logger).Log("msg", err)
+			}
+			defer tlsConn.Close()
+		}
+
+		t.stopCh <- struct{}{}
+	}()
+}
+
 // StartFTP starts a listener that negotiates a TLS connection with an ftp
 // client using AUTH TLS
 func (t *TCPServer) StartFTP() {
@@ -230,6 +277,103 @@ func (t *TCPServer) StartPOP3() {
 	}()
 }
 
+// StartPOP3NoSTLS starts a listener that refuses the STLS command sent by a
+// pop3 client
+func (t *TCPServer) StartPOP3NoSTLS() {
+	go func() {
+		conn, err := t.Listener.Accept()
+		if err != nil {
+			panic(fmt.Sprintf("Error accepting on socket: %s", err))
+		}
+		defer conn.Close()
+
+		fmt.Fprintf(conn, "+OK XPOP3 ready.\n")
+		if _, e := fmt.Fscanf(conn, "STLS\n"); e != nil {
+			panic("Error in dialog. No STLS received.")
+		}
+		fmt.Fprintf(conn, "-ERR Command not permitted.\n")
+
+		t.stopCh <- struct{}{}
+	}()
+}
+
+// StartIRC starts a listener that negotiates a TLS connection with an irc
+// client using STARTTLS
+func (t *TCPServer) StartIRC() {
+	go func() {
+		conn, err := t.Listener.Accept()
+		if err != nil {
+			panic(fmt.Sprintf("Error accepting on socket: %s", err))
+		}
+		defer conn.Close()
+
+		fmt.Fprintf(conn, ":irc.example.net NOTICE * :*** Looking up your hostname...\n")
+		if _, e := fmt.Fscanf(conn, "STARTTLS\n"); e != nil {
+			panic("Error in dialog. No STARTTLS received.")
+		}
+		fmt.Fprintf(conn, ":irc.example.net 670 * :STARTTLS successful, go ahead with TLS handshake\n")
+
+		// Upgrade to TLS.
+		tlsConn := tls.Server(conn, t.TLS)
+		if err := tlsConn.Handshake(); err != nil {
+			level.Error(t.logger).Log("msg", err)
+		}
+		defer tlsConn.Close()
+
+		t.stopCh <- struct{}{}
+	}()
+}
+
+// StartNATS starts a listener that upgrades to TLS after sending an INFO line
+// that requires TLS
+func (t *TCPServer) StartNATS() {
+	go func() {
+		conn, err := t.Listener.Accept()
+		if err != nil {
+			panic(fmt.Sprintf("Error accepting on socket: %s", err))
+		}
+		defer conn.Close()
+
+		fmt.Fprintf(conn, "INFO {\"server_id\":\"test\",\"version\":\"2.10.0\",\"tls_required\":true,\"max_payload\":1048576}\r\n")
+
+		// Upgrade to TLS.
+		tlsConn := tls.Server(conn, t.TLS)
+		if err := tlsConn.Handshake(); err != nil {
+			level.Error(t.logger).Log("msg", err)
+		}
+		defer tlsConn.Close()
+
+		t.stopCh <- struct{}{}
+	}()
+}
+
+// StartNNTP starts a listener that negotiates a TLS connection with an nntp
+// client using STARTTLS
+func (t *TCPServer) StartNNTP() {
+	go func() {
+		conn, err := t.Listener.Accept()
+		if err != nil {
+			panic(fmt.Sprintf("Error accepting on socket: %s", err))
+		}
+		defer conn.Close()
+
+		fmt.Fprintf(conn, "200 NNTP Service Ready, posting permitted\n")
+		if _, e := fmt.Fscanf(conn, "STARTTLS\n"); e != nil {
+			panic("Error in dialog. No STARTTLS received.")
+		}
+		fmt.Fprintf(conn, "382 Continue with TLS negotiation\n")
+
+		// Upgrade to TLS.
+		tlsConn := tls.Server(conn, t.TLS)
+		if err := tlsConn.Handshake(); err != nil {
+			level.Error(t.logger).Log("msg", err)
+		}
+		defer tlsConn.Close()
+
+		t.stopCh <- struct{}{}
+	}()
+}
+
 // StartPostgreSQL starts a listener that negotiates a TLS connection with an postgresql
 // client using STARTTLS
 func (t *TCPServer) StartPostgreSQL() {
@@ -269,6 +413,663 @@ func (t *TCPServer) StartPostgreSQL() {
 	}()
 }
 
+// StartPostgreSQLNoSSL starts a listener that refuses the SSLRequest sent by a
+// postgresql client
+func (t *TCPServer) StartPostgreSQLNoSSL() {
+	go func() {
+		conn, err := t.Listener.Accept()
+		if err != nil {
+			panic(fmt.Sprintf("Error accepting on socket: %s", err))
+		}
+		defer conn.Close()
+
+		buffer := make([]byte, 8)
+
+		_, err = io.ReadFull(conn, buffer)
+		if err != nil {
+			panic("Error reading input from client")
+		}
+
+		if _, err := conn.Write([]byte{0x4e}); err != nil {
+			panic("Error writing response to client")
+		}
+
+		t.stopCh <- struct{}{}
+	}()
+}
+
+// StartMySQL starts a listener that negotiates a TLS connection with a mysql
+// client using an SSLRequest packet
+func (t *TCPServer) StartMySQL() {
+	go func() {
+		conn, err := t.Listener.Accept()
+		if err != nil {
+			panic(fmt.Sprintf("Error accepting on socket: %s", err))
+		}
+		defer conn.Close()
+
+		// Protocol version, server version, connection id, auth plugin
+		// data, filler, capability flags (CLIENT_PROTOCOL_41|CLIENT_SSL|
+		// CLIENT_SECURE_CONNECTION), character set, status flags
+		handshake := []byte{0x0a}
+		handshake = append(handshake, []byte("8.0.0-test\x00")...)
+		handshake = append(handshake, 0x01, 0x00, 0x00, 0x00)
+		handshake = append(handshake, []byte("abcdefgh")...)
+		handshake = append(handshake, 0x00)
+		handshake = append(handshake, 0x00, 0x8a)
+		handshake = append(handshake, 0x21, 0x02, 0x00)
+
+		length := len(handshake)
+		packet := append([]byte{byte(length), byte(length >> 8), byte(length >> 16), 0x00}, handshake...)
+		if _, err := conn.Write(packet); err != nil {
+			panic("Error writing handshake to client")
+		}
+
+		sslRequest := make([]byte, 36)
+		if _, err := io.ReadFull(conn, sslRequest); err != nil {
+			panic("Error reading input from client")
+		}
+		if sslRequest[0] != 32 || sslRequest[3] != 1 {
+			panic(fmt.Sprintf("Error in dialog. Unexpected packet header %x", sslRequest[:4]))
+		}
+		if sslRequest[5]&0x08 == 0 {
+			panic("Error in dialog. CLIENT_SSL flag not set")
+		}
+
+		tlsConn := tls.Server(conn, t.TLS)
+		if err := tlsConn.Handshake(); err != nil {
+			level.Error(t.logger).Log("msg", err)
+		}
+		defer tlsConn.Close()
+
+		t.stopCh <- struct{}{}
+	}()
+}
+
+// StartMSSQL starts a listener that negotiates a TLS connection with an mssql
+// client using the TDS pre-login exchange
+func (t *TCPServer) StartMSSQL() {
+	go func() {
+		conn, err := t.Listener.Accept()
+		if err != nil {
+			panic(fmt.Sprintf("Error accepting on socket: %s", err))
+		}
+		defer conn.Close()
+
+		tds := &tdsConn{Conn: conn}
+
+		preLogin, err := tds.readPacket()
+		if err != nil {
+			panic(fmt.Sprintf("Error reading pre-login from client: %s", err))
+		}
+		if len(preLogin) == 0 || preLogin[0] != 0x00 {
+			panic(fmt.Sprintf("Error in dialog. Unexpected pre-login %x", preLogin))
+		}
+
+		// VERSION and ENCRYPTION options with ENCRYPT_ON
+		response := []byte{
+			0x00, 0x00, 0x0b, 0x00, 0x06,
+			0x01, 0x00, 0x11, 0x00, 0x01,
+			0xff,
+			0x10, 0x00, 0x00, 0x00, 0x00, 0x00,
+			0x01,
+		}
+		if _, err := tds.Write(response); err != nil {
+			panic("Error writing pre-login response to client")
+		}
+
+		// The TLS handshake is carried inside TDS packets
+		tlsConn := tls.Server(tds, t.TLS)
+		if err := tlsConn.Handshake(); err != nil {
+			level.Error(t.logger).Log("msg", err)
+		}
+		defer tlsConn.Close()
+
+		t.stopCh <- struct{}{}
+	}()
+}
+
+// StartLDAP starts a listener that negotiates a TLS connection with an ldap
+// client using the StartTLS extended operation
+func (t *TCPServer) StartLDAP() {
+	go func() {
+		conn, err := t.Listener.Accept()
+		if err != nil {
+			panic(fmt.Sprintf("Error accepting on socket: %s", err))
+		}
+		defer conn.Close()
+
+		startTLSRequest := append([]byte{0x30, 0x1d, 0x02, 0x01, 0x01, 0x77, 0x18, 0x80, 0x16}, []byte("1.3.6.1.4.1.1466.20037")...)
+
+		buffer := make([]byte, len(startTLSRequest))
+		if _, err := io.ReadFull(conn, buffer); err != nil {
+			panic("Error reading input from client")
+		}
+		if !bytes.Equal(buffer, startTLSRequest) {
+			panic(fmt.Sprintf("Error in dialog. No StartTLS request received: %x", buffer))
+		}
+
+		// ExtendedResponse with resultCode success
+		startTLSResponse := []byte{0x30, 0x0c, 0x02, 0x01, 0x01, 0x78, 0x07, 0x0a, 0x01, 0x00, 0x04, 0x00, 0x04, 0x00}
+		if _, err := conn.Write(startTLSResponse); err != nil {
+			panic("Error writing response to client")
+		}
+
+		tlsConn := tls.Server(conn, t.TLS)
+		if err := tlsConn.Handshake(); err != nil {
+			level.Error(t.logger).Log("msg", err)
+		}
+		defer tlsConn.Close()
+
+		t.stopCh <- struct{}{}
+	}()
+}
+
+// StartXMPP starts a listener that negotiates a TLS connection with an xmpp
+// client using STARTTLS
+func (t *TCPServer) StartXMPP() {
+	go func() {
+		conn, err := t.Listener.Accept()
+		if err != nil {
+			panic(fmt.Sprintf("Error accepting on socket: %s", err))
+		}
+		defer conn.Close()
+
+		if err := conn.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+			panic("Error setting deadline")
+		}
+
+		decoder := xml.NewDecoder(conn)
+		expectElement := func(name string) {
+			for {
+				token, err := decoder.Token()
+				if err != nil {
+					panic(fmt.Sprintf("Error in dialog. No %s received: %s", name, err))
+				}
+				if t, ok := token.(xml.StartElement); ok && t.Name.Local == name {
+					return
+				}
+			}
+		}
+
+		expectElement("stream")
+		fmt.Fprintf(conn, "<?xml version='1.0'?><stream:stream from='127.0.0.1' id='1' xmlns='jabber:client' xmlns:stream='http://etherx.jabber.org/streams' version='1.0'>")
+		fmt.Fprintf(conn, "<stream:features><starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'><required/></starttls><mechanisms xmlns='urn:ietf:params:xml:ns:xmpp-sasl'><mechanism>PLAIN</mechanism></mechanisms></stream:features>")
+
+		expectElement("starttls")
+		fmt.Fprintf(conn, "<proceed xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>")
+
+		// Upgrade to TLS.
+		tlsConn := tls.Server(conn, t.TLS)
+		if err := tlsConn.Handshake(); err != nil {
+			level.Error(t.logger).Log("msg", err)
+		}
+		defer tlsConn.Close()
+
+		t.stopCh <- struct{}{}
+	}()
+}
+
+// StartRDP starts a listener that negotiates a TLS connection with an rdp
+// client using an X.224 connection request
+func (t *TCPServer) StartRDP() {
+	go func() {
+		conn, err := t.Listener.Accept()
+		if err != nil {
+			panic(fmt.Sprintf("Error accepting on socket: %s", err))
+		}
+		defer conn.Close()
+
+		request := make([]byte, 19)
+		if _, err := io.ReadFull(conn, request); err != nil {
+			panic("Error reading input from client")
+		}
+		if request[5] != 0xe0 || request[11] != 0x01 || request[15]&0x01 == 0 {
+			panic(fmt.Sprintf("Error in dialog. Unexpected connection request %x", request))
+		}
+
+		// Connection Confirm selecting PROTOCOL_SSL
+		confirm := []byte{
+			0x03, 0x00, 0x00, 0x13,
+			0x0e, 0xd0, 0x00, 0x00, 0x12, 0x34, 0x00,
+			0x02, 0x00, 0x08, 0x00, 0x01, 0x00, 0x00, 0x00,
+		}
+		if _, err := conn.Write(confirm); err != nil {
+			panic("Error writing response to client")
+		}
+
+		tlsConn := tls.Server(conn, t.TLS)
+		if err := tlsConn.Handshake(); err != nil {
+			level.Error(t.logger).Log("msg", err)
+		}
+		defer tlsConn.Close()
+
+		t.stopCh <- struct{}{}
+	}()
+}
+
+// StartOpenVPN starts a listener that negotiates a TLS connection with an
+// openvpn client over the control channel
+func (t *TCPServer) StartOpenVPN() {
+	go func() {
+		conn, err := t.Listener.Accept()
+		if err != nil {
+			panic(fmt.Sprintf("Error accepting on socket: %s", err))
+		}
+		defer conn.Close()
+
+		ovpn := &openvpnConn{Conn: conn, sessionID: []byte{1, 2, 3, 4, 5, 6, 7, 8}}
+
+		opcode, sessionID, _, err := ovpn.readPacket()
+		if err != nil {
+			panic(fmt.Sprintf("Error reading hard reset from client: %s", err))
+		}
+		if opcode != 7 {
+			panic(fmt.Sprintf("Error in dialog. Unexpected opcode %d", opcode))
+		}
+
+		// P_CONTROL_HARD_RESET_SERVER_V2 acknowledging the client's packet
+		response := append([]byte{8 << 3}, ovpn.sessionID...)
+		response = append(response, 0x01, 0x00, 0x00, 0x00, 0x00)
+		response = append(response, sessionID...)
+		response = append(response, 0x00, 0x00, 0x00, 0x00)
+		if err := ovpn.writePacket(response); err != nil {
+			panic("Error writing hard reset response to client")
+		}
+		ovpn.packetID = 1
+
+		// The TLS handshake is carried inside P_CONTROL_V1 packets
+		tlsConn := tls.Server(ovpn, t.TLS)
+		if err := tlsConn.Handshake(); err != nil {
+			level.Error(t.logger).Log("msg", err)
+		}
+		defer tlsConn.Close()
+
+		t.stopCh <- struct{}{}
+	}()
+}
+
+// StartLDAPS starts a listener that performs an immediate TLS handshake and
+// then responds to a bind request with the given result code
+func (t *TCPServer) StartLDAPS(resultCode byte) {
+	t.serveTLS(func(conn net.Conn) {
+		tag, _, err := readBERElement(conn)
+		if err != nil {
+			panic(fmt.Sprintf("Error reading bind request from client: %s", err))
+		}
+		if tag != 0x30 {
+			panic(fmt.Sprintf("Error in dialog. Unexpected ldap message tag %x", tag))
+		}
+
+		bindResponse := []byte{0x30, 0x0c, 0x02, 0x01, 0x01, 0x61, 0x07, 0x0a, 0x01, resultCode, 0x04, 0x00, 0x04, 0x00}
+		if _, err := conn.Write(bindResponse); err != nil {
+			panic("Error writing response to client")
+		}
+	})
+}
+
+// StartMongoDB starts a listener that performs an immediate TLS handshake and
+// then responds to a command with {isWritablePrimary: true, ok: 1.0}
+func (t *TCPServer) StartMongoDB() {
+	t.serveTLS(func(conn net.Conn) {
+		header := make([]byte, 16)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			panic(fmt.Sprintf("Error reading command from client: %s", err))
+		}
+		if opCode := binary.LittleEndian.Uint32(header[12:16]); opCode != 2013 {
+			panic(fmt.Sprintf("Error in dialog. Unexpected opcode %d", opCode))
+		}
+		body := make([]byte, binary.LittleEndian.Uint32(header[0:4])-16)
+		if _, err := io.ReadFull(conn, body); err != nil {
+			panic(fmt.Sprintf("Error reading command from client: %s", err))
+		}
+
+		var elements []byte
+		elements = append(elements, 0x08)
+		elements = append(elements, []byte("isWritablePrimary\x00")...)
+		elements = append(elements, 0x01)
+		elements = append(elements, 0x01)
+		elements = append(elements, []byte("ok\x00")...)
+		elements = binary.LittleEndian.AppendUint64(elements, math.Float64bits(1))
+		elements = append(elements, 0x00)
+		doc := binary.LittleEndian.AppendUint32(nil, uint32(len(elements)+4))
+		doc = append(doc, elements...)
+
+		reply := binary.LittleEndian.AppendUint32(nil, uint32(16+5+len(doc)))
+		reply = binary.LittleEndian.AppendUint32(reply, 1)
+		reply = append(reply, header[4:8]...)
+		reply = binary.LittleEndian.AppendUint32(reply, 2013)
+		reply = append(reply, 0x00, 0x00, 0x00, 0x00, 0x00)
+		reply = append(reply, doc...)
+		if _, err := conn.Write(reply); err != nil {
+			panic("Error writing response to client")
+		}
+	})
+}
+
+// StartRedis starts a listener that performs an immediate TLS handshake and
+// then responds to the redis AUTH command, if a password is provided, and PING
+func (t *TCPServer) StartRedis(password string) {
+	t.serveTLS(func(conn net.Conn) {
+		reader := bufio.NewReader(conn)
+		readCommand := func() []string {
+			var n int
+			if _, err := fmt.Fscanf(reader, "*%d\r\n", &n); err != nil {
+				panic(fmt.Sprintf("Error reading command from client: %s", err))
+			}
+			args := make([]string, n)
+			for i := range args {
+				var length int
+				if _, err := fmt.Fscanf(reader, "$%d\r\n", &length); err != nil {
+					panic(fmt.Sprintf("Error reading command from client: %s", err))
+				}
+				arg := make([]byte, length+2)
+				if _, err := io.ReadFull(reader, arg); err != nil {
+					panic(fmt.Sprintf("Error reading command from client: %s", err))
+				}
+				args[i] = string(arg[:length])
+			}
+			return args
+		}
+
+		args := readCommand()
+		if password != "" {
+			if len(args) != 2 || args[0] != "AUTH" || args[1] != password {
+				fmt.Fprintf(conn, "-NOAUTH Authentication required.\r\n")
+				return
+			}
+			fmt.Fprintf(conn, "+OK\r\n")
+			args = readCommand()
+		}
+		if len(args) != 1 || args[0] != "PING" {
+			panic(fmt.Sprintf("Error in dialog. Unexpected command %v", args))
+		}
+		fmt.Fprintf(conn, "+PONG\r\n")
+	})
+}
+
+// StartKafka starts a listener that performs an immediate TLS handshake and
+// then responds to an ApiVersions request
+func (t *TCPServer) StartKafka() {
+	t.serveTLS(func(conn net.Conn) {
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			panic(fmt.Sprintf("Error reading request from client: %s", err))
+		}
+		request := make([]byte, binary.BigEndian.Uint32(header))
+		if _, err := io.ReadFull(conn, request); err != nil {
+			panic(fmt.Sprintf("Error reading request from client: %s", err))
+		}
+		if apiKey := binary.BigEndian.Uint16(request[0:2]); apiKey != 18 {
+			panic(fmt.Sprintf("Error in dialog. Unexpected api key %d", apiKey))
+		}
+
+		// Correlation id, error code and a single api version for
+		// ApiVersions
+		response := append([]byte{}, request[4:8]...)
+		response = append(response, 0x00, 0x00)
+		response = append(response, 0x00, 0x00, 0x00, 0x01)
+		response = append(response, 0x00, 0x12, 0x00, 0x00, 0x00, 0x03)
+		response = append(binary.BigEndian.AppendUint32(nil, uint32(len(response))), response...)
+		if _, err := conn.Write(response); err != nil {
+			panic("Error writing response to client")
+		}
+	})
+}
+
+// StartAMQP starts a listener that performs an immediate TLS handshake and
+// then responds to the AMQP 0-9-1 protocol header with Connection.Start
+func (t *TCPServer) StartAMQP() {
+	t.serveTLS(func(conn net.Conn) {
+		header := make([]byte, 8)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			panic(fmt.Sprintf("Error reading protocol header from client: %s", err))
+		}
+		if !bytes.Equal(header, []byte("AMQP\x00\x00\x09\x01")) {
+			panic(fmt.Sprintf("Error in dialog. Unexpected protocol header %x", header))
+		}
+
+		// Connection.Start with version 0-9, empty server properties
+		// and the PLAIN mechanism and en_US locale
+		payload := []byte{0x00, 0x0a, 0x00, 0x0a, 0x00, 0x09, 0x00, 0x00, 0x00, 0x00}
+		payload = append(payload, 0x00, 0x00, 0x00, 0x05)
+		payload = append(payload, []byte("PLAIN")...)
+		payload = append(payload, 0x00, 0x00, 0x00, 0x05)
+		payload = append(payload, []byte("en_US")...)
+
+		frame := []byte{0x01, 0x00, 0x00}
+		frame = binary.BigEndian.AppendUint32(frame, uint32(len(payload)))
+		frame = append(frame, payload...)
+		frame = append(frame, 0xce)
+		if _, err := conn.Write(frame); err != nil {
+			panic("Error writing response to client")
+		}
+	})
+}
+
+// StartMQTT starts a listener that performs an immediate TLS handshake and
+// then responds to an MQTT CONNECT with a CONNACK containing the given return
+// code
+func (t *TCPServer) StartMQTT(returnCode byte) {
+	t.serveTLS(func(conn net.Conn) {
+		header := make([]byte, 2)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			panic(fmt.Sprintf("Error reading CONNECT from client: %s", err))
+		}
+		if header[0] != 0x10 || header[1]&0x80 != 0 {
+			panic(fmt.Sprintf("Error in dialog. Unexpected packet %x", header))
+		}
+		body := make([]byte, header[1])
+		if _, err := io.ReadFull(conn, body); err != nil {
+			panic(fmt.Sprintf("Error reading CONNECT from client: %s", err))
+		}
+		if !bytes.HasPrefix(body, []byte("\x00\x04MQTT\x04")) {
+			panic(fmt.Sprintf("Error in dialog. Unexpected CONNECT %x", body))
+		}
+
+		if _, err := conn.Write([]byte{0x20, 0x02, 0x00, returnCode}); err != nil {
+			panic("Error writing response to client")
+		}
+	})
+}
+
+// StartFTPS starts a listener that performs an immediate TLS handshake and
+// then sends a multiline ftp greeting
+func (t *TCPServer) StartFTPS() {
+	t.serveTLS(func(conn net.Conn) {
+		fmt.Fprintf(conn, "220-Welcome to the test server\r\n")
+		fmt.Fprintf(conn, " Implicit TLS\r\n")
+		fmt.Fprintf(conn, "220 Ready\r\n")
+
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil {
+			panic(fmt.Sprintf("Error reading command from client: %s", err))
+		}
+		if line != "QUIT\r\n" {
+			panic(fmt.Sprintf("Error in dialog. Unexpected command %s", line))
+		}
+		fmt.Fprintf(conn, "221 Goodbye\r\n")
+	})
+}
+
+// StartSMTPS starts a listener that performs an immediate TLS handshake and
+// then responds to EHLO with the given code
+func (t *TCPServer) StartSMTPS(ehloCode int) {
+	t.serveTLS(func(conn net.Conn) {
+		fmt.Fprintf(conn, "220 ESMTP test server\r\n")
+
+		reader := bufio.NewReader(conn)
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			panic(fmt.Sprintf("Error reading command from client: %s", err))
+		}
+		if !strings.HasPrefix(line, "EHLO ") {
+			panic(fmt.Sprintf("Error in dialog. Unexpected command %s", line))
+		}
+		if ehloCode != 250 {
+			fmt.Fprintf(conn, "%d Service not available\r\n", ehloCode)
+			return
+		}
+		fmt.Fprintf(conn, "250-test server\r\n")
+		fmt.Fprintf(conn, "250-SIZE 10240000\r\n")
+		fmt.Fprintf(conn, "250 8BITMIME\r\n")
+
+		if _, err := reader.ReadString('\n'); err != nil {
+			panic(fmt.Sprintf("Error reading command from client: %s", err))
+		}
+		fmt.Fprintf(conn, "221 Bye\r\n")
+	})
+}
+
+// StartZooKeeper starts a listener that performs an immediate TLS handshake
+// and then responds to the srvr four letter word command
+func (t *TCPServer) StartZooKeeper() {
+	t.serveTLS(func(conn net.Conn) {
+		command := make([]byte, 4)
+		if _, err := io.ReadFull(conn, command); err != nil {
+			panic(fmt.Sprintf("Error reading command from client: %s", err))
+		}
+		if string(command) != "srvr" {
+			panic(fmt.Sprintf("Error in dialog. Unexpected command %s", command))
+		}
+
+		fmt.Fprintf(conn, "Zookeeper version: 3.8.4-test\n")
+		fmt.Fprintf(conn, "Latency min/avg/max: 0/0.0/0\n")
+		fmt.Fprintf(conn, "Mode: standalone\n")
+		fmt.Fprintf(conn, "Node count: 5\n")
+	})
+}
+
+// StartCassandra starts a listener that performs an immediate TLS handshake
+// and then responds to a native protocol OPTIONS request with SUPPORTED
+func (t *TCPServer) StartCassandra() {
+	t.serveTLS(func(conn net.Conn) {
+		request := make([]byte, 9)
+		if _, err := io.ReadFull(conn, request); err != nil {
+			panic(fmt.Sprintf("Error reading request from client: %s", err))
+		}
+		if request[0] != 0x04 || request[4] != 0x05 {
+			panic(fmt.Sprintf("Error in dialog. Unexpected request %x", request))
+		}
+
+		// A string multimap containing CQL_VERSION: [3.4.5]
+		body := []byte{0x00, 0x01}
+		body = append(body, 0x00, 0x0b)
+		body = append(body, []byte("CQL_VERSION")...)
+		body = append(body, 0x00, 0x01, 0x00, 0x05)
+		body = append(body, []byte("3.4.5")...)
+
+		response := []byte{0x84, 0x00, request[2], request[3], 0x06}
+		response = binary.BigEndian.AppendUint32(response, uint32(len(body)))
+		response = append(response, body...)
+		if _, err := conn.Write(response); err != nil {
+			panic("Error writing response to client")
+		}
+	})
+}
+
+// StartSIP starts a listener that performs an immediate TLS handshake and then
+// responds to a SIP OPTIONS request with a provisional and a final response
+func (t *TCPServer) StartSIP() {
+	t.serveTLS(func(conn net.Conn) {
+		reader := bufio.NewReader(conn)
+		requestLine, err := reader.ReadString('\n')
+		if err != nil {
+			panic(fmt.Sprintf("Error reading request from client: %s", err))
+		}
+		if !strings.HasPrefix(requestLine, "OPTIONS sip:") {
+			panic(fmt.Sprintf("Error in dialog. Unexpected request %s", requestLine))
+		}
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				panic(fmt.Sprintf("Error reading request from client: %s", err))
+			}
+			if line == "\r\n" {
+				break
+			}
+		}
+
+		fmt.Fprintf(conn, "SIP/2.0 100 Trying\r\nContent-Length: 0\r\n\r\n")
+		fmt.Fprintf(conn, "SIP/2.0 200 OK\r\nAllow: INVITE, ACK, CANCEL, OPTIONS, BYE\r\nContent-Length: 0\r\n\r\n")
+	})
+}
+
+// StartSTUN starts a listener that performs an immediate TLS handshake and
+// then responds to a STUN Binding request with the client's mapped address
+func (t *TCPServer) StartSTUN() {
+	t.serveTLS(func(conn net.Conn) {
+		request := make([]byte, 20)
+		if _, err := io.ReadFull(conn, request); err != nil {
+			panic(fmt.Sprintf("Error reading request from client: %s", err))
+		}
+		if !bytes.Equal(request[0:8], []byte{0x00, 0x01, 0x00, 0x00, 0x21, 0x12, 0xa4, 0x42}) {
+			panic(fmt.Sprintf("Error in dialog. Unexpected binding request %x", request))
+		}
+
+		// XOR-MAPPED-ADDRESS of 127.0.0.1:1234, preceded by a padded
+		// SOFTWARE attribute
+		attrs := []byte{
+			0x80, 0x22, 0x00, 0x03, 'f', 'o', 'o', 0x00,
+			0x00, 0x20, 0x00, 0x08, 0x00, 0x01, 0x04 ^ 0x21, 0xd2 ^ 0x12,
+			127 ^ 0x21, 0 ^ 0x12, 0 ^ 0xa4, 1 ^ 0x42,
+		}
+		response := []byte{0x01, 0x01, 0x00, byte(len(attrs))}
+		response = append(response, request[4:]...)
+		if _, err := conn.Write(append(response, attrs...)); err != nil {
+			panic("Error writing response to client")
+		}
+	})
+}
+
+// StartSyslog starts a listener that performs an immediate TLS handshake and
+// then reads a single octet counted syslog message
+func (t *TCPServer) StartSyslog() {
+	t.serveTLS(func(conn net.Conn) {
+		reader := bufio.NewReader(conn)
+		var length int
+		if _, err := fmt.Fscanf(reader, "%d ", &length); err != nil {
+			panic(fmt.Sprintf("Error reading message length from client: %s", err))
+		}
+		msg := make([]byte, length)
+		if _, err := io.ReadFull(reader, msg); err != nil {
+			panic(fmt.Sprintf("Error reading message from client: %s", err))
+		}
+		if !bytes.HasPrefix(msg, []byte("<14>1 ")) {
+			panic(fmt.Sprintf("Error in dialog. Unexpected message %s", msg))
+		}
+	})
+}
+
+// serveTLS starts a listener that performs an immediate TLS handshake and then
+// passes the connection to the handler
+func (t *TCPServer) serveTLS(handler func(conn net.Conn)) {
+	go func() {
+		ln := tls.NewListener(t.Listener, t.TLS)
+		conn, err := ln.Accept()
+		if err != nil {
+			panic(fmt.Sprintf("Error accepting on socket: %s", err))
+		}
+		defer conn.Close()
+
+		if err := conn.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+			panic("Error setting deadline")
+		}
+
+		if err := conn.(*tls.Conn).Handshake(); err != nil {
+			level.Error(t.logger).Log("msg", err)
+		} else {
+			handler(conn)
+		}
+
+		// Close the connection before signalling, so that clients reading
+		// until EOF return
+		conn.Close()
+
+		t.stopCh <- struct{}{}
+	}()
+}
+
 // Close stops the server and closes the listener
 func (t *TCPServer) Close() {
 	<-t.stopCh
@@ -327,3 +1128,134 @@ func SetupTCPServerWithCertAndKey(caPEM, certPEM, keyPEM []byte) (*TCPServer, st
 
 	return server, caFile, teardown, err
 }
+
+// tdsConn wraps writes in TDS tabular result packets and strips the TDS
+// headers from reads
+type tdsConn struct {
+	net.Conn
+	buf []byte
+}
+
+func (c *tdsConn) Write(b []byte) (int, error) {
+	length := 8 + len(b)
+	packet := append([]byte{0x04, 0x01, byte(length >> 8), byte(length), 0x00, 0x00, 0x01, 0x00}, b...)
+	if _, err := c.Conn.Write(packet); err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+func (c *tdsConn) Read(b []byte) (int, error) {
+	if len(c.buf) == 0 {
+		payload, err := c.readPacket()
+		if err != nil {
+			return 0, err
+		}
+		c.buf = payload
+	}
+	n := copy(b, c.buf)
+	c.buf = c.buf[n:]
+
+	return n, nil
+}
+
+func (c *tdsConn) readPacket() ([]byte, error) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(c.Conn, header); err != nil {
+		return nil, err
+	}
+	if header[0] != 0x12 {
+		return nil, fmt.Errorf("unexpected packet type %x", header[0])
+	}
+	payload := make([]byte, (int(header[2])<<8|int(header[3]))-8)
+	if _, err := io.ReadFull(c.Conn, payload); err != nil {
+		return nil, err
+	}
+
+	return payload, nil
+}
+
+// openvpnConn wraps writes in openvpn P_CONTROL_V1 packets and strips the
+// headers from the control packets that it reads
+type openvpnConn struct {
+	net.Conn
+	sessionID []byte
+	packetID  uint32
+	buf       []byte
+}
+
+func (c *openvpnConn) Write(b []byte) (int, error) {
+	packet := append([]byte{4 << 3}, c.sessionID...)
+	packet = append(packet, 0x00)
+	packet = binary.BigEndian.AppendUint32(packet, c.packetID)
+	c.packetID++
+	if err := c.writePacket(append(packet, b...)); err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+func (c *openvpnConn) Read(b []byte) (int, error) {
+	for len(c.buf) == 0 {
+		opcode, _, payload, err := c.readPacket()
+		if err != nil {
+			return 0, err
+		}
+		if opcode != 4 {
+			continue
+		}
+		// Strip the packet id
+		c.buf = payload[4:]
+	}
+	n := copy(b, c.buf)
+	c.buf = c.buf[n:]
+
+	return n, nil
+}
+
+func (c *openvpnConn) writePacket(packet []byte) error {
+	_, err := c.Conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(packet))), packet...))
+
+	return err
+}
+
+// readPacket returns the opcode and session id of the packet and the portion
+// of the packet that follows the ack array
+func (c *openvpnConn) readPacket() (byte, []byte, []byte, error) {
+	length := make([]byte, 2)
+	if _, err := io.ReadFull(c.Conn, length); err != nil {
+		return 0, nil, nil, err
+	}
+	packet := make([]byte, binary.BigEndian.Uint16(length))
+	if _, err := io.ReadFull(c.Conn, packet); err != nil {
+		return 0, nil, nil, err
+	}
+	if len(packet) < 10 {
+		return 0, nil, nil, fmt.Errorf("short packet %x", packet)
+	}
+	offset := 10 + int(packet[9])*4
+	if packet[9] > 0 {
+		offset += 8
+	}
+
+	return packet[0] >> 3, packet[1:9], packet[offset:], nil
+}
+
+// readBERElement reads a single BER element with a short form length
+func readBERElement(r io.Reader) (byte, []byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+	if header[1]&0x80 != 0 {
+		return 0, nil, fmt.Errorf("unsupported ber length %x", header[1])
+	}
+	content := make([]byte, header[1])
+	if _, err := io.ReadFull(r, content); err != nil {
+		return 0, nil, err
+	}
+
+	return header[0], content, nil
+}
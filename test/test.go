@@ -5,6 +5,7 @@ import (
 	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/binary"
 	"encoding/pem"
 	"fmt"
 	"io/ioutil"
@@ -103,3 +104,24 @@ func WriteFile(filename string, contents []byte) (string, error) {
 
 	return tmpFile.Name(), nil
 }
+
+// JavaKeyStore returns a Java KeyStore that trusts the certificates. Its
+// integrity digest isn't valid, as it's computed with a password.
+func JavaKeyStore(certs ...*x509.Certificate) []byte {
+	ks := binary.BigEndian.AppendUint32(nil, 0xfeedfeed)
+	ks = binary.BigEndian.AppendUint32(ks, 2)
+	ks = binary.BigEndian.AppendUint32(ks, uint32(len(certs)))
+	for i, cert := range certs {
+		alias := fmt.Sprintf("cert%d", i)
+		ks = binary.BigEndian.AppendUint32(ks, 2)
+		ks = binary.BigEndian.AppendUint16(ks, uint16(len(alias)))
+		ks = append(ks, alias...)
+		ks = binary.BigEndian.AppendUint64(ks, uint64(time.Now().UnixMilli()))
+		ks = binary.BigEndian.AppendUint16(ks, uint16(len("X.509")))
+		ks = append(ks, "X.509"...)
+		ks = binary.BigEndian.AppendUint32(ks, uint32(len(cert.Raw)))
+		ks = append(ks, cert.Raw...)
+	}
+
+	return append(ks, make([]byte, 20)...)
+}
@@ -0,0 +1,75 @@
+package test
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"os"
+	"time"
+)
+
+// TLSWrapper terminates an outer TLS session and forwards the stream inside it
+// to a backend, like stunnel or ghostunnel
+type TLSWrapper struct {
+	Listener net.Listener
+}
+
+// SetupTLSWrapper starts a TLS wrapper for the backend address with a
+// generated certificate. The returned CA file verifies the certificate.
+func SetupTLSWrapper(backend string) (*TLSWrapper, string, func(), error) {
+	var teardown func()
+
+	certPEM, keyPEM := GenerateTestCertificate(time.Now().AddDate(0, 0, 1))
+
+	caFile, err := WriteFile("wrapper_certfile.pem", certPEM)
+	if err != nil {
+		return nil, caFile, teardown, err
+	}
+
+	teardown = func() {
+		os.Remove(caFile)
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, caFile, teardown, err
+	}
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	})
+	if err != nil {
+		return nil, caFile, teardown, err
+	}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+
+				backendConn, err := net.DialTimeout("tcp", backend, 5*time.Second)
+				if err != nil {
+					return
+				}
+				defer backendConn.Close()
+
+				go func() {
+					io.Copy(backendConn, conn)
+					backendConn.Close()
+				}()
+				io.Copy(conn, backendConn)
+			}()
+		}
+	}()
+
+	return &TLSWrapper{Listener: ln}, caFile, teardown, nil
+}
+
+// Close stops the wrapper
+func (w *TLSWrapper) Close() {
+	w.Listener.Close()
+}